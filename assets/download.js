@@ -0,0 +1,60 @@
+// "download zip" buttons with data-job: the zip is made by a background job (/_/jobs), progress comes from its event stream
+// without EventSource (or when the queue is full) the normal link is used, which streams the zip directly
+(() => {
+  if (!("EventSource" in window)) {
+    return;
+  }
+
+  for (const btn of document.querySelectorAll("a[data-job]")) {
+    btn.onclick = async (e) => {
+      e.preventDefault();
+      if (btn.dataset.running) {
+        return;
+      }
+
+      btn.dataset.running = "1";
+      const label = btn.textContent;
+      const reset = (text) => {
+        btn.textContent = text;
+        delete btn.dataset.running;
+      };
+
+      let job;
+      try {
+        const resp = await fetch("/_/jobs", { method: "POST", body: new URLSearchParams(btn.dataset.job) });
+        if (!resp.ok) {
+          throw resp.status;
+        }
+        job = await resp.json();
+      } catch (err) {
+        console.log("download job:", err);
+        reset(label);
+        location.href = btn.href;
+        return;
+      }
+
+      btn.textContent = btn.dataset.preparing;
+      const es = new EventSource("/_/jobs/" + job.id + "/events");
+      es.addEventListener("progress", (ev) => {
+        const j = JSON.parse(ev.data);
+        if (j.status === "running") {
+          btn.textContent = btn.dataset.preparing + " " + Math.floor(j.progress * 100) + "%";
+        } else if (j.status === "done") {
+          es.close();
+          reset(label);
+          location.href = j.file;
+        } else if (j.status === "failed") {
+          es.close();
+          reset(btn.dataset.failed);
+        }
+      });
+      es.onerror = () => {
+        // the stream ends after the last event, don't reconnect
+        es.close();
+        if (btn.dataset.running) {
+          reset(btn.dataset.failed);
+        }
+      };
+    };
+  }
+})();
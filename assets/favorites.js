@@ -0,0 +1,52 @@
+// favorites used to be kept in localStorage (paths of tracks), move them to the instance once
+// on the home page, also lists the favorites from /favorites?format=json
+(async () => {
+  if (localStorage.favorites) {
+    const paths = localStorage.favorites.split(",").filter((p) => p.trim() !== "");
+    if (paths.length) {
+      try {
+        const resp = await fetch("/favorites/import", { method: "POST", body: new URLSearchParams({ paths: paths.join(",") }) });
+        if (!resp.ok) {
+          throw resp.status;
+        }
+        localStorage.removeItem("favorites");
+        if (location.pathname === "/favorites") {
+          location.reload();
+          return;
+        }
+      } catch (e) {
+        console.log("favorites import:", e);
+      }
+    } else {
+      localStorage.removeItem("favorites");
+    }
+  }
+
+  const list = document.getElementById("favorites");
+  if (!list) {
+    return;
+  }
+
+  const resp = await fetch("/favorites?format=json");
+  if (!resp.ok) {
+    return;
+  }
+
+  const fav = await resp.json();
+  const item = (href, text) => {
+    const li = document.createElement("li");
+    const a = document.createElement("a");
+    a.href = href;
+    a.className = "listing";
+    a.textContent = text;
+    li.append(a);
+    return li;
+  };
+
+  for (const t of fav.tracks || []) {
+    list.append(item("/" + t.permalink, t.artist + " - " + t.title));
+  }
+  for (const u of fav.users || []) {
+    list.append(item("/" + u.permalink, u.username));
+  }
+})();
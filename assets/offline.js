@@ -0,0 +1,42 @@
+// offline page: lists saved tracks (playable without a connection) and pages visited before
+(async () => {
+  const tracks = document.getElementById("offline-tracks");
+  const pages = document.getElementById("offline-pages");
+  if (!("caches" in window)) {
+    return;
+  }
+
+  const saved = await (await caches.open("offline-tracks")).keys();
+  for (const req of saved) {
+    const resp = await caches.match(req);
+    const li = document.createElement("li");
+    const title = document.createElement("p");
+    title.textContent = decodeURIComponent(resp.headers.get("x-artist") || "") + " - " + decodeURIComponent(resp.headers.get("x-title") || "");
+    const audio = document.createElement("audio");
+    audio.controls = true;
+    audio.preload = "none";
+    audio.src = new URL(req.url).pathname;
+    const remove = document.createElement("button");
+    remove.className = "btn";
+    remove.textContent = tracks.dataset.remove;
+    remove.onclick = async () => {
+      await (await caches.open("offline-tracks")).delete(req);
+      li.remove();
+    };
+    li.append(title, audio, remove);
+    tracks.append(li);
+  }
+
+  for (const req of await (await caches.open("pages")).keys()) {
+    const path = new URL(req.url).pathname;
+    if (path === "/" || path === "/offline") {
+      continue;
+    }
+    const li = document.createElement("li");
+    const a = document.createElement("a");
+    a.href = path;
+    a.textContent = decodeURIComponent(path);
+    li.append(a);
+    pages.append(li);
+  }
+})();
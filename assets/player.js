@@ -0,0 +1,17 @@
+// attaches hls.js to the track player when the stream is hls, data-preload on the script tag buffers the whole track
+(() => {
+  const audio = document.getElementById("track");
+  if (!audio || audio.dataset.hls !== "true") {
+    // progressive stream, the browser can play it by itself
+    return;
+  }
+
+  if (Hls.isSupported()) {
+    const preload = document.currentScript.dataset.preload === "true";
+    const hls = new Hls(preload ? { maxBufferLength: Infinity } : {});
+    hls.loadSource(audio.src);
+    hls.attachMedia(audio);
+  } else if (!audio.canPlayType("application/vnd.apple.mpegurl")) {
+    alert(audio.dataset.nohls);
+  }
+})();
@@ -0,0 +1,34 @@
+// search proof-of-work (lib/botguard): finds a nonce for the challenge, stores it in the pow cookie and reloads
+(async () => {
+  const el = document.getElementById("pow");
+  const challenge = el.dataset.challenge;
+  const difficulty = parseInt(el.dataset.difficulty);
+  const enc = new TextEncoder();
+
+  function zeros(hash) {
+    let n = 0;
+    for (const b of hash) {
+      if (b == 0) {
+        n += 8;
+        continue;
+      }
+      return n + Math.clz32(b) - 24;
+    }
+    return n;
+  }
+
+  if (!window.crypto || !crypto.subtle) {
+    document.getElementById("pow-status").textContent = el.dataset.nocrypto;
+    return;
+  }
+
+  for (let nonce = 0; ; nonce++) {
+    const candidate = challenge + "." + nonce;
+    const hash = new Uint8Array(await crypto.subtle.digest("SHA-256", enc.encode(candidate)));
+    if (zeros(hash) >= difficulty) {
+      document.cookie = "pow=" + candidate + "; max-age=" + el.dataset.ttl + "; path=/; SameSite=Lax";
+      location.reload();
+      return;
+    }
+  }
+})();
@@ -0,0 +1,68 @@
+// previews in track listings: hovering (with a mouse) or pressing and holding (touch) plays the first seconds of a track
+(() => {
+  const audio = new Audio();
+  let current = null;
+  let timer = null;
+  let pressed = false;
+
+  function stop() {
+    clearTimeout(timer);
+    audio.pause();
+    current = null;
+  }
+
+  function start(el, delay, touch) {
+    clearTimeout(timer);
+    timer = setTimeout(() => {
+      current = el;
+      pressed = touch;
+      audio.src = el.dataset.preview;
+      audio.play().catch(() => {});
+    }, delay);
+  }
+
+  for (const el of document.querySelectorAll("[data-preview]")) {
+    if (!el.dataset.preview) {
+      continue;
+    }
+
+    el.addEventListener("pointerenter", (e) => {
+      if (e.pointerType === "mouse") {
+        start(el, 300, false);
+      }
+    });
+    el.addEventListener("pointerleave", (e) => {
+      if (e.pointerType === "mouse" && current !== null) {
+        stop();
+      }
+      clearTimeout(timer);
+    });
+
+    el.addEventListener("pointerdown", (e) => {
+      if (e.pointerType !== "mouse") {
+        pressed = false;
+        start(el, 500, true);
+      }
+    });
+    for (const type of ["pointerup", "pointercancel"]) {
+      el.addEventListener(type, (e) => {
+        if (e.pointerType !== "mouse") {
+          stop();
+        }
+      });
+    }
+
+    // the long press was for the preview, not for opening the track
+    el.addEventListener("click", (e) => {
+      if (pressed) {
+        e.preventDefault();
+        pressed = false;
+      }
+    });
+    el.addEventListener("contextmenu", (e) => {
+      if (current === el) {
+        e.preventDefault();
+      }
+    });
+  }
+})();
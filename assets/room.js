@@ -0,0 +1,134 @@
+// listen together (/rooms/<id>): follows the room state from the websocket, the host also sends its player events
+(() => {
+  const audio = document.getElementById("roomAudio");
+  const status = document.getElementById("roomStatus");
+  const queue = document.getElementById("roomQueue");
+  const host = audio.dataset.host === "true";
+  let hls;
+  let ws;
+  let state;
+  let current; // id of the loaded track
+  let applying = false; // don't echo events caused by applying the state
+
+  // further than this from the room position (seconds) and we seek
+  const tolerance = 2;
+
+  const send = (msg) => {
+    if (host && ws && ws.readyState === WebSocket.OPEN) {
+      ws.send(JSON.stringify(msg));
+    }
+  };
+
+  const load = (t) => {
+    current = t.id;
+    document.getElementById("roomTitle").textContent = t.title;
+    document.getElementById("roomArtist").textContent = t.artist;
+    const art = document.getElementById("roomArtwork");
+    art.hidden = !t.artwork;
+    art.src = t.artwork;
+
+    if (hls) {
+      hls.destroy();
+      hls = null;
+    }
+
+    if (t.hls && window.Hls && Hls.isSupported()) {
+      hls = new Hls();
+      hls.loadSource(t.stream);
+      hls.attachMedia(audio);
+    } else if (t.hls && !audio.canPlayType("application/vnd.apple.mpegurl")) {
+      alert(audio.dataset.nohls);
+    } else {
+      audio.src = t.stream;
+    }
+  };
+
+  const position = () => state.position + (state.playing ? (Date.now() - state.at) / 1000 : 0);
+
+  const apply = () => {
+    const t = state.queue[state.index];
+    applying = true;
+    if (t && t.id !== current) {
+      load(t);
+    }
+
+    if (Math.abs(audio.currentTime - position()) > tolerance) {
+      audio.currentTime = position();
+    }
+
+    if (state.playing && audio.paused) {
+      audio.play().catch(() => {
+        // autoplay is blocked until the user interacts with the page
+        status.textContent = audio.dataset.blocked;
+      });
+    } else if (!state.playing && !audio.paused) {
+      audio.pause();
+    }
+    setTimeout(() => (applying = false), 100);
+
+    queue.replaceChildren(
+      ...state.queue.map((t, i) => {
+        const li = document.createElement("li");
+        li.textContent = t.artist + " - " + t.title;
+        if (i === state.index) {
+          li.style.fontWeight = "bold";
+        }
+        if (host) {
+          li.style.cursor = "pointer";
+          li.onclick = () => send({ type: "track", index: i });
+        }
+        return li;
+      })
+    );
+  };
+
+  const connect = () => {
+    ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/rooms/" + audio.dataset.room + "/ws");
+    ws.onmessage = (ev) => {
+      const msg = JSON.parse(ev.data);
+      if (msg.type === "state") {
+        state = msg;
+        status.textContent = status.dataset.members.replace("%s", msg.members);
+        apply();
+      }
+    };
+    ws.onclose = () => {
+      status.textContent = status.dataset.closed;
+      setTimeout(connect, 3000);
+    };
+  };
+
+  // only the host's player drives the room, listeners get put back in sync
+  for (const ev of ["play", "pause", "seeked"]) {
+    audio.addEventListener(ev, () => {
+      if (applying || !state) {
+        return;
+      }
+
+      if (host) {
+        send({ type: ev === "seeked" ? "seek" : ev, position: audio.currentTime });
+      } else {
+        apply();
+      }
+    });
+  }
+
+  audio.addEventListener("ended", () => {
+    if (state && state.index + 1 < state.queue.length) {
+      send({ type: "track", index: state.index + 1 });
+    } else {
+      send({ type: "pause", position: audio.currentTime });
+    }
+  });
+
+  const form = document.getElementById("roomAdd");
+  if (form) {
+    form.onsubmit = (e) => {
+      e.preventDefault();
+      send({ type: "add", track: form.track.value });
+      form.reset();
+    };
+  }
+
+  connect();
+})();
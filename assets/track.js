@@ -1,17 +1,237 @@
-const data = JSON.parse(document.getElementById("trackData").textContent);
-console.log(data);
-
-if ("mediaSession" in navigator) {
-  navigator.mediaSession.metadata = new MediaMetadata({
-    title: data.title,
-    artist: data.user.username,
-    album: "Soundcloak",
-    artwork: [
-      {
-        src: data.artwork_url,
-        sizes: "500x500",
-        type: "image/jpeg",
+// "save offline" button (only rendered when downloads are enabled), the service worker serves saved tracks from /_/offline/<id>
+(() => {
+  const btn = document.getElementById("saveOffline");
+  if (!btn || !("caches" in window) || !("serviceWorker" in navigator)) {
+    return;
+  }
+
+  const key = "/_/offline/" + btn.dataset.id;
+  caches
+    .open("offline-tracks")
+    .then((c) => c.match(key))
+    .then((r) => {
+      if (r) {
+        btn.textContent = btn.dataset.saved;
+        btn.disabled = true;
+      }
+    });
+
+  btn.hidden = false;
+  btn.onclick = async () => {
+    btn.disabled = true;
+    btn.textContent = btn.dataset.saving;
+    try {
+      const resp = await fetch("/_/download?url=" + encodeURIComponent(btn.dataset.id));
+      if (!resp.ok) {
+        throw resp.status;
+      }
+
+      const c = await caches.open("offline-tracks");
+      await c.put(
+        key,
+        new Response(await resp.blob(), {
+          headers: {
+            "Content-Type": "audio/mpeg",
+            "X-Title": encodeURIComponent(btn.dataset.title),
+            "X-Artist": encodeURIComponent(btn.dataset.artist),
+          },
+        })
+      );
+      btn.textContent = btn.dataset.saved;
+    } catch (e) {
+      console.log("save offline:", e);
+      btn.textContent = btn.dataset.failed;
+      btn.disabled = false;
+    }
+  };
+})();
+
+// player: media session metadata (hardware media keys, lock screen) and keyboard shortcuts
+// metadata comes from /_/nowplaying/<id>, the audio element needs data-id
+(() => {
+  const audio = document.getElementById("track");
+  if (!audio || !audio.dataset.id) {
+    return;
+  }
+
+  const seek = (by) => {
+    audio.currentTime = Math.max(0, Math.min(audio.duration || 0, audio.currentTime + by));
+  };
+
+  // #t=83 or #t=1:23, like soundcloud links (and /on/ redirects) have, or ?t= which the server put in data-start: start playing from there
+  // same formats as sc.ParseOffset: 83, 1:23, 1m30s
+  const parseOffset = (t) => {
+    if (/[hms]/.test(t)) {
+      const units = { h: 3600, m: 60, s: 1 };
+      return [...t.matchAll(/(\d+)([hms])/g)].reduce((total, [, v, unit]) => total + parseInt(v, 10) * units[unit], 0);
+    }
+
+    return t.split(":").reduce((total, v) => total * 60 + parseInt(v || "0", 10), 0);
+  };
+
+  const hash = /[#&]t=([\dhms:]+)/.exec(location.hash);
+  const offset = hash ? parseOffset(hash[1]) : parseInt(audio.dataset.start || "0", 10);
+  if (offset > 0) {
+    const jump = () => {
+      audio.currentTime = offset;
+    };
+
+    if (audio.readyState >= 1) {
+      jump();
+    } else {
+      audio.addEventListener("loadedmetadata", jump, { once: true });
+    }
+  }
+
+  const toggle = () => {
+    if (audio.paused) {
+      audio.play();
+    } else {
+      audio.pause();
+    }
+  };
+
+  if ("mediaSession" in navigator) {
+    fetch("/_/nowplaying/" + encodeURIComponent(audio.dataset.id))
+      .then((r) => (r.ok ? r.json() : Promise.reject(r.status)))
+      .then((data) => {
+        navigator.mediaSession.metadata = new MediaMetadata({
+          title: data.title,
+          artist: data.artist,
+          album: "soundcloak",
+          artwork: data.artwork
+            ? [{ src: new URL(data.artwork, location.href).href, sizes: "500x500", type: "image/jpeg" }]
+            : [],
+        });
+      })
+      .catch((e) => console.log("now playing:", e));
+
+    const handlers = {
+      play: () => audio.play(),
+      pause: () => audio.pause(),
+      stop: () => {
+        audio.pause();
+        audio.currentTime = 0;
+      },
+      seekbackward: (d) => seek(-(d.seekOffset || 10)),
+      seekforward: (d) => seek(d.seekOffset || 10),
+      seekto: (d) => {
+        audio.currentTime = d.seekTime;
       },
-    ],
+    };
+
+    for (const [action, handler] of Object.entries(handlers)) {
+      try {
+        navigator.mediaSession.setActionHandler(action, handler);
+      } catch {
+        // not supported by this browser
+      }
+    }
+
+    const updatePosition = () => {
+      if (navigator.mediaSession.setPositionState && isFinite(audio.duration)) {
+        navigator.mediaSession.setPositionState({
+          duration: audio.duration,
+          playbackRate: audio.playbackRate,
+          position: Math.min(audio.currentTime, audio.duration),
+        });
+      }
+    };
+
+    audio.addEventListener("loadedmetadata", updatePosition);
+    audio.addEventListener("seeked", updatePosition);
+    audio.addEventListener("ratechange", updatePosition);
+  }
+
+  // space/k: play/pause, arrows or j/l: seek, up/down: volume, m: mute, 0-9: jump to 0%-90%
+  document.addEventListener("keydown", (e) => {
+    if (e.ctrlKey || e.metaKey || e.altKey || /^(INPUT|TEXTAREA|SELECT)$/.test(e.target.tagName)) {
+      return;
+    }
+
+    switch (e.key) {
+      case " ":
+      case "k":
+        if (e.target === audio && e.key === " ") {
+          return; // the browser handles it already
+        }
+        toggle();
+        break;
+      case "ArrowLeft":
+        seek(-5);
+        break;
+      case "ArrowRight":
+        seek(5);
+        break;
+      case "j":
+        seek(-10);
+        break;
+      case "l":
+        seek(10);
+        break;
+      case "ArrowUp":
+        audio.volume = Math.min(1, audio.volume + 0.1);
+        break;
+      case "ArrowDown":
+        audio.volume = Math.max(0, audio.volume - 0.1);
+        break;
+      case "m":
+        audio.muted = !audio.muted;
+        break;
+      default:
+        if (e.key >= "0" && e.key <= "9" && isFinite(audio.duration)) {
+          audio.currentTime = (audio.duration * parseInt(e.key)) / 10;
+          break;
+        }
+        return;
+    }
+
+    e.preventDefault();
+  });
+})();
+
+// comment markers: timed comments as dots under the player, from /_/comments/<id>
+// hovering one shows the first few comments, clicking it seeks there
+(() => {
+  const audio = document.getElementById("track");
+  if (!audio || !audio.dataset.id) {
+    return;
+  }
+
+  fetch("/_/comments/" + encodeURIComponent(audio.dataset.id) + "?buckets=100")
+    .then((r) => (r.ok ? r.json() : Promise.reject(r.status)))
+    .then((data) => {
+      if (!data.markers.length || !data.duration) {
+        return;
+      }
+
+      const bar = document.createElement("div");
+      bar.className = "comment-markers";
+      bar.style.width = audio.offsetWidth + "px";
+      for (const m of data.markers) {
+        const dot = document.createElement("button");
+        dot.style.left = (m.position / data.duration) * 100 + "%";
+        dot.title = m.comments.map((c) => c.user.username + ": " + c.body).join("\n") + (m.count > m.comments.length ? "\n+" + (m.count - m.comments.length) : "");
+        dot.addEventListener("click", () => {
+          audio.currentTime = m.position / 1000;
+        });
+        bar.append(dot);
+      }
+
+      audio.after(bar);
+    })
+    .catch((e) => console.log("comments:", e));
+})();
+
+// short link form: the link starts where the player is right now
+(() => {
+  const form = document.querySelector("form.short-link");
+  const audio = document.getElementById("track");
+  if (!form || !audio) {
+    return;
+  }
+
+  form.addEventListener("submit", () => {
+    form.elements.t.value = Math.floor(audio.currentTime || 0);
   });
-}
+})();
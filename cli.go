@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/export"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Subcommands, so the resolver can be used without running the web server
+// everything (except for progress messages) is printed as json to stdout
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: soundcloak [command]")
+	fmt.Fprintln(os.Stderr, "without a command, runs the web server")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  resolve <url> [url...]                     print the entity behind a soundcloud url (a list of results for many urls)")
+	fmt.Fprintln(os.Stderr, "  stream-url <track url>                     print the hls stream url of a track")
+	fmt.Fprintln(os.Stderr, "  download <track url> [file]                download a track as mp3 (- for stdout)")
+	fmt.Fprintln(os.Stderr, "  search [-type tracks|users|playlists] [-limit n] [-offset n] <query>")
+	fmt.Fprintln(os.Stderr, "  export <playlist or user url> [directory]  save a playlist/user as static html + mp3 files")
+}
+
+func logStderr(s string) {
+	fmt.Fprintln(os.Stderr, s)
+}
+
+func printJSON(v any) int {
+	data, err := cfg.JSON.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode json: %s\n", err)
+		return 1
+	}
+
+	os.Stdout.Write(append(data, '\n'))
+	return 0
+}
+
+func fail(what string, err error) int {
+	fmt.Fprintf(os.Stderr, "%s failed: %s\n", what, err)
+	return 1
+}
+
+func resolve(raw string) int {
+	var res map[string]any
+	err := sc.Resolve(export.Permalink(raw), &res)
+	if err != nil {
+		return fail("resolve", err)
+	}
+
+	return printJSON(res)
+}
+
+type resolveOutput struct {
+	sc.ResolveResult
+	Error string `json:"error,omitempty"`
+}
+
+// exits with 1 if any of them failed, the rest is still printed
+func resolveMany(urls []string) int {
+	code := 0
+	out := []resolveOutput{}
+	for _, r := range sc.ResolveMany(urls) {
+		o := resolveOutput{ResolveResult: r}
+		if r.Err != nil {
+			o.Error = r.Err.Error()
+			code = 1
+		}
+
+		out = append(out, o)
+	}
+
+	if printJSON(out) != 0 {
+		return 1
+	}
+
+	return code
+}
+
+func streamURL(raw string) int {
+	t, err := sc.GetArbitraryTrack(raw)
+	if err != nil {
+		return fail("resolve", err)
+	}
+
+	stream, err := t.GetStream()
+	if err != nil {
+		return fail("getting stream", err)
+	}
+
+	return printJSON(map[string]string{"url": stream})
+}
+
+func downloadTrack(raw string, file string) int {
+	t, err := sc.GetArbitraryTrack(raw)
+	if err != nil {
+		return fail("resolve", err)
+	}
+
+	if file == "" {
+		file = download.Filename(t)
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return fail("download", err)
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	err = download.Track(t, w)
+	if err != nil {
+		return fail("download", err)
+	}
+
+	if file != "-" {
+		return printJSON(map[string]string{"file": file})
+	}
+
+	return 0
+}
+
+func search(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	kind := fs.String("type", "tracks", "tracks, users or playlists")
+	limit := fs.Int("limit", sc.DefaultLimit, "results per page (max 200)")
+	offset := fs.Int("offset", 0, "skip this many results")
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		usage()
+		return 2
+	}
+
+	q := strings.Join(fs.Args(), " ")
+	pg := sc.NewPage(*limit, *offset)
+	var res any
+	var err error
+	switch *kind {
+	case "tracks":
+		res, err = sc.SearchTracks(q, pg)
+	case "users":
+		res, err = sc.SearchUsers(q, pg)
+	case "playlists":
+		res, err = sc.SearchPlaylists(q, pg)
+	default:
+		usage()
+		return 2
+	}
+
+	if err != nil {
+		return fail("search", err)
+	}
+
+	return printJSON(res)
+}
+
+// returns exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "resolve":
+		if len(args) < 2 {
+			break
+		}
+
+		if len(args) > 2 {
+			return resolveMany(args[1:])
+		}
+
+		return resolve(args[1])
+	case "stream-url":
+		if len(args) < 2 {
+			break
+		}
+
+		return streamURL(args[1])
+	case "download":
+		if len(args) < 2 {
+			break
+		}
+
+		file := ""
+		if len(args) > 2 {
+			file = args[2]
+		}
+
+		return downloadTrack(args[1], file)
+	case "search":
+		return search(args[1:])
+	case "export":
+		if len(args) < 2 {
+			break
+		}
+
+		dir := path.Base(export.Permalink(args[1]))
+		if len(args) > 2 {
+			dir = args[2]
+		}
+
+		err := export.Export(args[1], dir, logStderr)
+		if err != nil {
+			return fail("export", err)
+		}
+
+		return printJSON(map[string]string{"directory": dir})
+	case "help", "-h", "--help":
+		usage()
+		return 0
+	}
+
+	usage()
+	return 2
+}
@@ -0,0 +1,210 @@
+package accounts
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Optional accounts, to have the same favorites, preferences and local playlists on every device
+// they don't replace the cookies: logging in sets the favorites/preferences cookies to the account's values,
+// and changes made in a logged in browser are copied back to the account
+
+const cookie = "session"
+
+// username.token
+func session(c *fiber.Ctx) (string, string) {
+	username, token, _ := strings.Cut(c.Cookies(cookie), ".")
+	return username, token
+}
+
+func current(c *fiber.Ctx) (Account, bool) {
+	if !cfg.Get().Features.EnableAccounts || c.Cookies(cookie) == "" {
+		return Account{}, false
+	}
+
+	a, err := Session(session(c))
+	if err != nil {
+		if err != ErrNotFound {
+			log.Printf("error getting session: %s\n", err)
+		}
+
+		return Account{}, false
+	}
+
+	return a, true
+}
+
+// remembers a local playlist for the logged in account, if there is one
+func AddPlaylist(c *fiber.Ctx, id string, title string) {
+	a, ok := current(c)
+	if !ok {
+		return
+	}
+
+	err := update(a.Username, func(a *Account) {
+		a.Playlists = append(a.Playlists, Playlist{ID: id, Title: title})
+	})
+	if err != nil {
+		log.Printf("error saving %s playlists: %s\n", a.Username, err)
+	}
+}
+
+// the local playlists of the logged in account, nil without one
+func Playlists(c *fiber.Ctx) []Playlist {
+	a, ok := current(c)
+	if !ok {
+		return nil
+	}
+
+	return a.Playlists
+}
+
+func setCookie(c *fiber.Ctx, name string, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HTTPOnly: true,
+		SameSite: "Lax",
+		Secure:   c.Protocol() == "https",
+	})
+
+	// so this request already sees it
+	c.Request().Header.SetCookie(name, value)
+}
+
+func clearCookie(c *fiber.Ctx, name string) {
+	c.Cookie(&fiber.Cookie{Name: name, Path: "/", Expires: time.Unix(0, 0), MaxAge: -1})
+	c.Request().Header.DelCookie(name)
+}
+
+// account values win when logging in, what's in the browser wins afterwards
+func syncCookies(c *fiber.Ctx, a Account, login bool) {
+	expires := time.Now().Add(365 * 24 * time.Hour)
+	changed := false
+
+	for _, v := range []struct {
+		cookie string
+		value  *string
+	}{{favorites.Cookie, &a.Favorites}, {preferences.Cookie, &a.Preferences}} {
+		browser := c.Cookies(v.cookie)
+		switch {
+		case browser == *v.value:
+		case *v.value == "" || (!login && browser != ""):
+			*v.value = browser
+			changed = true
+		default:
+			setCookie(c, v.cookie, *v.value, expires)
+		}
+	}
+
+	if changed {
+		err := update(a.Username, func(acc *Account) {
+			acc.Favorites = a.Favorites
+			acc.Preferences = a.Preferences
+		})
+		if err != nil {
+			log.Printf("error saving %s: %s\n", a.Username, err)
+		}
+	}
+}
+
+func login(c *fiber.Ctx, username string, password string) error {
+	token, err := Login(username, password)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Redirect("/account?msg=wrong", fiber.StatusSeeOther)
+		}
+
+		log.Printf("error logging in %s: %s\n", username, err)
+		return err
+	}
+
+	a, err := Get(username)
+	if err != nil {
+		return err
+	}
+
+	setCookie(c, cookie, username+"."+token, time.Now().Add(cfg.Get().SessionTTL))
+	syncCookies(c, a, true)
+	return c.Redirect("/account", fiber.StatusSeeOther)
+}
+
+func Load(r fiber.Router) {
+	r.Use(func(c *fiber.Ctx) error {
+		if a, ok := current(c); ok {
+			syncCookies(c, a, false)
+		}
+
+		return c.Next()
+	})
+
+	r.Use("/account", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableAccounts {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}, csrf.Check) // a forged login form would log someone into the attacker's account
+
+	r.Get("/account", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/html")
+		a, ok := current(c)
+		if !ok {
+			return templates.Base("account", templates.AccountLogin(c.Query("msg")), nil).Render(preferences.Context(c), c)
+		}
+
+		pl := make([]templates.AccountPlaylist, len(a.Playlists))
+		for i, p := range a.Playlists {
+			pl[i] = templates.AccountPlaylist{ID: p.ID, Title: p.Title}
+		}
+
+		return templates.Base("account", templates.Account(a.Username, pl), nil).Render(preferences.Context(c), c)
+	})
+
+	r.Post("/account/register", func(c *fiber.Ctx) error {
+		username := strings.ToLower(c.FormValue("username"))
+		password := c.FormValue("password")
+		err := Register(username, password)
+		switch err {
+		case nil:
+		case ErrBadUsername, ErrShortPassword, ErrExists:
+			return c.Redirect("/account?msg="+url.QueryEscape(err.Error()), fiber.StatusSeeOther)
+		default:
+			log.Printf("error registering %s: %s\n", username, err)
+			return err
+		}
+
+		return login(c, username, password)
+	})
+
+	r.Post("/account/login", func(c *fiber.Ctx) error {
+		return login(c, strings.ToLower(c.FormValue("username")), c.FormValue("password"))
+	})
+
+	// the favorites and preferences stay with the account, not on a (maybe shared) device
+	r.Post("/account/logout", func(c *fiber.Ctx) error {
+		if a, ok := current(c); ok {
+			_, token := session(c)
+			err := Logout(a.Username, token)
+			if err != nil {
+				log.Printf("error logging out %s: %s\n", a.Username, err)
+			}
+		}
+
+		clearCookie(c, cookie)
+		clearCookie(c, favorites.Cookie)
+		clearCookie(c, preferences.Cookie)
+		return c.Redirect("/account", fiber.StatusSeeOther)
+	})
+}
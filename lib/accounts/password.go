@@ -0,0 +1,89 @@
+package accounts
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Password hashes: pbkdf2 with hmac-sha256 (RFC 8018), stored as pbkdf2-sha256$<iterations>$<salt>$<hash>
+// the iterations are part of the hash, so they can be raised later without breaking old accounts
+
+const iterations = 600_000 // OWASP recommendation for pbkdf2-sha256
+const keyLen = 32
+
+var ErrBadHash = errors.New("malformed password hash")
+
+// hashing is slow on purpose, don't let a flood of logins eat every cpu
+var hashing = make(chan struct{}, 4)
+
+func pbkdf2(password []byte, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	// keyLen is the size of one sha256 block, so only one block is needed
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := prf.Sum(nil)
+
+	t := make([]byte, len(u))
+	copy(t, u)
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+
+	return t[:keyLen]
+}
+
+func hashPassword(password string) string {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		panic(err)
+	}
+
+	hashing <- struct{}{}
+	h := pbkdf2([]byte(password), salt, iterations)
+	<-hashing
+
+	enc := base64.RawStdEncoding
+	return "pbkdf2-sha256$" + strconv.Itoa(iterations) + "$" + enc.EncodeToString(salt) + "$" + enc.EncodeToString(h)
+}
+
+func checkPassword(hash string, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false, ErrBadHash
+	}
+
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false, ErrBadHash
+	}
+
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false, ErrBadHash
+	}
+
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false, ErrBadHash
+	}
+
+	hashing <- struct{}{}
+	got := pbkdf2([]byte(password), salt, iter)
+	<-hashing
+
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -0,0 +1,46 @@
+package accounts
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// RFC 7914 section 11, pbkdf2 only gives the first keyLen bytes of dkLen=64
+func TestPBKDF2(t *testing.T) {
+	for _, tc := range []struct {
+		password, salt string
+		iter           int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	} {
+		got := hex.EncodeToString(pbkdf2([]byte(tc.password), []byte(tc.salt), tc.iter))
+		if got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tc.password, tc.salt, tc.iter, got, tc.want)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	hash := hashPassword("correct horse")
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("unexpected hash format %s", hash)
+	}
+
+	ok, err := checkPassword(hash, "correct horse")
+	if err != nil || !ok {
+		t.Errorf("right password rejected (%v)", err)
+	}
+
+	ok, err = checkPassword(hash, "battery staple")
+	if err != nil || ok {
+		t.Errorf("wrong password accepted (%v)", err)
+	}
+
+	_, err = checkPassword("sha256$abc", "correct horse")
+	if err != ErrBadHash {
+		t.Errorf("malformed hash gave %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package accounts
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Accounts stored as json files in cfg.DataDir/accounts, one per username
+
+var ErrNotFound = errors.New("account not found")
+var ErrExists = errors.New("username is taken")
+var ErrBadUsername = errors.New("usernames are 3 to 32 characters: a-z, 0-9, - and _")
+var ErrShortPassword = errors.New("passwords need at least 8 characters")
+
+// sessions per account, the oldest one is dropped after this
+const maxSessions = 20
+
+type Playlist struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type Account struct {
+	Username string    `json:"username"`
+	Hash     string    `json:"hash"`
+	Created  time.Time `json:"created"`
+
+	// synced to the browsers the account is logged in on, these are the cookie values
+	Favorites   string `json:"favorites"` // key of the favorites
+	Preferences string `json:"preferences"`
+
+	Playlists []Playlist `json:"playlists"` // local playlists imported while logged in
+
+	Sessions map[string]time.Time `json:"sessions"` // sha256 of the token -> expiry
+}
+
+func dir() string {
+	return filepath.Join(cfg.Get().DataDir, "accounts")
+}
+
+func validUsername(u string) bool {
+	if len(u) < 3 || len(u) > 32 {
+		return false
+	}
+
+	for _, c := range u {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+var lock = &sync.Mutex{}
+
+func load(username string) (Account, error) {
+	if !validUsername(username) {
+		return Account{}, ErrNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir(), username+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Account{}, ErrNotFound
+		}
+
+		return Account{}, err
+	}
+
+	var a Account
+	err = cfg.JSON.Unmarshal(data, &a)
+	return a, err
+}
+
+func save(a Account) error {
+	data, err := cfg.JSON.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(filepath.Join(dir(), a.Username+".json"), data, 0o700)
+}
+
+func Get(username string) (Account, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return load(username)
+}
+
+func update(username string, f func(a *Account)) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	a, err := load(username)
+	if err != nil {
+		return err
+	}
+
+	f(&a)
+	return save(a)
+}
+
+func Register(username string, password string) error {
+	if !validUsername(username) {
+		return ErrBadUsername
+	}
+
+	if len(password) < 8 {
+		return ErrShortPassword
+	}
+
+	// outside of the lock, it takes a while
+	hash := hashPassword(password)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := load(username); err != ErrNotFound {
+		if err == nil {
+			return ErrExists
+		}
+
+		return err
+	}
+
+	return save(Account{Username: username, Hash: hash, Created: time.Now().UTC(), Sessions: map[string]time.Time{}})
+}
+
+// returns a session token, ErrNotFound for a wrong username or password
+func Login(username string, password string) (string, error) {
+	a, err := Get(username)
+	if err != nil {
+		// hash anyway, so the timing doesn't tell which usernames exist
+		checkPassword(dummyHash(), password)
+		return "", err
+	}
+
+	ok, err := checkPassword(a.Hash, password)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	token := newToken()
+	err = update(username, func(a *Account) {
+		if a.Sessions == nil {
+			a.Sessions = map[string]time.Time{}
+		}
+
+		now := time.Now()
+		for k, exp := range a.Sessions {
+			if exp.Before(now) {
+				delete(a.Sessions, k)
+			}
+		}
+
+		if len(a.Sessions) >= maxSessions {
+			keys := make([]string, 0, len(a.Sessions))
+			for k := range a.Sessions {
+				keys = append(keys, k)
+			}
+			sort.Slice(keys, func(i, j int) bool { return a.Sessions[keys[i]].Before(a.Sessions[keys[j]]) })
+			for _, k := range keys[:len(keys)-maxSessions+1] {
+				delete(a.Sessions, k)
+			}
+		}
+
+		a.Sessions[tokenHash(token)] = now.Add(cfg.Get().SessionTTL)
+	})
+
+	return token, err
+}
+
+var dummyHash = sync.OnceValue(func() string { return hashPassword("not a real password") })
+
+// the account of a session, ErrNotFound when it expired or doesn't exist
+func Session(username string, token string) (Account, error) {
+	a, err := Get(username)
+	if err != nil {
+		return a, err
+	}
+
+	exp, ok := a.Sessions[tokenHash(token)]
+	if !ok || exp.Before(time.Now()) {
+		return Account{}, ErrNotFound
+	}
+
+	return a, nil
+}
+
+func Logout(username string, token string) error {
+	return update(username, func(a *Account) {
+		delete(a.Sessions, tokenHash(token))
+	})
+}
@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"log"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Commenting, liking, reposting and following from the instance, as the account of cfg.OAuthToken. anyone who can use the instance can do this!
+
+const maxComment = 1000
+
+func enabled(c *fiber.Ctx) error {
+	if !cfg.Get().Features.EnableActions || cfg.Get().OAuthToken == "" {
+		return fiber.ErrNotFound
+	}
+
+	return c.Next()
+}
+
+func follow(c *fiber.Ctx, follow bool) error {
+	u, err := sc.GetUser(c.FormValue("user"))
+	if err != nil {
+		log.Printf("error getting %s (follow): %s\n", c.FormValue("user"), err)
+		return err
+	}
+
+	if follow {
+		err = u.Follow()
+	} else {
+		err = u.Unfollow()
+	}
+
+	if err != nil {
+		log.Printf("error following/unfollowing %s: %s\n", u.Permalink, err)
+		return err
+	}
+
+	return c.Redirect("/"+u.Permalink, fiber.StatusSeeOther)
+}
+
+func Load(r fiber.Router) {
+	// there are no credentials involved, but other sites still shouldn't be able to make visitors post things
+	g := r.Group("/_/actions", enabled, csrf.Check)
+
+	// user= (permalink)
+	g.Post("/follow", func(c *fiber.Ctx) error {
+		return follow(c, true)
+	})
+
+	g.Post("/unfollow", func(c *fiber.Ctx) error {
+		return follow(c, false)
+	})
+
+	// track= (id) for the rest
+	g.Post("/:action", func(c *fiber.Ctx) error {
+		t, err := sc.GetTrackByID(c.FormValue("track"))
+		if err != nil {
+			log.Printf("error getting %s (%s): %s\n", c.FormValue("track"), c.Params("action"), err)
+			return err
+		}
+
+		switch c.Params("action") {
+		case "comment":
+			body := c.FormValue("body")
+			if body == "" || utf8.RuneCountInString(body) > maxComment {
+				return fiber.ErrBadRequest
+			}
+
+			at, _ := strconv.ParseFloat(c.FormValue("at"), 64) // seconds, 0 if not set
+			_, err = t.Comment(body, time.Duration(at*float64(time.Second)))
+		case "like":
+			err = t.Like()
+		case "unlike":
+			err = t.Unlike()
+		case "repost":
+			err = t.Repost()
+		case "unrepost":
+			err = t.Unrepost()
+		default:
+			return fiber.ErrNotFound
+		}
+
+		if err != nil {
+			log.Printf("error doing %s on %s: %s\n", c.Params("action"), t.Permalink, err)
+			return err
+		}
+
+		return c.Redirect("/"+t.Author.Permalink+"/"+t.Permalink, fiber.StatusSeeOther)
+	})
+}
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/basicauth"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Protected status dashboard for instance operators
+
+func status() templates.AdminStatus {
+	var s templates.AdminStatus
+	s.ClientIDVersion, s.ClientIDNextCheck = sc.ClientIDInfo()
+	s.Caches = sc.CacheStats()
+	s.Streams = proxystreams.CacheStatus()
+	s.UpstreamRequests, s.UpstreamErrors = sc.UpstreamStats()
+	s.InFlight = proxystreams.InFlight()
+	s.Coalesced = proxystreams.Coalesced()
+	s.Bandwidth = bandwidth.GetStats(10)
+	s.Debug = cfg.Get().AdminDebug
+	s.Drift = sc.SchemaDrift()
+
+	return s
+}
+
+// accepts both "user/track" and "https://soundcloud.com/user/track"
+func permalink(c *fiber.Ctx) string {
+	p := c.FormValue("permalink", c.Query("permalink"))
+	p = strings.TrimPrefix(p, "https://")
+	p = strings.TrimPrefix(p, "soundcloud.com")
+
+	return strings.Trim(p, "/")
+}
+
+// set when there's a separate listener for the dashboard (cfg.AdminAddr)
+var separate bool
+
+type adminListener struct{ net.Listener }
+type adminConn struct{ net.Conn }
+
+func (l adminListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return adminConn{conn}, nil
+}
+
+// after this, the dashboard is only served on connections accepted by the returned listener
+func Listener(ln net.Listener) net.Listener {
+	separate = true
+	return adminListener{ln}
+}
+
+func onAdminListener(c *fiber.Ctx) error {
+	if _, ok := c.Context().Conn().(adminConn); separate && !ok {
+		return fiber.ErrNotFound
+	}
+
+	return c.Next()
+}
+
+func Load(r fiber.Router) {
+	if cfg.Get().AdminPassword == "" {
+		return
+	}
+
+	g := r.Group("/admin", onAdminListener, basicauth.New(basicauth.Config{
+		Users: map[string]string{cfg.Get().AdminUser: cfg.Get().AdminPassword},
+		Realm: "soundcloak admin",
+	}), csrf.Check) // basic auth credentials are sent automatically by the browser, so other sites can't submit our forms
+
+	g.Get("/", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/html")
+		return templates.Base("admin", templates.Admin(status(), c.Query("msg")), nil).Render(preferences.Context(c), c)
+	})
+
+	g.Post("/flush", func(c *fiber.Ctx) error {
+		sc.FlushCaches()
+		return c.Redirect("/admin?msg=flushed+entity+caches")
+	})
+
+	g.Post("/flush-streams", func(c *fiber.Ctx) error {
+		proxystreams.FlushCache()
+		return c.Redirect("/admin?msg=flushed+stream+cache")
+	})
+
+	g.Post("/purge", func(c *fiber.Ctx) error {
+		n := sc.Purge(permalink(c))
+		return c.Redirect("/admin?msg=purged+" + strconv.Itoa(n) + "+entries")
+	})
+
+	// json api, for scripts
+
+	g.Get("/api/cache", func(c *fiber.Ctx) error {
+		return c.JSON(sc.CacheKeys())
+	})
+
+	g.Get("/api/bandwidth", func(c *fiber.Ctx) error {
+		return c.JSON(bandwidth.GetStats(100))
+	})
+
+	g.Post("/api/purge", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"removed": sc.Purge(permalink(c))})
+	})
+
+	g.Post("/refresh-clientid", func(c *fiber.Ctx) error {
+		_, err := sc.RefreshClientID()
+		if err != nil {
+			log.Printf("error refreshing client id: %s\n", err)
+			return c.Redirect("/admin?msg=failed+to+refresh+client+id")
+		}
+
+		return c.Redirect("/admin?msg=refreshed+client+id")
+	})
+
+	if cfg.Get().AdminDebug {
+		loadDebug(g)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Output formats: compact json (default), pretty json, jsonp (?callback=) and msgpack
+// picked with ?format=, or the Accept header when there's none (application/msgpack)
+
+const (
+	formatJSON    = "json"
+	formatPretty  = "pretty"
+	formatJSONP   = "jsonp"
+	formatMsgpack = "msgpack"
+
+	mimeMsgpack = "application/msgpack"
+)
+
+// plain (dotted) js identifiers only, anything else could be used to inject code
+var callbackName = regexp.MustCompile(`^[A-Za-z_$][\w$.]{0,63}$`)
+
+func format(c *fiber.Ctx) string {
+	c.Vary(fiber.HeaderAccept) // responses are cacheable (lib/httpcache)
+	if f := c.Query("format"); f != "" {
+		return f
+	}
+
+	switch c.Accepts(fiber.MIMEApplicationJSON, mimeMsgpack, "application/x-msgpack") {
+	case mimeMsgpack, "application/x-msgpack":
+		return formatMsgpack
+	}
+
+	return formatJSON
+}
+
+// c.JSON, in the format the client asked for
+func send(c *fiber.Ctx, v any) error {
+	switch format(c) {
+	case formatJSON:
+		return c.JSON(v)
+	case formatPretty:
+		data, err := cfg.JSON.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.Send(data)
+	case formatJSONP:
+		cb := c.Query("callback", "callback")
+		if !callbackName.MatchString(cb) {
+			return fiber.ErrBadRequest
+		}
+
+		data, err := cfg.JSON.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMETextJavaScriptCharsetUTF8)
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		// the comment keeps the response from starting with user controlled bytes
+		return c.SendString("/**/" + cb + "(" + string(data) + ");")
+	case formatMsgpack:
+		data, err := marshalMsgpack(v)
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, mimeMsgpack)
+		return c.Send(data)
+	}
+
+	return fiber.ErrBadRequest
+}
@@ -0,0 +1,454 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// GraphQL parser, for /graphql (schema and execution are in graphql_schema.go)
+// queries only: fields, arguments, aliases, variables (with defaults), fragments and inline fragments
+// no mutations, subscriptions, directives or introspection
+
+type variable string
+
+type selection struct {
+	alias string
+	name  string
+	args  map[string]any // literals, with variable for $refs
+	sel   []selection
+
+	spread string // ...Name
+	inline bool   // ... on Type { }
+	on     string
+}
+
+// the name it has in the response
+func (s selection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+
+	return s.name
+}
+
+type varDef struct {
+	name     string
+	required bool // Type!
+	def      any
+	hasDef   bool
+}
+
+type operation struct {
+	name string
+	vars []varDef
+	sel  []selection
+}
+
+type fragment struct {
+	on  string
+	sel []selection
+}
+
+type document struct {
+	ops       []operation
+	fragments map[string]fragment
+}
+
+type token struct {
+	kind byte // one of the punctuators, 'n' (name), 'i' (int), 'f' (float), 's' (string), 0 at the end
+	val  string
+	pos  int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+	err error
+}
+
+var errUnexpectedEnd = errors.New("unexpected end of query")
+
+func (p *parser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("syntax error at %d: %s", p.tok.pos, fmt.Sprintf(format, args...))
+	}
+	p.tok = token{}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+
+	// whitespace, commas and comments don't mean anything
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			break
+		}
+	}
+
+	start := p.pos
+	p.tok = token{pos: start}
+	if p.pos == len(p.src) {
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte("{}()[]:!$=@", c) != -1:
+		p.pos++
+		p.tok.kind = c
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok.kind = '.'
+	case isNameStart(c):
+		for p.pos < len(p.src) && (isNameStart(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok.kind = 'n'
+		p.tok.val = p.src[start:p.pos]
+	case c == '-' || isDigit(c):
+		p.tok.kind = 'i'
+		p.pos++
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c == '.' || c == 'e' || c == 'E' || ((c == '+' || c == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E')) {
+				p.tok.kind = 'f'
+			} else if !isDigit(c) {
+				break
+			}
+			p.pos++
+		}
+		p.tok.val = p.src[start:p.pos]
+	case c == '"':
+		p.str()
+	default:
+		p.fail("unexpected character %q", c)
+	}
+}
+
+// "..." with json-like escapes, block strings aren't supported
+func (p *parser) str() {
+	var b strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			p.tok.kind = 's'
+			p.tok.val = b.String()
+			return
+		case c == '\n':
+			p.fail("unterminated string")
+			return
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u':
+				if p.pos+4 >= len(p.src) {
+					p.fail("bad unicode escape")
+					return
+				}
+
+				r, err := strconv.ParseUint(p.src[p.pos+1:p.pos+5], 16, 32)
+				if err != nil {
+					p.fail("bad unicode escape")
+					return
+				}
+				b.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				b.WriteByte(e) // \" \\ \/
+			}
+			p.pos++
+		default:
+			_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			b.WriteString(p.src[p.pos : p.pos+size])
+			p.pos += size
+		}
+	}
+
+	p.fail("unterminated string")
+}
+
+func (p *parser) expect(kind byte) string {
+	if p.tok.kind != kind {
+		if p.tok.kind == 0 && p.err == nil {
+			p.err = errUnexpectedEnd
+		}
+		if kind == 'n' {
+			p.fail("expected a name")
+		} else {
+			p.fail("expected %q", kind)
+		}
+		return ""
+	}
+
+	v := p.tok.val
+	p.next()
+	return v
+}
+
+func (p *parser) name() string {
+	return p.expect('n')
+}
+
+func (p *parser) keyword(k string) bool {
+	if p.tok.kind == 'n' && p.tok.val == k {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) value(constant bool) any {
+	t := p.tok
+	switch t.kind {
+	case '$':
+		if constant {
+			p.fail("variables aren't allowed here")
+			return nil
+		}
+		p.next()
+		return variable(p.name())
+	case 'i':
+		p.next()
+		i, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			p.fail("bad int %s", t.val)
+		}
+		return i
+	case 'f':
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			p.fail("bad float %s", t.val)
+		}
+		return f
+	case 's':
+		p.next()
+		return t.val
+	case 'n':
+		p.next()
+		switch t.val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return t.val // enum values are passed as strings
+	case '[':
+		p.next()
+		list := []any{}
+		for p.tok.kind != ']' && p.err == nil {
+			list = append(list, p.value(constant))
+		}
+		p.expect(']')
+		return list
+	case '{':
+		p.next()
+		obj := map[string]any{}
+		for p.tok.kind != '}' && p.err == nil {
+			k := p.name()
+			p.expect(':')
+			obj[k] = p.value(constant)
+		}
+		p.expect('}')
+		return obj
+	}
+
+	p.fail("expected a value")
+	return nil
+}
+
+func (p *parser) directives() {
+	if p.tok.kind == '@' {
+		p.fail("directives aren't supported")
+	}
+}
+
+func (p *parser) selectionSet() []selection {
+	p.expect('{')
+	var res []selection
+	for p.tok.kind != '}' && p.err == nil {
+		if p.tok.kind == '.' {
+			p.next()
+			if p.tok.kind == 'n' && p.tok.val != "on" {
+				res = append(res, selection{spread: p.name()})
+				p.directives()
+				continue
+			}
+
+			s := selection{inline: true}
+			if p.keyword("on") {
+				s.on = p.name()
+			}
+			p.directives()
+			s.sel = p.selectionSet()
+			res = append(res, s)
+			continue
+		}
+
+		s := selection{name: p.name()}
+		if p.tok.kind == ':' {
+			p.next()
+			s.alias = s.name
+			s.name = p.name()
+		}
+
+		if p.tok.kind == '(' {
+			p.next()
+			s.args = map[string]any{}
+			for p.tok.kind != ')' && p.err == nil {
+				k := p.name()
+				p.expect(':')
+				s.args[k] = p.value(false)
+			}
+			p.expect(')')
+		}
+
+		p.directives()
+		if p.tok.kind == '{' {
+			s.sel = p.selectionSet()
+		}
+		res = append(res, s)
+	}
+	p.expect('}')
+
+	if len(res) == 0 && p.err == nil {
+		p.fail("empty selection set")
+	}
+
+	return res
+}
+
+// [Type!]! and such, only whether it's required matters here
+func (p *parser) typeRef() bool {
+	if p.tok.kind == '[' {
+		p.next()
+		p.typeRef()
+		p.expect(']')
+	} else {
+		p.name()
+	}
+
+	if p.tok.kind == '!' {
+		p.next()
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) operation() operation {
+	var op operation
+	if p.tok.kind == '{' {
+		op.sel = p.selectionSet()
+		return op
+	}
+
+	switch kind := p.name(); kind {
+	case "query":
+	case "mutation", "subscription":
+		p.fail("%ss aren't supported", kind)
+		return op
+	default:
+		p.fail("unknown operation %q", kind)
+		return op
+	}
+
+	if p.tok.kind == 'n' {
+		op.name = p.name()
+	}
+
+	if p.tok.kind == '(' {
+		p.next()
+		for p.tok.kind != ')' && p.err == nil {
+			p.expect('$')
+			v := varDef{name: p.name()}
+			p.expect(':')
+			v.required = p.typeRef()
+			if p.tok.kind == '=' {
+				p.next()
+				v.def = p.value(true)
+				v.hasDef = true
+			}
+			op.vars = append(op.vars, v)
+		}
+		p.expect(')')
+	}
+
+	p.directives()
+	op.sel = p.selectionSet()
+	return op
+}
+
+func parseQuery(src string) (*document, error) {
+	p := &parser{src: strings.TrimPrefix(src, "\ufeff")}
+	p.next()
+
+	doc := &document{fragments: map[string]fragment{}}
+	for p.tok.kind != 0 && p.err == nil {
+		if p.keyword("fragment") {
+			name := p.name()
+			if !p.keyword("on") {
+				p.fail("expected on")
+				break
+			}
+
+			f := fragment{on: p.name()}
+			p.directives()
+			f.sel = p.selectionSet()
+			if _, ok := doc.fragments[name]; ok {
+				p.fail("fragment %s is defined twice", name)
+			}
+			doc.fragments[name] = f
+			continue
+		}
+
+		doc.ops = append(doc.ops, p.operation())
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	if len(doc.ops) == 0 {
+		return nil, errors.New("no operations in query")
+	}
+
+	return doc, nil
+}
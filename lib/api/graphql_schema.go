@@ -0,0 +1,580 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/botguard"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// /graphql: User, Track, Playlist, Search and streams in one request, with only the fields that were asked for
+// plain fields come from the json of the lib/sc structures (same names as the json api), the ones below make requests
+//
+//	{ track(url: "https://soundcloud.com/floppa/floppa-theme") { title user { username followers_count } stream { url } } }
+
+// requests to soundcloud one query can cause, search { tracks { collection { user { tracks } } } } would be a lot otherwise
+const maxFetches = 20
+
+// how deep selections can go
+const maxDepth = 10
+
+var errTooManyFetches = fmt.Errorf("query needs more than %d requests to soundcloud, split it up", maxFetches)
+
+type resolver func(v any, args map[string]any) (any, error)
+
+type field struct {
+	typ     string // object type of the result (a single one or a list), empty for plain json values
+	fetches bool   // counts towards maxFetches
+	guarded bool   // needs a solved proof-of-work, like the search pages (lib/botguard)
+	resolve resolver
+}
+
+type object struct {
+	fields map[string]field
+	plain  map[string]bool // json fields of the value
+}
+
+// json names of the fields of a struct type, including embedded ones
+func jsonFields(t reflect.Type, res map[string]bool) map[string]bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			jsonFields(f.Type, res)
+			continue
+		}
+
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		res[name] = true
+	}
+
+	return res
+}
+
+func newObject(v any, fields map[string]field) object {
+	return object{fields: fields, plain: jsonFields(reflect.TypeOf(v), map[string]bool{})}
+}
+
+func asTrack(v any) sc.Track {
+	if t, ok := v.(*sc.Track); ok {
+		return *t
+	}
+
+	t, _ := v.(sc.Track)
+	return t
+}
+
+func asUser(v any) sc.User {
+	if u, ok := v.(*sc.User); ok {
+		return *u
+	}
+
+	u, _ := v.(sc.User)
+	return u
+}
+
+func asPlaylist(v any) sc.Playlist {
+	if p, ok := v.(*sc.Playlist); ok {
+		return *p
+	}
+
+	p, _ := v.(sc.Playlist)
+	return p
+}
+
+func stringArg(args map[string]any, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func requiredArg(args map[string]any, name string) (string, error) {
+	s := stringArg(args, name)
+	if s == "" {
+		return "", fmt.Errorf("argument %q is required", name)
+	}
+
+	return s, nil
+}
+
+func intArg(args map[string]any, name string) int {
+	switch v := args[name].(type) {
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
+
+func pageArg(args map[string]any) sc.Page {
+	return sc.NewPage(intArg(args, "limit"), intArg(args, "offset"))
+}
+
+// for the methods on User, only the first page
+func cursorArg(args map[string]any) sc.Cursor {
+	return sc.NewCursor(intArg(args, "limit"), "")
+}
+
+type search struct {
+	q  string
+	pg sc.Page
+}
+
+var schema map[string]object
+
+func init() {
+	page := func(of string) map[string]field {
+		return map[string]field{"collection": {typ: of, resolve: func(v any, _ map[string]any) (any, error) {
+			return reflect.Indirect(reflect.ValueOf(v)).FieldByName("Collection").Interface(), nil
+		}}}
+	}
+
+	user := field{typ: "User", resolve: func(v any, _ map[string]any) (any, error) {
+		switch v := v.(type) {
+		case sc.Track, *sc.Track:
+			return asTrack(v).Author, nil
+		}
+		return asPlaylist(v).Author, nil
+	}}
+
+	userPlaylists := func(get func(u *sc.User, c sc.Cursor) (*sc.Paginated[sc.Playlist], error)) field {
+		return field{typ: "PlaylistPage", fetches: true, resolve: func(v any, args map[string]any) (any, error) {
+			u := asUser(v)
+			return get(&u, cursorArg(args))
+		}}
+	}
+
+	schema = map[string]object{
+		"Query": {fields: map[string]field{
+			"user": {typ: "User", fetches: true, resolve: func(_ any, args map[string]any) (any, error) {
+				permalink, err := requiredArg(args, "permalink")
+				if err != nil {
+					return nil, err
+				}
+
+				return sc.GetUser(permalink)
+			}},
+			"track": {typ: "Track", fetches: true, resolve: func(_ any, args map[string]any) (any, error) {
+				if id := stringArg(args, "id"); id != "" {
+					return sc.GetTrackByID(id)
+				}
+
+				u, err := requiredArg(args, "url")
+				if err != nil {
+					return nil, err
+				}
+
+				return sc.GetArbitraryTrack(u)
+			}},
+			"playlist": {typ: "Playlist", fetches: true, resolve: func(_ any, args map[string]any) (any, error) {
+				permalink, err := requiredArg(args, "permalink") // user/sets/playlist
+				if err != nil {
+					return nil, err
+				}
+
+				return sc.GetPlaylist(permalink)
+			}},
+			"search": {typ: "Search", guarded: true, resolve: func(_ any, args map[string]any) (any, error) {
+				if !cfg.Get().Features.EnableSearch {
+					return nil, errors.New("search is disabled on this instance")
+				}
+
+				q, err := requiredArg(args, "q")
+				if err != nil {
+					return nil, err
+				}
+
+				return search{q: q, pg: pageArg(args)}, nil
+			}},
+		}},
+
+		"Search": {fields: map[string]field{
+			"tracks": {typ: "TrackPage", fetches: true, resolve: func(v any, args map[string]any) (any, error) {
+				s := v.(search)
+				license := stringArg(args, "license")
+				if license != "" && !sc.ValidLicenseFilter(license) {
+					return nil, fmt.Errorf("unknown license filter %q", license)
+				}
+
+				return sc.SearchTracksByLicense(s.q, license, s.pg)
+			}},
+			"users": {typ: "UserPage", fetches: true, resolve: func(v any, _ map[string]any) (any, error) {
+				s := v.(search)
+				return sc.SearchUsers(s.q, s.pg)
+			}},
+			"playlists": {typ: "PlaylistPage", fetches: true, resolve: func(v any, _ map[string]any) (any, error) {
+				s := v.(search)
+				return sc.SearchPlaylists(s.q, s.pg)
+			}},
+		}},
+
+		"User": newObject(sc.User{}, map[string]field{
+			"tracks": {typ: "TrackPage", fetches: true, resolve: func(v any, args map[string]any) (any, error) {
+				return asUser(v).GetTracks(cursorArg(args))
+			}},
+			"playlists":       userPlaylists((*sc.User).GetPlaylists),
+			"albums":          userPlaylists((*sc.User).GetAlbums),
+			"liked_playlists": userPlaylists((*sc.User).GetLikedPlaylists),
+		}),
+
+		"Track": newObject(sc.Track{}, map[string]field{
+			"user": user,
+			"stream": {typ: "Stream", fetches: true, resolve: func(v any, args map[string]any) (any, error) {
+				return getStream(asTrack(v), stringArg(args, "preset"), stringArg(args, "protocol"))
+			}},
+			"streams": {resolve: func(v any, _ map[string]any) (any, error) {
+				return asTrack(v).Streams(), nil
+			}},
+			"tags": {resolve: func(v any, _ map[string]any) (any, error) {
+				return asTrack(v).Tags(), nil
+			}},
+		}),
+
+		"Playlist": newObject(sc.Playlist{}, map[string]field{
+			"user": user,
+			"tracks": {typ: "Track", resolve: func(v any, _ map[string]any) (any, error) {
+				return asPlaylist(v).Tracks, nil
+			}},
+			"tags": {resolve: func(v any, _ map[string]any) (any, error) {
+				return asPlaylist(v).Tags(), nil
+			}},
+		}),
+
+		"Stream":       newObject(stream{}, nil),
+		"TrackPage":    newObject(sc.Paginated[*sc.Track]{}, page("Track")),
+		"UserPage":     newObject(sc.Paginated[*sc.User]{}, page("User")),
+		"PlaylistPage": newObject(sc.Paginated[*sc.Playlist]{}, page("Playlist")),
+	}
+}
+
+// keeps the order of the selection in the response
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *orderedMap) set(k string, v any) {
+	if _, ok := m.values[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.values[k] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+
+		key, _ := cfg.JSON.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+
+		v, err := cfg.JSON.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+type gqlError struct {
+	Message    string    `json:"message"`
+	Path       []any     `json:"path,omitempty"`
+	Extensions fiber.Map `json:"extensions,omitempty"`
+}
+
+type executor struct {
+	doc     *document
+	vars    map[string]any
+	errors  []gqlError
+	fetches int
+
+	pow fiber.Map // challenge for guarded fields, nil if there is no need to solve one
+}
+
+// a copy of path with k at the end, paths end up in errors
+func extend(path []any, k any) []any {
+	return append(path[:len(path):len(path)], k)
+}
+
+func (e *executor) fail(path []any, err error) {
+	e.errors = append(e.errors, gqlError{Message: err.Error(), Path: append([]any{}, path...)})
+}
+
+// the literal with variables filled in
+func (e *executor) value(v any) any {
+	switch v := v.(type) {
+	case variable:
+		return e.vars[string(v)]
+	case []any:
+		res := make([]any, len(v))
+		for i, item := range v {
+			res[i] = e.value(item)
+		}
+		return res
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, item := range v {
+			res[k] = e.value(item)
+		}
+		return res
+	}
+
+	return v
+}
+
+// fields of a selection set by response key, with fragments spread and the sub-selections of repeated fields merged
+func (e *executor) collect(typ string, sels []selection, keys *[]string, fields map[string][]selection, seen map[string]bool) {
+	for _, s := range sels {
+		switch {
+		case s.spread != "":
+			f, ok := e.doc.fragments[s.spread]
+			if ok && !seen[s.spread] && f.on == typ {
+				seen[s.spread] = true
+				e.collect(typ, f.sel, keys, fields, seen)
+			}
+		case s.inline:
+			if s.on == "" || s.on == typ {
+				e.collect(typ, s.sel, keys, fields, seen)
+			}
+		default:
+			k := s.key()
+			if _, ok := fields[k]; !ok {
+				*keys = append(*keys, k)
+			}
+			fields[k] = append(fields[k], s)
+		}
+	}
+}
+
+func (e *executor) selectionSet(typ string, v any, sels []selection, path []any) *orderedMap {
+	o := schema[typ]
+	var keys []string
+	fields := map[string][]selection{}
+	e.collect(typ, sels, &keys, fields, map[string]bool{})
+
+	// plain fields are read from the json of the value, only made when one is asked for
+	var plain map[string]any
+	res := &orderedMap{values: make(map[string]any, len(keys))}
+	for _, k := range keys {
+		fs := fields[k]
+		s := fs[0]
+		p := extend(path, k)
+
+		if s.name == "__typename" {
+			res.set(k, typ)
+			continue
+		}
+
+		f, ok := o.fields[s.name]
+		if !ok {
+			if !o.plain[s.name] {
+				e.fail(p, fmt.Errorf("no field %q on type %s", s.name, typ))
+				res.set(k, nil)
+				continue
+			}
+
+			if plain == nil {
+				plain = toPlain(v)
+			}
+			res.set(k, plain[s.name])
+			continue
+		}
+
+		var sub []selection
+		for _, s := range fs {
+			sub = append(sub, s.sel...)
+		}
+		res.set(k, e.field(f, v, s, sub, p))
+	}
+
+	return res
+}
+
+func toPlain(v any) map[string]any {
+	res := map[string]any{}
+	data, err := cfg.JSON.Marshal(v)
+	if err != nil {
+		return res
+	}
+
+	d := cfg.JSON.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	d.Decode(&res)
+	return res
+}
+
+func (e *executor) field(f field, v any, s selection, sub []selection, path []any) any {
+	if f.typ != "" && len(sub) == 0 {
+		e.fail(path, fmt.Errorf("field %q of type %s needs a selection of subfields", s.name, f.typ))
+		return nil
+	} else if f.typ == "" && len(sub) != 0 {
+		e.fail(path, fmt.Errorf("field %q has no subfields", s.name))
+		return nil
+	}
+
+	depth := 0
+	for _, p := range path {
+		if _, ok := p.(string); ok { // not list indexes
+			depth++
+		}
+	}
+
+	if depth > maxDepth {
+		e.fail(path, errors.New("query is too deep"))
+		return nil
+	}
+
+	if f.guarded && e.pow != nil {
+		e.errors = append(e.errors, gqlError{Message: "proof-of-work required, send the solution in the X-PoW header", Path: append([]any{}, path...), Extensions: e.pow})
+		return nil
+	}
+
+	if f.fetches {
+		e.fetches++
+		if e.fetches > maxFetches {
+			e.fail(path, errTooManyFetches)
+			return nil
+		}
+	}
+
+	args := make(map[string]any, len(s.args))
+	for k, a := range s.args {
+		args[k] = e.value(a)
+	}
+
+	res, err := f.resolve(v, args)
+	if err != nil {
+		if err != sc.ErrBadParams && err != errTooManyFetches {
+			log.Printf("[API] graphql: error resolving %v: %s\n", path, err)
+		}
+		e.fail(path, err)
+		return nil
+	}
+
+	if f.typ == "" {
+		return res
+	}
+
+	rv := reflect.ValueOf(res)
+	if !rv.IsValid() || (rv.Kind() == reflect.Pointer && rv.IsNil()) {
+		return nil
+	}
+
+	if rv.Kind() != reflect.Slice {
+		return e.selectionSet(f.typ, res, sub, path)
+	}
+
+	list := make([]any, rv.Len())
+	for i := range list {
+		item := rv.Index(i).Interface()
+		if reflect.ValueOf(item).Kind() == reflect.Pointer && reflect.ValueOf(item).IsNil() {
+			continue
+		}
+		list[i] = e.selectionSet(f.typ, item, sub, extend(path, i))
+	}
+
+	return list
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func execute(req graphqlRequest, pow fiber.Map) fiber.Map {
+	doc, err := parseQuery(req.Query)
+	if err != nil {
+		return fiber.Map{"errors": []gqlError{{Message: err.Error()}}}
+	}
+
+	var op *operation
+	for i := range doc.ops {
+		if req.OperationName == "" || doc.ops[i].name == req.OperationName {
+			if op != nil {
+				return fiber.Map{"errors": []gqlError{{Message: "operationName is required when there's more than one operation"}}}
+			}
+			op = &doc.ops[i]
+		}
+	}
+
+	if op == nil {
+		return fiber.Map{"errors": []gqlError{{Message: fmt.Sprintf("no operation named %q", req.OperationName)}}}
+	}
+
+	e := &executor{doc: doc, vars: map[string]any{}, pow: pow}
+	for _, v := range op.vars {
+		val, ok := req.Variables[v.name]
+		if !ok && v.hasDef {
+			val, ok = v.def, true
+		}
+
+		if v.required && (!ok || val == nil) {
+			return fiber.Map{"errors": []gqlError{{Message: fmt.Sprintf("variable $%s is required", v.name)}}}
+		}
+
+		e.vars[v.name] = val
+	}
+
+	data := e.selectionSet("Query", nil, op.sel, nil)
+	if len(e.errors) != 0 {
+		return fiber.Map{"data": data, "errors": e.errors}
+	}
+
+	return fiber.Map{"data": data}
+}
+
+// GET with ?query=&operationName=&variables= (json), or POST with a json body
+func graphqlHandler(c *fiber.Ctx) error {
+	var req graphqlRequest
+	if c.Method() == fiber.MethodPost {
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.ErrBadRequest
+		}
+	} else {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if v := c.Query("variables"); v != "" {
+			if err := cfg.JSON.UnmarshalFromString(v, &req.Variables); err != nil {
+				return fiber.ErrBadRequest
+			}
+		}
+	}
+
+	if req.Query == "" {
+		return fiber.ErrBadRequest
+	}
+
+	var pow fiber.Map
+	if !botguard.Solved(c) {
+		challenge, difficulty := botguard.Challenge(c)
+		pow = fiber.Map{"challenge": challenge, "difficulty": difficulty}
+	}
+
+	return send(c, execute(req, pow))
+}
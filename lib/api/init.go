@@ -0,0 +1,312 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/botguard"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/events"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/palette"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/waveform"
+)
+
+// JSON API, returns the (fixed) structures from lib/sc as is (or as msgpack/jsonp, see format.go)
+
+// with the dominant colors of the artwork (lib/palette), for theming players
+type track struct {
+	sc.Track
+	Palette *palette.Palette `json:"palette,omitempty"`
+	Offset  int64            `json:"offset,omitempty"` // seconds, from a #t= in the url
+}
+
+// search results also get the path of their waveform thumbnail
+type searchTrack struct {
+	*sc.Track
+	WaveformThumbnail string `json:"waveform_thumbnail,omitempty"`
+}
+
+type stream struct {
+	URL      string      `json:"url"`
+	Protocol sc.Protocol `json:"protocol"`
+	MimeType string      `json:"mime_type"`
+}
+
+// the given preset/protocol (from /track/streams), the default one when preset is empty
+// fiber.ErrNotFound if the track doesn't have it
+func getStream(t sc.Track, preset string, protocol string) (stream, error) {
+	tr := t.PreferredStream()
+	if preset != "" {
+		if protocol == "" {
+			protocol = string(sc.ProtocolHLS)
+		}
+
+		tr = t.Media.Find(preset, sc.Protocol(protocol))
+		if tr == nil {
+			return stream{}, fiber.ErrNotFound
+		}
+	}
+
+	u, err := t.GetStreamFor(tr)
+	if err != nil {
+		return stream{}, err
+	}
+
+	if cfg.Get().Features.EnableStreamProxy {
+		u = proxystreams.ForTrack(proxystreams.URL(u), t.ID)
+	}
+
+	return stream{URL: u, Protocol: tr.Format.Protocol, MimeType: tr.Format.MimeType}, nil
+}
+
+func enabled(c *fiber.Ctx) error {
+	if !cfg.Get().Features.EnableAPI {
+		return fiber.ErrNotFound
+	}
+
+	return c.Next()
+}
+
+func Load(r fiber.Router) {
+	r.Get("/graphql", enabled, graphqlHandler)
+	r.Post("/graphql", enabled, graphqlHandler)
+
+	g := r.Group("/_/api", enabled)
+
+	g.Get("/track", func(c *fiber.Ctx) error {
+		u := c.Query("url")
+		if u == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetArbitraryTrack(u)
+		if err != nil {
+			log.Printf("[API] error getting %s: %s\n", u, err)
+			return err
+		}
+
+		res := track{Track: t, Offset: int64(sc.NormalizeURL(u).Offset.Seconds())}
+		if p, err := palette.Get(t); err != nil {
+			log.Printf("[API] error getting %s palette: %s\n", t.ID, err)
+		} else if p.Dominant != "" {
+			res.Palette = &p
+		}
+
+		return send(c, res)
+	})
+
+	g.Get("/track/streams", func(c *fiber.Ctx) error {
+		u := c.Query("url")
+		if u == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetArbitraryTrack(u)
+		if err != nil {
+			log.Printf("[API] error getting %s: %s\n", u, err)
+			return err
+		}
+
+		return send(c, t.Streams())
+	})
+
+	// ?preset=&protocol= to choose a specific stream (from /track/streams), the default one otherwise
+	g.Get("/stream", func(c *fiber.Ctx) error {
+		u := c.Query("url")
+		if u == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetArbitraryTrack(u)
+		if err != nil {
+			log.Printf("[API] error getting %s: %s\n", u, err)
+			return err
+		}
+
+		s, err := getStream(t, c.Query("preset"), c.Query("protocol"))
+		if err != nil {
+			if err != fiber.ErrNotFound {
+				log.Printf("[API] error getting %s stream from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+			}
+			return err
+		}
+
+		return send(c, s)
+	})
+
+	// the track after ?track= (id) in a playlist (?playlist=user/sets/name), local playlist (?local=) or queue (?queue=id,id,...)
+	// resolves its stream and warms the stream cache, so the player can switch without a gap
+	g.Get("/next", func(c *fiber.Ctx) error {
+		id := c.Query("track")
+		if id == "" {
+			return fiber.ErrNotFound
+		}
+
+		var queue []string
+		switch {
+		case c.Query("playlist") != "":
+			p, err := sc.GetPlaylist(c.Query("playlist"))
+			if err != nil {
+				log.Printf("[API] error getting %s (next): %s\n", c.Query("playlist"), err)
+				return err
+			}
+
+			for _, t := range p.Tracks {
+				if !t.Unavailable {
+					queue = append(queue, t.ID)
+				}
+			}
+		case c.Query("local") != "":
+			if !cfg.Get().Features.EnableLocalPlaylists {
+				return fiber.ErrNotFound
+			}
+
+			p, err := local.Get(c.Query("local"))
+			if err != nil {
+				if err == local.ErrNotFound {
+					return fiber.ErrNotFound
+				}
+
+				log.Printf("[API] error getting local playlist %s (next): %s\n", c.Query("local"), err)
+				return err
+			}
+
+			queue = p.Tracks
+		default:
+			queue = strings.Split(c.Query("queue"), ",")
+		}
+
+		next := ""
+		for i, t := range queue {
+			if t == id && i+1 < len(queue) {
+				next = queue[i+1]
+				break
+			}
+		}
+
+		if next == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetTrackByID(next)
+		if err != nil {
+			log.Printf("[API] error getting %s (next): %s\n", next, err)
+			return err
+		}
+
+		stream, err := t.GetStream()
+		if err != nil {
+			log.Printf("[API] error getting %s stream from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+			return err
+		}
+
+		if cfg.Get().Features.EnableStreamProxy {
+			proxystreams.Warm(stream, t.ID)
+			stream = proxystreams.ForTrack(proxystreams.URL(stream), t.ID)
+		}
+
+		return send(c, fiber.Map{"track": t, "stream": stream})
+	})
+
+	// buffering progress of the track returned by /next, ends once it's cached (or immediately when it isn't being warmed)
+	g.Get("/warm/:id/events", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		return events.Stream(c, proxystreams.WarmTopic(id), func() (events.Event, bool) {
+			p, ok := proxystreams.WarmStatus(id)
+			if !ok {
+				return events.Event{Name: "done"}, true
+			}
+
+			return events.Event{Name: "buffered", Data: p}, false
+		})
+	})
+
+	g.Get("/user/:user", func(c *fiber.Ctx) error {
+		u, err := sc.GetUser(c.Params("user"))
+		if err != nil {
+			log.Printf("[API] error getting %s: %s\n", c.Params("user"), err)
+			return err
+		}
+
+		return send(c, u)
+	})
+
+	g.Get("/user/:user/tracks", func(c *fiber.Ctx) error {
+		u, err := sc.GetUser(c.Params("user"))
+		if err != nil {
+			log.Printf("[API] error getting %s (tracks): %s\n", c.Params("user"), err)
+			return err
+		}
+
+		// offset is the one from next_href
+		p, err := u.GetTracks(sc.ParseCursor(c.Query("limit"), c.Query("offset")))
+		if err != nil {
+			log.Printf("[API] error getting %s tracks: %s\n", c.Params("user"), err)
+			return err
+		}
+
+		return send(c, p)
+	})
+
+	g.Get("/playlist/:user/:playlist", func(c *fiber.Ctx) error {
+		p, err := sc.GetPlaylist(c.Params("user") + "/sets/" + c.Params("playlist"))
+		if err != nil {
+			log.Printf("[API] error getting %s playlist from %s: %s\n", c.Params("playlist"), c.Params("user"), err)
+			return err
+		}
+
+		return send(c, p)
+	})
+
+	g.Get("/search", botguard.APIProofOfWork, func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableSearch {
+			return fiber.ErrNotFound
+		}
+
+		q := c.Query("q")
+		pg := sc.ParsePage(c.Query("limit"), c.Query("offset"))
+		switch c.Query("type") {
+		case "tracks":
+			license := c.Query("license")
+			if license != "" && !sc.ValidLicenseFilter(license) {
+				return fiber.ErrBadRequest
+			}
+
+			p, err := sc.SearchTracksByLicense(q, license, pg)
+			if err != nil {
+				log.Printf("[API] error getting tracks for %s: %s\n", q, err)
+				return err
+			}
+
+			// the page can be a shared cached one, so the tracks are wrapped instead of changed
+			res := sc.Paginated[searchTrack]{Total: p.Total, Next: p.Next, Page: p.Page, Hidden: p.Hidden, Collection: make([]searchTrack, len(p.Collection))}
+			for i, t := range p.Collection {
+				res.Collection[i] = searchTrack{Track: t, WaveformThumbnail: waveform.URL(t.Waveform)}
+			}
+
+			return send(c, res)
+		case "users":
+			p, err := sc.SearchUsers(q, pg)
+			if err != nil {
+				log.Printf("[API] error getting users for %s: %s\n", q, err)
+				return err
+			}
+
+			return send(c, p)
+		case "playlists":
+			p, err := sc.SearchPlaylists(q, pg)
+			if err != nil {
+				log.Printf("[API] error getting playlists for %s: %s\n", q, err)
+				return err
+			}
+
+			return send(c, p)
+		}
+
+		return fiber.ErrNotFound
+	})
+}
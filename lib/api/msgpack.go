@@ -0,0 +1,107 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Minimal msgpack encoder. values go through json first, so the output has the same fields
+// (json tags, omitempty, custom marshalers) as the json api, there's no separate schema to keep up to date
+
+func marshalMsgpack(v any) ([]byte, error) {
+	data, err := cfg.JSON.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d := cfg.JSON.NewDecoder(bytes.NewReader(data))
+	d.UseNumber() // keeps integers (ids, counts) integers
+	var generic any
+	err = d.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMsgpack(nil, generic), nil
+}
+
+// 8/16/32 bit length headers, the fix variant when n fits in it
+func appendLength(b []byte, n int, fix byte, fixMax int, h8 byte, h16 byte, h32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case h8 != 0 && n <= math.MaxUint8:
+		return append(b, h8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, h16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, h32), uint32(n))
+	}
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpack(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendInt(b, i)
+		}
+
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+	case string:
+		b = appendLength(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...)
+	case []any:
+		b = appendLength(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case map[string]any:
+		// sorted, so the same value always encodes the same way
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b = appendLength(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			b = appendMsgpack(b, k)
+			b = appendMsgpack(b, v[k])
+		}
+		return b
+	}
+
+	// not something json decodes to
+	return append(b, 0xc0)
+}
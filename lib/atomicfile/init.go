@@ -0,0 +1,38 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Writing files so nobody reads a partially written one: data goes to a temporary file in the same directory,
+// which is then renamed over the target (renames within a file system are atomic)
+
+// writes data to path, the directory is created with dirPerm if it doesn't exist
+func Write(path string, data []byte, dirPerm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
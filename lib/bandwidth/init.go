@@ -0,0 +1,125 @@
+package bandwidth
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Accounting of bytes sent by the stream proxy and downloads (per client ip and per track), and daily per-ip quotas
+
+var total atomic.Int64
+
+// counters for the current day (UTC), reset at midnight
+var day struct {
+	sync.Mutex
+	date   string
+	ips    map[string]int64
+	tracks map[string]int64
+	total  int64
+}
+
+type Usage struct {
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+type Stats struct {
+	Total     int64   `json:"total"` // since startup
+	Today     int64   `json:"today"`
+	TopIPs    []Usage `json:"top_ips"`
+	TopTracks []Usage `json:"top_tracks"`
+}
+
+// day must be locked
+func rollover() {
+	if d := time.Now().UTC().Format(time.DateOnly); d != day.date {
+		day.date = d
+		day.ips = map[string]int64{}
+		day.tracks = map[string]int64{}
+		day.total = 0
+	}
+}
+
+// track can be empty when we don't know which track the bytes belong to
+func Add(ip string, track string, n int64) {
+	if n <= 0 {
+		return
+	}
+
+	total.Add(n)
+
+	day.Lock()
+	rollover()
+	day.ips[ip] += n
+	if track != "" {
+		day.tracks[track] += n
+	}
+	day.total += n
+	day.Unlock()
+}
+
+// bytes sent to ip today
+func Used(ip string) int64 {
+	day.Lock()
+	defer day.Unlock()
+	rollover()
+
+	return day.ips[ip]
+}
+
+func top(m map[string]int64, n int) []Usage {
+	res := make([]Usage, 0, len(m))
+	for k, v := range m {
+		res = append(res, Usage{Key: k, Bytes: v})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Bytes > res[j].Bytes })
+	if len(res) > n {
+		res = res[:n]
+	}
+
+	return res
+}
+
+// totals and the n biggest ips/tracks of today
+func GetStats(n int) Stats {
+	day.Lock()
+	defer day.Unlock()
+	rollover()
+
+	return Stats{
+		Total:     total.Load(),
+		Today:     day.total,
+		TopIPs:    top(day.ips, n),
+		TopTracks: top(day.tracks, n),
+	}
+}
+
+// rejects requests from ips which used up their daily quota (cfg.DailyQuota)
+func Quota(c *fiber.Ctx) error {
+	if cfg.Get().DailyQuota > 0 && Used(c.IP()) >= cfg.Get().DailyQuota {
+		c.Set("Retry-After", "3600")
+		return fiber.NewError(fiber.StatusTooManyRequests, "daily bandwidth quota exceeded")
+	}
+
+	return c.Next()
+}
+
+// counts whatever was written to w
+type Writer struct {
+	W     io.Writer
+	IP    string
+	Track string
+}
+
+func (w Writer) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	Add(w.IP, w.Track, int64(n))
+	return n, err
+}
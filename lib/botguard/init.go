@@ -0,0 +1,138 @@
+package botguard
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Crawler controls: robots.txt, user agent blocklist and proof-of-work for search
+
+const cookieName = "pow"
+
+// challenges are signed, so we don't need to store them
+// (with prefork every process has its own secret, so a challenge might need to be solved again)
+var secret = make([]byte, 32)
+
+func init() {
+	_, err := rand.Read(secret)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func sign(ip string, exp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ip + "|" + exp))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func leadingZeros(hash []byte) (n int) {
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+
+		n += 8
+	}
+
+	return
+}
+
+// cookie: <expiry>.<signature>.<nonce>, sha256 of the whole thing needs to have enough leading zero bits
+func valid(ip string, cookie string) bool {
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(sign(ip, parts[0]))) {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(cookie))
+	return leadingZeros(hash[:]) >= int(cfg.Get().SearchPoWDifficulty)
+}
+
+// browsers send the solution as a cookie, scripts can put it in the X-PoW header
+func solution(c *fiber.Ctx) string {
+	if s := c.Get("X-PoW"); s != "" {
+		return s
+	}
+
+	return c.Cookies(cookieName)
+}
+
+// true if c doesn't need to solve a proof-of-work (anymore)
+func Solved(c *fiber.Ctx) bool {
+	return cfg.Get().SearchPoWDifficulty == 0 || valid(c.IP(), solution(c))
+}
+
+// a new challenge for c's ip, solved by finding a nonce so sha256(challenge + "." + nonce) has difficulty leading zero bits
+func Challenge(c *fiber.Ctx) (challenge string, difficulty int) {
+	exp := strconv.FormatInt(time.Now().Add(cfg.Get().SearchPoWTTL).Unix(), 10)
+	return exp + "." + sign(c.IP(), exp), int(cfg.Get().SearchPoWDifficulty)
+}
+
+// middleware for expensive endpoints (search), renders a challenge page if there is no solved proof-of-work
+func ProofOfWork(c *fiber.Ctx) error {
+	if Solved(c) {
+		return c.Next()
+	}
+
+	challenge, difficulty := Challenge(c)
+
+	c.Status(fiber.StatusForbidden)
+	c.Set("Content-Type", "text/html")
+	return templates.Base("checking your browser", templates.ProofOfWork(challenge, difficulty, int(cfg.Get().SearchPoWTTL.Seconds())), nil).Render(preferences.Context(c), c)
+}
+
+// ProofOfWork for the api, the challenge comes as json and the solution goes in the X-PoW header
+func APIProofOfWork(c *fiber.Ctx) error {
+	if Solved(c) {
+		return c.Next()
+	}
+
+	challenge, difficulty := Challenge(c)
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "proof-of-work required", "challenge": challenge, "difficulty": difficulty})
+}
+
+func blocked(ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, b := range cfg.Get().BlockedUserAgents {
+		if strings.Contains(ua, strings.ToLower(b)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Load(r fiber.Router) {
+	r.Use(func(c *fiber.Ctx) error {
+		if blocked(c.Get("User-Agent")) {
+			return fiber.ErrForbidden
+		}
+
+		return c.Next()
+	})
+
+	r.Get("/robots.txt", func(c *fiber.Ctx) error {
+		return c.SendString(cfg.Get().RobotsTxt)
+	})
+}
@@ -1,65 +1,482 @@
 package cfg
 
+// Options and their defaults, every option can be overridden at runtime with environment variables or a config file (check load.go)
+// read them with Get(). a Config is never changed once it's in use, reloading swaps in a new one (check reload.go)
+
 import (
+	"sync/atomic"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
-// fully loads the track on page load
-// this option is here since the stream expires after some time (5 minutes? correct me if im wrong)
-// if the stream isn't fully loaded before it expires - you'll need to reload the page
-const FullyPreloadTrack = false
+type Config struct {
+	// fully loads the track on page load
+	// this option is here since the stream expires after some time (5 minutes? correct me if im wrong)
+	// if the stream isn't fully loaded before it expires - you'll need to reload the page
+	FullyPreloadTrack bool `cfg:"fully_preload_track"`
+
+	// which stream to play, first available one wins: hls_mp3, hls_opus, hls_aac, progressive_mp3 (or just hls/progressive for any codec)
+	// hls.js can't play opus in most browsers, downloads always use hls_mp3
+	AudioPreference []string `cfg:"audio_preference"`
+
+	// time-to-live for clientid cache
+	// larger number will improve performance (no need to recheck everytime) but might make soundcloak briefly unusable for a larger amount of time if the client id is invalidated
+	ClientIDTTL time.Duration `cfg:"client_id_ttl"`
+
+	// time-to-live for user profile cache
+	UserTTL time.Duration `cfg:"user_ttl"`
+
+	// time-to-live for track cache
+	TrackTTL time.Duration `cfg:"track_ttl"`
+
+	// time-to-live for playlist cache
+	PlaylistTTL time.Duration `cfg:"playlist_ttl"`
+
+	// delay between cleanups of the caches in lib/sc, a quarter of the shortest ttl
+	CacheCleanDelay time.Duration
+
+	// time-to-live for popular tags (extracted from charts)
+	PopularTagsTTL time.Duration `cfg:"popular_tags_ttl"`
+
+	// time-to-live for remixes shown on track pages (found by searching, so it's a few requests)
+	RemixesTTL time.Duration `cfg:"remixes_ttl"`
+
+	// time-to-live for timed comments (the dots on the seek bar of track pages)
+	CommentsTTL time.Duration `cfg:"comments_ttl"`
+
+	// time-to-live for the discover page modules (/discover)
+	DiscoverTTL time.Duration `cfg:"discover_ttl"`
+
+	// time-to-live for the first page of search results
+	SearchTTL time.Duration `cfg:"search_ttl"`
+
+	// max amount of cached search pages (each query + filters + type is one), 0 to disable
+	SearchCacheSize int `cfg:"search_cache_size"`
+
+	// hide obvious spam from track search results: the same title twice from one uploader,
+	// and more than SpamMaxPerUploader tracks on a page from an account without followers or plays
+	SpamFilter         bool `cfg:"spam_filter"`
+	SpamMaxPerUploader int  `cfg:"spam_max_per_uploader"`
+
+	// track titles containing any of these (case-insensitive) are hidden too when SpamFilter is on
+	SpamKeywords []string `cfg:"spam_keywords"`
+
+	// users and tracks hidden on this instance (dmca requests, spam): from search, playlists, related tracks and their own pages
+	// entries are ids ("123456") or permalink patterns ("someone" for a user, "someone/*" or "someone/some-track" for tracks)
+	// blocking a user hides their tracks and playlists too
+	BlockedUsers  []string `cfg:"blocked_users"`
+	BlockedTracks []string `cfg:"blocked_tracks"`
+
+	// exceptions to the lists above, same format
+	AllowedUsers  []string `cfg:"allowed_users"`
+	AllowedTracks []string `cfg:"allowed_tracks"`
+
+	// how many urls are resolved at once when resolving in bulk (playlist import, cli)
+	ResolveConcurrency int `cfg:"resolve_concurrency"`
+
+	// default locale for the ui language and formatting numbers/dates (en, en-gb, de, nl, fr...), used when the browser doesn't ask for a supported one
+	// users can change it in /preferences, translations are in lib/i18n/locales
+	Locale string `cfg:"locale"`
+
+	// color palette for users who didn't choose one: dark, light, black or system (follows the browser)
+	Theme string `cfg:"theme"`
+
+	// css added to every page (after the built-in styles), served at /_/custom.css
+	// for example to change the palette: ":root { --accent: hotpink; }"
+	CustomCSS string `cfg:"custom_css"`
+
+	// compression of responses (brotli, zstd or gzip, whatever the browser supports): off, fastest, default or best
+	// static assets are compressed once and cached, this mostly matters for rendered pages and api json
+	CompressionLevel string `cfg:"compression_level"`
+
+	// set Cache-Control/Age on responses (lifetimes from the ttls above), so a cdn or caching proxy can sit in front of the instance
+	HTTPCache bool `cfg:"http_cache"`
+
+	// default fasthttp one was causing connections to be stuck? todo make it cycle browser useragents or just choose random at startup
+	UserAgent string `cfg:"user_agent"`
+
+	// features which can be turned off (or on) per instance, handlers check these on every request
+	// public instances might want to disable expensive or legally risky ones
+	Features FeatureSet
+
+	// how many tracks are downloaded at once for a playlist zip (/_/download/playlist), they are kept in memory until written
+	DownloadConcurrency int `cfg:"download_concurrency"`
+
+	// how long a login lasts
+	SessionTTL time.Duration `cfg:"session_ttl"`
+
+	// max tracks + artists in someone's favorites
+	FavoritesMax int `cfg:"favorites_max"`
+
+	// listen together rooms: max people in one, and how long an empty room is kept
+	RoomMaxMembers int           `cfg:"room_max_members"`
+	RoomTTL        time.Duration `cfg:"room_ttl"`
+
+	// background downloads (/_/jobs): how many run at once, how many can wait, and how long finished files are kept
+	JobWorkers   int           `cfg:"job_workers,restart"`
+	JobQueueSize int           `cfg:"job_queue_size,restart"`
+	JobTTL       time.Duration `cfg:"job_ttl"`
+
+	// max amount of bytes a single ip can get through the stream proxy and downloads per day (UTC), 0 for no limit
+	// keeps the bandwidth of public instances under control, usage is shown in /admin
+	DailyQuota int64 `cfg:"daily_quota"`
+
+	// max size of the on-disk cache for proxied segments/progressive streams (in bytes), 0 to disable
+	// popular tracks won't be pulled from the cdn over and over again
+	StreamCacheSize int64 `cfg:"stream_cache_size,restart"`
+
+	// where the stream cache lives
+	StreamCacheDir string `cfg:"stream_cache_dir,restart"`
+
+	// length of the previews played when hovering (or pressing) tracks in search results, needs the stream proxy. 0 to disable
+	PreviewSeconds int `cfg:"preview_seconds"`
+
+	// how long the signed direct stream links (for casting to chromecast/airplay, they don't have our cookies) stay valid
+	DirectStreamTTL time.Duration `cfg:"direct_stream_ttl"`
+
+	// key for signing them. when empty ProxySecret is used, or a random one made on every start (and with prefork, in every process), so links break on restarts
+	DirectStreamSecret string `cfg:"direct_stream_secret,restart"`
+
+	// key for signing the stream and image proxy urls, without it anyone can use the proxies for any soundcloud media
+	// use something long and random, every process (and instance behind the same domain) needs the same one. empty turns signing off
+	ProxySecret string `cfg:"proxy_secret,restart"`
+
+	// how long signed proxy urls stay valid, at least. pages (and hls playlists) get fresh ones every time
+	ProxyURLTTL time.Duration `cfg:"proxy_url_ttl"`
+
+	// where data created on the instance (like local playlists) is stored
+	DataDir string `cfg:"data_dir,restart"`
+
+	// max amount of tracks in a local playlist, entries after that are ignored on import
+	LocalPlaylistMaxTracks int `cfg:"local_playlist_max_tracks"`
+
+	// requests per hour and ip which create files in DataDir (short links, playlist imports), so nobody can fill up the disk. 0 for no limit
+	// with prefork every process counts on its own
+	WritesPerHour int `cfg:"writes_per_hour"`
+
+	// users (permalinks) to watch for new uploads
+	WatchedUsers []string `cfg:"watched_users"`
+
+	// playlists (user/sets/playlist) to keep snapshots of, the changes are shown at /user/sets/playlist/changes
+	WatchedPlaylists []string `cfg:"watched_playlists"`
+
+	// snapshots kept per watched playlist, a new one is only taken when the tracks changed
+	SnapshotsMax int `cfg:"snapshots_max"`
+
+	// how often to check watched users and playlists
+	WatchInterval time.Duration `cfg:"watch_interval"`
+
+	// how many of the latest tracks to request per check, more are requested only when all of them are new
+	WatchPageSize int `cfg:"watch_page_size"`
+
+	// notified about new uploads of watched users
+	// plain urls get a json POST, prefix with ntfy: or discord: for those services (like ntfy:https://ntfy.sh/mytopic)
+	Webhooks []string `cfg:"webhooks"`
+
+	// oauth token of a soundcloud account (from the oauth_token cookie on soundcloud.com), enables /feed and other account features
+	// keep in mind that everyone using the instance will see (and be able to use) this account
+	OAuthToken string `cfg:"oauth_token"`
+
+	// credentials for the /admin dashboard (http basic auth), leave the password empty to disable the dashboard
+	AdminUser     string `cfg:"admin_user,restart"`
+	AdminPassword string `cfg:"admin_password,restart"`
+
+	// serve pprof profiles (/admin/debug/pprof/) and runtime stats (/admin/debug/vars) behind the same auth, for debugging memory growth
+	AdminDebug bool `cfg:"admin_debug,restart"`
+
+	// served at /robots.txt
+	RobotsTxt string `cfg:"robots_txt"`
+
+	// requests with a user agent containing any of these (case-insensitive) get rejected
+	// crawlers cause a lot of requests to soundcloud, which might get the instance rate limited
+	BlockedUserAgents []string `cfg:"blocked_user_agents"`
+
+	// require a lightweight proof-of-work (done by javascript in the browser) before searching
+	// this is the amount of leading zero bits in the hash, around 16 is a good start. 0 to disable
+	// api and graphql search get the challenge in the 403 response and take the solution in the X-PoW header
+	SearchPoWDifficulty int64 `cfg:"search_pow_difficulty"`
+
+	// how long a solved proof-of-work stays valid
+	SearchPoWTTL time.Duration `cfg:"search_pow_ttl"`
+
+	// send Content-Security-Policy, Referrer-Policy and Permissions-Policy headers
+	// scripts only load from the instance itself (no inline ones), media and images also from soundcloud's cdn unless they are proxied
+	SecurityHeaders bool `cfg:"security_headers"`
+
+	// extra origins allowed for images, media and connections (like https://cdn.example.com), for setups serving those from elsewhere
+	CSPSources []string `cfg:"csp_sources"`
+
+	// origins allowed to use the api, graphql and the stream proxy from the browser (like https://app.example.com or moz-extension://<uuid>), "*" for any
+	// empty sends no CORS headers, so only our own pages can
+	CORSOrigins []string `cfg:"cors_origins"`
+
+	// how long browsers may cache preflight responses
+	CORSMaxAge time.Duration `cfg:"cors_max_age,restart"`
+
+	// same-origin keeps links to soundcloud (and elsewhere) from leaking what was being looked at
+	// no-referrer would also blank the Origin of our own forms, which lib/csrf rejects
+	ReferrerPolicy    string `cfg:"referrer_policy"`
+	PermissionsPolicy string `cfg:"permissions_policy"`
+
+	// look up names of deleted tracks in playlists on the Wayback Machine (archive.org), this sends their ids there
+	WaybackFallback bool `cfg:"wayback_fallback"`
+
+	// public url of this instance (like https://tunes.floppa.nl), used where absolute links are needed
+	InstanceURL string `cfg:"instance_url"`
+
+	// other public instances (base urls, like https://sc.example.com), listed at /instances
+	Instances []string `cfg:"instances"`
+
+	// how often to check if the instances above are healthy
+	InstancesCheckInterval time.Duration `cfg:"instances_check_interval"`
+
+	// redirect users to a random healthy instance from the list while soundcloud is rate limiting us
+	RedirectWhenRateLimited bool `cfg:"redirect_when_rate_limited"`
+
+	// for how long after getting rate limited we keep redirecting
+	RateLimitCooldown time.Duration `cfg:"rate_limit_cooldown"`
+
+	// time-to-live for dns cache
+	DNSCacheTTL time.Duration `cfg:"dns_cache_ttl,restart"`
+
+	// where soundcloud is, only worth changing for testing against a local server (check lib/sctest) or a mirror
+	// the web url is where the client id comes from and what gets resolved
+	SoundcloudAPI string `cfg:"soundcloud_api,restart"`
+	SoundcloudWeb string `cfg:"soundcloud_web,restart"`
+
+	// compare api responses with the fields soundcloud is known to send, and log/count changes (check lib/sc/drift.go)
+	// every response gets decoded twice, so it's off by default
+	SchemaDrift bool `cfg:"schema_drift"`
+
+	// cdn hosts (and their subdomains) the image and stream proxies fetch from, also allowed by the content security policy
+	// images covers artwork, avatars and waveforms, aac streams come from media-streaming.soundcloud.cloud
+	SoundcloudImageHosts []string `cfg:"soundcloud_image_hosts"`
+	SoundcloudMediaHosts []string `cfg:"soundcloud_media_hosts"`
+
+	// send traces to an opentelemetry collector over otlp/http (json), like http://localhost:4318. empty disables tracing
+	// spans cover incoming requests, soundcloud api calls, stream proxy segments and cache lookups
+	// not free even when a request isn't sampled: every span parses runtime.Stack for the goroutine id and goes through a global lock
+	TracingEndpoint string `cfg:"tracing_endpoint"`
+
+	// percentage of incoming requests which get traced, requests with a sampled traceparent header from one of TrustedProxies always are
+	TracingSamplePercent int `cfg:"tracing_sample_percent"`
+
+	// service.name of the traces, to tell instances apart
+	TracingServiceName string `cfg:"tracing_service_name"`
+
+	// // // some webserver configuration, put here to make it easier to configure what you need // // //
+	// more info can be found here: https://docs.gofiber.io/api/fiber#config
+
+	// run soundcloak on this address (localhost:4664 by default)
+	Addr string `cfg:"addr,restart"`
+
+	// more addresses to listen on (same format as Addr), for example ["[::]:4664"] next to "0.0.0.0:4664"
+	// "unix:/run/soundcloak/soundcloak.sock" listens on a unix socket, so a reverse proxy on the same machine doesn't need tcp
+	Listen []string `cfg:"listen,restart"`
+
+	// permissions of unix sockets (octal), the reverse proxy needs to be able to write to it
+	UnixSocketMode string `cfg:"unix_socket_mode,restart"`
+
+	// serve the admin dashboard only on this address (like 127.0.0.1:4665 or a unix socket), instead of on every listener
+	AdminAddr string `cfg:"admin_addr,restart"`
+
+	// speak the mpd protocol here (like 127.0.0.1:6600), so mpd can use soundcloak as its library (database plugin "proxy")
+	// songs are stream proxy urls, so it needs instance_url and the stream proxy. empty turns it off
+	MPDAddr string `cfg:"mpd_addr,restart"`
+
+	// DLNA/UPnP media server for tvs and speakers on the lan (like :4666, announced over ssdp), empty turns it off. needs the stream proxy
+	// it shows the favorites of DLNAFavorites (a favorites key) and the local playlists in DLNAPlaylists, to anyone on the network
+	DLNAAddr      string   `cfg:"dlna_addr,restart"`
+	DLNAName      string   `cfg:"dlna_name,restart"`
+	DLNAFavorites string   `cfg:"dlna_favorites"`
+	DLNAPlaylists []string `cfg:"dlna_playlists"`
+
+	// gRPC service (lib/grpc/soundcloak.proto) for bots and bridges, plaintext http/2 (like 127.0.0.1:4667). needs the api, empty turns it off
+	GRPCAddr string `cfg:"grpc_addr,restart"`
+
+	// on SIGTERM/SIGINT, how long to wait for open connections (like proxied streams) to finish before exiting
+	ShutdownTimeout time.Duration `cfg:"shutdown_timeout"`
+
+	// run multiple instances of soundcloud locally to be able to handle more requests
+	// each one will be a separate process, so they will have separate cache
+	// rooms need EnableRooms off, and background download jobs are turned off (zips are streamed directly instead)
+	Prefork bool `cfg:"prefork,restart"`
+
+	// Enables TLS Early Data (0-RTT / zero round trip time)
+	// This can reduce latency, but also makes requests replayable (not that much of a concern for soundcloak, since there are no authenticated operations)
+	// There might be breakage when used together with TrustedProxyCheck and the proxy is untrusted
+	EarlyData bool `cfg:"early_data,restart"`
+
+	// use X-Forwarded-* headers ONLY when ip is in TrustedProxies list
+	// when disabled, the X-Forwarded-* headers will be blindly used
+	TrustedProxyCheck bool `cfg:"trusted_proxy_check,restart"`
+
+	// list of ips or ip ranges of trusted proxies (check above), also the only ones whose traceparent headers are followed
+	TrustedProxies []string `cfg:"trusted_proxies,restart"`
+}
+
+type FeatureSet struct {
+	// /_/download, downloading tracks as mp3 files
+	EnableDownloads bool `cfg:"enable_downloads"`
+
+	// proxy streams (hls playlists and segments) through soundcloak instead of having the browser fetch them from soundcloud's cdn
+	EnableStreamProxy bool `cfg:"enable_stream_proxy"`
 
-// time-to-live for clientid cache
-// larger number will improve performance (no need to recheck everytime) but might make soundcloak briefly unusable for a larger amount of time if the client id is invalidated
-const ClientIDTTL = 30 * time.Minute
+	// proxy artwork and avatars through soundcloak
+	EnableImageProxy bool `cfg:"enable_image_proxy"`
 
-// time-to-live for user profile cache
-const UserTTL = 10 * time.Minute
+	// /search
+	EnableSearch bool `cfg:"enable_search"`
 
-// delay between cleanup of user cache
-const UserCacheCleanDelay = UserTTL / 4
+	// json api under /_/api
+	EnableAPI bool `cfg:"enable_api"`
 
-// time-to-live for track cache
-const TrackTTL = 10 * time.Minute
+	// /w/player, embeddable player
+	EnableEmbeds bool `cfg:"enable_embeds"`
 
-// delay between cleanup of track cache
-const TrackCacheCleanDelay = TrackTTL / 4
+	// /playlists, playlists stored on the instance (imported from files)
+	EnableLocalPlaylists bool `cfg:"enable_local_playlists"`
 
-// time-to-live for playlist cache
-const PlaylistTTL = 10 * time.Minute
+	// /rooms, listening together over websockets (needs the stream proxy)
+	EnableRooms bool `cfg:"enable_rooms"`
 
-// delay between cleanup of playlist cache
-const PlaylistCacheCleanDelay = PlaylistTTL / 4
+	// commenting, liking and reposting as the account of OAuthToken
+	EnableActions bool `cfg:"enable_actions"`
 
-// default fasthttp one was causing connections to be stuck? todo make it cycle browser useragents or just choose random at startup
-const UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/127.0.0.0 Safari/537.3"
+	// /favorites, starred tracks and artists stored on the instance (keyed by a cookie)
+	EnableFavorites bool `cfg:"enable_favorites"`
 
-// time-to-live for dns cache
-const DNSCacheTTL = 10 * time.Minute
+	// /account, optional accounts to sync favorites, preferences and local playlists between devices
+	// username and password only (no passkeys), stored as json files in DataDir/accounts like everything else (no sqlite)
+	EnableAccounts bool `cfg:"enable_accounts"`
 
-// // // some webserver configuration, put here to make it easier to configure what you need // // //
-// more info can be found here: https://docs.gofiber.io/api/fiber#config
+	// /s/<code>, short links for tracks (with the position and playlist) and playlists, stored on the instance
+	EnableShortLinks bool `cfg:"enable_short_links"`
+}
 
-// run soundcloak on this address (localhost:4664 by default)
-const Addr = ":4664"
+var defaults = Config{
+	FullyPreloadTrack:  false,
+	AudioPreference:    []string{"hls_mp3", "hls_aac", "progressive"},
+	ClientIDTTL:        30 * time.Minute,
+	UserTTL:            10 * time.Minute,
+	TrackTTL:           10 * time.Minute,
+	PlaylistTTL:        10 * time.Minute,
+	PopularTagsTTL:     1 * time.Hour,
+	RemixesTTL:         1 * time.Hour,
+	CommentsTTL:        30 * time.Minute,
+	DiscoverTTL:        30 * time.Minute,
+	SearchTTL:          5 * time.Minute,
+	SearchCacheSize:    500,
+	SpamFilter:         false,
+	SpamMaxPerUploader: 2,
+	SpamKeywords:       []string{},
+	BlockedUsers:       []string{},
+	BlockedTracks:      []string{},
+	AllowedUsers:       []string{},
+	AllowedTracks:      []string{},
+	ResolveConcurrency: 4,
+	Locale:             "en",
+	Theme:              "dark",
+	CustomCSS:          "",
+	CompressionLevel:   "default",
+	HTTPCache:          true,
+	UserAgent:          "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/127.0.0.0 Safari/537.3",
+	Features: FeatureSet{
+		EnableDownloads:   false,
+		EnableStreamProxy: false,
+		EnableImageProxy:  false,
+		EnableSearch:      true,
+		EnableAPI:         true,
+		EnableEmbeds:      true,
 
-// run multiple instances of soundcloud locally to be able to handle more requests
-// each one will be a separate process, so they will have separate cache
-const Prefork = false
+		EnableLocalPlaylists: true,
+		EnableRooms:          true,
+		EnableActions:        false,
+		EnableFavorites:      true,
+		EnableAccounts:       false,
+		EnableShortLinks:     true,
+	},
+	DownloadConcurrency:     3,
+	SessionTTL:              30 * 24 * time.Hour,
+	FavoritesMax:            1000,
+	RoomMaxMembers:          50,
+	RoomTTL:                 1 * time.Hour,
+	JobWorkers:              2,
+	JobQueueSize:            32,
+	JobTTL:                  1 * time.Hour,
+	DailyQuota:              0,
+	StreamCacheSize:         0,
+	StreamCacheDir:          "cache/streams",
+	PreviewSeconds:          15,
+	DirectStreamTTL:         6 * time.Hour,
+	DirectStreamSecret:      "",
+	ProxySecret:             "",
+	ProxyURLTTL:             6 * time.Hour,
+	DataDir:                 "data",
+	LocalPlaylistMaxTracks:  500,
+	WritesPerHour:           60,
+	WatchedUsers:            []string{},
+	WatchedPlaylists:        []string{},
+	SnapshotsMax:            100,
+	WatchInterval:           15 * time.Minute,
+	WatchPageSize:           5,
+	Webhooks:                []string{},
+	OAuthToken:              "",
+	AdminUser:               "admin",
+	AdminPassword:           "",
+	AdminDebug:              false,
+	RobotsTxt:               "User-agent: *\nDisallow: /",
+	BlockedUserAgents:       []string{"AhrefsBot", "SemrushBot", "MJ12bot", "DotBot", "PetalBot", "Bytespider", "GPTBot", "CCBot", "Amazonbot"},
+	SearchPoWDifficulty:     0,
+	SearchPoWTTL:            1 * time.Hour,
+	SecurityHeaders:         true,
+	CSPSources:              []string{},
+	CORSOrigins:             []string{},
+	CORSMaxAge:              1 * time.Hour,
+	ReferrerPolicy:          "same-origin",
+	PermissionsPolicy:       "camera=(), microphone=(), geolocation=(), payment=(), usb=(), browsing-topics=()",
+	WaybackFallback:         false,
+	InstanceURL:             "",
+	Instances:               []string{},
+	InstancesCheckInterval:  5 * time.Minute,
+	RedirectWhenRateLimited: false,
+	RateLimitCooldown:       2 * time.Minute,
+	DNSCacheTTL:             10 * time.Minute,
+	SoundcloudAPI:           "https://api-v2.soundcloud.com",
+	SoundcloudWeb:           "https://soundcloud.com",
+	SchemaDrift:             false,
+	SoundcloudImageHosts:    []string{"sndcdn.com"},
+	SoundcloudMediaHosts:    []string{"sndcdn.com", "media-streaming.soundcloud.cloud"},
+	TracingEndpoint:         "",
+	TracingSamplePercent:    100,
+	TracingServiceName:      "soundcloak",
+	Addr:                    ":4664",
+	Listen:                  []string{},
+	UnixSocketMode:          "0660",
+	AdminAddr:               "",
+	MPDAddr:                 "",
+	DLNAAddr:                "",
+	DLNAName:                "soundcloak",
+	DLNAFavorites:           "",
+	DLNAPlaylists:           []string{},
+	GRPCAddr:                "",
+	ShutdownTimeout:         30 * time.Second,
+	Prefork:                 false,
+	EarlyData:               false,
+	TrustedProxyCheck:       true,
+	TrustedProxies:          []string{},
+}
 
-// Enables TLS Early Data (0-RTT / zero round trip time)
-// This can reduce latency, but also makes requests replayable (not that much of a concern for soundcloak, since there are no authenticated operations)
-// There might be breakage when used together with TrustedProxyCheck and the proxy is untrusted
-const EarlyData = false
+var current atomic.Pointer[Config]
 
-// use X-Forwarded-* headers ONLY when ip is in TrustedProxies list
-// when disabled, the X-Forwarded-* headers will be blindly used
-const TrustedProxyCheck = true
+// the configuration in use, don't change it. read it again for every request, so reloads apply
+func Get() *Config {
+	return current.Load()
+}
 
-// list of ips or ip ranges of trusted proxies (check above)
-var TrustedProxies = []string{}
+// set at build time: go build -ldflags "-X github.com/maid-zone/soundcloak/lib/cfg.Version=v1.2.3"
+var Version = "dev"
 
 // what JSON library should be used
 var JSON = jsoniter.ConfigFastest
@@ -0,0 +1,367 @@
+package cfg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Runtime configuration
+//
+// Options are read from (later ones override earlier ones):
+// 1. defaults in init.go
+// 2. yaml config file (path in SOUNDCLOAK_CONFIG, soundcloak.yaml by default, skipped if the default one doesn't exist)
+// 3. environment variables, SOUNDCLOAK_ + uppercased key (for example SOUNDCLOAK_USER_TTL=15m)
+//
+// Durations use go syntax (10m, 1h30m), lists in environment variables are comma separated
+
+type option struct {
+	key   string
+	index []int // field of Config
+
+	restart bool // only used on startup, can't be hot-reloaded
+}
+
+// every field of Config with a cfg tag ("key" or "key,restart"), untagged structs (Features) are walked into
+var options = collect(reflect.TypeOf(Config{}), nil)
+
+func collect(t reflect.Type, parent []int) []option {
+	var opts []option
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		index := append(append([]int{}, parent...), i)
+
+		tag, ok := f.Tag.Lookup("cfg")
+		if !ok {
+			if f.Type.Kind() == reflect.Struct {
+				opts = append(opts, collect(f.Type, index)...)
+			}
+			continue
+		}
+
+		key, flag, _ := strings.Cut(tag, ",")
+		opts = append(opts, option{key, index, flag == "restart"})
+	}
+
+	return opts
+}
+
+// pointer to the option's field in c
+func (o option) ptr(c *Config) any {
+	return reflect.ValueOf(c).Elem().FieldByIndex(o.index).Addr().Interface()
+}
+
+const defaultConfigFile = "soundcloak.yaml"
+
+func setString(ptr any, val string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = val
+	case *bool:
+		v, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int:
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int64:
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *[]string:
+		*p = []string{}
+		for _, s := range strings.Split(val, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported option type %T", ptr)
+	}
+
+	return nil
+}
+
+func loadFile(path string, required bool, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	var raw map[string]yaml.Node
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]bool{}
+	for _, o := range options {
+		known[o.key] = true
+
+		node, ok := raw[o.key]
+		if !ok {
+			continue
+		}
+
+		ptr := o.ptr(c)
+		if d, ok := ptr.(*time.Duration); ok {
+			var s string
+			err = node.Decode(&s)
+			if err == nil {
+				*d, err = time.ParseDuration(s)
+			}
+		} else {
+			err = node.Decode(ptr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.key, err)
+		}
+	}
+
+	for key := range raw {
+		if !known[key] {
+			log.Printf("config: unknown option %s in %s\n", key, path)
+		}
+	}
+
+	return nil
+}
+
+func loadEnv(c *Config) error {
+	for _, o := range options {
+		val, ok := os.LookupEnv("SOUNDCLOAK_" + strings.ToUpper(o.key))
+		if !ok {
+			continue
+		}
+
+		err := setString(o.ptr(c), val)
+		if err != nil {
+			return fmt.Errorf("SOUNDCLOAK_%s: %w", strings.ToUpper(o.key), err)
+		}
+	}
+
+	return nil
+}
+
+func validate(c *Config) error {
+	for _, ttl := range []struct {
+		key string
+		val time.Duration
+	}{
+		{"client_id_ttl", c.ClientIDTTL},
+		{"user_ttl", c.UserTTL},
+		{"track_ttl", c.TrackTTL},
+		{"playlist_ttl", c.PlaylistTTL},
+		{"popular_tags_ttl", c.PopularTagsTTL},
+		{"search_ttl", c.SearchTTL},
+		{"discover_ttl", c.DiscoverTTL},
+		{"remixes_ttl", c.RemixesTTL},
+		{"comments_ttl", c.CommentsTTL},
+		{"dns_cache_ttl", c.DNSCacheTTL},
+		{"instances_check_interval", c.InstancesCheckInterval},
+		{"watch_interval", c.WatchInterval},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"job_ttl", c.JobTTL},
+		{"room_ttl", c.RoomTTL},
+		{"session_ttl", c.SessionTTL},
+		{"direct_stream_ttl", c.DirectStreamTTL},
+		{"proxy_url_ttl", c.ProxyURLTTL},
+	} {
+		if ttl.val <= 0 {
+			return fmt.Errorf("%s must be positive", ttl.key)
+		}
+	}
+
+	if c.FavoritesMax < 1 {
+		return errors.New("favorites_max must be positive")
+	}
+
+	if c.RoomMaxMembers < 1 {
+		return errors.New("room_max_members must be positive")
+	}
+
+	// members of a room would end up in different processes, which don't know about each other's rooms
+	if c.Prefork && c.Features.EnableRooms {
+		return errors.New("rooms don't work with prefork, set enable_rooms to false")
+	}
+
+	if c.JobWorkers < 1 || c.JobQueueSize < 1 {
+		return errors.New("job_workers and job_queue_size must be positive")
+	}
+
+	if c.DownloadConcurrency < 1 {
+		return errors.New("download_concurrency must be positive")
+	}
+
+	if c.DailyQuota < 0 {
+		return errors.New("daily_quota can't be negative")
+	}
+
+	if c.StreamCacheSize < 0 {
+		return errors.New("stream_cache_size can't be negative")
+	}
+
+	if c.StreamCacheSize != 0 && c.StreamCacheDir == "" {
+		return errors.New("stream_cache_dir is required when stream_cache_size is set")
+	}
+
+	if c.SpamMaxPerUploader < 1 {
+		return errors.New("spam_max_per_uploader must be positive")
+	}
+
+	for key, list := range map[string][]string{"blocked_users": c.BlockedUsers, "blocked_tracks": c.BlockedTracks, "allowed_users": c.AllowedUsers, "allowed_tracks": c.AllowedTracks} {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%s: bad pattern %q", key, pattern)
+			}
+		}
+	}
+
+	if c.TracingSamplePercent < 0 || c.TracingSamplePercent > 100 {
+		return errors.New("tracing_sample_percent must be between 0 and 100")
+	}
+
+	if c.PreviewSeconds < 0 {
+		return errors.New("preview_seconds can't be negative")
+	}
+
+	if c.SearchPoWDifficulty < 0 || c.SearchPoWDifficulty > 32 {
+		return errors.New("search_pow_difficulty must be between 0 and 32")
+	}
+
+	if c.SearchPoWDifficulty != 0 && c.SearchPoWTTL <= 0 {
+		return errors.New("search_pow_ttl must be positive")
+	}
+
+	if c.WatchPageSize < 1 || c.WatchPageSize > 50 {
+		return errors.New("watch_page_size must be between 1 and 50")
+	}
+
+	if c.SnapshotsMax < 1 {
+		return errors.New("snapshots_max must be positive")
+	}
+
+	if len(c.AudioPreference) == 0 {
+		return errors.New("audio_preference can't be empty")
+	}
+
+	switch c.Theme {
+	case "dark", "light", "black", "system":
+	default:
+		return errors.New("theme must be one of dark, light, black or system")
+	}
+
+	if c.SearchCacheSize < 0 {
+		return errors.New("search_cache_size can't be negative")
+	}
+
+	switch c.CompressionLevel {
+	case "off", "fastest", "default", "best":
+	default:
+		return errors.New("compression_level must be one of off, fastest, default or best")
+	}
+
+	if c.ResolveConcurrency < 1 {
+		return errors.New("resolve_concurrency must be positive")
+	}
+
+	if c.DataDir == "" {
+		return errors.New("data_dir can't be empty")
+	}
+
+	if c.WritesPerHour < 0 {
+		return errors.New("writes_per_hour can't be negative")
+	}
+
+	if c.LocalPlaylistMaxTracks < 1 {
+		return errors.New("local_playlist_max_tracks must be positive")
+	}
+
+	if c.Addr == "" {
+		return errors.New("addr can't be empty")
+	}
+
+	if m, err := strconv.ParseUint(c.UnixSocketMode, 8, 32); err != nil || m > 0o777 {
+		return errors.New("unix_socket_mode must be an octal mode like 0660")
+	}
+
+	for key, base := range map[string]string{"soundcloud_api": c.SoundcloudAPI, "soundcloud_web": c.SoundcloudWeb} {
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an http(s) url", key)
+		}
+	}
+
+	if c.UserAgent == "" {
+		return errors.New("user_agent can't be empty")
+	}
+
+	return nil
+}
+
+// fills c (a copy of defaults) from the config file and environment variables
+func load(c *Config) error {
+	path, required := os.LookupEnv("SOUNDCLOAK_CONFIG")
+	if !required {
+		path = defaultConfigFile
+	}
+
+	err := loadFile(path, required, c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	err = loadEnv(c)
+	if err != nil {
+		return err
+	}
+
+	err = validate(c)
+	if err != nil {
+		return err
+	}
+
+	c.InstanceURL = strings.TrimSuffix(c.InstanceURL, "/")
+
+	c.CacheCleanDelay = min(c.UserTTL, c.TrackTTL, c.PlaylistTTL, c.RemixesTTL, c.CommentsTTL, c.SearchTTL) / 4
+
+	return nil
+}
+
+// runs before any package depending on cfg is initialized, so everyone sees the loaded values
+func init() {
+	c := defaults
+	err := load(&c)
+	if err != nil {
+		log.Fatalf("config: %s\n", err)
+	}
+
+	current.Store(&c)
+}
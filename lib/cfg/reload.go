@@ -0,0 +1,74 @@
+package cfg
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+)
+
+// Hot-reloading of the configuration on SIGHUP
+// Only tunables are reloaded, options marked as restart keep their value until soundcloak is restarted
+// Nothing gets restarted, so in-flight requests (and proxied streams) are unaffected
+
+var reloadLock sync.Mutex
+var reloadHooks []func()
+
+// f will be called after every successful reload, to apply changed values (e.g. reset tickers)
+func OnReload(f func()) {
+	reloadLock.Lock()
+	reloadHooks = append(reloadHooks, f)
+	reloadLock.Unlock()
+}
+
+// reloads configuration from the config file and environment variables into a new Config, which replaces the current one
+// on error, the old configuration stays in place
+func Reload() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	// start from defaults again, so removed options go back to them
+	next := defaults
+	err := load(&next)
+	if err != nil {
+		return err
+	}
+
+	old := Get()
+	for _, o := range options {
+		was := reflect.ValueOf(old).Elem().FieldByIndex(o.index)
+		now := reflect.ValueOf(&next).Elem().FieldByIndex(o.index)
+		if o.restart && !reflect.DeepEqual(was.Interface(), now.Interface()) {
+			log.Printf("config: %s changed, restart soundcloak to apply it\n", o.key)
+			now.Set(was)
+		}
+	}
+
+	current.Store(&next)
+
+	for _, f := range reloadHooks {
+		f()
+	}
+
+	return nil
+}
+
+// reload configuration when receiving SIGHUP
+func WatchReload() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+
+	go func() {
+		for range ch {
+			err := Reload()
+			if err != nil {
+				log.Printf("config: reload failed, keeping old configuration: %s\n", err)
+				continue
+			}
+
+			log.Println("config: reloaded")
+		}
+	}()
+}
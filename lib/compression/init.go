@@ -0,0 +1,89 @@
+package compression
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/valyala/fasthttp"
+)
+
+// Compresses rendered pages and api responses, static assets are compressed (and cached on disk) by the static handler itself
+
+// smaller bodies aren't worth it
+const minSize = 512
+
+type levels struct {
+	brotli, zstd, gzip int
+}
+
+var presets = map[string]levels{
+	"fastest": {fasthttp.CompressBrotliBestSpeed, fasthttp.CompressZstdBestSpeed, fasthttp.CompressBestSpeed},
+	"default": {fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressZstdDefault, fasthttp.CompressDefaultCompression},
+	"best":    {fasthttp.CompressBrotliBestCompression, fasthttp.CompressZstdBestCompression, fasthttp.CompressBestCompression},
+}
+
+// audio and images are already compressed
+func compressible(ct string) bool {
+	return strings.HasPrefix(ct, "text/") ||
+		strings.Contains(ct, "json") ||
+		strings.Contains(ct, "javascript") ||
+		strings.Contains(ct, "xml") ||
+		strings.Contains(ct, "mpegurl") ||
+		strings.HasPrefix(ct, "image/svg")
+}
+
+// brotli > zstd > gzip, the q-values are ignored (like fasthttp does)
+func negotiate(accept []byte) string {
+	for _, enc := range []string{"br", "zstd", "gzip"} {
+		if bytes.Contains(accept, []byte(enc)) {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+func New() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		l, ok := presets[cfg.Get().CompressionLevel]
+		if !ok {
+			return nil // off
+		}
+
+		resp := c.Response()
+		// streamed bodies (downloads, static files) would have to be read into memory fully
+		if resp.IsBodyStream() || len(resp.Header.Peek(fiber.HeaderContentEncoding)) != 0 {
+			return nil
+		}
+
+		body := resp.Body()
+		if len(body) < minSize || !compressible(string(resp.Header.ContentType())) {
+			return nil
+		}
+
+		resp.Header.Add(fiber.HeaderVary, fiber.HeaderAcceptEncoding)
+
+		var out []byte
+		enc := negotiate(c.Request().Header.Peek(fiber.HeaderAcceptEncoding))
+		switch enc {
+		case "br":
+			out = fasthttp.AppendBrotliBytesLevel(nil, body, l.brotli)
+		case "zstd":
+			out = fasthttp.AppendZstdBytesLevel(nil, body, l.zstd)
+		case "gzip":
+			out = fasthttp.AppendGzipBytesLevel(nil, body, l.gzip)
+		default:
+			return nil
+		}
+
+		resp.SetBodyRaw(out)
+		resp.Header.Set(fiber.HeaderContentEncoding, enc)
+		return nil
+	}
+}
@@ -0,0 +1,37 @@
+package cors
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	fibercors "github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// CORS headers for the api and the stream proxy (cfg.CORSOrigins), so web apps and browser extensions can use an instance directly
+// credentials are never allowed, preferences and favorites cookies stay with our own pages
+
+// prefixes the headers are sent for
+var paths = []string{"/_/api", "/graphql", "/_/proxy/streams"}
+
+// checked on every request, so the origins can be changed without a restart
+func allowed(origin string) bool {
+	return slices.Contains(cfg.Get().CORSOrigins, "*") || slices.ContainsFunc(cfg.Get().CORSOrigins, func(o string) bool {
+		return strings.EqualFold(strings.TrimSuffix(o, "/"), origin)
+	})
+}
+
+func Load(r fiber.Router) {
+	h := fibercors.New(fibercors.Config{
+		AllowOriginsFunc: allowed,
+		AllowMethods:     "GET,HEAD,POST",
+		AllowHeaders:     "Accept,Content-Type,Range",
+		ExposeHeaders:    "Content-Length,Content-Range,Accept-Ranges,ETag",
+		MaxAge:           int(cfg.Get().CORSMaxAge.Seconds()),
+	})
+
+	for _, p := range paths {
+		r.Use(p, h)
+	}
+}
@@ -0,0 +1,66 @@
+package csp
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Security headers for every response: Content-Security-Policy, Referrer-Policy and Permissions-Policy
+// scripts only come from files (assets/), no inline ones, so injected markup can't run anything
+// and pages stay the same for every visitor (etags, http_cache), which a per-request nonce would break
+
+// images, media and hls requests go to soundcloud's cdn, unless the instance proxies them
+func sources(proxied bool, hosts []string) string {
+	s := "'self'"
+	if !proxied {
+		for _, h := range hosts {
+			s += " https://" + h + " https://*." + h
+		}
+	}
+
+	if len(cfg.Get().CSPSources) != 0 {
+		s += " " + strings.Join(cfg.Get().CSPSources, " ")
+	}
+
+	return s
+}
+
+// embeds (/w/) are meant to be framed by other sites, everything else only by us
+func policy(embed bool) string {
+	ancestors := "'self'"
+	if embed {
+		ancestors = "*"
+	}
+
+	return "default-src 'none'" +
+		"; script-src 'self'" +
+		"; style-src 'self' 'unsafe-inline' https://fonts.googleapis.com" + // style attributes all over the templates, the index page uses dm mono from google fonts
+		"; img-src " + sources(cfg.Get().Features.EnableImageProxy, cfg.Get().SoundcloudImageHosts) + " data:" +
+		"; media-src " + sources(cfg.Get().Features.EnableStreamProxy, cfg.Get().SoundcloudMediaHosts) + " blob:" + // hls.js plays from a MediaSource blob
+		"; connect-src " + sources(cfg.Get().Features.EnableStreamProxy, cfg.Get().SoundcloudMediaHosts) +
+		"; worker-src 'self' blob:" +
+		"; manifest-src 'self'" +
+		"; font-src 'self' https://fonts.gstatic.com" +
+		"; form-action 'self'" +
+		"; base-uri 'none'" +
+		"; frame-ancestors " + ancestors
+}
+
+func Load(r fiber.Router) {
+	r.Use(func(c *fiber.Ctx) error {
+		if !cfg.Get().SecurityHeaders {
+			return c.Next()
+		}
+
+		// handlers can still replace it, like the svg cards in lib/nowplaying
+		c.Set("Content-Security-Policy", policy(strings.HasPrefix(c.Path(), "/w/")))
+		c.Set("Referrer-Policy", cfg.Get().ReferrerPolicy)
+		if cfg.Get().PermissionsPolicy != "" {
+			c.Set("Permissions-Policy", cfg.Get().PermissionsPolicy)
+		}
+
+		return c.Next()
+	})
+}
@@ -0,0 +1,35 @@
+package csrf
+
+import (
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Middleware for forms which change something, they have to be submitted from our own pages
+// the cookies are SameSite=Lax, but a cross-site post without them can still do harm (log someone into another account, replace their favorites key)
+
+// the Origin header, or the Referer when a browser leaves the origin out. requests with neither are rejected
+func Check(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+		return c.Next()
+	}
+
+	if !SameOrigin(c) {
+		return fiber.ErrForbidden
+	}
+
+	return c.Next()
+}
+
+// the check itself, for requests which aren't posts but still act on the visitor's behalf (websockets)
+func SameOrigin(c *fiber.Ctx) bool {
+	origin := c.Get("Origin")
+	if origin == "" {
+		if ref, err := url.Parse(c.Get("Referer")); err == nil && ref.Host != "" {
+			origin = ref.Scheme + "://" + ref.Host
+		}
+	}
+
+	return origin == c.BaseURL()
+}
@@ -0,0 +1,289 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// The ContentDirectory tree, object ids are paths:
+//
+//	0                        root
+//	favorites                favorite tracks of cfg.DLNAFavorites
+//	artists/<user>           favorite artists, with their tracks
+//	playlists/<id>           local playlists from cfg.DLNAPlaylists
+//
+// tracks are <container>/<track id>
+
+// tracks of an artist
+const pageSize = 50
+
+var errNoObject = errors.New("no such object")
+
+type object struct {
+	id     string
+	parent string
+	title  string
+	class  string
+
+	container bool
+
+	// items
+	track    string
+	artist   string
+	album    string
+	duration time.Duration
+}
+
+func container(id string, parent string, title string, class string) object {
+	return object{id: id, parent: parent, title: title, class: class, container: true}
+}
+
+func item(parent string, t *sc.Track, album string) object {
+	return object{
+		id:       parent + "/" + t.ID,
+		parent:   parent,
+		title:    t.Title,
+		class:    "object.item.audioItem.musicTrack",
+		track:    t.ID,
+		artist:   t.Author.Username,
+		album:    album,
+		duration: time.Duration(t.DurationMs) * time.Millisecond,
+	}
+}
+
+func root() object {
+	return container("0", "-1", cfg.Get().DLNAName, "object.container.storageFolder")
+}
+
+// the containers under the root
+func top() []object {
+	var res []object
+	if cfg.Get().DLNAFavorites != "" {
+		res = append(res, container("favorites", "0", "Favorites", "object.container.storageFolder"))
+		res = append(res, container("artists", "0", "Artists", "object.container.storageFolder"))
+	}
+
+	if len(cfg.Get().DLNAPlaylists) != 0 {
+		res = append(res, container("playlists", "0", "Playlists", "object.container.storageFolder"))
+	}
+
+	return res
+}
+
+func localPlaylist(id string) (local.Playlist, error) {
+	if !slices.Contains(cfg.Get().DLNAPlaylists, id) {
+		return local.Playlist{}, errNoObject
+	}
+
+	return local.Get(id)
+}
+
+func artistTracks(id string, permalink string) ([]object, error) {
+	u, err := sc.GetUser(permalink)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := u.GetTracks(sc.NewCursor(pageSize, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]object, 0, len(p.Collection))
+	for i := range p.Collection {
+		if !p.Collection[i].Unavailable {
+			res = append(res, item(id, &p.Collection[i], ""))
+		}
+	}
+
+	return res, nil
+}
+
+func children(id string) ([]object, error) {
+	parts := strings.Split(id, "/")
+	switch {
+	case id == "0":
+		return top(), nil
+	case id == "favorites" && cfg.Get().DLNAFavorites != "":
+		f, err := favorites.Get(cfg.Get().DLNAFavorites)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]object, 0, len(f.Tracks))
+		for _, t := range f.Tracks {
+			res = append(res, object{id: id + "/" + t.ID, parent: id, title: t.Title, artist: t.Artist, track: t.ID, class: "object.item.audioItem.musicTrack"})
+		}
+		return res, nil
+	case id == "artists" && cfg.Get().DLNAFavorites != "":
+		f, err := favorites.Get(cfg.Get().DLNAFavorites)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]object, 0, len(f.Users))
+		for _, u := range f.Users {
+			res = append(res, container("artists/"+u.Permalink, id, u.Username, "object.container.person.musicArtist"))
+		}
+		return res, nil
+	case len(parts) == 2 && parts[0] == "artists" && cfg.Get().DLNAFavorites != "":
+		return artistTracks(id, parts[1])
+	case id == "playlists":
+		var res []object
+		for _, pid := range cfg.Get().DLNAPlaylists {
+			p, err := local.Get(pid)
+			if err != nil {
+				log.Printf("dlna: error getting local playlist %s: %s\n", pid, err)
+				continue
+			}
+
+			res = append(res, container("playlists/"+p.ID, id, p.Title, "object.container.playlistContainer"))
+		}
+		return res, nil
+	case len(parts) == 2 && parts[0] == "playlists":
+		p, err := localPlaylist(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		tracks, err := local.GetTracks(p.Tracks)
+		if err != nil {
+			return nil, err
+		}
+
+		res := make([]object, 0, len(tracks))
+		for _, t := range tracks {
+			res = append(res, item(id, t, p.Title))
+		}
+		return res, nil
+	}
+
+	return nil, errNoObject
+}
+
+// the object itself, for BrowseMetadata
+func metadata(id string) (object, error) {
+	if id == "0" {
+		return root(), nil
+	}
+
+	for _, o := range top() {
+		if o.id == id {
+			return o, nil
+		}
+	}
+
+	parts := strings.Split(id, "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "artists" && cfg.Get().DLNAFavorites != "":
+		u, err := sc.GetUser(parts[1])
+		if err != nil {
+			return object{}, err
+		}
+		return container(id, "artists", u.Username, "object.container.person.musicArtist"), nil
+	case len(parts) == 2 && parts[0] == "playlists":
+		p, err := localPlaylist(parts[1])
+		if err != nil {
+			return object{}, err
+		}
+		return container(id, "playlists", p.Title, "object.container.playlistContainer"), nil
+	case (len(parts) == 2 && parts[0] == "favorites") || (len(parts) == 3 && (parts[0] == "artists" || parts[0] == "playlists")):
+		parent := strings.Join(parts[:len(parts)-1], "/")
+		if _, err := metadata(parent); err != nil {
+			return object{}, err
+		}
+
+		t, err := sc.GetTrackByID(parts[len(parts)-1])
+		if err != nil {
+			return object{}, err
+		}
+		return item(parent, &t, ""), nil
+	}
+
+	return object{}, errNoObject
+}
+
+func audioURL(base string, track string) string {
+	return base + "/audio/" + url.PathEscape(track) + ".mp3"
+}
+
+// h:mm:ss.000
+func formatDuration(d time.Duration) string {
+	s := int(d.Seconds())
+	return strconv.Itoa(s/3600) + ":" + pad(s/60%60) + ":" + pad(s%60) + ".000"
+}
+
+func pad(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+
+	return strconv.Itoa(n)
+}
+
+func element(b *strings.Builder, name string, value string) {
+	if value == "" {
+		return
+	}
+
+	b.WriteString("<" + name + ">")
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</" + name + ">")
+}
+
+func attr(b *strings.Builder, name string, value string) {
+	b.WriteString(" " + name + `="`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteByte('"')
+}
+
+// DIDL-Lite, base is the url of this server (for the audio urls)
+func didl(objects []object, base string) string {
+	var b strings.Builder
+	b.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">`)
+	for _, o := range objects {
+		tag := "item"
+		if o.container {
+			tag = "container"
+		}
+
+		b.WriteString("<" + tag)
+		attr(&b, "id", o.id)
+		attr(&b, "parentID", o.parent)
+		attr(&b, "restricted", "1")
+		b.WriteByte('>')
+
+		element(&b, "dc:title", o.title)
+		element(&b, "upnp:class", o.class)
+		if !o.container {
+			element(&b, "upnp:artist", o.artist)
+			element(&b, "dc:creator", o.artist)
+			element(&b, "upnp:album", o.album)
+
+			b.WriteString("<res")
+			attr(&b, "protocolInfo", "http-get:*:audio/mpeg:"+contentFeatures)
+			if o.duration != 0 {
+				attr(&b, "duration", formatDuration(o.duration))
+			}
+			b.WriteByte('>')
+			xml.EscapeText(&b, []byte(audioURL(base, o.track)))
+			b.WriteString("</res>")
+		}
+
+		b.WriteString("</" + tag + ">")
+	}
+	b.WriteString("</DIDL-Lite>")
+
+	return b.String()
+}
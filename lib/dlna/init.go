@@ -0,0 +1,304 @@
+package dlna
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// DLNA/UPnP media server (cfg.DLNAAddr): a ContentDirectory with the favorites and local playlists, found by tvs and speakers over ssdp
+// tracks are served as mp3 (the same way as downloads), so renderers that can't do hls play them too
+
+// streaming, no seeking (we don't know the size up front), no transcoding
+const contentFeatures = "DLNA.ORG_OP=00;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+
+// the library only changes when favorites/playlists do, clients refetch anyway
+const systemUpdateID = "1"
+
+// 701 - No such object
+const errCodeNoObject = 701
+
+type soapError struct {
+	code int
+	desc string
+}
+
+func (e soapError) Error() string {
+	return e.desc
+}
+
+// the unique device name, stable for the same name and address so clients remember us
+func udn() string {
+	sum := sha1.Sum([]byte(cfg.Get().DLNAName + "\x00" + cfg.Get().DLNAAddr))
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// the arguments of the action in a soap envelope
+type envelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	}
+}
+
+func parseAction(body []byte) (string, map[string]string, error) {
+	var e envelope
+	if err := xml.Unmarshal(body, &e); err != nil {
+		return "", nil, err
+	}
+
+	args := make(map[string]string, len(e.Body.Action.Args))
+	for _, a := range e.Body.Action.Args {
+		args[a.XMLName.Local] = a.Value
+	}
+
+	return e.Body.Action.XMLName.Local, args, nil
+}
+
+type arg struct {
+	name  string
+	value string
+}
+
+func respond(c *fiber.Ctx, service string, action string, args ...arg) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	b.WriteString(`<u:` + action + `Response xmlns:u="` + service + `">`)
+	for _, a := range args {
+		element(&b, a.name, a.value)
+		if a.value == "" {
+			b.WriteString("<" + a.name + "/>")
+		}
+	}
+	b.WriteString(`</u:` + action + `Response></s:Body></s:Envelope>`)
+
+	c.Set("Content-Type", `text/xml; charset="utf-8"`)
+	return c.SendString(b.String())
+}
+
+func fault(c *fiber.Ctx, err error) error {
+	e, ok := err.(soapError)
+	if !ok {
+		e = soapError{code: 501, desc: "Action Failed"} // logged by the caller
+	}
+
+	c.Set("Content-Type", `text/xml; charset="utf-8"`)
+	return c.Status(500).SendString(`<?xml version="1.0" encoding="utf-8"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>` +
+		strconv.Itoa(e.code) + `</errorCode><errorDescription>` + escape(e.desc) + `</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+}
+
+func browse(c *fiber.Ctx, args map[string]string) error {
+	id := args["ObjectID"]
+	start, _ := strconv.Atoi(args["StartingIndex"])
+	count, _ := strconv.Atoi(args["RequestedCount"])
+	if start < 0 {
+		start = 0
+	}
+
+	var objects []object
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		o, err := metadata(id)
+		if err != nil {
+			return err
+		}
+		objects = []object{o}
+	case "BrowseDirectChildren":
+		var err error
+		objects, err = children(id)
+		if err != nil {
+			return err
+		}
+	default:
+		return soapError{code: 402, desc: "Invalid Args"}
+	}
+
+	total := len(objects)
+	if start > total {
+		start = total
+	}
+	objects = objects[start:]
+	if count > 0 && count < len(objects) {
+		objects = objects[:count]
+	}
+
+	return respond(c, contentDirectoryType, "Browse",
+		arg{"Result", didl(objects, c.BaseURL())},
+		arg{"NumberReturned", strconv.Itoa(len(objects))},
+		arg{"TotalMatches", strconv.Itoa(total)},
+		arg{"UpdateID", systemUpdateID},
+	)
+}
+
+func contentDirectory(c *fiber.Ctx) error {
+	action, args, err := parseAction(c.Body())
+	if err != nil {
+		return fault(c, soapError{code: 401, desc: "Invalid Action"})
+	}
+
+	switch action {
+	case "Browse":
+		err = browse(c, args)
+	case "GetSystemUpdateID":
+		err = respond(c, contentDirectoryType, action, arg{"Id", systemUpdateID})
+	case "GetSearchCapabilities":
+		err = respond(c, contentDirectoryType, action, arg{"SearchCaps", ""})
+	case "GetSortCapabilities":
+		err = respond(c, contentDirectoryType, action, arg{"SortCaps", ""})
+	default:
+		err = soapError{code: 401, desc: "Invalid Action"}
+	}
+
+	if err != nil {
+		if errors.Is(err, errNoObject) || errors.Is(err, sc.ErrNoURL) {
+			err = soapError{code: errCodeNoObject, desc: "No such object"}
+		}
+
+		if _, ok := err.(soapError); !ok {
+			log.Printf("dlna: %s %s: %s\n", action, args["ObjectID"], err)
+		}
+
+		return fault(c, err)
+	}
+
+	return nil
+}
+
+func connectionManager(c *fiber.Ctx) error {
+	action, _, err := parseAction(c.Body())
+	if err != nil {
+		return fault(c, soapError{code: 401, desc: "Invalid Action"})
+	}
+
+	switch action {
+	case "GetProtocolInfo":
+		return respond(c, connectionManagerType, action, arg{"Source", "http-get:*:audio/mpeg:*"}, arg{"Sink", ""})
+	case "GetCurrentConnectionIDs":
+		return respond(c, connectionManagerType, action, arg{"ConnectionIDs", "0"})
+	}
+
+	return fault(c, soapError{code: 401, desc: "Invalid Action"})
+}
+
+func audio(c *fiber.Ctx) error {
+	id, err := url.PathUnescape(strings.TrimSuffix(c.Params("id"), ".mp3"))
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+
+	t, err := sc.GetTrackByID(id)
+	if err != nil {
+		log.Printf("dlna: error getting track %s: %s\n", id, err)
+		return fiber.ErrNotFound
+	}
+
+	c.Set("Content-Type", "audio/mpeg")
+	c.Set("transferMode.dlna.org", "Streaming")
+	c.Set("contentFeatures.dlna.org", contentFeatures)
+	if c.Method() == fiber.MethodHead {
+		return nil
+	}
+
+	ip := c.IP()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		err := download.Track(t, bandwidth.Writer{W: w, IP: ip, Track: t.ID})
+		if err != nil {
+			log.Printf("dlna: error streaming %s from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+		}
+	})
+
+	return nil
+}
+
+// serves http and ssdp until ln is closed
+func Serve(ln net.Listener) {
+	u := udn()
+	description := fmt.Sprintf(deviceDescription, escape(cfg.Get().DLNAName), escape(cfg.Version), u)
+
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+		RequestMethods:        append(append([]string{}, fiber.DefaultMethods...), "SUBSCRIBE", "UNSUBSCRIBE"),
+		ServerHeader:          serverHeader,
+	})
+
+	xmlFile := func(s string) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			c.Set("Content-Type", `text/xml; charset="utf-8"`)
+			return c.SendString(s)
+		}
+	}
+
+	app.Get("/description.xml", xmlFile(description))
+	app.Get("/ContentDirectory.xml", xmlFile(contentDirectorySCPD))
+	app.Get("/ConnectionManager.xml", xmlFile(connectionManagerSCPD))
+	app.Post("/ctl/ContentDirectory", contentDirectory)
+	app.Post("/ctl/ConnectionManager", connectionManager)
+
+	// nothing ever changes, so subscribers just never get events
+	app.Add("SUBSCRIBE", "/evt/:service", func(c *fiber.Ctx) error {
+		sid := c.Get("SID")
+		if sid == "" {
+			sid = u + "-" + c.Params("service")
+		}
+
+		c.Set("SID", sid)
+		c.Set("TIMEOUT", "Second-1800")
+		return nil
+	})
+	app.Add("UNSUBSCRIBE", "/evt/:service", func(c *fiber.Ctx) error {
+		return nil
+	})
+
+	app.Get("/audio/:id", audio) // HEAD too
+
+	done := make(chan struct{})
+	defer close(done)
+	go ssdp(u, ln.Addr().(*net.TCPAddr).Port, done)
+
+	if err := app.Listener(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("dlna: %s\n", err)
+	}
+}
+
+// checks the config, false (after logging why) when the server can't work
+func Enabled() bool {
+	if cfg.Get().DLNAAddr == "" {
+		return false
+	}
+
+	if strings.HasPrefix(cfg.Get().DLNAAddr, "unix:") {
+		log.Println("dlna_addr has to be a tcp address (renderers on the lan connect to it), not starting the dlna server")
+		return false
+	}
+
+	if !cfg.Get().Features.EnableStreamProxy {
+		log.Println("dlna_addr needs the stream proxy, not starting the dlna server")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,129 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// SSDP: answers M-SEARCH requests and announces the server (NOTIFY) on the lan
+
+const ssdpAddr = "239.255.255.250:1900"
+
+// how long announcements are valid, they're repeated every half of it
+const maxAge = 30 * time.Minute
+
+var serverHeader = "Linux/1.0 UPnP/1.0 soundcloak/" + cfg.Version
+
+// nt (or st) -> usn, for everything we announce
+func notifications(udn string) [][2]string {
+	return [][2]string{
+		{"upnp:rootdevice", udn + "::upnp:rootdevice"},
+		{udn, udn},
+		{deviceType, udn + "::" + deviceType},
+		{contentDirectoryType, udn + "::" + contentDirectoryType},
+		{connectionManagerType, udn + "::" + connectionManagerType},
+	}
+}
+
+// the address of the interface packets to remote go out of, nothing is sent
+func localIP(remote *net.UDPAddr) string {
+	c, err := net.DialUDP("udp4", nil, remote)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func location(remote *net.UDPAddr, port int) string {
+	return "http://" + net.JoinHostPort(localIP(remote), strconv.Itoa(port)) + "/description.xml"
+}
+
+func notify(conn *net.UDPConn, group *net.UDPAddr, udn string, port int, nts string) {
+	for _, n := range notifications(udn) {
+		msg := "NOTIFY * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"NT: " + n[0] + "\r\n" +
+			"NTS: " + nts + "\r\n" +
+			"USN: " + n[1] + "\r\n"
+		if nts == "ssdp:alive" {
+			msg += "CACHE-CONTROL: max-age=" + strconv.Itoa(int(maxAge.Seconds())) + "\r\n" +
+				"LOCATION: " + location(group, port) + "\r\n" +
+				"SERVER: " + serverHeader + "\r\n"
+		}
+
+		if _, err := conn.WriteToUDP([]byte(msg+"\r\n"), group); err != nil {
+			log.Printf("dlna: error sending ssdp notify: %s\n", err)
+			return
+		}
+	}
+}
+
+func answer(conn *net.UDPConn, to *net.UDPAddr, udn string, port int, st string) {
+	for _, n := range notifications(udn) {
+		if st != "ssdp:all" && st != n[0] {
+			continue
+		}
+
+		msg := fmt.Sprintf("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=%d\r\nDATE: %s\r\nEXT:\r\nLOCATION: %s\r\nSERVER: %s\r\nST: %s\r\nUSN: %s\r\n\r\n",
+			int(maxAge.Seconds()), time.Now().UTC().Format(http.TimeFormat), location(to, port), serverHeader, n[0], n[1])
+		conn.WriteToUDP([]byte(msg), to)
+	}
+}
+
+// until done is closed, then says goodbye
+func ssdp(udn string, port int, done <-chan struct{}) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		log.Printf("dlna: %s\n", err)
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.Printf("dlna: can't listen for ssdp, the server won't be discoverable: %s\n", err)
+		return
+	}
+
+	go func() {
+		notify(conn, group, udn, port, "ssdp:alive")
+		ticker := time.NewTicker(maxAge / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				notify(conn, group, udn, port, "ssdp:alive")
+			case <-done:
+				notify(conn, group, udn, port, "ssdp:byebye")
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return // closed
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || strings.Trim(req.Header.Get("MAN"), `"`) != "ssdp:discover" {
+			continue
+		}
+
+		answer(conn, from, udn, port, req.Header.Get("ST"))
+	}
+}
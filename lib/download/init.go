@@ -0,0 +1,174 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Downloading tracks as a single mp3 file
+// the stream is hls with mp3 segments, which can simply be concatenated
+
+var ErrNoSegments = errors.New("no segments")
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+}
+
+func get(u string, resp *fasthttp.Response) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	err := httpc.Do(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("download: got status code %d", resp.StatusCode())
+	}
+
+	return nil
+}
+
+// returns segment urls of a hls playlist
+func Segments(playlist string) ([]string, error) {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := get(playlist, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []string
+	for _, line := range bytes.Split(resp.Body(), []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) != 0 && line[0] != '#' {
+			segments = append(segments, string(line))
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, ErrNoSegments
+	}
+
+	return segments, nil
+}
+
+// writes the whole track as mp3 to w
+func Track(t sc.Track, w io.Writer) error {
+	return TrackProgress(t, w, nil)
+}
+
+// same as Track, progress (if not nil) is called after every segment
+func TrackProgress(t sc.Track, w io.Writer, progress func(done int, total int)) error {
+	// always mp3, the segments can just be concatenated
+	stream, err := t.GetStreamFor(t.Media.SelectCompatible())
+	if err != nil {
+		return err
+	}
+
+	segments, err := Segments(stream)
+	if err != nil {
+		return err
+	}
+
+	return EachSegment(segments, func(i int, data []byte) error {
+		_, err := w.Write(data)
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(i+1, len(segments))
+		}
+		return nil
+	})
+}
+
+// fetches the urls one after another and calls f with each body, data is only valid until f returns
+func EachSegment(segments []string, f func(i int, data []byte) error) error {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	for i, s := range segments {
+		err := get(s, resp)
+		if err != nil {
+			return err
+		}
+
+		err = f(i, resp.Body())
+		if err != nil {
+			return err
+		}
+
+		resp.Reset()
+	}
+
+	return nil
+}
+
+// replaces everything that file systems hate
+func Sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+}
+
+// file name for the downloaded track
+func Filename(t sc.Track) string {
+	return Sanitize(t.Author.Username+" - "+t.Title) + ".mp3"
+}
+
+func Load(r fiber.Router) {
+	r.Get("/_/download", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableDownloads {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}, bandwidth.Quota, func(c *fiber.Ctx) error {
+		u := c.Query("url")
+		if u == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetArbitraryTrack(u)
+		if err != nil {
+			log.Printf("error getting %s (download): %s\n", u, err)
+			return err
+		}
+
+		c.Set("Content-Type", "audio/mpeg")
+		c.Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(Filename(t)))
+		ip := c.IP()
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			err := Track(t, bandwidth.Writer{W: w, IP: ip, Track: t.ID})
+			if err != nil {
+				log.Printf("error downloading %s from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+			}
+		})
+
+		return nil
+	})
+}
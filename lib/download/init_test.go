@@ -0,0 +1,67 @@
+package download_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/sctest"
+)
+
+var srv *sctest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	srv, err = sctest.Start()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+func TestHLS(t *testing.T) {
+	tr, err := sc.GetTrack("floppa/floppa-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tr.GetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := download.Segments(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[0] != srv.URL+"/hls/0.mp3" || segments[1] != srv.URL+"/hls/1.mp3" {
+		t.Fatalf("got segments %q", segments)
+	}
+
+	var parts [][]byte
+	err = download.EachSegment(segments, func(i int, data []byte) error {
+		if i != len(parts) {
+			t.Errorf("segment %d came as %d", len(parts), i)
+		}
+		parts = append(parts, bytes.Clone(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var whole bytes.Buffer
+	err = download.Track(tr, &whole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(whole.Bytes(), bytes.Join(parts, nil)) {
+		t.Errorf("downloaded track (%d bytes) isn't the segments concatenated", whole.Len())
+	}
+}
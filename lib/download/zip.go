@@ -0,0 +1,138 @@
+package download
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Downloading many tracks (a playlist) as one zip, with a .m3u and the cover art
+// tracks are downloaded cfg.DownloadConcurrency at a time into memory and written in order, so memory use stays bounded
+
+type downloaded struct {
+	data []byte
+	err  error
+}
+
+// stored (not deflated, mp3 doesn't compress) with the crc known upfront, so no data descriptors which some unzippers choke on
+func writeFile(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Store,
+		Modified:           time.Now(),
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// downloads tracks in the background, the result of tracks[i] is sent to the i-th channel
+// a new download only starts after the writer took a finished one (release), close done to stop early
+func downloadAll(tracks []*sc.Track, release <-chan struct{}, done <-chan struct{}) []chan downloaded {
+	results := make([]chan downloaded, len(tracks))
+	for i := range results {
+		results[i] = make(chan downloaded, 1)
+	}
+
+	go func() {
+		slots := cfg.Get().DownloadConcurrency
+		for i, t := range tracks {
+			if slots == 0 {
+				select {
+				case <-release:
+				case <-done:
+					return
+				}
+			} else {
+				slots--
+			}
+
+			go func(i int, t *sc.Track) {
+				var buf bytes.Buffer
+				err := Track(*t, &buf)
+				results[i] <- downloaded{data: buf.Bytes(), err: err}
+			}(i, t)
+		}
+	}()
+
+	return results
+}
+
+// writes a zip of the tracks to w: "01 - author - title.mp3"..., "<title>.m3u" and cover.jpg (if there's artwork)
+// tracks which fail to download are skipped, progress (if not nil) is called after every track
+func Zip(w io.Writer, title string, artwork string, tracks []*sc.Track, progress func(done int, total int)) error {
+	zw := zip.NewWriter(w)
+
+	release := make(chan struct{}, len(tracks))
+	done := make(chan struct{})
+	defer close(done)
+	results := downloadAll(tracks, release, done)
+
+	width := len(strconv.Itoa(len(tracks)))
+	if width < 2 {
+		width = 2
+	}
+
+	var m3u strings.Builder
+	m3u.WriteString("#EXTM3U\n")
+	for i, t := range tracks {
+		res := <-results[i]
+		release <- struct{}{}
+		if progress != nil {
+			progress(i+1, len(tracks))
+		}
+
+		if res.err != nil {
+			log.Printf("error downloading %s from %s (zip): %s\n", t.Permalink, t.Author.Permalink, res.err)
+			continue
+		}
+
+		name := fmt.Sprintf("%0*d - %s", width, i+1, Filename(*t))
+		err := writeFile(zw, name, res.data)
+		if err != nil {
+			return err
+		}
+
+		m3u.WriteString("#EXTINF:" + strconv.FormatInt(int64(t.Duration.Seconds()), 10) + "," + strings.ReplaceAll(t.Author.Username+" - "+t.Title, "\n", " ") + "\n")
+		m3u.WriteString(name + "\n")
+	}
+
+	err := writeFile(zw, Sanitize(title)+".m3u", []byte(m3u.String()))
+	if err != nil {
+		return err
+	}
+
+	if artwork != "" {
+		resp := fasthttp.AcquireResponse()
+		err := get(artwork, resp)
+		if err == nil {
+			err = writeFile(zw, "cover.jpg", resp.Body())
+		} else {
+			log.Printf("error downloading cover %s (zip): %s\n", artwork, err)
+			err = nil
+		}
+		fasthttp.ReleaseResponse(resp)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
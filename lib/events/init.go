@@ -0,0 +1,140 @@
+package events
+
+import (
+	"bufio"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Server-Sent Events: other modules publish to a topic (like "job:<id>"), browsers get them through Stream
+// in memory, so subscribers only get events published by the same process
+
+// comment sent when nothing happened for a while, so proxies don't drop the connection
+const keepAlive = 15 * time.Second
+
+type Event struct {
+	Name string
+	Data any // encoded as json
+}
+
+// events are dropped for slow subscribers instead of blocking the publisher
+const buffer = 16
+
+var topics = map[string]map[chan Event]struct{}{}
+var topicsLock = &sync.Mutex{}
+
+func Subscribe(topic string) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	topicsLock.Lock()
+	subs, ok := topics[topic]
+	if !ok {
+		subs = map[chan Event]struct{}{}
+		topics[topic] = subs
+	}
+	subs[ch] = struct{}{}
+	topicsLock.Unlock()
+
+	return ch, func() {
+		topicsLock.Lock()
+		defer topicsLock.Unlock()
+
+		if _, ok := topics[topic][ch]; ok {
+			delete(topics[topic], ch)
+			if len(topics[topic]) == 0 {
+				delete(topics, topic)
+			}
+			close(ch)
+		}
+	}
+}
+
+func Publish(topic string, name string, data any) {
+	topicsLock.Lock()
+	defer topicsLock.Unlock()
+
+	for ch := range topics[topic] {
+		select {
+		case ch <- Event{name, data}:
+		default:
+		}
+	}
+}
+
+// ends every stream subscribed to the topic, after the events already published in it
+func Close(topic string) {
+	topicsLock.Lock()
+	defer topicsLock.Unlock()
+
+	for ch := range topics[topic] {
+		close(ch)
+	}
+	delete(topics, topic)
+}
+
+func write(w *bufio.Writer, e Event) error {
+	data, err := cfg.JSON.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+
+	if e.Name != "" {
+		w.WriteString("event: " + e.Name + "\n")
+	}
+	w.WriteString("data: " + strings.ReplaceAll(string(data), "\n", "\ndata: ") + "\n\n")
+
+	return w.Flush()
+}
+
+// streams the topic as text/event-stream until it gets closed or the client goes away
+// state (can be nil) is called after subscribing, its event is sent first so the client doesn't have to fetch the current state separately
+// when it returns true, the stream ends right after (for example when a job is already done, nothing will be published anymore)
+func Stream(c *fiber.Ctx, topic string, state func() (Event, bool)) error {
+	ch, cancel := Subscribe(topic)
+	var initial Event
+	var end bool
+	if state != nil {
+		initial, end = state()
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("X-Accel-Buffering", "no") // nginx
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		if state != nil {
+			if write(w, initial) != nil || end {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				if write(w, e) != nil {
+					return
+				}
+			case <-ticker.C:
+				w.WriteString(": keepalive\n\n")
+				if w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+	"github.com/valyala/fasthttp"
+)
+
+// Exporting playlists/users to a directory with static html and downloaded audio, for archiving
+
+var ErrUnsupportedURL = errors.New("unsupported url, expected a playlist or a user")
+
+// accepts https://soundcloud.com/... links and plain permalinks
+func Permalink(raw string) string {
+	if u, err := url.Parse(raw); err == nil && u.Host != "" {
+		raw = u.Path
+	}
+
+	return strings.Trim(raw, "/")
+}
+
+func saveURL(u string, p string) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := fasthttp.Do(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("export: got status code %d for %s", resp.StatusCode(), u)
+	}
+
+	return os.WriteFile(p, resp.Body(), 0o644)
+}
+
+func saveTrack(t *sc.Track, p string) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+
+	err = download.Track(*t, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(p)
+	}
+
+	return err
+}
+
+// downloads everything and writes index.html, log is called with progress messages
+func write(dir string, title string, author string, description string, artwork string, tracks []*sc.Track, log func(string)) error {
+	err := os.MkdirAll(filepath.Join(dir, "tracks"), 0o755)
+	if err != nil {
+		return err
+	}
+
+	page := templates.ExportPage{Title: title, Author: author, Description: description}
+	if artwork != "" {
+		err = saveURL(artwork, filepath.Join(dir, "cover.jpg"))
+		if err != nil {
+			log("failed to download cover: " + err.Error())
+		} else {
+			page.Artwork = "cover.jpg"
+		}
+	}
+
+	for i, t := range tracks {
+		name := fmt.Sprintf("%03d - %s", i+1, download.Filename(*t))
+		log(fmt.Sprintf("[%d/%d] %s", i+1, len(tracks), name))
+
+		et := templates.ExportTrack{Title: t.Title, Author: t.Author.Username, Permalink: t.Author.Permalink + "/" + t.Permalink}
+		err = saveTrack(t, filepath.Join(dir, "tracks", name))
+		if err != nil {
+			log("failed to download " + name + ": " + err.Error())
+		} else {
+			et.File = "tracks/" + url.PathEscape(name)
+		}
+
+		page.Tracks = append(page.Tracks, et)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return templates.Export(page).Render(context.Background(), f)
+}
+
+// all tracks of the playlist, including ones which weren't included in the response
+func AllTracks(p sc.Playlist) ([]*sc.Track, error) {
+	err := p.ResolveMore(len(p.Tracks))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []*sc.Track{}
+	for _, t := range p.Tracks {
+		if !t.Unavailable {
+			tracks = append(tracks, t)
+		}
+	}
+
+	return tracks, nil
+}
+
+func Playlist(permalink string, dir string, log func(string)) error {
+	p, err := sc.GetPlaylist(permalink)
+	if err != nil {
+		return err
+	}
+
+	tracks, err := AllTracks(p)
+	if err != nil {
+		return err
+	}
+
+	return write(dir, p.Title, p.Author.Username, p.Description, p.Artwork, tracks, log)
+}
+
+func User(permalink string, dir string, log func(string)) error {
+	u, err := sc.GetUser(permalink)
+	if err != nil {
+		return err
+	}
+
+	p, err := u.GetTracks(sc.NewCursor(100, ""))
+	if err != nil {
+		return err
+	}
+
+	tracks := []*sc.Track{}
+	for {
+		for i := range p.Collection {
+			tracks = append(tracks, &p.Collection[i])
+		}
+
+		if p.Next == "" {
+			break
+		}
+
+		p.Collection = nil
+		err = p.Proceed()
+		if err != nil {
+			return err
+		}
+	}
+
+	return write(dir, u.Username, u.Username, u.Description, u.Avatar, tracks, log)
+}
+
+// exports a playlist or a user, depending on the url
+func Export(raw string, dir string, log func(string)) error {
+	permalink := Permalink(raw)
+	parts := strings.Split(permalink, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return User(permalink, dir, log)
+	case len(parts) == 3 && parts[1] == "sets":
+		return Playlist(permalink, dir, log)
+	}
+
+	return ErrUnsupportedURL
+}
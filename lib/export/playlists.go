@@ -0,0 +1,175 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Exporting playlists as .m3u8/.xspf for VLC/mpv, entries point at the stream proxy
+
+type Entry struct {
+	Title    string
+	Author   string
+	Artwork  string
+	Location string
+	Duration int64 // seconds, -1 if unknown
+}
+
+func entryTitle(e Entry) string {
+	return strings.NewReplacer("\n", " ", "\r", " ").Replace(e.Author + " - " + e.Title)
+}
+
+func M3U(w io.Writer, title string, entries []Entry) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#PLAYLIST:" + strings.ReplaceAll(title, "\n", " ") + "\n")
+	for _, e := range entries {
+		b.WriteString("#EXTINF:" + strconv.FormatInt(e.Duration, 10) + "," + entryTitle(e) + "\n")
+		b.WriteString(e.Location + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+	Image    string `xml:"image,omitempty"`
+	Duration int64  `xml:"duration,omitempty"` // milliseconds
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+func XSPF(w io.Writer, title string, entries []Entry) error {
+	pl := xspfPlaylist{Version: "1", XMLNS: "http://xspf.org/ns/0/", Title: title}
+	for _, e := range entries {
+		t := xspfTrack{Location: e.Location, Title: e.Title, Creator: e.Author, Image: e.Artwork}
+		if e.Duration > 0 {
+			t.Duration = e.Duration * 1000
+		}
+
+		pl.Tracks = append(pl.Tracks, t)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(pl)
+}
+
+// base is the url of the instance
+func Entries(base string, tracks []*sc.Track) []Entry {
+	entries := make([]Entry, 0, len(tracks))
+	for _, t := range tracks {
+		entries = append(entries, Entry{
+			Title:    t.Title,
+			Author:   t.Author.Username,
+			Artwork:  t.Artwork,
+			Location: proxystreams.TrackURL(base, t.ID),
+			Duration: -1,
+		})
+
+		if t.Duration != 0 {
+			entries[len(entries)-1].Duration = int64(t.Duration / time.Second)
+		}
+	}
+
+	return entries
+}
+
+// writes the playlist in the requested format (m3u8 or xspf)
+func Write(c *fiber.Ctx, format string, title string, entries []Entry) error {
+	name := url.PathEscape(strings.NewReplacer("/", "_", "\\", "_").Replace(title))
+	switch format {
+	case "m3u8":
+		c.Set("Content-Type", "audio/x-mpegurl")
+		c.Set("Content-Disposition", "attachment; filename*=UTF-8''"+name+".m3u8")
+		return M3U(c, title, entries)
+	case "xspf":
+		c.Set("Content-Type", "application/xspf+xml")
+		c.Set("Content-Disposition", "attachment; filename*=UTF-8''"+name+".xspf")
+		return XSPF(c, title, entries)
+	}
+
+	return fiber.ErrNotFound
+}
+
+// url of this instance, for absolute links
+func BaseURL(c *fiber.Ctx) string {
+	if cfg.Get().InstanceURL != "" {
+		return cfg.Get().InstanceURL
+	}
+
+	return c.BaseURL()
+}
+
+func Load(r fiber.Router) {
+	loadZip(r)
+
+	r.Get("/_/export/playlist", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableStreamProxy {
+			return fiber.ErrNotFound
+		}
+
+		permalink := Permalink(c.Query("url"))
+		p, err := sc.GetPlaylist(permalink)
+		if err != nil {
+			log.Printf("error getting %s (export): %s\n", permalink, err)
+			return err
+		}
+
+		tracks, err := AllTracks(p)
+		if err != nil {
+			log.Printf("error getting %s tracks (export): %s\n", permalink, err)
+			return err
+		}
+
+		return Write(c, c.Query("format", "m3u8"), p.Title, Entries(BaseURL(c), tracks))
+	})
+	r.Get("/_/export/local", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableStreamProxy || !cfg.Get().Features.EnableLocalPlaylists {
+			return fiber.ErrNotFound
+		}
+
+		p, err := local.Get(c.Query("id"))
+		if err != nil {
+			if err == local.ErrNotFound {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error getting local playlist %s (export): %s\n", c.Query("id"), err)
+			return err
+		}
+
+		tracks, err := local.GetTracks(p.Tracks)
+		if err != nil {
+			log.Printf("error getting local playlist %s tracks (export): %s\n", p.ID, err)
+			return err
+		}
+
+		return Write(c, c.Query("format", "m3u8"), p.Title, Entries(BaseURL(c), tracks))
+	})
+}
@@ -0,0 +1,108 @@
+package export
+
+import (
+	"bufio"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Playlists as a zip with all tracks (or the ones in ?tracks=id,id,...)
+
+// keeps only the tracks in sel (comma separated ids), in playlist order
+func SelectTracks(tracks []*sc.Track, sel string) []*sc.Track {
+	if sel == "" {
+		return tracks
+	}
+
+	want := map[string]bool{}
+	for _, id := range strings.Split(sel, ",") {
+		want[strings.TrimSpace(id)] = true
+	}
+
+	res := []*sc.Track{}
+	for _, t := range tracks {
+		if want[t.ID] {
+			res = append(res, t)
+		}
+	}
+
+	return res
+}
+
+func sendZip(c *fiber.Ctx, title string, artwork string, tracks []*sc.Track) error {
+	tracks = SelectTracks(tracks, c.Query("tracks"))
+	if len(tracks) == 0 {
+		return fiber.ErrNotFound
+	}
+
+	ip := c.IP()
+	c.Set("Content-Type", "application/zip")
+	c.Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(download.Sanitize(title)+".zip"))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		err := download.Zip(bandwidth.Writer{W: w, IP: ip}, title, artwork, tracks, nil)
+		if err != nil {
+			log.Printf("error writing zip of %s: %s\n", title, err)
+		}
+	})
+
+	return nil
+}
+
+func loadZip(r fiber.Router) {
+	enabled := func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableDownloads {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}
+
+	r.Get("/_/download/playlist", enabled, bandwidth.Quota, func(c *fiber.Ctx) error {
+		permalink := Permalink(c.Query("url"))
+		p, err := sc.GetPlaylist(permalink)
+		if err != nil {
+			log.Printf("error getting %s (zip): %s\n", permalink, err)
+			return err
+		}
+
+		tracks, err := AllTracks(p)
+		if err != nil {
+			log.Printf("error getting %s tracks (zip): %s\n", permalink, err)
+			return err
+		}
+
+		return sendZip(c, p.Title, p.Artwork, tracks)
+	})
+
+	r.Get("/_/download/local", enabled, bandwidth.Quota, func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableLocalPlaylists {
+			return fiber.ErrNotFound
+		}
+
+		p, err := local.Get(c.Query("id"))
+		if err != nil {
+			if err == local.ErrNotFound {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error getting local playlist %s (zip): %s\n", c.Query("id"), err)
+			return err
+		}
+
+		tracks, err := local.GetTracks(p.Tracks)
+		if err != nil {
+			log.Printf("error getting local playlist %s tracks (zip): %s\n", p.ID, err)
+			return err
+		}
+
+		return sendZip(c, p.Title, "", tracks)
+	})
+}
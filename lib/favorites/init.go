@@ -0,0 +1,203 @@
+package favorites
+
+import (
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/proxyimages"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+const Cookie = "favorites"
+
+// the favorites of whoever made the request, empty without a cookie
+func For(c *fiber.Ctx) Favorites {
+	if !cfg.Get().Features.EnableFavorites {
+		return Favorites{}
+	}
+
+	f, err := Get(c.Cookies(Cookie))
+	if err != nil {
+		log.Printf("error getting favorites: %s\n", err)
+	}
+
+	return f
+}
+
+// creates a key (and sets the cookie) when there isn't one yet
+// the cookie is SameSite=Lax, so it isn't sent with forms from other sites. those would get a new key here,
+// replacing the cookie, which is why the handlers changing favorites are behind csrf.Check
+func Key(c *fiber.Ctx) string {
+	k := c.Cookies(Cookie)
+	if !validKey(k) {
+		k = newKey()
+	}
+
+	// refreshed every time, so favorites in use don't expire
+	c.Cookie(&fiber.Cookie{
+		Name:     Cookie,
+		Value:    k,
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+
+	return k
+}
+
+func items(f Favorites) (tracks []templates.FavoriteItem, users []templates.FavoriteItem) {
+	for _, t := range f.Tracks {
+		tracks = append(tracks, templates.FavoriteItem{Href: "/" + t.Permalink, Title: t.Title, Subtitle: t.Artist, Image: proxyimages.URL(t.Artwork)})
+	}
+
+	for _, u := range f.Users {
+		users = append(users, templates.FavoriteItem{Href: "/" + u.Permalink, Title: u.Username, Image: proxyimages.URL(u.Avatar)})
+	}
+
+	return
+}
+
+// paths of tracks, like /user/track (what the favorites button used to keep in localStorage)
+func resolvePaths(paths []string) Favorites {
+	var f Favorites
+	for _, p := range paths {
+		p = strings.Trim(strings.TrimSpace(p), "/")
+		if p == "" || len(f.Tracks) >= cfg.Get().FavoritesMax {
+			continue
+		}
+
+		t, err := sc.GetTrack(p)
+		if err != nil {
+			log.Printf("error getting %s (favorites import): %s\n", p, err)
+			continue
+		}
+
+		f.Tracks = append(f.Tracks, fromTrack(t))
+	}
+
+	return f
+}
+
+func Load(r fiber.Router) {
+	r.Use("/favorites", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableFavorites {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}, csrf.Check)
+
+	r.Get("/favorites", func(c *fiber.Ctx) error {
+		f := For(c)
+		if c.Query("format") == "json" {
+			return c.JSON(f)
+		}
+
+		tracks, users := items(f)
+		c.Set("Content-Type", "text/html")
+		return templates.Base("favorites", templates.Favorites(tracks, users), nil).Render(preferences.Context(c), c)
+	})
+
+	r.Get("/favorites/export", func(c *fiber.Ctx) error {
+		c.Attachment("favorites.json")
+		return c.JSON(For(c))
+	})
+
+	// id=, remove=1 to remove it
+	r.Post("/favorites/track", func(c *fiber.Ctx) error {
+		t, err := sc.GetTrackByID(c.FormValue("id"))
+		if err != nil {
+			log.Printf("error getting %s (favorites): %s\n", c.FormValue("id"), err)
+			return err
+		}
+
+		if c.FormValue("remove") == "1" {
+			err = RemoveTrack(Key(c), t.ID)
+		} else {
+			err = AddTrack(Key(c), t)
+		}
+
+		if err != nil {
+			if err == ErrTooMany {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+
+			log.Printf("error saving favorites: %s\n", err)
+			return err
+		}
+
+		return c.Redirect("/"+t.Author.Permalink+"/"+t.Permalink, fiber.StatusSeeOther)
+	})
+
+	// user= (permalink), remove=1 to remove them
+	r.Post("/favorites/user", func(c *fiber.Ctx) error {
+		u, err := sc.GetUser(c.FormValue("user"))
+		if err != nil {
+			log.Printf("error getting %s (favorites): %s\n", c.FormValue("user"), err)
+			return err
+		}
+
+		if c.FormValue("remove") == "1" {
+			err = RemoveUser(Key(c), u.ID)
+		} else {
+			err = AddUser(Key(c), u)
+		}
+
+		if err != nil {
+			if err == ErrTooMany {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+
+			log.Printf("error saving favorites: %s\n", err)
+			return err
+		}
+
+		return c.Redirect("/"+u.Permalink, fiber.StatusSeeOther)
+	})
+
+	// file= (exported json) and/or paths= (comma separated, from the old localStorage favorites)
+	r.Post("/favorites/import", func(c *fiber.Ctx) error {
+		var imported Favorites
+		if fh, err := c.FormFile("file"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			data, err := io.ReadAll(f)
+			if err != nil {
+				return err
+			}
+
+			if cfg.JSON.Unmarshal(data, &imported) != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "not a favorites export")
+			}
+		}
+
+		if paths := c.FormValue("paths"); paths != "" {
+			old := resolvePaths(strings.Split(paths, ","))
+			imported.Tracks = append(imported.Tracks, old.Tracks...)
+		}
+
+		err := Merge(Key(c), imported)
+		if err != nil {
+			if err == ErrTooMany {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+
+			log.Printf("error saving favorites: %s\n", err)
+			return err
+		}
+
+		return c.Redirect("/favorites", fiber.StatusSeeOther)
+	})
+}
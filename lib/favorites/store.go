@@ -0,0 +1,200 @@
+package favorites
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Starred tracks and artists, without a soundcloud account
+// stored as json files in cfg.DataDir/favorites, named after a random key which the browser keeps in a cookie
+// enough is copied from the entities to show the lists without asking soundcloud
+
+var ErrTooMany = errors.New("too many favorites")
+
+type Track struct {
+	ID        string    `json:"id"`
+	Permalink string    `json:"permalink"` // user/track
+	Title     string    `json:"title"`
+	Artist    string    `json:"artist"`
+	Artwork   string    `json:"artwork"`
+	Added     time.Time `json:"added"`
+}
+
+type User struct {
+	ID        string    `json:"id"`
+	Permalink string    `json:"permalink"`
+	Username  string    `json:"username"`
+	Avatar    string    `json:"avatar"`
+	Added     time.Time `json:"added"`
+}
+
+// newest first
+type Favorites struct {
+	Tracks []Track `json:"tracks"`
+	Users  []User  `json:"users"`
+}
+
+func dir() string {
+	return filepath.Join(cfg.Get().DataDir, "favorites")
+}
+
+func newKey() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validKey(key string) bool {
+	if len(key) != 32 {
+		return false
+	}
+
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// loading, changing and saving has to happen under this, or two requests at once would lose one of the changes
+var lock = &sync.Mutex{}
+
+// empty favorites for keys without a file
+func load(key string) (Favorites, error) {
+	var f Favorites
+	if !validKey(key) {
+		return f, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir(), key+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+
+		return f, err
+	}
+
+	err = cfg.JSON.Unmarshal(data, &f)
+	return f, err
+}
+
+func save(key string, f Favorites) error {
+	data, err := cfg.JSON.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(filepath.Join(dir(), key+".json"), data, 0o755)
+}
+
+func Get(key string) (Favorites, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return load(key)
+}
+
+func update(key string, f func(fav *Favorites) error) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	fav, err := load(key)
+	if err != nil {
+		return err
+	}
+
+	err = f(&fav)
+	if err != nil {
+		return err
+	}
+
+	if len(fav.Tracks)+len(fav.Users) > cfg.Get().FavoritesMax {
+		return ErrTooMany
+	}
+
+	return save(key, fav)
+}
+
+func (f Favorites) HasTrack(id string) bool {
+	return slices.ContainsFunc(f.Tracks, func(t Track) bool { return t.ID == id })
+}
+
+func (f Favorites) HasUser(id string) bool {
+	return slices.ContainsFunc(f.Users, func(u User) bool { return u.ID == id })
+}
+
+func fromTrack(t sc.Track) Track {
+	return Track{ID: t.ID, Permalink: t.Author.Permalink + "/" + t.Permalink, Title: t.Title, Artist: t.Author.Username, Artwork: t.Artwork, Added: time.Now().UTC()}
+}
+
+func fromUser(u sc.User) User {
+	return User{ID: u.ID, Permalink: u.Permalink, Username: u.Username, Avatar: u.Avatar, Added: time.Now().UTC()}
+}
+
+func AddTrack(key string, t sc.Track) error {
+	return update(key, func(f *Favorites) error {
+		if !f.HasTrack(t.ID) {
+			f.Tracks = append([]Track{fromTrack(t)}, f.Tracks...)
+		}
+		return nil
+	})
+}
+
+func AddUser(key string, u sc.User) error {
+	return update(key, func(f *Favorites) error {
+		if !f.HasUser(u.ID) {
+			f.Users = append([]User{fromUser(u)}, f.Users...)
+		}
+		return nil
+	})
+}
+
+func RemoveTrack(key string, id string) error {
+	return update(key, func(f *Favorites) error {
+		f.Tracks = slices.DeleteFunc(f.Tracks, func(t Track) bool { return t.ID == id })
+		return nil
+	})
+}
+
+func RemoveUser(key string, id string) error {
+	return update(key, func(f *Favorites) error {
+		f.Users = slices.DeleteFunc(f.Users, func(u User) bool { return u.ID == id })
+		return nil
+	})
+}
+
+// adds everything from imported that isn't there yet, keeping the order
+func Merge(key string, imported Favorites) error {
+	return update(key, func(f *Favorites) error {
+		for _, t := range imported.Tracks {
+			if t.ID != "" && !f.HasTrack(t.ID) {
+				f.Tracks = append(f.Tracks, t)
+			}
+		}
+
+		for _, u := range imported.Users {
+			if u.ID != "" && !f.HasUser(u.ID) {
+				f.Users = append(f.Users, u)
+			}
+		}
+
+		return nil
+	})
+}
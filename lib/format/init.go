@@ -0,0 +1,131 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/i18n"
+)
+
+// Locale-aware formatting of numbers and dates, soundcloud gives us raw ints and iso timestamps
+
+type locale struct {
+	thousands string
+	date      string // go time layout
+}
+
+var locales = map[string]locale{
+	"en":    {",", "Jan 2, 2006"},
+	"en-gb": {",", "2 Jan 2006"},
+	"de":    {".", "2.1.2006"},
+	"nl":    {".", "2-1-2006"},
+	"fr":    {" ", "02/01/2006"},
+	"es":    {".", "02/01/2006"},
+	"it":    {".", "02/01/2006"},
+	"pt":    {".", "02/01/2006"},
+	"ru":    {" ", "02.01.2006"},
+	"uk":    {" ", "02.01.2006"},
+	"pl":    {" ", "02.01.2006"},
+	"sv":    {" ", "2006-01-02"},
+	"ja":    {",", "2006/01/02"},
+	"zh":    {",", "2006/01/02"},
+}
+
+// returns the normalized locale if it's supported (or its language is), "en" otherwise
+func Normalize(l string) string {
+	l = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(l), "_", "-"))
+	if _, ok := locales[l]; ok {
+		return l
+	}
+
+	if i := strings.IndexByte(l, '-'); i != -1 {
+		if _, ok := locales[l[:i]]; ok {
+			return l[:i]
+		}
+	}
+
+	return "en"
+}
+
+// all supported locales, for settings
+func Locales() []string {
+	return []string{"en", "en-gb", "de", "nl", "fr", "es", "it", "pt", "ru", "uk", "pl", "sv", "ja", "zh"}
+}
+
+func get(l string) locale {
+	return locales[Normalize(l)]
+}
+
+// 1234567 -> 1,234,567
+func Number(n int64, l string) string {
+	s := strconv.FormatInt(n, 10)
+	neg := n < 0
+	if neg {
+		s = s[1:]
+	}
+
+	if len(s) <= 3 {
+		if neg {
+			return "-" + s
+		}
+
+		return s
+	}
+
+	sep := get(l).thousands
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+
+	first := len(s) % 3
+	if first == 0 {
+		first = 3
+	}
+
+	b.WriteString(s[:first])
+	for i := first; i < len(s); i += 3 {
+		b.WriteString(sep)
+		b.WriteString(s[i : i+3])
+	}
+
+	return b.String()
+}
+
+func plural(l string, n int64, one string, many string) string {
+	if n == 1 {
+		return i18n.T(l, one)
+	}
+
+	return i18n.T(l, many, n)
+}
+
+// 3 years ago, 5 minutes ago, just now
+func Ago(t time.Time, l string) string {
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return i18n.T(l, "just now")
+	case d < time.Hour:
+		return plural(l, int64(d/time.Minute), "1 minute ago", "%d minutes ago")
+	case d < 24*time.Hour:
+		return plural(l, int64(d/time.Hour), "1 hour ago", "%d hours ago")
+	case d < 30*24*time.Hour:
+		return plural(l, int64(d/(24*time.Hour)), "1 day ago", "%d days ago")
+	case d < 365*24*time.Hour:
+		return plural(l, int64(d/(30*24*time.Hour)), "1 month ago", "%d months ago")
+	}
+
+	return plural(l, int64(d/(365*24*time.Hour)), "1 year ago", "%d years ago")
+}
+
+// formats an iso timestamp from soundcloud like "Jan 2, 2006 (3 years ago)", returns it as is if it's not valid
+func Date(iso string, l string) string {
+	t, err := time.Parse(time.RFC3339, iso)
+	if err != nil {
+		return iso
+	}
+
+	return t.Format(get(l).date) + " (" + Ago(t, l) + ")"
+}
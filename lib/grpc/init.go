@@ -0,0 +1,425 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// gRPC service (soundcloak.proto) on cfg.GRPCAddr, for bots and bridges that want to share this instance's caches and client id
+// plaintext http/2 (h2c), put it behind a reverse proxy for tls. no reflection, clients need the .proto
+
+const service = "/soundcloak.v1.Soundcloak/"
+
+// requests are a few urls at most
+const maxRequest = 64 * 1024
+
+// urls per Resolve call
+const maxResolve = 50
+
+// progressive streams are sent in pieces of this size, hls ones segment by segment
+const chunkSize = 256 * 1024
+
+// https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeNotFound           = 5
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+)
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return e.msg
+}
+
+func status(code int, format string, args ...any) error {
+	return statusError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// the status for errors from lib/sc, anything else is logged by the caller
+func toStatus(err error) (statusError, bool) {
+	var s statusError
+	switch {
+	case errors.As(err, &s):
+		return s, true
+	case errors.Is(err, sc.ErrBlocked):
+		return statusError{code: codePermissionDenied, msg: err.Error()}, true
+	case errors.Is(err, sc.ErrNoURL), errors.Is(err, sc.ErrKindNotCorrect):
+		return statusError{code: codeNotFound, msg: err.Error()}, true
+	case errors.Is(err, sc.ErrBadParams):
+		return statusError{code: codeInvalidArgument, msg: err.Error()}, true
+	}
+
+	return statusError{code: codeUnknown, msg: err.Error()}, false
+}
+
+type call struct {
+	w  http.ResponseWriter
+	ip string
+}
+
+func (c *call) send(msg []byte) error {
+	_, err := c.w.Write(frame(msg))
+	if err != nil {
+		return err
+	}
+
+	c.w.(http.Flusher).Flush()
+	return nil
+}
+
+type method func(c *call, req []byte) error
+
+var methods = map[string]method{
+	"Resolve":       resolve,
+	"GetTrack":      getTrack,
+	"GetUser":       getUser,
+	"GetPlaylist":   getPlaylist,
+	"GetStream":     getStream,
+	"Search":        search,
+	"FetchSegments": fetchSegments,
+}
+
+func resolve(c *call, req []byte) error {
+	var r resolveRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	if len(r.urls) > maxResolve {
+		return status(codeInvalidArgument, "at most %d urls per call", maxResolve)
+	}
+
+	return c.send(marshalResolveResponse(sc.ResolveMany(r.urls)))
+}
+
+func track(r trackRequest) (sc.Track, error) {
+	switch {
+	case r.id != "":
+		return sc.GetTrackByID(r.id)
+	case r.url != "":
+		return sc.GetArbitraryTrack(r.url)
+	}
+
+	return sc.Track{}, status(codeInvalidArgument, "url or id is required")
+}
+
+func getTrack(c *call, req []byte) error {
+	var r trackRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	t, err := track(r)
+	if err != nil {
+		return err
+	}
+
+	return c.send(marshalTrack(&t))
+}
+
+func getUser(c *call, req []byte) error {
+	var r permalinkRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	if r.permalink == "" {
+		return status(codeInvalidArgument, "permalink is required")
+	}
+
+	u, err := sc.GetUser(r.permalink)
+	if err != nil {
+		return err
+	}
+
+	return c.send(marshalUser(&u))
+}
+
+func getPlaylist(c *call, req []byte) error {
+	var r permalinkRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	if r.permalink == "" {
+		return status(codeInvalidArgument, "permalink is required")
+	}
+
+	p, err := sc.GetPlaylist(r.permalink)
+	if err != nil {
+		return err
+	}
+
+	return c.send(marshalPlaylist(&p))
+}
+
+// the track and its transcoding for a GetStreamRequest, the default one when no preset is given
+func transcoding(r streamRequest) (sc.Track, *sc.Transcoding, error) {
+	t, err := track(r.track)
+	if err != nil {
+		return t, nil, err
+	}
+
+	if r.preset == "" {
+		return t, t.PreferredStream(), nil
+	}
+
+	if r.protocol == "" {
+		r.protocol = string(sc.ProtocolHLS)
+	}
+
+	tr := t.Media.Find(r.preset, sc.Protocol(r.protocol))
+	if tr == nil {
+		return t, nil, status(codeNotFound, "no %s stream with preset %s", r.protocol, r.preset)
+	}
+
+	return t, tr, nil
+}
+
+func getStream(c *call, req []byte) error {
+	var r streamRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	t, tr, err := transcoding(r)
+	if err != nil {
+		return err
+	}
+
+	u, err := t.GetStreamFor(tr)
+	if err != nil {
+		return err
+	}
+
+	// clients aren't on our pages, so proxied urls have to be absolute
+	if cfg.Get().Features.EnableStreamProxy && cfg.Get().InstanceURL != "" {
+		u = cfg.Get().InstanceURL + proxystreams.ForTrack(proxystreams.URL(u), t.ID)
+	}
+
+	return c.send(stream{url: u, protocol: tr.Format.Protocol, mimeType: tr.Format.MimeType}.marshal())
+}
+
+func search(c *call, req []byte) error {
+	if !cfg.Get().Features.EnableSearch {
+		return status(codeFailedPrecondition, "search is disabled on this instance")
+	}
+
+	var r searchRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	pg := sc.NewPage(r.limit, r.offset)
+	switch r.typ {
+	case "tracks", "":
+		if r.license != "" && !sc.ValidLicenseFilter(r.license) {
+			return status(codeInvalidArgument, "unknown license filter %q", r.license)
+		}
+
+		p, err := sc.SearchTracksByLicense(r.q, r.license, pg)
+		if err != nil {
+			return err
+		}
+
+		return c.send(marshalSearchResponse(p, 3, marshalTrack))
+	case "users":
+		p, err := sc.SearchUsers(r.q, pg)
+		if err != nil {
+			return err
+		}
+
+		return c.send(marshalSearchResponse(p, 4, marshalUser))
+	case "playlists":
+		p, err := sc.SearchPlaylists(r.q, pg)
+		if err != nil {
+			return err
+		}
+
+		return c.send(marshalSearchResponse(p, 5, marshalPlaylist))
+	default:
+		return status(codeInvalidArgument, "type has to be tracks, users or playlists")
+	}
+}
+
+// same as downloads: needs cfg.Features.EnableDownloads, counts towards the bandwidth quota of the ip
+func fetchSegments(c *call, req []byte) error {
+	if !cfg.Get().Features.EnableDownloads {
+		return status(codeFailedPrecondition, "downloads are disabled on this instance")
+	}
+
+	if cfg.Get().DailyQuota > 0 && bandwidth.Used(c.ip) >= cfg.Get().DailyQuota {
+		return status(codeResourceExhausted, "daily bandwidth quota exceeded")
+	}
+
+	var r streamRequest
+	if err := r.unmarshal(req); err != nil {
+		return status(codeInvalidArgument, "%s", err)
+	}
+
+	t, tr, err := transcoding(r)
+	if err != nil {
+		return err
+	}
+
+	u, err := t.GetStreamFor(tr)
+	if err != nil {
+		return err
+	}
+
+	mime := tr.Format.MimeType
+	if tr.Format.Protocol == sc.ProtocolProgressive {
+		n := 0
+		return download.EachSegment([]string{u}, func(_ int, data []byte) error {
+			for len(data) != 0 {
+				chunk := data[:min(chunkSize, len(data))]
+				data = data[len(chunk):]
+				if err := c.send(marshalSegment(n, mime, chunk)); err != nil {
+					return err
+				}
+
+				bandwidth.Add(c.ip, t.ID, int64(len(chunk)))
+				n++
+			}
+			return nil
+		})
+	}
+
+	segments, err := download.Segments(u)
+	if err != nil {
+		return err
+	}
+
+	return download.EachSegment(segments, func(i int, data []byte) error {
+		if err := c.send(marshalSegment(i, mime, data)); err != nil {
+			return err
+		}
+
+		bandwidth.Add(c.ip, t.ID, int64(len(data)))
+		return nil
+	})
+}
+
+// the one message of a unary or server streaming call
+func readRequest(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, status(codeInvalidArgument, "missing request message")
+	}
+
+	if prefix[0] != 0 {
+		return nil, status(codeUnimplemented, "compressed messages aren't supported")
+	}
+
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxRequest {
+		return nil, status(codeResourceExhausted, "request message too large")
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, status(codeInvalidArgument, "truncated request message")
+	}
+
+	return msg, nil
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "grpc only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	err := serveCall(w, r)
+	code, msg := 0, ""
+	if err != nil {
+		s, known := toStatus(err)
+		if !known {
+			log.Printf("grpc: %s: %s\n", r.URL.Path, err)
+		}
+		code, msg = s.code, s.msg
+	}
+
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(msg)) // percent-encoded, as the spec wants
+	}
+}
+
+func serveCall(w http.ResponseWriter, r *http.Request) (err error) {
+	// net/http recovers too, but then the client gets a reset stream instead of a status
+	defer func() {
+		if e := recover(); e != nil {
+			log.Printf("grpc: panic in %s: %v\n", r.URL.Path, e)
+			err = status(codeInternal, "internal error")
+		}
+	}()
+
+	name, ok := strings.CutPrefix(r.URL.Path, service)
+	m, found := methods[name]
+	if !ok || !found {
+		return status(codeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+
+	req, err := readRequest(http.MaxBytesReader(w, r.Body, maxRequest+5))
+	if err != nil {
+		return err
+	}
+
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return m(&call{w: w, ip: ip}, req)
+}
+
+// serves until ln is closed
+func Serve(ln net.Listener) {
+	srv := &http.Server{
+		Handler:  h2c.NewHandler(http.HandlerFunc(handle), &http2.Server{}),
+		ErrorLog: log.New(io.Discard, "", 0), // clients probing with http/1.1
+	}
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("grpc: %s\n", err)
+	}
+}
+
+// checks the config, false (after logging why) when the server can't work
+func Enabled() bool {
+	if cfg.Get().GRPCAddr == "" {
+		return false
+	}
+
+	if !cfg.Get().Features.EnableAPI {
+		log.Println("grpc_addr needs the api (enable_api), not starting the grpc server")
+		return false
+	}
+
+	return true
+}
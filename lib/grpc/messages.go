@@ -0,0 +1,240 @@
+package grpc
+
+import (
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// the messages of soundcloak.proto, field numbers have to match it
+
+type resolveRequest struct {
+	urls []string
+}
+
+func (r *resolveRequest) unmarshal(msg []byte) error {
+	return decode(msg, func(field int, _ uint64, data []byte) error {
+		if field == 1 {
+			r.urls = append(r.urls, string(data))
+		}
+		return nil
+	})
+}
+
+// oneof url/id
+type trackRequest struct {
+	url string
+	id  string
+}
+
+func (r *trackRequest) unmarshal(msg []byte) error {
+	return decode(msg, func(field int, _ uint64, data []byte) error {
+		switch field {
+		case 1:
+			r.url, r.id = string(data), ""
+		case 2:
+			r.id, r.url = string(data), ""
+		}
+		return nil
+	})
+}
+
+// GetUserRequest and GetPlaylistRequest
+type permalinkRequest struct {
+	permalink string
+}
+
+func (r *permalinkRequest) unmarshal(msg []byte) error {
+	return decode(msg, func(field int, _ uint64, data []byte) error {
+		if field == 1 {
+			r.permalink = string(data)
+		}
+		return nil
+	})
+}
+
+type streamRequest struct {
+	track    trackRequest
+	preset   string
+	protocol string
+}
+
+func (r *streamRequest) unmarshal(msg []byte) error {
+	return decode(msg, func(field int, _ uint64, data []byte) error {
+		switch field {
+		case 1:
+			return r.track.unmarshal(data)
+		case 2:
+			r.preset = string(data)
+		case 3:
+			r.protocol = string(data)
+		}
+		return nil
+	})
+}
+
+type searchRequest struct {
+	q       string
+	typ     string
+	limit   int
+	offset  int
+	license string
+}
+
+func (r *searchRequest) unmarshal(msg []byte) error {
+	return decode(msg, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			r.q = string(data)
+		case 2:
+			r.typ = string(data)
+		case 3:
+			r.limit = int(int32(v))
+		case 4:
+			r.offset = int(int32(v))
+		case 5:
+			r.license = string(data)
+		}
+		return nil
+	})
+}
+
+func marshalResolveResult(r sc.ResolveResult) []byte {
+	var b []byte
+	b = appendString(b, 1, r.URL)
+	b = appendString(b, 2, r.Kind)
+	b = appendString(b, 3, r.ID)
+	if r.Err != nil {
+		b = appendString(b, 4, r.Err.Error())
+	}
+
+	return b
+}
+
+func marshalResolveResponse(results []sc.ResolveResult) []byte {
+	var b []byte
+	for _, r := range results {
+		b = appendMessage(b, 1, marshalResolveResult(r))
+	}
+
+	return b
+}
+
+// field is tracks, users or playlists, only one of them is set
+func marshalSearchResponse[T any](p *sc.Paginated[T], field int, marshal func(T) []byte) []byte {
+	var b []byte
+	b = appendInt(b, 1, p.Total)
+	b = appendInt(b, 2, int64(p.Hidden))
+	for _, v := range p.Collection {
+		b = appendMessage(b, field, marshal(v))
+	}
+
+	return b
+}
+
+func marshalUser(u *sc.User) []byte {
+	var b []byte
+	b = appendString(b, 1, u.ID)
+	b = appendString(b, 2, u.Permalink)
+	b = appendString(b, 3, u.Username)
+	b = appendString(b, 4, u.FullName)
+	b = appendString(b, 5, u.Avatar)
+	b = appendString(b, 6, u.Description)
+	b = appendInt(b, 7, u.Followers)
+	b = appendInt(b, 8, u.Following)
+	b = appendInt(b, 9, u.Tracks)
+	b = appendInt(b, 10, u.Playlists)
+	b = appendBool(b, 11, u.Verified)
+	b = appendString(b, 12, u.Plan)
+	b = appendString(b, 13, u.CreatedAt)
+	b = appendString(b, 14, u.LastModified)
+	return b
+}
+
+func marshalStreamOption(o sc.StreamOption) []byte {
+	var b []byte
+	b = appendString(b, 1, string(o.Protocol))
+	b = appendString(b, 2, o.MimeType)
+	b = appendString(b, 3, o.Preset)
+	b = appendString(b, 4, o.Quality)
+	b = appendInt(b, 5, o.Duration)
+	b = appendBool(b, 6, o.Snipped)
+	b = appendInt(b, 7, int64(o.Bitrate))
+	return b
+}
+
+func marshalTrack(t *sc.Track) []byte {
+	var b []byte
+	b = appendString(b, 1, t.ID)
+	b = appendInt(b, 2, t.IDint)
+	b = appendString(b, 3, t.Permalink)
+	b = appendString(b, 4, t.Title)
+	b = appendString(b, 5, t.Description)
+	b = appendString(b, 6, t.Artwork)
+	b = appendString(b, 7, t.Genre)
+	b = appendString(b, 8, t.TagList)
+	b = appendString(b, 9, t.License)
+	b = appendInt(b, 10, t.DurationMs)
+	b = appendString(b, 11, t.DurationText)
+	b = appendInt(b, 12, t.Played)
+	b = appendInt(b, 13, t.Likes)
+	b = appendInt(b, 14, int64(t.Comments))
+	b = appendBool(b, 15, t.Explicit)
+	b = appendString(b, 16, t.Waveform)
+	b = appendString(b, 17, t.CreatedAt)
+	b = appendString(b, 18, t.LastModified)
+	if !t.Unavailable {
+		b = appendMessage(b, 19, marshalUser(&t.Author))
+	}
+	for _, o := range t.Streams() {
+		b = appendMessage(b, 20, marshalStreamOption(o))
+	}
+	b = appendBool(b, 21, t.Unavailable)
+	b = appendString(b, 22, t.KnownTitle)
+	return b
+}
+
+func marshalPlaylist(p *sc.Playlist) []byte {
+	var b []byte
+	b = appendString(b, 1, p.Permalink)
+	b = appendString(b, 2, p.Title)
+	b = appendString(b, 3, p.Description)
+	b = appendString(b, 4, p.Artwork)
+	b = appendString(b, 5, p.Kind)
+	b = appendString(b, 6, p.Category)
+	b = appendString(b, 7, p.Type)
+	b = appendString(b, 8, p.ReleaseDate)
+	b = appendString(b, 9, p.TagList)
+	b = appendInt(b, 10, p.Likes)
+	b = appendInt(b, 11, p.Reposts)
+	b = appendInt(b, 12, p.TrackCount)
+	b = appendInt(b, 13, p.DurationMs)
+	b = appendBool(b, 14, p.Secret)
+	b = appendString(b, 15, p.CreatedAt)
+	b = appendString(b, 16, p.LastModified)
+	b = appendMessage(b, 17, marshalUser(&p.Author))
+	for _, t := range p.Tracks {
+		b = appendMessage(b, 18, marshalTrack(t))
+	}
+	return b
+}
+
+type stream struct {
+	url      string
+	protocol sc.Protocol
+	mimeType string
+}
+
+func (s stream) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, s.url)
+	b = appendString(b, 2, string(s.protocol))
+	b = appendString(b, 3, s.mimeType)
+	return b
+}
+
+func marshalSegment(i int, mimeType string, data []byte) []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(i))
+	b = appendString(b, 2, mimeType)
+	b = appendBytes(b, 3, data)
+	return b
+}
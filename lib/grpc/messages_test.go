@@ -0,0 +1,145 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// The codec against golden messages in testdata: every .bin has to be what protoc makes of the .txtpb next to it,
+// regenerate them from lib/grpc/testdata with
+//
+//	protoc --proto_path=.. --encode=soundcloak.v1.<Message> soundcloak.proto < <Message>.txtpb > <Message>.bin
+//
+// so a field number that doesn't match soundcloak.proto anymore shows up here. change both when adding fields
+
+func golden(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func checkEncoded(t *testing.T, name string, got []byte) {
+	t.Helper()
+	if want := golden(t, name); !bytes.Equal(got, want) {
+		t.Errorf("%s doesn't match testdata/%s.bin\ngot  %s\nwant %s", name, name, hex.EncodeToString(got), hex.EncodeToString(want))
+	}
+}
+
+var testUser = sc.User{
+	ID:           "2000001",
+	Permalink:    "floppa",
+	Username:     "Floppa",
+	FullName:     "Big Floppa",
+	Avatar:       "https://i1.sndcdn.com/avatars-000000000001-abcdef-original.jpg",
+	Description:  "big cat, makes music sometimes\nünïcödé",
+	Followers:    1520,
+	Following:    3,
+	Tracks:       3,
+	Playlists:    1,
+	Verified:     true,
+	Plan:         sc.PlanPro,
+	CreatedAt:    "2019-04-01T12:00:00Z",
+	LastModified: "2024-01-02T03:04:05Z",
+}
+
+var testTrack = sc.Track{
+	ID:           "1000001",
+	IDint:        1000001,
+	Permalink:    "floppa-theme",
+	Title:        "Floppa Theme",
+	Description:  "the theme of floppa",
+	Artwork:      "https://i1.sndcdn.com/artworks-000000000001-abcdef-original.jpg",
+	Genre:        "Electronic",
+	TagList:      `electronic "deep house"`,
+	License:      "cc-by",
+	DurationMs:   185000,
+	DurationText: "3:05",
+	Played:       123456789012,
+	Likes:        4200,
+	Comments:     17,
+	Explicit:     true,
+	Waveform:     "https://wave.sndcdn.com/abcdef_m.json",
+	CreatedAt:    "2020-05-06T07:08:09Z",
+	LastModified: "2021-01-01T00:00:00Z",
+	Author:       testUser,
+	Media: sc.Media{Transcodings: []sc.Transcoding{
+		{Preset: "mp3_1_0", Format: sc.Format{Protocol: sc.ProtocolHLS, MimeType: "audio/mpeg"}, Quality: "sq", Duration: 185000},
+		{Preset: "mp3_0_0", Format: sc.Format{Protocol: sc.ProtocolProgressive, MimeType: "audio/mpeg"}, Quality: "sq", Duration: 30000, Snipped: true},
+	}},
+}
+
+func TestMarshal(t *testing.T) {
+	checkEncoded(t, "User", marshalUser(&testUser))
+	checkEncoded(t, "StreamOption", marshalStreamOption(testTrack.Streams()[0]))
+	checkEncoded(t, "Track", marshalTrack(&testTrack))
+
+	unavailable := sc.Track{ID: "1000009", IDint: 1000009, Unavailable: true, KnownTitle: "Old Track"}
+	checkEncoded(t, "Playlist", marshalPlaylist(&sc.Playlist{
+		Permalink:    "mix",
+		Title:        "Mix",
+		Description:  "a mix",
+		Artwork:      "https://i1.sndcdn.com/artworks-000000000002-abcdef-original.jpg",
+		Kind:         "playlist",
+		Category:     sc.CategoryAlbum,
+		Type:         "ep",
+		ReleaseDate:  "2022-02-02",
+		TagList:      "mix",
+		Likes:        10,
+		Reposts:      2,
+		TrackCount:   2,
+		DurationMs:   370000,
+		Secret:       true,
+		CreatedAt:    "2022-02-01T00:00:00Z",
+		LastModified: "2022-02-03T00:00:00Z",
+		Author:       testUser,
+		Tracks:       []*sc.Track{&testTrack, &unavailable},
+	}))
+
+	checkEncoded(t, "ResolveResponse", marshalResolveResponse([]sc.ResolveResult{
+		{URL: "https://soundcloud.com/floppa", Kind: "user", ID: "2000001"},
+		{URL: "https://soundcloud.com/floppa/nope", Err: errors.New("not found")},
+	}))
+
+	checkEncoded(t, "SearchResponse", marshalSearchResponse(&sc.Paginated[*sc.User]{
+		Total:      5000000000,
+		Hidden:     2,
+		Collection: []*sc.User{&testUser, {ID: "2000002", Permalink: "caracal"}},
+	}, 4, marshalUser))
+
+	checkEncoded(t, "Stream", stream{url: "https://tunes.example/_/proxy/stream?x=1&y=2", protocol: sc.ProtocolHLS, mimeType: "audio/mpeg"}.marshal())
+	checkEncoded(t, "Segment", marshalSegment(3, "audio/mpeg", []byte("ID3\x04\x00\xff")))
+}
+
+func TestUnmarshal(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		got  interface{ unmarshal([]byte) error }
+		want any
+	}{
+		{"ResolveRequest", &resolveRequest{}, &resolveRequest{urls: []string{"https://soundcloud.com/floppa", "https://on.soundcloud.com/abc"}}},
+		{"GetTrackRequest", &trackRequest{}, &trackRequest{id: "1000001"}},
+		{"GetUserRequest", &permalinkRequest{}, &permalinkRequest{permalink: "floppa"}},
+		{"GetStreamRequest", &streamRequest{}, &streamRequest{track: trackRequest{url: "floppa/floppa-theme"}, preset: "opus_0_0", protocol: "progressive"}},
+		{"SearchRequest", &searchRequest{}, &searchRequest{q: "deep house", typ: "tracks", limit: 50, offset: 100, license: "to_share"}},
+	} {
+		err := tc.got.unmarshal(golden(t, tc.name))
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protobuf wire format, only what soundcloak.proto needs. there is no protobuf library in our dependencies,
+// the messages are small and fixed, so they're encoded and decoded by hand (messages.go)
+
+var errMalformed = errors.New("malformed protobuf message")
+
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+// proto3 leaves out fields with default values, so empty strings, zeros and false aren't written
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// int32 and int64 are the same on the wire, negative numbers take 10 bytes
+func appendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return appendVarint(appendTag(b, field, wireVarint), uint64(v))
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+
+	return appendVarint(appendTag(b, field, wireVarint), 1)
+}
+
+// embedded messages are written even when empty, so the other side knows they're set
+func appendMessage(b []byte, field int, msg []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
+
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// calls f with every field of msg, varints are in v, length-delimited fields in data
+// fixed size fields are skipped, none of the requests have them
+func decode(msg []byte, f func(field int, v uint64, data []byte) error) error {
+	for len(msg) != 0 {
+		tag, n := readVarint(msg)
+		if n == 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return errMalformed
+		}
+		msg = msg[n:]
+
+		field := int(tag >> 3)
+		var v uint64
+		var data []byte
+		switch tag & 7 {
+		case wireVarint:
+			v, n = readVarint(msg)
+			if n == 0 {
+				return errMalformed
+			}
+			msg = msg[n:]
+		case wire64:
+			if len(msg) < 8 {
+				return errMalformed
+			}
+			msg = msg[8:]
+			continue
+		case wire32:
+			if len(msg) < 4 {
+				return errMalformed
+			}
+			msg = msg[4:]
+			continue
+		case wireBytes:
+			l, n := readVarint(msg)
+			if n == 0 || l > uint64(len(msg)-n) {
+				return errMalformed
+			}
+			data = msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+		default: // groups, nobody uses them anymore
+			return errMalformed
+		}
+
+		if err := f(field, v, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// gRPC messages are prefixed with a compressed flag and the length
+func frame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
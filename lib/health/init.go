@@ -0,0 +1,141 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Health/readiness endpoints for load balancers and uptime monitors
+
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type ClientIDCheck struct {
+	Check
+	Version   string    `json:"version,omitempty"`
+	NextCheck time.Time `json:"next_check"`
+}
+
+type UpstreamCheck struct {
+	Check
+	Latency int64 `json:"latency_ms"`
+}
+
+type CachesCheck struct {
+	Check
+	Entities map[string]sc.CacheStat `json:"entities"`
+	Streams  proxystreams.CacheInfo  `json:"streams"`
+}
+
+type Readiness struct {
+	OK       bool          `json:"ok"`
+	ClientID ClientIDCheck `json:"client_id"`
+	Upstream UpstreamCheck `json:"upstream"`
+	Caches   CachesCheck   `json:"caches"`
+}
+
+func check(err error) Check {
+	if err != nil {
+		return Check{Error: err.Error()}
+	}
+
+	return Check{OK: true}
+}
+
+func Ready() Readiness {
+	var r Readiness
+
+	// refreshes the client id if it expired
+	_, err := sc.GetClientID()
+	r.ClientID.Check = check(err)
+	r.ClientID.Version, r.ClientID.NextCheck = sc.ClientIDInfo()
+
+	start := time.Now()
+	r.Upstream.Check = check(sc.Ping())
+	r.Upstream.Latency = time.Since(start).Milliseconds()
+
+	r.Caches.Check = Check{OK: true}
+	r.Caches.Entities = sc.CacheStats()
+	r.Caches.Streams = proxystreams.CacheStatus()
+
+	r.OK = r.ClientID.OK && r.Upstream.OK && r.Caches.OK
+	return r
+}
+
+// cache and upstream counters in the prometheus text format
+func metrics(w io.Writer) {
+	stats := sc.CacheStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, m := range []struct {
+		name string
+		kind string
+		help string
+		val  func(sc.CacheStat) int64
+	}{
+		{"soundcloak_cache_entries", "gauge", "Entries currently in the cache.", func(s sc.CacheStat) int64 { return int64(s.Size) }},
+		{"soundcloak_cache_hits_total", "counter", "Lookups served from the cache.", func(s sc.CacheStat) int64 { return s.Hits }},
+		{"soundcloak_cache_misses_total", "counter", "Lookups that had to go upstream.", func(s sc.CacheStat) int64 { return s.Misses }},
+		{"soundcloak_cache_stale_total", "counter", "Misses that found an expired or flushed entry.", func(s sc.CacheStat) int64 { return s.Stale }},
+		{"soundcloak_cache_evictions_total", "counter", "Entries removed by sweeps, purges or size limits.", func(s sc.CacheStat) int64 { return s.Evictions }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{cache=%q} %d\n", m.name, name, m.val(stats[name]))
+		}
+	}
+
+	streams := proxystreams.CacheStatus()
+	if streams.Enabled {
+		fmt.Fprintf(w, "# HELP soundcloak_stream_cache_bytes Size of the stream cache on disk.\n# TYPE soundcloak_stream_cache_bytes gauge\nsoundcloak_stream_cache_bytes %d\n", streams.Size)
+		fmt.Fprintf(w, "# HELP soundcloak_stream_cache_files Files in the stream cache.\n# TYPE soundcloak_stream_cache_files gauge\nsoundcloak_stream_cache_files %d\n", streams.Files)
+	}
+
+	requests, errors := sc.UpstreamStats()
+	fmt.Fprintf(w, "# HELP soundcloak_upstream_requests_total Requests made to soundcloud.\n# TYPE soundcloak_upstream_requests_total counter\nsoundcloak_upstream_requests_total %d\n", requests)
+	fmt.Fprintf(w, "# HELP soundcloak_upstream_errors_total Requests to soundcloud that failed or were rate limited.\n# TYPE soundcloak_upstream_errors_total counter\nsoundcloak_upstream_errors_total %d\n", errors)
+
+	if cfg.Get().SchemaDrift {
+		fmt.Fprintf(w, "# HELP soundcloak_schema_drift_total Api objects with fields that were added or went missing.\n# TYPE soundcloak_schema_drift_total counter\n")
+		for _, d := range sc.SchemaDrift() {
+			fmt.Fprintf(w, "soundcloak_schema_drift_total{kind=%q,field=%q,change=%q} %d\n", d.Kind, d.Field, d.Change, d.Count)
+		}
+	}
+}
+
+func Load(r fiber.Router) {
+	// the process is up and serving requests
+	r.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(Check{OK: true})
+	})
+
+	// we are actually able to serve content
+	r.Get("/readyz", func(c *fiber.Ctx) error {
+		res := Ready()
+		if !res.OK {
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+
+		return c.JSON(res)
+	})
+
+	// same numbers as /readyz and the admin dashboard, for scraping
+	r.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/plain; version=0.0.4")
+		metrics(c)
+		return nil
+	})
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Cache-Control (and Age) for responses, per route class, so the instance can be put behind a cdn
+// lifetimes come from the same ttls as the in-memory caches, no point in caching longer than we do
+
+// track pages contain a stream url, which expires after a few minutes
+const streamLifetime = 2 * time.Minute
+
+type policy struct {
+	private bool
+	maxAge  func() time.Duration
+
+	// in-memory cache entry the response was built from, used for Age (kinds from sc.CacheKeys)
+	kind string
+	key  func(c *fiber.Ctx) string
+}
+
+func user(c *fiber.Ctx) string {
+	return c.Params("user")
+}
+
+func userPlaylist(c *fiber.Ctx) string {
+	return c.Params("user") + "/sets/" + c.Params("playlist")
+}
+
+// read from the current config every time, so reloads apply
+func ttl(f func(c *cfg.Config) time.Duration) func() time.Duration {
+	return func() time.Duration { return f(cfg.Get()) }
+}
+
+// keyed by route path (as registered)
+var policies = map[string]policy{
+	"/_/proxy/images": {maxAge: func() time.Duration { return 365 * 24 * time.Hour }}, // same url = same image
+	"/_/waveform":     {maxAge: func() time.Duration { return 365 * 24 * time.Hour }},
+	"/_/qr":           {maxAge: func() time.Duration { return 365 * 24 * time.Hour }},
+
+	"/search":       {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.SearchTTL })},
+	"/tags/:tag":    {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.SearchTTL })},
+	"/_/api/search": {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.SearchTTL })},
+	"/tags":         {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.PopularTagsTTL })},
+	"/discover":     {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.DiscoverTTL })},
+
+	"/:user/:track":   {maxAge: func() time.Duration { return min(cfg.Get().TrackTTL, streamLifetime) }},
+	"/_/api/track":    {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.TrackTTL })},
+	"/_/comments/:id": {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.CommentsTTL })},
+
+	"/:user":                 {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.UserTTL }), kind: "users", key: user},
+	"/:user/sets":            {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.UserTTL })},
+	"/:user/albums":          {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.UserTTL })},
+	"/:user/likes/playlists": {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.UserTTL })},
+	"/_/api/user/:user":      {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.UserTTL }), kind: "users", key: user},
+
+	"/:user/sets/:playlist":           {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.PlaylistTTL }), kind: "playlists", key: userPlaylist},
+	"/_/api/playlist/:user/:playlist": {maxAge: ttl(func(c *cfg.Config) time.Duration { return c.PlaylistTTL }), kind: "playlists", key: userPlaylist},
+
+	"/nowplaying/:id": {maxAge: func() time.Duration { return 30 * time.Second }}, // rooms change tracks
+
+	// personal or depending on the instance's account
+	"/preferences":      {private: true},
+	"/favorites":        {private: true},
+	"/account":          {private: true},
+	"/favorites/export": {private: true},
+	"/data/export":      {private: true},
+	"/feed":             {private: true},
+}
+
+func Load(r fiber.Router) {
+	r.Use(func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		if !cfg.Get().HTTPCache || (c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead) || c.Response().StatusCode() != 200 || len(c.Response().Header.Peek(fiber.HeaderCacheControl)) != 0 {
+			return nil
+		}
+
+		p, ok := policies[c.Route().Path]
+		if !ok {
+			return nil
+		}
+
+		if p.private {
+			c.Set(fiber.HeaderCacheControl, "private, no-cache")
+			return nil
+		}
+
+		maxAge := p.maxAge()
+		c.Set(fiber.HeaderCacheControl, "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+
+		if p.key != nil {
+			if expires, ok := sc.CacheExpiry(p.kind, p.key(c)); ok {
+				if age := maxAge - time.Until(expires); age > 0 {
+					c.Set(fiber.HeaderAge, strconv.Itoa(int(age.Seconds())))
+				}
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,138 @@
+package i18n
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Translations of ui strings, catalogs are embedded from locales/<language>.json
+// keys are the english strings themselves, so a missing translation just falls back to english
+// to add a language, copy one of the catalogs and translate the values
+
+//go:embed locales/*.json
+var files embed.FS
+
+var catalogs = map[string]map[string]string{}
+
+func init() {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, e := range entries {
+		data, err := files.ReadFile("locales/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		var c map[string]string
+		err = cfg.JSON.Unmarshal(data, &c)
+		if err != nil {
+			log.Fatalf("i18n: %s: %s\n", e.Name(), err)
+		}
+
+		catalogs[strings.TrimSuffix(e.Name(), path.Ext(e.Name()))] = c
+	}
+}
+
+// language part of a locale (de-at -> de)
+func Language(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if i := strings.IndexByte(locale, '-'); i != -1 {
+		return locale[:i]
+	}
+
+	return locale
+}
+
+// languages with a catalog (english is always there)
+func Languages() []string {
+	res := []string{"en"}
+	for l := range catalogs {
+		if l != "en" {
+			res = append(res, l)
+		}
+	}
+
+	sort.Strings(res[1:])
+	return res
+}
+
+func Has(lang string) bool {
+	if lang == "en" {
+		return true
+	}
+
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// translates key for the locale, args are formatted in like with fmt.Sprintf
+func T(locale string, key string, args ...any) string {
+	s := key
+	if c, ok := catalogs[Language(locale)]; ok {
+		if v, ok := c[key]; ok && v != "" {
+			s = v
+		}
+	}
+
+	if len(args) != 0 {
+		return fmt.Sprintf(s, args...)
+	}
+
+	return s
+}
+
+// picks the best of the supported locales for an Accept-Language header, empty if none match
+// supported is checked with full tags first (en-gb), then languages (en)
+func Negotiate(header string, supported []string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	tags := []tag{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || name == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		if q > 0 {
+			tags = append(tags, tag{strings.ToLower(name), q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, t := range tags {
+		for _, s := range supported {
+			if s == t.name {
+				return s
+			}
+		}
+
+		for _, s := range supported {
+			if s == Language(t.name) {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package instances
+
+import (
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Instance metadata for redirect extensions (like LibRedirect) and instance lists
+
+type Features struct {
+	Downloads   bool `json:"downloads"`
+	StreamProxy bool `json:"stream_proxy"`
+	ImageProxy  bool `json:"image_proxy"`
+	Search      bool `json:"search"`
+	API         bool `json:"api"`
+	Embeds      bool `json:"embeds"`
+}
+
+type Info struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Commit   string   `json:"commit,omitempty"`
+	Clearnet []string `json:"clearnet"`
+	Features Features `json:"features"`
+
+	// true if all traffic to soundcloud (api, streams, images) goes through the instance
+	UpstreamProxy bool `json:"upstream_proxy"`
+
+	// no accounts are needed to use anything
+	RegistrationFree bool `json:"registration_free"`
+}
+
+var commit = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+
+	return ""
+}()
+
+func GetInfo() Info {
+	f := cfg.Get().Features
+	info := Info{
+		Name:     "soundcloak",
+		Version:  cfg.Version,
+		Commit:   commit,
+		Clearnet: []string{},
+		Features: Features{
+			Downloads:   f.EnableDownloads,
+			StreamProxy: f.EnableStreamProxy,
+			ImageProxy:  f.EnableImageProxy,
+			Search:      f.EnableSearch,
+			API:         f.EnableAPI,
+			Embeds:      f.EnableEmbeds,
+		},
+		UpstreamProxy:    f.EnableStreamProxy && f.EnableImageProxy,
+		RegistrationFree: true,
+	}
+
+	if cfg.Get().InstanceURL != "" {
+		info.Clearnet = append(info.Clearnet, cfg.Get().InstanceURL)
+	}
+
+	return info
+}
+
+func loadInfo(r fiber.Router) {
+	r.Get("/instance-info", func(c *fiber.Ctx) error {
+		c.Set("Access-Control-Allow-Origin", "*") // extensions fetch this from other origins
+		return c.JSON(GetInfo())
+	})
+}
@@ -0,0 +1,145 @@
+package instances
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// List of other public instances, with health checks and redirects when we are rate limited
+
+type Instance struct {
+	URL         string    `json:"url"`
+	Healthy     bool      `json:"healthy"`
+	Latency     int64     `json:"latency_ms"`
+	LastChecked time.Time `json:"last_checked"`
+}
+
+var state = map[string]Instance{}
+var stateLock = &sync.RWMutex{}
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+	ReadTimeout:   10 * time.Second,
+	WriteTimeout:  10 * time.Second,
+}
+
+func check(base string) Instance {
+	inst := Instance{URL: base, LastChecked: time.Now()}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(base + "/healthz")
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	start := time.Now()
+	err := httpc.DoTimeout(req, resp, 10*time.Second)
+	inst.Latency = time.Since(start).Milliseconds()
+	inst.Healthy = err == nil && resp.StatusCode() == 200
+
+	return inst
+}
+
+func checkAll() {
+	res := map[string]Instance{}
+	for _, base := range cfg.Get().Instances {
+		base = strings.TrimSuffix(base, "/")
+		res[base] = check(base)
+	}
+
+	stateLock.Lock()
+	state = res
+	stateLock.Unlock()
+}
+
+// all known instances, in the order they are configured
+func List() []Instance {
+	stateLock.RLock()
+	defer stateLock.RUnlock()
+
+	res := make([]Instance, 0, len(cfg.Get().Instances))
+	for _, base := range cfg.Get().Instances {
+		base = strings.TrimSuffix(base, "/")
+		inst, ok := state[base]
+		if !ok {
+			inst = Instance{URL: base} // not checked yet
+		}
+
+		res = append(res, inst)
+	}
+
+	return res
+}
+
+// random healthy instance, empty string if there are none
+func Random() string {
+	healthy := []string{}
+	for _, inst := range List() {
+		if inst.Healthy {
+			healthy = append(healthy, inst.URL)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return ""
+	}
+
+	return healthy[rand.Intn(len(healthy))]
+}
+
+func rateLimited() bool {
+	last := sc.LastRateLimit()
+	return !last.IsZero() && time.Since(last) < cfg.Get().RateLimitCooldown
+}
+
+// paths which should always be served by us
+var local = []string{"/_/", "/admin", "/healthz", "/readyz", "/metrics", "/instances", "/instance-info", "/robots.txt"}
+
+func Load(r fiber.Router) {
+	go func() {
+		for {
+			if len(cfg.Get().Instances) != 0 {
+				checkAll()
+			}
+
+			time.Sleep(cfg.Get().InstancesCheckInterval)
+		}
+	}()
+
+	r.Get("/instances", func(c *fiber.Ctx) error {
+		return c.JSON(List())
+	})
+
+	loadInfo(r)
+
+	r.Use(func(c *fiber.Ctx) error {
+		if !cfg.Get().RedirectWhenRateLimited || c.Method() != fiber.MethodGet || !rateLimited() {
+			return c.Next()
+		}
+
+		p := c.Path()
+		for _, l := range local {
+			if strings.HasPrefix(p, l) {
+				return c.Next()
+			}
+		}
+
+		peer := Random()
+		if peer == "" {
+			return c.Next()
+		}
+
+		return c.Redirect(peer + c.OriginalURL())
+	})
+}
@@ -0,0 +1,327 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/events"
+	"github.com/maid-zone/soundcloak/lib/export"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Downloads in the background: POST /_/jobs creates a job, a few workers process them, GET /_/jobs/:id reports progress
+// the finished file is kept in cfg.DataDir/jobs for cfg.JobTTL. jobs live in memory, so they are off with prefork
+// GET /_/jobs/:id/events streams the same json as server-sent events ("progress"), ending once the job is done or failed
+
+var ErrQueueFull = errors.New("too many jobs queued")
+var ErrUnknownKind = errors.New("unknown job kind, expected track, playlist or local")
+
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+type Job struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`   // track, playlist or local
+	Target   string    `json:"target"` // track/playlist url, or local playlist id
+	Status   Status    `json:"status"`
+	Progress float64   `json:"progress"` // 0 to 1
+	Error    string    `json:"error,omitempty"`
+	File     string    `json:"file,omitempty"` // url of the result, once done
+	Name     string    `json:"name,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"` // zero while not finished
+
+	tracks string // selection for playlists, check export.SelectTracks
+	path   string
+}
+
+var jobs = map[string]*Job{}
+var jobsLock = &sync.RWMutex{}
+var queue chan *Job
+
+func dir() string {
+	return filepath.Join(cfg.Get().DataDir, "jobs")
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// copy of the job, so it can be read without holding the lock
+func Get(id string) (Job, bool) {
+	jobsLock.RLock()
+	defer jobsLock.RUnlock()
+
+	j, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *j, true
+}
+
+func (j Job) Finished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed
+}
+
+func topic(id string) string {
+	return "job:" + id
+}
+
+func update(j *Job, f func(j *Job)) {
+	jobsLock.Lock()
+	f(j)
+	res := *j
+	jobsLock.Unlock()
+
+	events.Publish(topic(res.ID), "progress", res)
+	if res.Finished() {
+		events.Close(topic(res.ID))
+	}
+}
+
+func Create(kind string, target string, tracks string) (Job, error) {
+	switch kind {
+	case "track", "playlist", "local":
+	default:
+		return Job{}, ErrUnknownKind
+	}
+
+	j := &Job{ID: newID(), Kind: kind, Target: target, Status: StatusQueued, Created: time.Now(), tracks: tracks}
+
+	jobsLock.Lock()
+	select {
+	case queue <- j:
+		jobs[j.ID] = j
+	default:
+		jobsLock.Unlock()
+		return Job{}, ErrQueueFull
+	}
+	res := *j
+	jobsLock.Unlock()
+
+	return res, nil
+}
+
+func progress(j *Job) func(done int, total int) {
+	last := -1
+	return func(done int, total int) {
+		// no need to send an event for every segment
+		if pc := done * 100 / total; pc != last {
+			last = pc
+			update(j, func(j *Job) { j.Progress = float64(done) / float64(total) })
+		}
+	}
+}
+
+// downloads into f, returns the file name for the user
+func run(j *Job, f *os.File) (string, error) {
+	switch j.Kind {
+	case "track":
+		t, err := sc.GetArbitraryTrack(j.Target)
+		if err != nil {
+			return "", err
+		}
+
+		return download.Filename(t), download.TrackProgress(t, f, progress(j))
+	case "playlist":
+		p, err := sc.GetPlaylist(export.Permalink(j.Target))
+		if err != nil {
+			return "", err
+		}
+
+		tracks, err := export.AllTracks(p)
+		if err != nil {
+			return "", err
+		}
+
+		return download.Sanitize(p.Title) + ".zip", download.Zip(f, p.Title, p.Artwork, export.SelectTracks(tracks, j.tracks), progress(j))
+	default: // local
+		p, err := local.Get(j.Target)
+		if err != nil {
+			return "", err
+		}
+
+		tracks, err := local.GetTracks(p.Tracks)
+		if err != nil {
+			return "", err
+		}
+
+		return download.Sanitize(p.Title) + ".zip", download.Zip(f, p.Title, "", export.SelectTracks(tracks, j.tracks), progress(j))
+	}
+}
+
+func process(j *Job) {
+	update(j, func(j *Job) { j.Status = StatusRunning })
+
+	var name string
+	err := os.MkdirAll(dir(), 0o755)
+	p := filepath.Join(dir(), j.ID)
+	if err == nil {
+		var f *os.File
+		f, err = os.Create(p)
+		if err == nil {
+			name, err = run(j, f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("error processing job %s (%s %s): %s\n", j.ID, j.Kind, j.Target, err)
+		os.Remove(p)
+	}
+
+	update(j, func(j *Job) {
+		j.Expires = time.Now().Add(cfg.Get().JobTTL)
+		if err != nil {
+			j.Status = StatusFailed
+			j.Error = err.Error()
+			return
+		}
+
+		j.Status = StatusDone
+		j.Progress = 1
+		j.Name = name
+		j.File = "/_/jobs/" + j.ID + "/file"
+		j.path = p
+	})
+}
+
+func cleanup() {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+
+	for id, j := range jobs {
+		if !j.Expires.IsZero() && j.Expires.Before(time.Now()) {
+			if j.path != "" {
+				os.Remove(j.path)
+			}
+
+			delete(jobs, id)
+		}
+	}
+}
+
+func Load(r fiber.Router) {
+	// a job would only be known to the process which got the POST. without the routes,
+	// the download buttons fall back to streaming the zip (assets/download.js)
+	if cfg.Get().Prefork {
+		return
+	}
+
+	// files from the last run, we don't know about those jobs anymore
+	os.RemoveAll(dir())
+
+	queue = make(chan *Job, cfg.Get().JobQueueSize)
+	for i := 0; i < cfg.Get().JobWorkers; i++ {
+		go func() {
+			for j := range queue {
+				process(j)
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Get().JobTTL / 4)
+		cfg.OnReload(func() { ticker.Reset(cfg.Get().JobTTL / 4) })
+		for range ticker.C {
+			cleanup()
+		}
+	}()
+
+	enabled := func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableDownloads {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}
+
+	// kind=track&url=..., kind=playlist&url=...(&tracks=id,id) or kind=local&id=...
+	r.Post("/_/jobs", enabled, bandwidth.Quota, func(c *fiber.Ctx) error {
+		kind := c.FormValue("kind")
+		target := c.FormValue("url")
+		if kind == "local" {
+			if !cfg.Get().Features.EnableLocalPlaylists {
+				return fiber.ErrNotFound
+			}
+
+			target = c.FormValue("id")
+		}
+
+		if target == "" {
+			return fiber.ErrBadRequest
+		}
+
+		j, err := Create(kind, target, c.FormValue("tracks"))
+		if err != nil {
+			if err == ErrQueueFull {
+				return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+			}
+
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		c.Location("/_/jobs/" + j.ID)
+		return c.Status(fiber.StatusAccepted).JSON(j)
+	})
+
+	r.Get("/_/jobs/:id", enabled, func(c *fiber.Ctx) error {
+		j, ok := Get(c.Params("id"))
+		if !ok {
+			return fiber.ErrNotFound
+		}
+
+		return c.JSON(j)
+	})
+
+	r.Get("/_/jobs/:id/events", enabled, func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, ok := Get(id); !ok {
+			return fiber.ErrNotFound
+		}
+
+		return events.Stream(c, topic(id), func() (events.Event, bool) {
+			j, ok := Get(id)
+			return events.Event{Name: "progress", Data: j}, !ok || j.Finished()
+		})
+	})
+
+	r.Get("/_/jobs/:id/file", enabled, func(c *fiber.Ctx) error {
+		j, ok := Get(c.Params("id"))
+		if !ok || j.Status != StatusDone {
+			return fiber.ErrNotFound
+		}
+
+		if st, err := os.Stat(j.path); err == nil {
+			bandwidth.Add(c.IP(), "", st.Size())
+		}
+
+		return c.Download(j.path, j.Name)
+	})
+}
@@ -0,0 +1,240 @@
+package local
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Importing playlists from m3u/csv/json files with soundcloud urls (or stream urls from playlists exported by soundcloak)
+
+type Failed struct {
+	Entry string `json:"entry"`
+	Error string `json:"error"`
+}
+
+// track id from a stream proxy url (/_/proxy/streams/track?id=...)
+func proxyID(entry string) string {
+	u, err := url.Parse(entry)
+	if err != nil || !strings.HasSuffix(u.Path, "/_/proxy/streams/track") {
+		return ""
+	}
+
+	return u.Query().Get("id")
+}
+
+func numeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// good enough to skip csv headers and other columns
+func looksLikeTrack(s string) bool {
+	return numeric(s) || proxyID(s) != "" || strings.Contains(s, "soundcloud.com/")
+}
+
+func jsonEntry(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return ""
+}
+
+func parseJSON(data []byte) []string {
+	var raw any
+	if cfg.JSON.Unmarshal(data, &raw) != nil {
+		return nil
+	}
+
+	// {"tracks": [...]}, like local playlists or api responses
+	if m, ok := raw.(map[string]any); ok {
+		for _, key := range []string{"tracks", "collection"} {
+			if l, ok := m[key]; ok {
+				raw = l
+				break
+			}
+		}
+	}
+
+	l, ok := raw.([]any)
+	if !ok {
+		return nil
+	}
+
+	entries := []string{}
+	for _, e := range l {
+		if m, ok := e.(map[string]any); ok {
+			for _, key := range []string{"permalink_url", "url", "location", "id"} {
+				if s := jsonEntry(m[key]); s != "" {
+					entries = append(entries, s)
+					break
+				}
+			}
+		} else if s := jsonEntry(e); s != "" {
+			entries = append(entries, s)
+		}
+	}
+
+	return entries
+}
+
+// first column that looks like a track in every row
+func parseCSV(data []byte) []string {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil
+	}
+
+	entries := []string{}
+	for _, row := range rows {
+		for _, field := range row {
+			if field = strings.TrimSpace(field); looksLikeTrack(field) {
+				entries = append(entries, field)
+				break
+			}
+		}
+	}
+
+	return entries
+}
+
+// m3u/m3u8 or a plain list of urls, one per line
+func parseLines(data []byte) []string {
+	entries := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '#' {
+			entries = append(entries, line)
+		}
+	}
+
+	return entries
+}
+
+// the format is guessed from the file extension, falling back to the content
+func ParseFile(name string, data []byte) []string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // utf-8 bom
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		return parseJSON(data)
+	case ".csv":
+		return parseCSV(data)
+	case ".m3u", ".m3u8", ".txt":
+		return parseLines(data)
+	}
+
+	if t := bytes.TrimSpace(data); len(t) != 0 && (t[0] == '[' || t[0] == '{') {
+		return parseJSON(data)
+	}
+
+	return parseLines(data)
+}
+
+func failed(entry string, err error) Failed {
+	return Failed{Entry: entry, Error: err.Error()}
+}
+
+// resolves the entries into track ids. ids are checked in batches with sc.GetTracks, links are resolved with sc.ResolveMany
+func Resolve(entries []string) (ids []string, fails []Failed) {
+	if len(entries) > cfg.Get().LocalPlaylistMaxTracks {
+		entries = entries[:cfg.Get().LocalPlaylistMaxTracks]
+	}
+
+	ids = make([]string, len(entries))
+	links := []string{}
+	linkIdx := []int{}
+	unchecked := []string{}
+	for i, entry := range entries {
+		if id := proxyID(entry); id != "" {
+			entry = id
+		}
+
+		if numeric(entry) {
+			ids[i] = entry
+			unchecked = append(unchecked, entry)
+		} else {
+			links = append(links, entry)
+			linkIdx = append(linkIdx, i)
+		}
+	}
+
+	exists := map[string]bool{}
+	for i, r := range sc.ResolveMany(links) {
+		if r.Err == nil && r.Kind != "track" {
+			r.Err = sc.ErrKindNotCorrect
+		}
+
+		if r.Err != nil {
+			fails = append(fails, failed(r.URL, r.Err))
+			continue
+		}
+
+		ids[linkIdx[i]] = r.ID
+		exists[r.ID] = true
+	}
+
+	for i := 0; i < len(unchecked); i += 50 {
+		batch := unchecked[i:min(i+50, len(unchecked))]
+		res, err := sc.GetTracks(strings.Join(batch, ","))
+		if err != nil {
+			// don't know, keep them
+			for _, id := range batch {
+				exists[id] = true
+			}
+			continue
+		}
+
+		for _, t := range res {
+			exists[t.ID] = true
+		}
+	}
+
+	res := ids[:0]
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		if !exists[id] {
+			fails = append(fails, Failed{Entry: entries[i], Error: "track not found"})
+			continue
+		}
+
+		res = append(res, id)
+	}
+
+	return res, fails
+}
+
+func Import(title string, entries []string) (Playlist, []Failed, error) {
+	ids, fails := Resolve(entries)
+	if len(ids) == 0 {
+		return Playlist{}, fails, nil
+	}
+
+	p, err := Create(title, ids)
+	return p, fails, err
+}
@@ -0,0 +1,86 @@
+package local
+
+import (
+	"io"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/accounts"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/ratelimit"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+func Load(r fiber.Router) {
+	r.Use("/playlists", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableLocalPlaylists {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	})
+
+	r.Get("/playlists/import", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/html")
+		return templates.Base("import playlist", templates.ImportPlaylist(cfg.Get().LocalPlaylistMaxTracks), nil).Render(preferences.Context(c), c)
+	})
+
+	r.Post("/playlists/import", ratelimit.Writes.Handler, func(c *fiber.Ctx) error {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		entries := ParseFile(fh.Filename, data)
+		p, fails, err := Import(c.FormValue("title"), entries)
+		if err != nil {
+			log.Printf("error saving imported playlist: %s\n", err)
+			return err
+		}
+
+		if p.ID != "" {
+			accounts.AddPlaylist(c, p.ID, p.Title)
+		}
+
+		ff := make([]templates.ImportFailure, len(fails))
+		for i, f := range fails {
+			ff[i] = templates.ImportFailure{Entry: f.Entry, Error: f.Error}
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("import playlist", templates.ImportResult(p.ID, len(p.Tracks), len(entries), ff), nil).Render(preferences.Context(c), c)
+	})
+
+	r.Get("/playlists/:id", func(c *fiber.Ctx) error {
+		p, err := Get(c.Params("id"))
+		if err != nil {
+			if err == ErrNotFound {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error getting local playlist %s: %s\n", c.Params("id"), err)
+			return err
+		}
+
+		tracks, err := GetTracks(p.Tracks)
+		if err != nil {
+			log.Printf("error getting local playlist %s tracks: %s\n", p.ID, err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base(p.Title, templates.LocalPlaylist(p.ID, p.Title, len(p.Tracks), tracks), nil).Render(preferences.Context(c), c)
+	})
+}
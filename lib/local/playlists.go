@@ -0,0 +1,121 @@
+package local
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Playlists stored on the instance, as json files in cfg.DataDir/playlists
+// there are no accounts, anyone with the link can see the playlist (the id is random enough to not be guessable)
+
+var ErrNotFound = errors.New("local playlist not found")
+
+type Playlist struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Created time.Time `json:"created"`
+	Tracks  []string  `json:"tracks"` // track ids, in order
+}
+
+func dir() string {
+	return filepath.Join(cfg.Get().DataDir, "playlists")
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func save(p Playlist) error {
+	data, err := cfg.JSON.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(filepath.Join(dir(), p.ID+".json"), data, 0o755)
+}
+
+func Create(title string, tracks []string) (Playlist, error) {
+	if len(tracks) > cfg.Get().LocalPlaylistMaxTracks {
+		tracks = tracks[:cfg.Get().LocalPlaylistMaxTracks]
+	}
+
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = "Imported playlist"
+	}
+
+	p := Playlist{ID: newID(), Title: title, Created: time.Now().UTC(), Tracks: tracks}
+	return p, save(p)
+}
+
+func Get(id string) (Playlist, error) {
+	if !validID(id) {
+		return Playlist{}, ErrNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir(), id+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Playlist{}, ErrNotFound
+		}
+
+		return Playlist{}, err
+	}
+
+	var p Playlist
+	err = cfg.JSON.Unmarshal(data, &p)
+	return p, err
+}
+
+// fetches tracks with the given ids in batches of 50, returned in the same order (tracks that don't exist anymore are skipped)
+func GetTracks(ids []string) ([]*sc.Track, error) {
+	found := make(map[string]*sc.Track, len(ids))
+	for i := 0; i < len(ids); i += 50 {
+		res, err := sc.GetTracks(strings.Join(ids[i:min(i+50, len(ids))], ","))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range res {
+			found[t.ID] = t
+		}
+	}
+
+	tracks := make([]*sc.Track, 0, len(ids))
+	for _, id := range ids {
+		if t, ok := found[id]; ok {
+			tracks = append(tracks, t)
+		}
+	}
+
+	return tracks, nil
+}
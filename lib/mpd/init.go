@@ -0,0 +1,301 @@
+package mpd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Music Player Daemon protocol bridge (cfg.MPDAddr): a read-only library for mpd and its clients, it doesn't play anything itself
+// point mpd at it with
+//
+//	database {
+//	    plugin "proxy"
+//	    host   "127.0.0.1"
+//	    port   "6600"
+//	}
+//
+// and ncmpcpp & co can browse and search soundcloud, songs are stream proxy urls which mpd plays like any other stream
+// `password <favorites key>` (the favorites cookie) adds your favorite tracks and artists
+
+const version = "0.23.5"
+
+// connections at once, mpd keeps one open (idling) and clients usually one each
+const maxConns = 64
+
+const (
+	ackNotList    = 1
+	ackArg        = 2
+	ackPassword   = 3
+	ackUnknown    = 5
+	ackNoExist    = 50
+	ackSystem     = 52
+	ackPermission = 4
+)
+
+type ackError struct {
+	code int
+	msg  string
+}
+
+func (e ackError) Error() string {
+	return e.msg
+}
+
+func ack(code int, format string, args ...any) error {
+	return ackError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+var errClose = errors.New("close")
+
+var started time.Time
+
+type conn struct {
+	net.Conn
+	r   *bufio.Reader
+	w   *bufio.Writer
+	key string // favorites, from password
+}
+
+// key: value, values can't span lines
+func (c *conn) pair(k string, v string) {
+	c.w.WriteString(k)
+	c.w.WriteString(": ")
+	c.w.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(v))
+	c.w.WriteByte('\n')
+}
+
+func (c *conn) ack(err error, index int, cmd string) {
+	e, ok := err.(ackError)
+	if !ok {
+		e = ackError{code: ackSystem, msg: err.Error()}
+	}
+
+	fmt.Fprintf(c.w, "ACK [%d@%d] {%s} %s\n", e.code, index, cmd, e.msg)
+}
+
+// splits a command line: words, or "quoted strings" with \" and \\ escapes
+func parseLine(line string) ([]string, error) {
+	var res []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return res, nil
+		}
+
+		if line[0] != '"' {
+			word, rest, _ := strings.Cut(line, " ")
+			res = append(res, strings.TrimRight(word, "\t"))
+			line = rest
+			continue
+		}
+
+		var b strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+
+		if i == len(line) {
+			return nil, ack(ackArg, "Invalid unquoted character")
+		}
+
+		res = append(res, b.String())
+		line = line[i+1:]
+	}
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errors.New("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// runs one command, the caller writes the OK
+func (c *conn) run(args []string) error {
+	if len(args) == 0 {
+		return ack(ackUnknown, "No command given")
+	}
+
+	h, ok := commands[args[0]]
+	if !ok {
+		if playback[args[0]] {
+			return ack(ackPermission, "soundcloak is only a library, use it as the database of mpd (plugin \"proxy\") to play things")
+		}
+
+		return ack(ackUnknown, "unknown command %q", args[0])
+	}
+
+	return h(c, args[1:])
+}
+
+func (c *conn) serve() {
+	defer c.Close()
+
+	fmt.Fprintf(c.w, "OK MPD %s\n", version)
+	c.w.Flush()
+
+	var list [][]string
+	inList, listOK := false, false
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("mpd: %s: %s\n", c.RemoteAddr(), err)
+			}
+			return
+		}
+
+		args, err := parseLine(line)
+		if err != nil {
+			c.ack(err, 0, "")
+			c.w.Flush()
+			continue
+		}
+
+		cmd := ""
+		if len(args) != 0 {
+			cmd = args[0]
+		}
+
+		switch {
+		case cmd == "command_list_begin" || cmd == "command_list_ok_begin":
+			inList, listOK, list = true, cmd == "command_list_ok_begin", nil
+			continue
+		case inList && cmd != "command_list_end":
+			if len(args) != 0 { // empty lines would have no command to report errors for
+				list = append(list, args)
+			}
+			continue
+		case cmd == "command_list_end":
+			if !inList {
+				c.ack(ack(ackNotList, "not in command list mode"), 0, cmd)
+				break
+			}
+
+			inList = false
+			failed := false
+			for i, args := range list {
+				err := c.run(args)
+				if err == errClose {
+					return
+				}
+
+				if err != nil {
+					c.ack(err, i, args[0])
+					failed = true
+					break
+				}
+
+				if listOK {
+					c.w.WriteString("list_OK\n")
+				}
+			}
+
+			if !failed {
+				c.w.WriteString("OK\n")
+			}
+		case cmd == "idle":
+			// the library never changes, so this only ends with noidle (or the connection closing)
+			c.w.Flush()
+			line, err := c.readLine()
+			if err != nil {
+				return
+			}
+
+			if strings.TrimSpace(line) != "noidle" {
+				c.ack(ack(ackUnknown, "only noidle is allowed while idle"), 0, "idle")
+				break
+			}
+			c.w.WriteString("OK\n")
+		default:
+			err := c.run(args)
+			if err == errClose {
+				return
+			}
+
+			if err != nil {
+				c.ack(err, 0, cmd)
+			} else {
+				c.w.WriteString("OK\n")
+			}
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// accepts connections until ln is closed
+func Serve(ln net.Listener) {
+	started = time.Now()
+	sem := make(chan struct{}, maxConns)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("mpd: %s\n", err)
+			}
+			return
+		}
+
+		select {
+		case sem <- struct{}{}:
+		default:
+			fmt.Fprintf(nc, "ACK [%d@0] {} too many connections\n", ackSystem)
+			nc.Close()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("mpd: panic serving %s: %v\n", nc.RemoteAddr(), err)
+					nc.Close()
+				}
+
+				<-sem
+				wg.Done()
+			}()
+
+			c := &conn{Conn: nc, r: bufio.NewReaderSize(nc, 4096), w: bufio.NewWriter(nc)}
+			c.serve()
+		}()
+	}
+}
+
+// checks the config, false (after logging why) when the bridge can't work
+func Enabled() bool {
+	if cfg.Get().MPDAddr == "" {
+		return false
+	}
+
+	if cfg.Get().InstanceURL == "" || !cfg.Get().Features.EnableStreamProxy {
+		log.Println("mpd_addr needs instance_url and the stream proxy, not starting the mpd bridge")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,453 @@
+package mpd
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// The library: directories are made up from paths, nothing is stored
+//
+//	favorites                        favorite tracks (after password)
+//	users                            favorite artists (after password)
+//	users/<user>/tracks
+//	users/<user>/playlists/<playlist>
+//	users/<user>/albums/<album>
+
+// tracks in a directory or search result
+const pageSize = 50
+
+type song struct {
+	file     string
+	title    string
+	artist   string
+	album    string
+	genre    string
+	modified string
+	duration time.Duration
+}
+
+func trackSong(t *sc.Track, album string) song {
+	return song{
+		file:     proxystreams.TrackURL(cfg.Get().InstanceURL, t.ID),
+		title:    t.Title,
+		artist:   t.Author.Username,
+		album:    album,
+		genre:    t.Genre,
+		modified: t.LastModified,
+		duration: time.Duration(t.DurationMs) * time.Millisecond, // Duration isn't set in every listing
+	}
+}
+
+func (c *conn) song(s song) {
+	c.pair("file", s.file)
+	if s.modified != "" {
+		c.pair("Last-Modified", s.modified)
+	}
+	if s.duration != 0 {
+		c.pair("Time", strconv.Itoa(int(s.duration.Seconds())))
+		c.pair("duration", strconv.FormatFloat(s.duration.Seconds(), 'f', 3, 64))
+	}
+	c.pair("Title", s.title)
+	c.pair("Artist", s.artist)
+	if s.album != "" {
+		c.pair("Album", s.album)
+	}
+	if s.genre != "" {
+		c.pair("Genre", s.genre)
+	}
+}
+
+func (c *conn) tracks(tracks []*sc.Track, album string) {
+	for _, t := range tracks {
+		if t != nil && !t.Unavailable {
+			c.song(trackSong(t, album))
+		}
+	}
+}
+
+func (c *conn) favorites() (favorites.Favorites, error) {
+	if c.key == "" {
+		return favorites.Favorites{}, ack(ackPermission, "send your favorites key with password first")
+	}
+
+	return favorites.Get(c.key)
+}
+
+func notFound(what string, err error) error {
+	if err != sc.ErrBlocked {
+		log.Printf("mpd: error getting %s: %s\n", what, err)
+	}
+
+	return ack(ackNoExist, "No such directory")
+}
+
+// songs are our stream proxy urls, the track is in the id
+func songByURI(uri string) (*sc.Track, error) {
+	u, err := url.Parse(uri)
+	if err != nil || !strings.HasPrefix(uri, cfg.Get().InstanceURL+"/") || u.Query().Get("id") == "" {
+		return nil, ack(ackNoExist, "No such song")
+	}
+
+	t, err := sc.GetArbitraryTrack(u.Query().Get("id"))
+	if err != nil {
+		return nil, notFound(uri, err)
+	}
+
+	return &t, nil
+}
+
+// leaf directories (the ones with songs in them), ok is false for everything else
+func (c *conn) songs(parts []string) (ok bool, err error) {
+	switch {
+	case len(parts) == 1 && parts[0] == "favorites":
+		f, err := c.favorites()
+		if err != nil {
+			return true, err
+		}
+
+		for _, t := range f.Tracks {
+			c.song(song{file: proxystreams.TrackURL(cfg.Get().InstanceURL, t.ID), title: t.Title, artist: t.Artist})
+		}
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "tracks":
+		u, err := sc.GetUser(parts[1])
+		if err != nil {
+			return true, notFound(parts[1], err)
+		}
+
+		p, err := u.GetTracks(sc.NewCursor(pageSize, ""))
+		if err != nil {
+			return true, notFound(parts[1]+" tracks", err)
+		}
+
+		for i := range p.Collection {
+			if !p.Collection[i].Unavailable {
+				c.song(trackSong(&p.Collection[i], ""))
+			}
+		}
+	case len(parts) == 4 && parts[0] == "users" && (parts[2] == "playlists" || parts[2] == "albums"):
+		p, err := sc.GetPlaylist(parts[1] + "/sets/" + parts[3])
+		if err != nil {
+			return true, notFound(parts[1]+"/sets/"+parts[3], err)
+		}
+
+		c.tracks(p.Tracks, p.Title)
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (c *conn) lsinfo(uri string) error {
+	if strings.Contains(uri, "://") {
+		t, err := songByURI(uri)
+		if err != nil {
+			return err
+		}
+
+		c.song(trackSong(t, ""))
+		return nil
+	}
+
+	uri = strings.Trim(uri, "/")
+	var parts []string
+	if uri != "" {
+		parts = strings.Split(uri, "/")
+	}
+
+	if ok, err := c.songs(parts); ok {
+		return err
+	}
+
+	switch {
+	case len(parts) == 0:
+		if c.key != "" {
+			c.pair("directory", "favorites")
+		}
+		c.pair("directory", "users")
+	case len(parts) == 1 && parts[0] == "users":
+		// anyone can be listed, but only favorite artists are known
+		if c.key != "" {
+			f, err := c.favorites()
+			if err != nil {
+				return err
+			}
+
+			for _, u := range f.Users {
+				c.pair("directory", "users/"+u.Permalink)
+			}
+		}
+	case len(parts) == 2 && parts[0] == "users":
+		if _, err := sc.GetUser(parts[1]); err != nil {
+			return notFound(parts[1], err)
+		}
+
+		for _, d := range []string{"tracks", "playlists", "albums"} {
+			c.pair("directory", uri+"/"+d)
+		}
+	case len(parts) == 3 && parts[0] == "users" && (parts[2] == "playlists" || parts[2] == "albums"):
+		u, err := sc.GetUser(parts[1])
+		if err != nil {
+			return notFound(parts[1], err)
+		}
+
+		get := u.GetPlaylists
+		if parts[2] == "albums" {
+			get = u.GetAlbums
+		}
+
+		p, err := get(sc.NewCursor(pageSize, ""))
+		if err != nil {
+			return notFound(uri, err)
+		}
+
+		for _, pl := range p.Collection {
+			c.pair("directory", uri+"/"+pl.Permalink)
+			if pl.LastModified != "" {
+				c.pair("Last-Modified", pl.LastModified)
+			}
+		}
+	default:
+		return ack(ackNoExist, "No such directory")
+	}
+
+	return nil
+}
+
+// only directories with songs can be listed recursively, listing everything isn't possible
+func (c *conn) listall(uri string) error {
+	var parts []string
+	if uri = strings.Trim(uri, "/"); uri != "" {
+		parts = strings.Split(uri, "/")
+	}
+
+	if ok, err := c.songs(parts); ok {
+		return err
+	}
+
+	return ack(ackNoExist, "soundcloud is too big to be listed recursively, use lsinfo")
+}
+
+// new style: (title == 'x') AND (any contains "y")
+var filterExpr = regexp.MustCompile(`\(\s*(\w+)\s+(==|contains|starts_with|eq)\s+(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)")\s*\)`)
+
+// tag -> value, from the old style (title x artist y) or an expression
+func parseFilter(args []string) map[string]string {
+	res := map[string]string{}
+	if len(args) != 0 && strings.HasPrefix(args[0], "(") {
+		for _, m := range filterExpr.FindAllStringSubmatch(args[0], -1) {
+			v := m[3] + m[4]
+			res[strings.ToLower(m[1])] = strings.NewReplacer(`\'`, `'`, `\"`, `"`, `\\`, `\`).Replace(v)
+		}
+		return res
+	}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		switch k := strings.ToLower(args[i]); k {
+		case "sort", "window", "position":
+		default:
+			res[k] = args[i+1]
+		}
+	}
+
+	return res
+}
+
+func (c *conn) search(args []string, exact bool) error {
+	filter := parseFilter(args)
+	if uri, ok := filter["file"]; ok {
+		t, err := songByURI(uri)
+		if err != nil {
+			return nil // nothing found
+		}
+
+		c.song(trackSong(t, ""))
+		return nil
+	}
+
+	var q []string
+	for _, k := range []string{"any", "artist", "title", "album", "genre"} {
+		if v := filter[k]; v != "" {
+			q = append(q, v)
+		}
+	}
+
+	if len(q) == 0 {
+		return ack(ackArg, "nothing to search for")
+	}
+
+	if !cfg.Get().Features.EnableSearch {
+		return ack(ackPermission, "search is disabled on this instance")
+	}
+
+	p, err := sc.SearchTracks(strings.Join(q, " "), sc.NewPage(pageSize, 0))
+	if err != nil {
+		log.Printf("mpd: error searching for %s: %s\n", q, err)
+		return ack(ackSystem, "search failed")
+	}
+
+	match := func(have string, want string) bool {
+		if want == "" {
+			return true
+		}
+
+		if exact {
+			return have == want
+		}
+
+		return strings.Contains(strings.ToLower(have), strings.ToLower(want))
+	}
+
+	for _, t := range p.Collection {
+		if match(t.Title, filter["title"]) && match(t.Author.Username, filter["artist"]) && match(t.Genre, filter["genre"]) {
+			c.song(trackSong(t, ""))
+		}
+	}
+
+	return nil
+}
+
+type handler func(c *conn, args []string) error
+
+var commands map[string]handler
+
+// what clients might send which needs a player, answered with a hint instead of unknown command
+var playback = map[string]bool{}
+
+func empty(*conn, []string) error {
+	return nil
+}
+
+func init() {
+	for _, cmd := range []string{
+		"play", "playid", "pause", "stop", "next", "previous", "seek", "seekid", "seekcur", "add", "addid", "clear", "delete",
+		"deleteid", "move", "moveid", "shuffle", "setvol", "volume", "repeat", "random", "single", "consume", "crossfade",
+		"load", "save", "rm", "rename", "playlistadd", "playlistclear", "playlistdelete", "update", "rescan", "enableoutput",
+		"disableoutput", "toggleoutput",
+	} {
+		playback[cmd] = true
+	}
+
+	commands = map[string]handler{
+		"ping": empty,
+		"close": func(*conn, []string) error {
+			return errClose
+		},
+		"password": func(c *conn, args []string) error {
+			if len(args) != 1 || !cfg.Get().Features.EnableFavorites {
+				return ack(ackPassword, "incorrect password")
+			}
+
+			f, err := favorites.Get(args[0])
+			if err != nil || len(f.Tracks)+len(f.Users) == 0 {
+				return ack(ackPassword, "incorrect password")
+			}
+
+			c.key = args[0]
+			return nil
+		},
+		"binarylimit": empty,
+		"tagtypes": func(c *conn, args []string) error {
+			if len(args) == 0 {
+				for _, t := range []string{"Artist", "Album", "Title", "Genre"} {
+					c.pair("tagtype", t)
+				}
+			}
+			return nil
+		},
+		"commands": func(c *conn, _ []string) error {
+			names := make([]string, 0, len(commands))
+			for name := range commands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				c.pair("command", name)
+			}
+			return nil
+		},
+		"notcommands": func(c *conn, _ []string) error {
+			names := make([]string, 0, len(playback))
+			for name := range playback {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				c.pair("command", name)
+			}
+			return nil
+		},
+		"status": func(c *conn, _ []string) error {
+			for _, k := range []string{"repeat", "random", "single", "consume", "playlist", "playlistlength"} {
+				c.pair(k, "0")
+			}
+			c.pair("state", "stop")
+			return nil
+		},
+		"stats": func(c *conn, _ []string) error {
+			for _, k := range []string{"artists", "albums", "songs", "playtime", "db_playtime"} {
+				c.pair(k, "0")
+			}
+			c.pair("uptime", strconv.Itoa(int(time.Since(started).Seconds())))
+			c.pair("db_update", strconv.FormatInt(started.Unix(), 10))
+			return nil
+		},
+		"count": func(c *conn, _ []string) error {
+			c.pair("songs", "0")
+			c.pair("playtime", "0")
+			return nil
+		},
+
+		// nothing playing, nothing queued, nothing to output
+		"currentsong":    empty,
+		"playlistinfo":   empty,
+		"playlistid":     empty,
+		"plchanges":      empty,
+		"plchangesposid": empty,
+		"outputs":        empty,
+		"decoders":       empty,
+		"urlhandlers":    empty,
+		"channels":       empty,
+		"readmessages":   empty,
+		"listmounts":     empty,
+		"listneighbors":  empty,
+		"listplaylists":  empty,
+		"list":           empty,
+
+		"lsinfo": func(c *conn, args []string) error {
+			uri := ""
+			if len(args) != 0 {
+				uri = args[0]
+			}
+			return c.lsinfo(uri)
+		},
+		"listall": func(c *conn, args []string) error {
+			uri := ""
+			if len(args) != 0 {
+				uri = args[0]
+			}
+			return c.listall(uri)
+		},
+		"find": func(c *conn, args []string) error {
+			return c.search(args, true)
+		},
+		"search": func(c *conn, args []string) error {
+			return c.search(args, false)
+		},
+	}
+
+	commands["listallinfo"] = commands["listall"]
+	commands["listfiles"] = commands["lsinfo"]
+}
@@ -0,0 +1,111 @@
+package nowplaying
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/i18n"
+	"github.com/maid-zone/soundcloak/lib/proxyimages"
+	"github.com/maid-zone/soundcloak/lib/rooms"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Now playing widget for overlays (OBS browser source) and readme badges: /nowplaying/<room id or user>
+// a room shows its current track, a user their latest upload (we don't know what people listen to)
+// svg by default, ?format=json for the data
+
+type NowPlaying struct {
+	Source  string `json:"source"` // room or user
+	Playing bool   `json:"playing"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Artwork string `json:"artwork"`
+	URL     string `json:"url"`
+}
+
+func get(id string) (NowPlaying, sc.Track, error) {
+	var t sc.Track
+	var err error
+	np := NowPlaying{Source: "user"}
+	if track, playing, ok := rooms.Current(id); ok {
+		np.Source = "room"
+		np.Playing = playing
+		t, err = sc.GetTrackByID(track)
+	} else {
+		var u sc.User
+		u, err = sc.GetUser(id)
+		if err != nil {
+			return np, t, err
+		}
+
+		var p *sc.Paginated[sc.Track]
+		p, err = u.GetTracks(sc.NewCursor(1, ""))
+		if err != nil {
+			return np, t, err
+		}
+
+		if len(p.Collection) == 0 {
+			return np, t, fiber.ErrNotFound
+		}
+
+		t = p.Collection[0]
+	}
+
+	if err != nil {
+		return np, t, err
+	}
+
+	np.Title = t.Title
+	np.Artist = t.Author.Username
+	np.Artwork = proxyimages.URL(t.Artwork)
+	np.URL = "/" + t.Author.Permalink + "/" + t.Permalink
+	return np, t, nil
+}
+
+// as a data uri, badges are shown through image proxies (github's camo) which don't load anything from inside the svg
+func artwork(t sc.Track) string {
+	if t.Artwork == "" {
+		return ""
+	}
+
+	data, ct, err := proxyimages.Fetch(strings.Replace(t.Artwork, "-t500x500.", "-t200x200.", 1))
+	if err != nil {
+		log.Printf("error getting %s artwork (now playing): %s\n", t.ID, err)
+		return ""
+	}
+
+	return "data:" + ct + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func Load(r fiber.Router) {
+	r.Get("/nowplaying/:id", func(c *fiber.Ctx) error {
+		np, t, err := get(c.Params("id"))
+		if err != nil {
+			if err != fiber.ErrNotFound {
+				log.Printf("error getting %s (now playing): %s\n", c.Params("id"), err)
+			}
+			return err
+		}
+
+		if c.Query("format") == "json" {
+			return c.JSON(np)
+		}
+
+		// loaded from other sites without cookies, so no preferences
+		label := i18n.T(cfg.Get().Locale, "Latest upload")
+		if np.Source == "room" {
+			label = i18n.T(cfg.Get().Locale, "Now playing")
+			if !np.Playing {
+				label = i18n.T(cfg.Get().Locale, "Paused")
+			}
+		}
+
+		c.Set("Content-Type", "image/svg+xml")
+		c.Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+		return templates.NowPlayingWidget(label, np.Title, np.Artist, artwork(t)).Render(c.Context(), c)
+	})
+}
@@ -0,0 +1,180 @@
+package palette
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/proxyimages"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Dominant colors of track artwork, so the player page can tint its background like the official app
+// extracted from the smallest artwork size and kept per track id. pages only use what's already known and
+// queue the rest in the background, so rendering never waits for an image download
+
+const (
+	maxColors  = 5
+	maxEntries = 10000 // the oldest ones are forgotten when there are more
+	minDist    = 48    // colors closer than this (rgb distance) count as the same
+)
+
+type Palette struct {
+	Dominant string   `json:"dominant"` // #rrggbb
+	Colors   []string `json:"colors"`   // most common first, the dominant one included
+}
+
+// by track id
+var entries = sc.NewStore[Palette]("palettes", func(*cfg.Config) time.Duration { return 24 * time.Hour }).
+	Limit(func(*cfg.Config) int { return maxEntries })
+
+// track ids in queue
+var pending = map[string]bool{}
+var lock = &sync.Mutex{}
+
+var queue = make(chan sc.Track, 100)
+var startOnce = &sync.Once{}
+
+type bucket struct {
+	n       int
+	r, g, b int
+}
+
+func (b bucket) rgb() (int, int, int) {
+	return b.r / b.n, b.g / b.n, b.b / b.n
+}
+
+func extract(img image.Image) Palette {
+	// 4 bits per channel, the average of each bucket is used as its color
+	buckets := map[int]*bucket{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+
+			r, g, b = r>>8, g>>8, b>>8
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+
+			bk.n++
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].n > sorted[j].n })
+
+	var p Palette
+	var picked [][3]int
+	for _, bk := range sorted {
+		r, g, b := bk.rgb()
+		similar := false
+		for _, c := range picked {
+			dr, dg, db := r-c[0], g-c[1], b-c[2]
+			if dr*dr+dg*dg+db*db < minDist*minDist {
+				similar = true
+				break
+			}
+		}
+
+		if similar {
+			continue
+		}
+
+		picked = append(picked, [3]int{r, g, b})
+		p.Colors = append(p.Colors, fmt.Sprintf("#%02x%02x%02x", r, g, b))
+		if len(p.Colors) == maxColors {
+			break
+		}
+	}
+
+	if len(p.Colors) != 0 {
+		p.Dominant = p.Colors[0]
+	}
+
+	return p
+}
+
+// downloads the artwork and extracts its palette, it's cached afterwards
+func Get(t sc.Track) (Palette, error) {
+	if t.Artwork == "" {
+		return Palette{}, nil
+	}
+
+	p, ok := entries.Get(t.ID)
+	if ok {
+		return p, nil
+	}
+
+	data, _, err := proxyimages.Fetch(sc.ArtworkURL(t.Artwork, "t50x50"))
+	if err != nil {
+		return Palette{}, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Palette{}, err
+	}
+
+	p = extract(img)
+	entries.Set(t.ID, p)
+
+	return p, nil
+}
+
+func worker() {
+	for t := range queue {
+		_, err := Get(t)
+		if err != nil {
+			log.Printf("error getting %s palette: %s\n", t.ID, err)
+		}
+
+		lock.Lock()
+		delete(pending, t.ID)
+		lock.Unlock()
+	}
+}
+
+// the palette if it's known already, otherwise it gets extracted in the background and ok is false
+func Cached(t sc.Track) (Palette, bool) {
+	if t.Artwork == "" {
+		return Palette{}, false
+	}
+
+	if p, ok := entries.Get(t.ID); ok {
+		return p, true
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !pending[t.ID] {
+		startOnce.Do(func() { go worker() })
+		select {
+		case queue <- t:
+			pending[t.ID] = true
+		default: // busy, next time
+		}
+	}
+
+	return Palette{}, false
+}
@@ -0,0 +1,118 @@
+package preferences
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/i18n"
+	"github.com/maid-zone/soundcloak/lib/themes"
+)
+
+// Per-user settings, stored in a cookie (nothing is kept on the instance, unless the user has an account)
+// templates get them through the render context, use Context(c) when rendering
+
+type Preferences struct {
+	Locale string // language and number/date format, empty means automatic (Accept-Language, then cfg.Locale)
+	Theme  string // empty means cfg.Theme
+	Safe   string // what happens to explicit tracks in listings: SafeBlur, SafeHide or empty to show them
+
+	accept string // Accept-Language header
+}
+
+// also read by lib/accounts, which syncs it between browsers
+const Cookie = "prefs"
+
+type ctxKey struct{}
+
+const (
+	SafeBlur = "blur"
+	SafeHide = "hide"
+)
+
+func ValidSafe(s string) bool {
+	return s == SafeBlur || s == SafeHide
+}
+
+func Get(c *fiber.Ctx) Preferences {
+	p := Preferences{accept: c.Get("Accept-Language")}
+	v, err := url.ParseQuery(c.Cookies(Cookie))
+	if err == nil {
+		if l := v.Get("locale"); l != "" {
+			p.Locale = format.Normalize(l)
+		}
+
+		if t := v.Get("theme"); themes.Valid(t) {
+			p.Theme = t
+		}
+
+		if s := v.Get("safe"); ValidSafe(s) {
+			p.Safe = s
+		}
+	}
+
+	return p
+}
+
+func (p Preferences) Save(c *fiber.Ctx) {
+	v := url.Values{}
+	if p.Locale != "" {
+		v.Set("locale", p.Locale)
+	}
+	if p.Theme != "" {
+		v.Set("theme", p.Theme)
+	}
+	if p.Safe != "" {
+		v.Set("safe", p.Safe)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     Cookie,
+		Value:    v.Encode(),
+		Path:     "/",
+		Expires:  time.Now().Add(365 * 24 * time.Hour),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// the locale to actually use
+func (p Preferences) GetLocale() string {
+	if p.Locale != "" {
+		return p.Locale
+	}
+
+	if l := i18n.Negotiate(p.accept, format.Locales()); l != "" {
+		return l
+	}
+
+	return format.Normalize(cfg.Get().Locale)
+}
+
+func (p Preferences) GetTheme() string {
+	if p.Theme != "" {
+		return p.Theme
+	}
+
+	return cfg.Get().Theme
+}
+
+// render context with the preferences of the user, on top of what middleware put into the request
+func Context(c *fiber.Ctx) context.Context {
+	c.Vary("Accept-Language", "Cookie")
+	return context.WithValue(c.UserContext(), ctxKey{}, Get(c))
+}
+
+// like Context, but with p instead of the preferences c came with
+func With(c *fiber.Ctx, p Preferences) context.Context {
+	return context.WithValue(c.UserContext(), ctxKey{}, p)
+}
+
+// defaults if there are none (like in static exports)
+func From(ctx context.Context) Preferences {
+	p, _ := ctx.Value(ctxKey{}).(Preferences)
+	return p
+}
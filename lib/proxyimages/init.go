@@ -0,0 +1,136 @@
+package proxyimages
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/signing"
+	"github.com/valyala/fasthttp"
+)
+
+// Proxies artwork and avatars through the instance
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+}
+
+// returns url of the proxied image when the image proxy is enabled, otherwise u as is
+func URL(u string) string {
+	if u == "" || !cfg.Get().Features.EnableImageProxy {
+		return u
+	}
+
+	return "/_/proxy/images?" + signing.Query(u)
+}
+
+// srcset for an image shown px (css pixels) wide: the smallest fitting size for 1x, 2x and 3x displays, proxied like URL
+// the browser picks one, so retina displays get sharp images and everyone else doesn't download the original
+func SrcSet(u string, px int) string {
+	if u == "" {
+		return ""
+	}
+
+	var set []string
+	prev := ""
+	for x := 1; x <= 3; x++ {
+		size := sc.ArtworkSizeFor(px * x)
+		if size == prev {
+			continue
+		}
+		prev = size
+
+		set = append(set, URL(sc.ArtworkURL(u, size))+" "+strconv.Itoa(x)+"x")
+	}
+
+	return strings.Join(set, ", ")
+}
+
+var ErrNotArtwork = errors.New("not a soundcloud image url")
+
+// downloads an image from soundcloud's cdn (for embedding it somewhere), returns the body and content type
+func Fetch(raw string) ([]byte, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || !sc.IsImageURL(u) {
+		return nil, "", ErrNotArtwork
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u.String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = httpc.Do(req, resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, "", fmt.Errorf("got status code %d", resp.StatusCode())
+	}
+
+	return append([]byte(nil), resp.Body()...), string(resp.Header.ContentType()), nil
+}
+
+func Load(r fiber.Router) {
+	r.Get("/_/proxy/images", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableImageProxy {
+			return fiber.ErrNotFound
+		}
+
+		if !signing.Valid(c) {
+			return fiber.ErrForbidden
+		}
+
+		u, err := url.Parse(c.Query("url"))
+		if err != nil || !sc.IsImageURL(u) {
+			return fiber.ErrBadRequest
+		}
+
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+
+		// ?size=t300x300 (check sc.ArtworkSizes), for picking a size without knowing the url format
+		req.SetRequestURI(sc.ArtworkURL(u.String(), c.Query("size")))
+		req.Header.Set("User-Agent", cfg.Get().UserAgent)
+		// artwork never changes for the same url, let the cdn do the revalidation
+		if v := c.Request().Header.Peek("If-None-Match"); len(v) != 0 {
+			req.Header.SetBytesV("If-None-Match", v)
+		}
+		if v := c.Request().Header.Peek("If-Modified-Since"); len(v) != 0 {
+			req.Header.SetBytesV("If-Modified-Since", v)
+		}
+
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		err = httpc.Do(req, resp)
+		if err != nil {
+			return err
+		}
+
+		for _, h := range []string{"ETag", "Last-Modified"} {
+			if v := resp.Header.Peek(h); len(v) != 0 {
+				c.Set(h, string(v))
+			}
+		}
+
+		if resp.StatusCode() != 200 {
+			return c.SendStatus(resp.StatusCode())
+		}
+
+		c.Set("Content-Type", string(resp.Header.ContentType()))
+		c.Response().SetBody(resp.Body()) // copy, resp is released after this
+		return nil
+	})
+}
@@ -0,0 +1,170 @@
+package proxystreams
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+)
+
+// size-bounded on-disk lru cache for proxied segments and progressive streams
+// files are named after the hash of the key (+ original extension), so the index can be rebuilt on startup
+
+type entry struct {
+	name string
+	size int64
+}
+
+type diskCache struct {
+	dir   string
+	limit int64
+
+	lock  sync.Mutex
+	size  int64
+	order *list.List // front = most recently used
+	items map[string]*list.Element
+}
+
+func newDiskCache(dir string, limit int64) (*diskCache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &diskCache{dir: dir, limit: limit, order: list.New(), items: map[string]*list.Element{}}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name  string
+		size  int64
+		mtime int64
+	}
+
+	existing := []file{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		if strings.HasPrefix(f.Name(), ".tmp-") { // leftover from an interrupted write
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		existing = append(existing, file{f.Name(), info.Size(), info.ModTime().UnixNano()})
+	}
+
+	// oldest first, so they end up at the back of the list
+	sort.Slice(existing, func(i, j int) bool { return existing[i].mtime < existing[j].mtime })
+	for _, f := range existing {
+		d.items[f.name] = d.order.PushFront(&entry{name: f.name, size: f.size})
+		d.size += f.size
+	}
+
+	d.lock.Lock()
+	d.evict()
+	d.lock.Unlock()
+
+	return d, nil
+}
+
+func (d *diskCache) Stats() (size int64, files int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.size, len(d.items)
+}
+
+// removes everything from the cache
+func (d *diskCache) Flush() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for name, el := range d.items {
+		os.Remove(filepath.Join(d.dir, name))
+		d.order.Remove(el)
+	}
+
+	clear(d.items)
+	d.size = 0
+}
+
+func cacheName(key string) string {
+	h := sha1.Sum([]byte(key))
+	return hex.EncodeToString(h[:]) + path.Ext(key)
+}
+
+// returns path to the cached file
+func (d *diskCache) Get(key string) (string, bool) {
+	name := cacheName(key)
+
+	d.lock.Lock()
+	el, ok := d.items[name]
+	if ok {
+		d.order.MoveToFront(el)
+	}
+	d.lock.Unlock()
+
+	if !ok {
+		return "", false
+	}
+
+	return filepath.Join(d.dir, name), true
+}
+
+func (d *diskCache) Put(key string, data []byte) {
+	size := int64(len(data))
+	if size > d.limit {
+		return
+	}
+
+	name := cacheName(key)
+
+	err := atomicfile.Write(filepath.Join(d.dir, name), data, 0o755)
+	if err != nil {
+		return
+	}
+
+	d.lock.Lock()
+	if el, ok := d.items[name]; ok {
+		d.size -= el.Value.(*entry).size
+		d.order.Remove(el)
+	}
+
+	d.items[name] = d.order.PushFront(&entry{name: name, size: size})
+	d.size += size
+	d.evict()
+	d.lock.Unlock()
+}
+
+// lock must be held
+func (d *diskCache) evict() {
+	for d.size > d.limit {
+		el := d.order.Back()
+		if el == nil {
+			return
+		}
+
+		e := el.Value.(*entry)
+		d.order.Remove(el)
+		delete(d.items, e.name)
+		d.size -= e.size
+		os.Remove(filepath.Join(d.dir, e.name))
+	}
+}
@@ -0,0 +1,64 @@
+package proxystreams
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// request coalescing: when many listeners want the same segment at once (popular tracks), it's fetched from the cdn only once and everyone waiting gets the same bytes
+
+type fetched struct {
+	status      int
+	contentType string
+	body        []byte // shared between everyone waiting, don't modify
+	err         error
+}
+
+type call struct {
+	done chan struct{}
+	res  fetched
+}
+
+var calls = map[string]*call{}
+var callsLock sync.Mutex
+
+var coalesced atomic.Int64
+
+// amount of requests which were served by another request's fetch
+func Coalesced() int64 {
+	return coalesced.Load()
+}
+
+// fetches u (without a range) once for everyone asking for key at the same time
+// leader is true for the request which did the fetch, so the result only gets cached once
+func fetchShared(key string, u string) (res fetched, leader bool) {
+	callsLock.Lock()
+	if cl, ok := calls[key]; ok {
+		callsLock.Unlock()
+		coalesced.Add(1)
+		<-cl.done
+		return cl.res, false
+	}
+
+	cl := &call{done: make(chan struct{})}
+	calls[key] = cl
+	callsLock.Unlock()
+
+	resp := fasthttp.AcquireResponse()
+	err := fetch(u, nil, resp)
+	if err != nil {
+		cl.res = fetched{err: err}
+	} else {
+		cl.res = fetched{status: resp.StatusCode(), contentType: string(resp.Header.ContentType()), body: append([]byte(nil), resp.Body()...)}
+	}
+	fasthttp.ReleaseResponse(resp)
+
+	callsLock.Lock()
+	delete(calls, key)
+	callsLock.Unlock()
+	close(cl.done)
+
+	return cl.res, true
+}
@@ -0,0 +1,118 @@
+package proxystreams
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// direct links: one plain audio file per track, signed and expiring (cfg.DirectStreamTTL)
+// chromecast/airplay receivers fetch the url themselves, without cookies or hls.js, so it has to work on its own
+// progressive streams are passed through, hls ones get their mp3 segments glued together (like downloads)
+
+var directSecret []byte
+
+func initDirect() {
+	if cfg.Get().DirectStreamSecret != "" {
+		directSecret = []byte(cfg.Get().DirectStreamSecret)
+		return
+	}
+
+	if cfg.Get().ProxySecret != "" {
+		directSecret = []byte(cfg.Get().ProxySecret)
+		return
+	}
+
+	directSecret = make([]byte, 32)
+	_, err := rand.Read(directSecret)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func signDirect(id string, exp string) string {
+	mac := hmac.New(sha256.New, directSecret)
+	mac.Write([]byte(id + "|" + exp))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// absolute signed url for the track, base is the url of the instance
+func DirectURL(base string, id string) string {
+	exp := strconv.FormatInt(time.Now().Add(cfg.Get().DirectStreamTTL).Unix(), 10)
+	return base + "/_/proxy/streams/direct?id=" + url.QueryEscape(id) + "&exp=" + exp + "&sig=" + signDirect(id, exp)
+}
+
+func validDirect(id string, exp string, sig string) bool {
+	e, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > e {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(signDirect(id, exp)))
+}
+
+// the progressive mp3 transcoding, if the track has one
+func progressiveMP3(t sc.Track) *sc.Transcoding {
+	for _, tr := range t.Media.Transcodings {
+		if tr.Format.Protocol == sc.ProtocolProgressive && tr.Format.MimeType == "audio/mpeg" {
+			return &tr
+		}
+	}
+
+	return nil
+}
+
+func loadDirect(r fiber.Router) {
+	initDirect()
+
+	r.Get("/_/proxy/streams/direct", func(c *fiber.Ctx) error {
+		id := c.Query("id")
+		if id == "" || !validDirect(id, c.Query("exp"), c.Query("sig")) {
+			return fiber.ErrForbidden
+		}
+
+		t, err := sc.GetTrackByID(id)
+		if err != nil {
+			log.Printf("error getting %s (direct stream): %s\n", id, err)
+			return err
+		}
+
+		if tr := progressiveMP3(t); tr != nil {
+			stream, err := t.GetStreamFor(tr)
+			if err != nil {
+				log.Printf("error getting %s stream from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+				return err
+			}
+
+			// ranges (seeking on the receiver) work there
+			return c.Redirect(streamURL(stream) + "&t=" + url.QueryEscape(t.ID))
+		}
+
+		c.Set("Content-Type", "audio/mpeg")
+		if c.Method() == fiber.MethodHead {
+			return nil
+		}
+
+		ip := c.IP()
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			err := download.Track(t, bandwidth.Writer{W: w, IP: ip, Track: t.ID})
+			if err != nil {
+				log.Printf("error streaming %s from %s (direct stream): %s\n", t.Permalink, t.Author.Permalink, err)
+			}
+		})
+
+		return nil
+	})
+}
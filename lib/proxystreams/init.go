@@ -0,0 +1,392 @@
+package proxystreams
+
+import (
+	"bytes"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/bandwidth"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/signing"
+	"github.com/maid-zone/soundcloak/lib/tracing"
+	"github.com/valyala/fasthttp"
+)
+
+// Proxies HLS playlists/segments and progressive streams through the instance, so the browser never talks to soundcloud's cdn
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+}
+
+var cache *diskCache
+
+var inflight atomic.Int64
+
+// amount of proxy requests currently being served
+func InFlight() int64 {
+	return inflight.Load()
+}
+
+var contentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".opus": "audio/ogg",
+	".m4s":  "audio/mp4",
+	".mp4":  "audio/mp4",
+}
+
+type CacheInfo struct {
+	Enabled bool  `json:"enabled"`
+	Size    int64 `json:"size"`
+	Limit   int64 `json:"limit"`
+	Files   int   `json:"files"`
+}
+
+// status of the on-disk stream cache
+func CacheStatus() CacheInfo {
+	if cache == nil {
+		return CacheInfo{}
+	}
+
+	size, files := cache.Stats()
+	return CacheInfo{Enabled: true, Size: size, Limit: cache.limit, Files: files}
+}
+
+func FlushCache() {
+	if cache != nil {
+		cache.Flush()
+	}
+}
+
+// returns url of the proxied playlist for the stream returned by sc.Track.GetStream
+func PlaylistURL(stream string) string {
+	if stream == "" {
+		return ""
+	}
+
+	return "/_/proxy/streams/playlist?" + signing.Query(stream)
+}
+
+// PlaylistURL for hls streams, ProgressiveURL for everything else
+func URL(stream string) string {
+	if IsPlaylist(stream) {
+		return PlaylistURL(stream)
+	}
+
+	return ProgressiveURL(stream)
+}
+
+func IsPlaylist(stream string) bool {
+	u, err := url.Parse(stream)
+	return err == nil && strings.HasSuffix(u.Path, ".m3u8")
+}
+
+// same as PlaylistURL, but for progressive streams
+func ProgressiveURL(stream string) string {
+	if stream == "" {
+		return ""
+	}
+
+	return streamURL(stream)
+}
+
+func streamURL(u string) string {
+	return "/_/proxy/streams?" + signing.Query(u)
+}
+
+// tags the proxied url with the track id, for bandwidth accounting per track (lib/bandwidth)
+func ForTrack(proxied string, id string) string {
+	if proxied == "" || id == "" {
+		return proxied
+	}
+
+	return proxied + "&t=" + url.QueryEscape(id)
+}
+
+// starts playback of a proxied hls playlist at offset (EXT-X-START), for shared timestamps
+func WithStart(proxied string, offset time.Duration) string {
+	if proxied == "" || offset <= 0 || !strings.HasPrefix(proxied, "/_/proxy/streams/playlist?") {
+		return proxied
+	}
+
+	return proxied + "&start=" + strconv.Itoa(int(offset.Seconds()))
+}
+
+// params that have to be carried over from a playlist to its segments
+func carried(c *fiber.Ctx) string {
+	if t := c.Query("t"); t != "" {
+		return "&t=" + url.QueryEscape(t)
+	}
+
+	return ""
+}
+
+// only allow soundcloud cdn hosts, we are not an open proxy
+func parse(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fiber.ErrBadRequest
+	}
+
+	if !sc.IsMediaURL(u) {
+		return nil, fiber.ErrBadRequest
+	}
+
+	return u, nil
+}
+
+// rng is the client's Range header, forwarded as is (can be empty)
+func fetch(u string, rng []byte, resp *fasthttp.Response) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+	if len(rng) != 0 {
+		req.Header.SetBytesV("Range", rng)
+	}
+
+	span := tracing.StartClient("proxy.fetch")
+	err := httpc.Do(req, resp)
+	span.Error(err)
+	span.End()
+	return err
+}
+
+// rewrites segment (and init segment) urls inside of a hls playlist to point at the proxy
+// extra is appended to every url (check carried)
+func rewritePlaylist(data []byte, extra string) []byte {
+	var out bytes.Buffer
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) != 0 && line[0] != '#' {
+			out.WriteString(streamURL(string(line)) + extra)
+		} else if i := bytes.Index(line, []byte(`URI="`)); i != -1 {
+			end := bytes.IndexByte(line[i+5:], '"')
+			if end == -1 {
+				out.Write(line)
+			} else {
+				out.Write(line[:i+5])
+				out.WriteString(streamURL(string(line[i+5:i+5+end])) + extra)
+				out.Write(line[i+5+end:])
+			}
+		} else {
+			out.Write(line)
+		}
+		out.WriteByte('\n')
+	}
+
+	return bytes.TrimSuffix(out.Bytes(), []byte{'\n'})
+}
+
+// players begin with the segment containing the offset instead of the first one
+func startAt(playlist []byte, seconds int) []byte {
+	header, rest, ok := bytes.Cut(playlist, []byte{'\n'})
+	if !ok || !bytes.Equal(bytes.TrimSpace(header), []byte("#EXTM3U")) {
+		return playlist
+	}
+
+	res := make([]byte, 0, len(playlist)+64)
+	res = append(res, header...)
+	res = append(res, "\n#EXT-X-START:TIME-OFFSET="+strconv.Itoa(seconds)+",PRECISE=YES\n"...)
+	return append(res, rest...)
+}
+
+// returns the absolute stable url for a track, base is the url of the instance
+func TrackURL(base string, id string) string {
+	return base + "/_/proxy/streams/track?id=" + url.QueryEscape(id)
+}
+
+func servePlaylist(c *fiber.Ctx, u string) error {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := fetch(u, nil, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return c.SendStatus(resp.StatusCode())
+	}
+
+	playlist := rewritePlaylist(resp.Body(), carried(c))
+	if start := c.QueryInt("start"); start > 0 {
+		playlist = startAt(playlist, start)
+	}
+
+	c.Set("Content-Type", "application/vnd.apple.mpegurl")
+	return c.Send(playlist)
+}
+
+func Load(r fiber.Router) {
+	if cfg.Get().StreamCacheSize != 0 {
+		var err error
+		cache, err = newDiskCache(cfg.Get().StreamCacheDir, cfg.Get().StreamCacheSize)
+		if err != nil {
+			log.Printf("failed to initialize stream cache, continuing without it: %s\n", err)
+		}
+	}
+
+	r.Use("/_/proxy/streams", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableStreamProxy {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}, bandwidth.Quota, func(c *fiber.Ctx) error {
+		inflight.Add(1)
+		defer inflight.Add(-1)
+
+		err := c.Next()
+		resp := c.Response()
+		var n int64
+		if resp.IsBodyStream() { // cached files, reading the body would read the whole file
+			n = int64(resp.Header.ContentLength())
+		} else {
+			n = int64(len(resp.Body()))
+		}
+		bandwidth.Add(c.IP(), c.Query("t"), n)
+
+		return err
+	})
+
+	loadPreview(r)
+	loadDirect(r)
+
+	r.Get("/_/proxy/streams/playlist", func(c *fiber.Ctx) error {
+		if !signing.Valid(c) {
+			return fiber.ErrForbidden
+		}
+
+		u, err := parse(c.Query("url"))
+		if err != nil {
+			return err
+		}
+
+		return servePlaylist(c, u.String())
+	})
+
+	// stable url for a track (the stream urls expire), used in exported playlists
+	r.Get("/_/proxy/streams/track", func(c *fiber.Ctx) error {
+		id := c.Query("id")
+		if id == "" {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetArbitraryTrack(id)
+		if err != nil {
+			log.Printf("error getting %s (stream proxy): %s\n", id, err)
+			return err
+		}
+
+		stream, err := t.GetStream()
+		if err != nil {
+			log.Printf("error getting %s stream from %s: %s\n", t.Permalink, t.Author.Permalink, err)
+			return err
+		}
+
+		c.Request().URI().QueryArgs().Set("t", t.ID) // carried over to the segments
+		if !IsPlaylist(stream) {
+			return c.Redirect(streamURL(stream) + carried(c))
+		}
+
+		return servePlaylist(c, stream)
+	})
+
+	r.Get("/_/proxy/streams", func(c *fiber.Ctx) error {
+		if !signing.Valid(c) {
+			return fiber.ErrForbidden
+		}
+
+		u, err := parse(c.Query("url"))
+		if err != nil {
+			return err
+		}
+
+		// the query contains a signature which changes every time, the path does not
+		key := u.Host + u.Path
+		span := tracing.Start("proxy.segment", "segment", key, "range", string(c.Request().Header.Peek("Range")))
+		defer span.End()
+		ct, ok := contentTypes[strings.ToLower(path.Ext(u.Path))]
+		if !ok {
+			ct = "application/octet-stream"
+		}
+
+		c.Set("Accept-Ranges", "bytes")
+		rng := c.Request().Header.Peek("Range")
+
+		if cache != nil {
+			if p, ok := cache.Get(key); ok {
+				span.Set("cache.hit", "true")
+				err := serveFile(c, p, ct, string(rng))
+				if err != errEvicted {
+					return err
+				}
+				// evicted in the meantime, just fetch it again
+			}
+		}
+
+		if len(rng) == 0 {
+			res, leader := fetchShared(key, u.String())
+			span.Set("coalesced", strconv.FormatBool(!leader))
+			if res.err != nil {
+				span.Error(res.err)
+				return res.err
+			}
+
+			if res.status != 200 {
+				return c.SendStatus(res.status)
+			}
+
+			if leader && cache != nil {
+				cache.Put(key, res.body)
+			}
+
+			if res.contentType != "" {
+				ct = res.contentType
+			}
+
+			c.Set("Content-Type", ct)
+			c.Response().SetBody(res.body) // copy, the body is shared
+			return nil
+		}
+
+		// ranges are different for everyone, no point in coalescing them
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+
+		err = fetch(u.String(), rng, resp)
+		if err != nil {
+			span.Error(err)
+			return err
+		}
+
+		switch resp.StatusCode() {
+		case 200:
+			// only cache full responses
+			if cache != nil {
+				cache.Put(key, resp.Body())
+			}
+		case 206:
+			c.Status(206)
+			c.Set("Content-Range", string(resp.Header.Peek("Content-Range")))
+		default:
+			return c.SendStatus(resp.StatusCode())
+		}
+
+		if t := resp.Header.ContentType(); len(t) != 0 {
+			ct = string(t)
+		}
+
+		c.Set("Content-Type", ct)
+		c.Response().SetBody(resp.Body()) // copy, resp is released after this
+		return nil
+	})
+}
@@ -0,0 +1,197 @@
+package proxystreams
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// previews: only the first cfg.PreviewSeconds of a track, for hover/press previews in listings
+// hls: the init segment (if there is one) and the first segments glued together, progressive: a byte range from the start
+// either way it's a plain file the browser plays by itself, without hls.js or a stream session
+
+// 320 kbps, the most a progressive stream has. the file is cut off somewhere anyway
+const progressiveBytesPerSecond = 40000
+
+func PreviewsEnabled() bool {
+	return cfg.Get().PreviewSeconds != 0 && cfg.Get().Features.EnableStreamProxy
+}
+
+// "" when previews are turned off
+func PreviewURL(id string) string {
+	if !PreviewsEnabled() {
+		return ""
+	}
+
+	return "/_/proxy/streams/preview?t=" + url.QueryEscape(id)
+}
+
+func contentType(u string) string {
+	if p, err := url.Parse(u); err == nil {
+		if ct, ok := contentTypes[strings.ToLower(path.Ext(p.Path))]; ok {
+			return ct
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+// urls of the init segment and the segments covering the first seconds
+func previewParts(playlist []byte, seconds float64) []string {
+	var parts []string
+	var total float64
+	for _, line := range bytes.Split(playlist, []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		switch {
+		case bytes.HasPrefix(line, []byte("#EXT-X-MAP:")):
+			if i := bytes.Index(line, []byte(`URI="`)); i != -1 {
+				if end := bytes.IndexByte(line[i+5:], '"'); end != -1 {
+					parts = append(parts, string(line[i+5:i+5+end]))
+				}
+			}
+		case bytes.HasPrefix(line, []byte("#EXTINF:")):
+			d, _, _ := strings.Cut(string(line[8:]), ",")
+			if v, err := strconv.ParseFloat(d, 64); err == nil {
+				total += v
+			}
+		case len(line) != 0 && line[0] != '#':
+			parts = append(parts, string(line))
+			if total >= seconds {
+				return parts
+			}
+		}
+	}
+
+	return parts
+}
+
+func hlsPreview(stream string, seconds float64) ([]byte, string, error) {
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := fetch(stream, nil, resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, "", fmt.Errorf("preview playlist: got status code %d", resp.StatusCode())
+	}
+
+	var body []byte
+	var ct string
+	for _, part := range previewParts(resp.Body(), seconds) {
+		u, err := parse(part)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// same keys as the segment proxy, so previews and playback share the cache
+		key := u.Host + u.Path
+		if cache != nil {
+			if p, ok := cache.Get(key); ok {
+				if data, err := os.ReadFile(p); err == nil { // could be evicted in the meantime
+					body = append(body, data...)
+					ct = contentType(part)
+					continue
+				}
+			}
+		}
+
+		res, leader := fetchShared(key, u.String())
+		if res.err != nil {
+			return nil, "", res.err
+		}
+
+		if res.status != 200 {
+			return nil, "", fmt.Errorf("preview segment: got status code %d", res.status)
+		}
+
+		if leader && cache != nil {
+			cache.Put(key, res.body)
+		}
+
+		body = append(body, res.body...)
+		ct = contentType(part)
+	}
+
+	return body, ct, nil
+}
+
+func progressivePreview(stream string, seconds float64) ([]byte, string, error) {
+	u, err := parse(stream)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = fetch(u.String(), []byte("bytes=0-"+strconv.Itoa(int(seconds*progressiveBytesPerSecond)-1)), resp)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if resp.StatusCode() != 200 && resp.StatusCode() != 206 {
+		return nil, "", fmt.Errorf("preview: got status code %d", resp.StatusCode())
+	}
+
+	ct := string(resp.Header.ContentType())
+	if ct == "" {
+		ct = contentType(stream)
+	}
+
+	return append([]byte(nil), resp.Body()...), ct, nil
+}
+
+func loadPreview(r fiber.Router) {
+	// t is the track id, named like everywhere else in the proxy for bandwidth accounting
+	r.Get("/_/proxy/streams/preview", func(c *fiber.Ctx) error {
+		if cfg.Get().PreviewSeconds == 0 {
+			return fiber.ErrNotFound
+		}
+
+		id := c.Query("t")
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetTrackByID(id)
+		if err != nil {
+			log.Printf("error getting %s (preview): %s\n", id, err)
+			return err
+		}
+
+		stream, err := t.GetStream()
+		if err != nil {
+			log.Printf("error getting %s stream (preview): %s\n", id, err)
+			return err
+		}
+
+		var body []byte
+		var ct string
+		if IsPlaylist(stream) {
+			body, ct, err = hlsPreview(stream, float64(cfg.Get().PreviewSeconds))
+		} else {
+			body, ct, err = progressivePreview(stream, float64(cfg.Get().PreviewSeconds))
+		}
+
+		if err != nil {
+			log.Printf("error getting %s preview: %s\n", id, err)
+			return err
+		}
+
+		c.Set("Content-Type", ct)
+		return c.Send(body)
+	})
+}
@@ -0,0 +1,115 @@
+package proxystreams
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var errEvicted = errors.New("cached file evicted")
+
+type rangeResult uint8
+
+const (
+	rangeIgnored       rangeResult = iota // no range/unsupported range, serve the whole file (allowed by the spec)
+	rangeOK                               // serve start-end
+	rangeUnsatisfiable                    // respond with 416
+)
+
+// parses a single "bytes=" range against a file of the given size, multiple ranges are not supported
+func parseRange(header string, size int64) (start int64, end int64, res rangeResult) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.IndexByte(spec, ',') != -1 {
+		return 0, 0, rangeIgnored
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, rangeIgnored
+	}
+
+	if first == "" { // suffix range, last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, rangeIgnored
+		}
+
+		if n <= 0 || size == 0 {
+			return 0, 0, rangeUnsatisfiable
+		}
+
+		if n > size {
+			n = size
+		}
+
+		return size - n, size - 1, rangeOK
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, rangeIgnored
+	}
+
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, rangeIgnored
+		}
+
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size {
+		return 0, 0, rangeUnsatisfiable
+	}
+
+	return start, end, rangeOK
+}
+
+// serves a cached file, honoring the Range header
+func serveFile(c *fiber.Ctx, p string, ct string, rng string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return errEvicted
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	c.Set("Content-Type", ct)
+
+	start, end, res := parseRange(rng, size)
+	switch res {
+	case rangeIgnored:
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		return c.Send(data)
+	case rangeUnsatisfiable:
+		c.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+
+	data := make([]byte, end-start+1)
+	_, err = f.ReadAt(data, start)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	c.Status(fiber.StatusPartialContent)
+	c.Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10))
+	return c.Send(data)
+}
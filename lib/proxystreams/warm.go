@@ -0,0 +1,122 @@
+package proxystreams
+
+import (
+	"bytes"
+	"net/url"
+	"sync"
+
+	"github.com/maid-zone/soundcloak/lib/events"
+	"github.com/valyala/fasthttp"
+)
+
+// warming the stream cache before a track is played (used for the next track in a queue), so it starts instantly
+// progress is published as "buffered" events to "warm:<track id>", check WarmTopic and WarmStatus
+
+// amount of segments to warm per track, hls segments are a few seconds long
+const warmSegments = 3
+
+// don't let preload requests pile up
+var warmers = make(chan struct{}, 4)
+
+type WarmProgress struct {
+	Segments int `json:"segments"` // cached so far
+	Total    int `json:"total"`
+}
+
+var warming = map[string]WarmProgress{}
+var warmingLock = &sync.Mutex{}
+
+func WarmTopic(id string) string {
+	return "warm:" + id
+}
+
+// false when the track isn't being warmed (anymore)
+func WarmStatus(id string) (WarmProgress, bool) {
+	warmingLock.Lock()
+	defer warmingLock.Unlock()
+
+	p, ok := warming[id]
+	return p, ok
+}
+
+func setWarming(id string, p WarmProgress) {
+	warmingLock.Lock()
+	warming[id] = p
+	warmingLock.Unlock()
+
+	events.Publish(WarmTopic(id), "buffered", p)
+}
+
+func warm(stream string, id string) {
+	defer func() {
+		warmingLock.Lock()
+		delete(warming, id)
+		warmingLock.Unlock()
+
+		events.Publish(WarmTopic(id), "done", nil)
+		events.Close(WarmTopic(id))
+	}()
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := fetch(stream, nil, resp)
+	if err != nil || resp.StatusCode() != 200 {
+		return
+	}
+
+	segments := []string{}
+	for _, line := range bytes.Split(resp.Body(), []byte{'\n'}) {
+		line = bytes.TrimSpace(line)
+		if len(line) != 0 && line[0] != '#' {
+			segments = append(segments, string(line))
+			if len(segments) == warmSegments {
+				break
+			}
+		}
+	}
+
+	setWarming(id, WarmProgress{Total: len(segments)})
+	for i, s := range segments {
+		u, err := url.Parse(s)
+		if err != nil {
+			return
+		}
+
+		key := u.Host + u.Path
+		if _, ok := cache.Get(key); ok {
+			setWarming(id, WarmProgress{Segments: i + 1, Total: len(segments)})
+			continue
+		}
+
+		res, leader := fetchShared(key, s)
+		if res.err != nil || res.status != 200 {
+			return
+		}
+
+		if leader {
+			cache.Put(key, res.body)
+		}
+		setWarming(id, WarmProgress{Segments: i + 1, Total: len(segments)})
+	}
+}
+
+// fetches the first segments of a hls stream into the cache in the background, does nothing without a cache (or when too many are already running)
+func Warm(stream string, id string) {
+	if cache == nil || !IsPlaylist(stream) {
+		return
+	}
+
+	select {
+	case warmers <- struct{}{}:
+	default:
+		return
+	}
+
+	// before returning, so a client subscribing right after sees it
+	setWarming(id, WarmProgress{})
+	go func() {
+		defer func() { <-warmers }()
+		warm(stream, id)
+	}()
+}
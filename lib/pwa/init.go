@@ -0,0 +1,68 @@
+package pwa
+
+import (
+	_ "embed"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Progressive web app: manifest, service worker (app shell + offline tracks) and the offline page
+
+//go:embed sw.js
+var sw string
+
+type icon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes"`
+	Type  string `json:"type"`
+}
+
+type manifest struct {
+	Name            string `json:"name"`
+	ShortName       string `json:"short_name"`
+	StartURL        string `json:"start_url"`
+	Scope           string `json:"scope"`
+	Display         string `json:"display"`
+	BackgroundColor string `json:"background_color"`
+	ThemeColor      string `json:"theme_color"`
+	Icons           []icon `json:"icons"`
+}
+
+func Load(r fiber.Router) {
+	// new version = new shell cache, old one gets deleted when the new worker activates
+	script := strings.Replace(sw, "{{version}}", cfg.Version, 1)
+
+	r.Get("/sw.js", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/javascript; charset=utf-8")
+		c.Set("Cache-Control", "no-cache")
+		return c.SendString(script)
+	})
+
+	r.Get("/manifest.webmanifest", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "application/manifest+json")
+		data, err := cfg.JSON.Marshal(manifest{
+			Name:            "tunes.floppa.nl",
+			ShortName:       "tunes",
+			StartURL:        "/",
+			Scope:           "/",
+			Display:         "standalone",
+			BackgroundColor: "#151515",
+			ThemeColor:      "#151515",
+			Icons:           []icon{{Src: "/icon.svg", Sizes: "any", Type: "image/svg+xml"}},
+		})
+		if err != nil {
+			return err
+		}
+
+		return c.Send(data)
+	})
+
+	r.Get("/offline", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/html")
+		return templates.Base("offline", templates.Offline(), nil).Render(preferences.Context(c), c)
+	})
+}
@@ -0,0 +1,72 @@
+// service worker: cache-first app shell, network-first pages with an offline fallback, and tracks saved for offline listening
+const version = "{{version}}"; // filled in by lib/pwa
+const SHELL = "shell-" + version;
+const PAGES = "pages";
+const OFFLINE = "offline-tracks"; // filled by the "save offline" button on track pages, kept across versions
+
+const shell = ["/", "/offline", "/global.css", "/normalize.css", "/fixed.ttf", "/track.js", "/player.js", "/offline.js", "/register.js", "/placeholder.jpg", "/icon.svg", "/favicon.ico"];
+
+// amount of visited pages to keep for offline use
+const maxPages = 50;
+
+self.addEventListener("install", (e) => {
+  e.waitUntil(caches.open(SHELL).then((c) => c.addAll(shell)).then(() => self.skipWaiting()));
+});
+
+self.addEventListener("activate", (e) => {
+  e.waitUntil(
+    caches
+      .keys()
+      .then((keys) => Promise.all(keys.filter((k) => k.startsWith("shell-") && k !== SHELL).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+async function trimPages() {
+  const c = await caches.open(PAGES);
+  const keys = await c.keys();
+  for (let i = 0; i < keys.length - maxPages; i++) {
+    await c.delete(keys[i]);
+  }
+}
+
+async function page(req) {
+  try {
+    const resp = await fetch(req);
+    if (resp.ok) {
+      const c = await caches.open(PAGES);
+      await c.put(req, resp.clone());
+      trimPages();
+    }
+    return resp;
+  } catch {
+    return (await caches.match(req)) || (await caches.match("/offline"));
+  }
+}
+
+self.addEventListener("fetch", (e) => {
+  const req = e.request;
+  const url = new URL(req.url);
+  if (req.method !== "GET" || url.origin !== location.origin) {
+    return;
+  }
+
+  if (url.pathname.startsWith("/_/offline/")) {
+    e.respondWith(caches.open(OFFLINE).then((c) => c.match(req, { ignoreSearch: true })).then((r) => r || new Response("not saved", { status: 404 })));
+    return;
+  }
+
+  // never cache streams, api responses etc
+  if (url.pathname.startsWith("/_/")) {
+    return;
+  }
+
+  if (shell.includes(url.pathname) && url.pathname !== "/" && url.pathname !== "/offline") {
+    e.respondWith(caches.match(req).then((r) => r || fetch(req)));
+    return;
+  }
+
+  if (req.mode === "navigate") {
+    e.respondWith(page(req));
+  }
+});
@@ -0,0 +1,454 @@
+package qr
+
+import (
+	"errors"
+)
+
+// QR code encoder: byte mode, error correction level M, versions 1 to 10 (up to 213 bytes, plenty for links)
+
+var ErrTooLong = errors.New("too long for a qr code")
+
+// per version: error correction codewords per block, then (blocks, data codewords per block) of both groups
+var versions = [...]struct {
+	ec     int
+	groups [2][2]int
+}{
+	{10, [2][2]int{{1, 16}}},
+	{16, [2][2]int{{1, 28}}},
+	{26, [2][2]int{{1, 44}}},
+	{18, [2][2]int{{2, 32}}},
+	{24, [2][2]int{{2, 43}}},
+	{16, [2][2]int{{4, 27}}},
+	{18, [2][2]int{{4, 31}}},
+	{22, [2][2]int{{2, 38}, {2, 39}}},
+	{22, [2][2]int{{3, 36}, {2, 37}}},
+	{26, [2][2]int{{4, 43}, {1, 44}}},
+}
+
+// centers of the alignment patterns, per version
+var alignment = [...][]int{
+	nil,
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+type Code struct {
+	Size     int
+	modules  []bool
+	function []bool // finder/timing/alignment patterns and format/version info, masks leave them alone
+}
+
+// true for dark modules, anything outside is light (the quiet zone)
+func (c *Code) Dark(x int, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+
+	return c.modules[y*c.Size+x]
+}
+
+func (c *Code) set(x int, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+func dataCodewords(v int) int {
+	n := 0
+	for _, g := range versions[v-1].groups {
+		n += g[0] * g[1]
+	}
+
+	return n
+}
+
+// bits of the character count for byte mode
+func countBits(v int) int {
+	if v < 10 {
+		return 8
+	}
+
+	return 16
+}
+
+type bitBuffer struct {
+	data []byte
+	n    int
+}
+
+func (b *bitBuffer) write(v int, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if v>>i&1 == 1 {
+			b.data[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+func Encode(data []byte) (*Code, error) {
+	v := 0
+	for i := 1; i <= len(versions); i++ {
+		if 4+countBits(i)+len(data)*8 <= dataCodewords(i)*8 {
+			v = i
+			break
+		}
+	}
+
+	if v == 0 {
+		return nil, ErrTooLong
+	}
+
+	capacity := dataCodewords(v) * 8
+	var b bitBuffer
+	b.write(0b0100, 4)
+	b.write(len(data), countBits(v))
+	for _, d := range data {
+		b.write(int(d), 8)
+	}
+
+	b.write(0, min(4, capacity-b.n)) // terminator
+	b.write(0, (8-b.n%8)%8)
+	for pad := 0xEC; b.n < capacity; pad ^= 0xEC ^ 0x11 {
+		b.write(pad, 8)
+	}
+
+	c := &Code{Size: 17 + 4*v}
+	c.modules = make([]bool, c.Size*c.Size)
+	c.function = make([]bool, c.Size*c.Size)
+	c.drawFunctionPatterns(v)
+	c.drawCodewords(interleave(v, b.data))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); bestPenalty == -1 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		c.applyMask(mask) // xor, this undoes it
+	}
+
+	c.applyMask(best)
+	c.drawFormat(best)
+	return c, nil
+}
+
+// splits the data into blocks, adds error correction to each and interleaves them
+func interleave(v int, data []byte) []byte {
+	info := versions[v-1]
+	divisor := rsDivisor(info.ec)
+
+	var blocks, ecs [][]byte
+	for _, g := range info.groups {
+		for i := 0; i < g[0]; i++ {
+			blocks = append(blocks, data[:g[1]])
+			ecs = append(ecs, rsRemainder(data[:g[1]], divisor))
+			data = data[g[1]:]
+		}
+	}
+
+	var res []byte
+	for i := 0; ; i++ {
+		added := false
+		for _, b := range blocks {
+			if i < len(b) {
+				res = append(res, b[i])
+				added = true
+			}
+		}
+
+		if !added {
+			break
+		}
+	}
+
+	for i := 0; i < info.ec; i++ {
+		for _, e := range ecs {
+			res = append(res, e[i])
+		}
+	}
+
+	return res
+}
+
+func (c *Code) drawFunctionPatterns(v int) {
+	for i := 0; i < c.Size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	pos := alignment[v-1]
+	for i, x := range pos {
+		for j, y := range pos {
+			// not on top of the finders
+			if (i == 0 && j == 0) || (i == 0 && j == len(pos)-1) || (i == len(pos)-1 && j == 0) {
+				continue
+			}
+
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserved here, the real values come with the mask
+	c.drawFormat(0)
+
+	if v >= 7 {
+		rem := v
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+
+		bits := v<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := c.Size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+}
+
+// finder pattern with its separator, centered on x, y
+func (c *Code) drawFinder(x int, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+
+			d := max(abs(dx), abs(dy))
+			c.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// format info (level M and the mask) next to the finders, and the dark module
+func (c *Code) drawFormat(mask int) {
+	data := 0b00<<3 | mask // 00 is level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return bits>>i&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+// zigzags through the non-function modules from the bottom right, two columns at a time
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+
+				if !c.function[y*c.Size+x] && i < len(data)*8 {
+					c.modules[y*c.Size+x] = data[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+				// the remainder bits stay light
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !c.function[y*c.Size+x] {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+// lower is better: long runs, 2x2 blocks, things looking like finders and too much of one color
+func (c *Code) penalty() int {
+	p := 0
+	line := make([]bool, c.Size)
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			for b := 0; b < c.Size; b++ {
+				if vertical {
+					line[b] = c.Dark(a, b)
+				} else {
+					line[b] = c.Dark(b, a)
+				}
+			}
+
+			run := 1
+			for b := 1; b <= c.Size; b++ {
+				if b < c.Size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					p += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for b := 0; b+11 <= c.Size; b++ {
+				if finderLike(line[b:b+11], false) || finderLike(line[b:b+11], true) {
+					p += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				dark++
+			}
+
+			if x+1 < c.Size && y+1 < c.Size {
+				d := c.Dark(x, y)
+				if c.Dark(x+1, y) == d && c.Dark(x, y+1) == d && c.Dark(x+1, y+1) == d {
+					p += 3
+				}
+			}
+		}
+	}
+
+	percent := dark * 100 / (c.Size * c.Size)
+	return p + abs(percent-50)/5*10
+}
+
+// 1011101 with four light modules after (or before, reversed)
+func finderLike(s []bool, reversed bool) bool {
+	const pattern = "10111010000"
+	for i := range s {
+		want := pattern[i]
+		if reversed {
+			want = pattern[len(pattern)-1-i]
+		}
+
+		if s[i] != (want == '1') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// reed-solomon over GF(2^8) with the polynomial 0x11D
+
+func gfMul(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+// generator polynomial of the given degree, highest coefficient (always 1) left out
+func rsDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range res {
+			res[j] = gfMul(res[j], root)
+			if j+1 < len(res) {
+				res[j] ^= res[j+1]
+			}
+		}
+
+		root = gfMul(root, 0x02)
+	}
+
+	return res
+}
+
+func rsRemainder(data []byte, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i, coef := range divisor {
+			res[i] ^= gfMul(coef, factor)
+		}
+	}
+
+	return res
+}
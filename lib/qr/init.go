@@ -0,0 +1,84 @@
+package qr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/export"
+)
+
+// /_/qr?url=...: qr codes for links to this instance (shown next to short links), so a page can be opened on a phone
+// only for our own urls, this isn't a qr code service for everyone
+
+// light modules around the code, the spec wants 4
+const quietZone = 4
+
+// pixels per module in png
+const scale = 8
+
+func SVG(c *Code) []byte {
+	size := strconv.Itoa(c.Size + 2*quietZone)
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 ` + size + ` ` + size + `" shape-rendering="crispEdges"><rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				b.WriteString("M" + strconv.Itoa(x+quietZone) + " " + strconv.Itoa(y+quietZone) + "h1v1h-1z")
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+
+	return []byte(b.String())
+}
+
+func PNG(c *Code) ([]byte, error) {
+	size := (c.Size + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if c.Dark(x/scale-quietZone, y/scale-quietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
+
+func Load(r fiber.Router) {
+	r.Get("/_/qr", func(c *fiber.Ctx) error {
+		u := c.Query("url")
+		base := export.BaseURL(c)
+		if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+			u = base + u
+		} else if u != base && !strings.HasPrefix(u, base+"/") {
+			return fiber.ErrBadRequest
+		}
+
+		code, err := Encode([]byte(u))
+		if err != nil {
+			return fiber.ErrRequestURITooLong
+		}
+
+		if c.Query("format") == "png" {
+			data, err := PNG(code)
+			if err != nil {
+				return err
+			}
+
+			c.Set("Content-Type", "image/png")
+			return c.Send(data)
+		}
+
+		c.Set("Content-Type", "image/svg+xml")
+		return c.Send(SVG(code))
+	})
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Per-ip request limits for endpoints which store something on the instance (short links, playlist imports)
+// requests are counted per hour, every limiter on its own. with prefork every process counts separately
+
+type Limiter struct {
+	limit func(c *cfg.Config) int
+
+	lock   sync.Mutex
+	hour   int64
+	counts map[string]int
+}
+
+// limit is read on every request, 0 or less means no limit
+func New(limit func(c *cfg.Config) int) *Limiter {
+	return &Limiter{limit: limit, counts: map[string]int{}}
+}
+
+// counts the request, false if ip went over the limit this hour
+func (l *Limiter) Allow(ip string) bool {
+	max := l.limit(cfg.Get())
+	if max <= 0 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if h := time.Now().Unix() / 3600; h != l.hour {
+		l.hour = h
+		clear(l.counts)
+	}
+
+	if l.counts[ip] >= max {
+		return false
+	}
+
+	l.counts[ip]++
+	return true
+}
+
+// middleware, rejects requests over the limit with 429
+func (l *Limiter) Handler(c *fiber.Ctx) error {
+	if !l.Allow(c.IP()) {
+		c.Set("Retry-After", strconv.FormatInt(3600-time.Now().Unix()%3600, 10))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many requests, try again later")
+	}
+
+	return c.Next()
+}
+
+// shared by everything which writes files to the data dir (cfg.WritesPerHour)
+var Writes = New(func(c *cfg.Config) int { return c.WritesPerHour })
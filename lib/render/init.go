@@ -0,0 +1,121 @@
+package render
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rendering of user-provided text (descriptions) into safe html
+
+// links, @mentions and #hashtags
+var tokenRegex = regexp.MustCompile(`https?://[^\s<>"]+|@[A-Za-z0-9_-]+|#[\p{L}\p{N}_]+`)
+var paragraphRegex = regexp.MustCompile(`\n\s*\n`)
+
+// rewrites soundcloud links to point at the instance, returns u as is for other links
+func Rewrite(u string) (res string, local bool) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u, false
+	}
+
+	switch strings.ToLower(parsed.Host) {
+	case "soundcloud.com", "www.soundcloud.com", "m.soundcloud.com":
+		if parsed.Path == "" {
+			return "/", true
+		}
+
+		// soundcloud.com//example.com would be a protocol-relative link to example.com (browsers read /\ the same way)
+		if strings.HasPrefix(parsed.Path, "//") || strings.HasPrefix(parsed.Path, "/\\") {
+			return u, false
+		}
+
+		return parsed.Path, true
+	case "on.soundcloud.com":
+		return "/on" + parsed.Path, true
+	}
+
+	return u, false
+}
+
+// trailing punctuation is most likely not a part of the link ("check out https://example.com!")
+func trimURL(u string) (string, string) {
+	end := len(u)
+	for end > 0 && strings.ContainsRune(".,!?:;)]}'", rune(u[end-1])) {
+		// keep closing parens if the link has a matching opening one, like wikipedia links
+		if u[end-1] == ')' && strings.Count(u[:end], "(") >= strings.Count(u[:end], ")") {
+			break
+		}
+		end--
+	}
+
+	return u[:end], u[end:]
+}
+
+func link(href string, text string, local bool) string {
+	if local {
+		return `<a class="link" href="` + html.EscapeString(href) + `">` + html.EscapeString(text) + `</a>`
+	}
+
+	return `<a class="link" href="` + html.EscapeString(href) + `" rel="nofollow noreferrer" target="_blank">` + html.EscapeString(text) + `</a>`
+}
+
+func renderLine(line string, b *strings.Builder) {
+	last := 0
+	for _, m := range tokenRegex.FindAllStringIndex(line, -1) {
+		token := line[m[0]:m[1]]
+
+		// mentions/hashtags glued to a word are most likely not mentions/hashtags (emails, anchors)
+		if token[0] != 'h' && m[0] != 0 {
+			prev, _ := utf8.DecodeLastRuneInString(line[:m[0]])
+			if unicode.IsLetter(prev) || unicode.IsDigit(prev) || prev == '&' {
+				continue
+			}
+		}
+
+		b.WriteString(html.EscapeString(line[last:m[0]]))
+		last = m[1]
+
+		switch token[0] {
+		case '@':
+			name := strings.TrimRight(token[1:], "-_")
+			b.WriteString(link("/"+name, "@"+name, true))
+			b.WriteString(html.EscapeString(token[1+len(name):]))
+		case '#':
+			b.WriteString(link("/tags/"+url.PathEscape(token[1:]), token, true))
+		default:
+			u, rest := trimURL(token)
+			href, local := Rewrite(u)
+			text := u
+			if local {
+				text = strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://")
+			}
+
+			b.WriteString(link(href, text, local))
+			b.WriteString(html.EscapeString(rest))
+		}
+	}
+
+	b.WriteString(html.EscapeString(line[last:]))
+}
+
+// renders a description into paragraphs, with clickable links, @mentions (to local user pages) and #hashtags (to local tag pages)
+func Description(text string) string {
+	var b strings.Builder
+	for _, para := range paragraphRegex.Split(strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n")), -1) {
+		b.WriteString("<p>")
+		for i, line := range strings.Split(para, "\n") {
+			if i != 0 {
+				b.WriteString("<br/>")
+			}
+
+			renderLine(line, &b)
+		}
+		b.WriteString("</p>")
+	}
+
+	return b.String()
+}
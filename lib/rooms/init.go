@@ -0,0 +1,357 @@
+package rooms
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/proxyimages"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Listen together: the host of a room controls a queue, everyone in it plays the same track at the same position
+// the server only relays player events over a websocket (/rooms/:id/ws), audio goes through the stream proxy as usual
+// rooms live in memory, so they can't be used with prefork (cfg validation rejects it)
+
+var ErrQueueFull = errors.New("room queue is full")
+
+const maxQueue = 200
+
+type Track struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	Artwork string `json:"artwork"`
+	Stream  string `json:"stream"`
+	HLS     bool   `json:"hls"`
+}
+
+type Room struct {
+	sync.Mutex
+	ID string
+
+	host     string // secret, the host has it in a cookie
+	queue    []Track
+	index    int
+	playing  bool
+	position float64 // seconds, at updated
+	updated  time.Time
+	members  map[*wsConn]chan []byte
+	empty    time.Time // when the last member left
+}
+
+// sent to everyone after every change
+type State struct {
+	Type     string  `json:"type"` // "state"
+	Queue    []Track `json:"queue"`
+	Index    int     `json:"index"`
+	Playing  bool    `json:"playing"`
+	Position float64 `json:"position"`
+	At       int64   `json:"at"` // unix ms, position is from this moment
+	Members  int     `json:"members"`
+}
+
+// from the host
+type message struct {
+	Type     string  `json:"type"` // play, pause, seek, track (jump to index) or add (a track url or id)
+	Position float64 `json:"position"`
+	Index    int     `json:"index"`
+	Track    string  `json:"track"`
+}
+
+var rooms = map[string]*Room{}
+var roomsLock = &sync.RWMutex{}
+
+func newID() string {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func cookie(id string) string {
+	return "room_" + id
+}
+
+func resolve(s string) (Track, error) {
+	t, err := sc.GetArbitraryTrack(s)
+	if err != nil {
+		return Track{}, err
+	}
+
+	tr := t.PreferredStream()
+	return Track{
+		ID:      t.ID,
+		Title:   t.Title,
+		Artist:  t.Author.Username,
+		Artwork: proxyimages.URL(t.Artwork),
+		Stream:  "/_/proxy/streams/track?id=" + t.ID, // stable, the stream urls expire
+		HLS:     tr != nil && tr.Format.Protocol == sc.ProtocolHLS,
+	}, nil
+}
+
+func Create(first Track) *Room {
+	r := &Room{ID: newID(), host: newID(), queue: []Track{first}, updated: time.Now(), members: map[*wsConn]chan []byte{}, empty: time.Now()}
+
+	roomsLock.Lock()
+	rooms[r.ID] = r
+	roomsLock.Unlock()
+
+	return r
+}
+
+// id of the track the room is on, false when there is no such room
+func Current(id string) (track string, playing bool, ok bool) {
+	r, ok := Get(id)
+	if !ok {
+		return "", false, false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	return r.queue[r.index].ID, r.playing, true
+}
+
+func Get(id string) (*Room, bool) {
+	roomsLock.RLock()
+	defer roomsLock.RUnlock()
+
+	r, ok := rooms[id]
+	return r, ok
+}
+
+// room must be locked
+func (r *Room) current() float64 {
+	if !r.playing {
+		return r.position
+	}
+
+	return r.position + time.Since(r.updated).Seconds()
+}
+
+// room must be locked
+func (r *Room) state() State {
+	return State{Type: "state", Queue: r.queue, Index: r.index, Playing: r.playing, Position: r.current(), At: time.Now().UnixMilli(), Members: len(r.members)}
+}
+
+// room must be locked
+func (r *Room) broadcast() {
+	data, err := cfg.JSON.Marshal(r.state())
+	if err != nil {
+		log.Printf("error encoding room %s state: %s\n", r.ID, err)
+		return
+	}
+
+	// every state replaces the previous one, so a slow member only gets the latest instead of holding up the room
+	for _, send := range r.members {
+		select {
+		case <-send:
+		default:
+		}
+		send <- data
+	}
+}
+
+// room must be locked
+func (r *Room) apply(m message) {
+	r.position = r.current()
+	r.updated = time.Now()
+	switch m.Type {
+	case "play":
+		r.playing = true
+		r.position = m.Position
+	case "pause":
+		r.playing = false
+		r.position = m.Position
+	case "seek":
+		r.position = m.Position
+	case "track":
+		if m.Index >= 0 && m.Index < len(r.queue) {
+			r.index = m.Index
+			r.position = 0
+			r.playing = true
+		}
+	}
+}
+
+func (r *Room) Add(t Track) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.queue) >= maxQueue {
+		return ErrQueueFull
+	}
+
+	r.queue = append(r.queue, t)
+	r.broadcast()
+	return nil
+}
+
+func (r *Room) isHost(c *fiber.Ctx) bool {
+	return subtle.ConstantTimeCompare([]byte(c.Cookies(cookie(r.ID))), []byte(r.host)) == 1
+}
+
+func (r *Room) join(ws *wsConn) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if len(r.members) >= cfg.Get().RoomMaxMembers {
+		return false
+	}
+
+	send := make(chan []byte, 1)
+	r.members[ws] = send
+	go func() {
+		for data := range send {
+			if ws.WriteText(data) != nil {
+				ws.conn.Close() // ends the read loop in serve
+				return
+			}
+		}
+	}()
+
+	r.broadcast()
+	return true
+}
+
+func (r *Room) leave(ws *wsConn) {
+	r.Lock()
+	defer r.Unlock()
+
+	close(r.members[ws])
+	delete(r.members, ws)
+	if len(r.members) == 0 {
+		r.empty = time.Now()
+	}
+	r.broadcast()
+}
+
+func (r *Room) serve(ws *wsConn, host bool) {
+	// before joining, so it's the first message
+	hello, _ := cfg.JSON.Marshal(fiber.Map{"type": "hello", "host": host})
+	if ws.WriteText(hello) != nil {
+		return
+	}
+
+	if !r.join(ws) {
+		ws.Close()
+		return
+	}
+	defer r.leave(ws)
+
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if !host {
+			continue
+		}
+
+		var m message
+		if cfg.JSON.Unmarshal(data, &m) != nil {
+			continue
+		}
+
+		if m.Type == "add" {
+			t, err := resolve(m.Track)
+			if err != nil {
+				log.Printf("error getting %s (room %s): %s\n", m.Track, r.ID, err)
+				continue
+			}
+
+			r.Add(t)
+			continue
+		}
+
+		r.Lock()
+		r.apply(m)
+		r.broadcast()
+		r.Unlock()
+	}
+}
+
+// removes rooms nobody was in for cfg.RoomTTL
+func cleanup() {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+
+	for id, r := range rooms {
+		r.Lock()
+		if len(r.members) == 0 && time.Since(r.empty) > cfg.Get().RoomTTL {
+			delete(rooms, id)
+		}
+		r.Unlock()
+	}
+}
+
+func Load(app fiber.Router) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			cleanup()
+		}
+	}()
+
+	// everyone streams through the proxy, otherwise the stream urls would expire in the middle of a session
+	app.Use("/rooms", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableRooms || !cfg.Get().Features.EnableStreamProxy {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	})
+
+	// track= is a track url or id
+	app.Post("/rooms", func(c *fiber.Ctx) error {
+		t, err := resolve(c.FormValue("track"))
+		if err != nil {
+			log.Printf("error getting %s (new room): %s\n", c.FormValue("track"), err)
+			return err
+		}
+
+		r := Create(t)
+		c.Cookie(&fiber.Cookie{Name: cookie(r.ID), Value: r.host, Path: "/rooms/" + r.ID, HTTPOnly: true, SameSite: "Strict", Secure: c.Protocol() == "https"})
+		return c.Redirect("/rooms/"+r.ID, fiber.StatusSeeOther)
+	})
+
+	app.Get("/rooms/:id", func(c *fiber.Ctx) error {
+		r, ok := Get(c.Params("id"))
+		if !ok {
+			return fiber.ErrNotFound
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("listen together", templates.Room(r.ID, r.isHost(c)), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/rooms/:id/ws", func(c *fiber.Ctx) error {
+		r, ok := Get(c.Params("id"))
+		if !ok {
+			return fiber.ErrNotFound
+		}
+
+		// cookies are sent with websockets from other sites too
+		if origin := c.Get("Origin"); origin != "" && origin != c.BaseURL() {
+			return fiber.ErrForbidden
+		}
+
+		host := r.isHost(c)
+		return upgrade(c, func(ws *wsConn) {
+			r.serve(ws, host)
+		})
+	})
+}
@@ -0,0 +1,185 @@
+package rooms
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+)
+
+// Just enough of RFC 6455 for small json text messages, there is no websocket library for fasthttp in our dependencies
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// messages are tiny json objects, anything bigger is a misbehaving client
+const maxMessage = 4096
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+var ErrTooLarge = errors.New("websocket message too large")
+var ErrProtocol = errors.New("websocket protocol error")
+
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeLock sync.Mutex
+}
+
+// responds with 101 and calls handler with the connection once the response is written
+func upgrade(c *fiber.Ctx, handler func(ws *wsConn)) error {
+	if !strings.EqualFold(c.Get("Upgrade"), "websocket") || c.Get("Sec-WebSocket-Version") != "13" {
+		return fiber.NewError(fiber.StatusUpgradeRequired, "expected a websocket")
+	}
+
+	key := c.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fiber.ErrBadRequest
+	}
+
+	// websockets aren't bound by cors, any page could join rooms as the visitor otherwise
+	if !csrf.SameOrigin(c) {
+		return fiber.ErrForbidden
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+	c.Set("Upgrade", "websocket")
+	c.Set("Connection", "Upgrade")
+	c.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(h[:]))
+
+	c.Context().Hijack(func(conn net.Conn) {
+		ws := &wsConn{conn: conn, r: bufio.NewReader(conn)}
+		defer conn.Close()
+
+		// fasthttp doesn't recover in hijacked connections, a panic here would take the whole server down
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("rooms: panic in websocket handler: %v\n", err)
+			}
+		}()
+
+		handler(ws)
+	})
+
+	return c.SendStatus(fiber.StatusSwitchingProtocols)
+}
+
+func (ws *wsConn) writeFrame(op byte, data []byte) error {
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+
+	// server frames are never masked
+	header := []byte{0x80 | op, 0}
+	switch {
+	case len(data) < 126:
+		header[1] = byte(len(data))
+	case len(data) <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+
+	_, err := ws.conn.Write(append(header, data...))
+	return err
+}
+
+func (ws *wsConn) WriteText(data []byte) error {
+	return ws.writeFrame(opText, data)
+}
+
+// reads the next text or binary message, answering pings along the way. io.EOF when the client closed the connection
+func (ws *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(ws.r, header[:]); err != nil {
+			return nil, err
+		}
+
+		fin := header[0]&0x80 != 0
+		op := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+		n := uint64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(ws.r, b[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(ws.r, b[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+
+		// clients have to mask everything, control frames can't be fragmented and carry at most 125 bytes
+		if !masked || (op >= opClose && (!fin || n > 125)) {
+			ws.writeFrame(opClose, []byte{0x03, 0xea}) // 1002, protocol error
+			return nil, ErrProtocol
+		}
+
+		// not n+len(msg), a 64-bit length would wrap around
+		if n > maxMessage-uint64(len(msg)) {
+			ws.writeFrame(opClose, []byte{0x03, 0xf1}) // 1009, message too big
+			return nil, ErrTooLarge
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+			return nil, err
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.r, payload); err != nil {
+			return nil, err
+		}
+
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case opClose:
+			ws.writeFrame(opClose, nil)
+			return nil, io.EOF
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		}
+	}
+}
+
+func (ws *wsConn) Close() error {
+	ws.writeFrame(opClose, nil)
+	return ws.conn.Close()
+}
@@ -0,0 +1,204 @@
+package sc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/valyala/fasthttp"
+)
+
+// Writing to soundcloud as the account of the configured oauth token (comments, likes, reposts, follows)
+// afterwards the affected cached entities are fixed up right away instead of waiting for their ttl
+
+var me struct {
+	sync.Mutex
+	id        string
+	permalink string
+}
+
+// id of the account behind cfg.OAuthToken, it doesn't change so it's only fetched once
+func MeID() (string, error) {
+	if cfg.Get().OAuthToken == "" {
+		return "", ErrNoOAuth
+	}
+
+	me.Lock()
+	defer me.Unlock()
+
+	if me.id != "" {
+		return me.id, nil
+	}
+
+	var u User
+	err := authenticated(fasthttp.MethodGet, newRequest(nil, "me"), nil, &u)
+	if err != nil {
+		return "", err
+	}
+
+	u.Fix(false)
+	me.id = u.ID
+	me.permalink = u.Permalink
+	return me.id, nil
+}
+
+// our own profile shows likes, reposts and followings, so it gets refetched
+func forgetMe() {
+	me.Lock()
+	p := me.permalink
+	me.Unlock()
+
+	if p != "" {
+		usersCache.remove(p)
+	}
+}
+
+// changes the cached user (if it's cached), keeping its expiry
+func adjustUser(permalink string, f func(u *User)) {
+	usersCache.Update(permalink, f)
+}
+
+func adjustTrack(permalink string, f func(t *Track)) {
+	tracksCache.Update(permalink, f)
+}
+
+func (t Track) cacheKey() string {
+	return t.Author.Permalink + "/" + t.Permalink
+}
+
+// body and out are json, both can be nil
+func authenticated(method string, r request, body any, out any) error {
+	if cfg.Get().OAuthToken == "" {
+		return ErrNoOAuth
+	}
+
+	cid, err := GetClientID()
+	if err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod(method)
+	r.query.Set("client_id", cid)
+	req.SetRequestURI(r.String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	req.Header.Set("Authorization", "OAuth "+cfg.Get().OAuthToken)
+	if body != nil {
+		data, err := cfg.JSON.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		req.Header.SetContentType("application/json")
+		req.SetBody(data)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = DoWithRetry(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() < 200 || resp.StatusCode() > 299 {
+		return fmt.Errorf("%s %s: got status code %d", method, req.URI().Path(), resp.StatusCode())
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	data, err := resp.BodyUncompressed()
+	if err != nil {
+		data = resp.Body()
+	}
+
+	return cfg.JSON.Unmarshal(data, out)
+}
+
+type Comment struct {
+	Body      string `json:"body"`
+	Timestamp int64  `json:"timestamp"` // ms into the track
+	CreatedAt string `json:"created_at"`
+	Author    User   `json:"user"`
+}
+
+// at is the position in the track the comment is attached to
+func (t Track) Comment(body string, at time.Duration) (Comment, error) {
+	var c Comment
+	err := authenticated(fasthttp.MethodPost, newRequest(nil, "tracks", t.ID, "comments"), map[string]any{
+		"comment": map[string]any{"body": body, "timestamp": at.Milliseconds()},
+	}, &c)
+
+	if err == nil {
+		adjustTrack(t.cacheKey(), func(t *Track) { t.Comments++ })
+	}
+
+	return c, err
+}
+
+func (t Track) like(method string, by int64) error {
+	id, err := MeID()
+	if err != nil {
+		return err
+	}
+
+	err = authenticated(method, newRequest(nil, "users", id, "track_likes", t.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	adjustTrack(t.cacheKey(), func(t *Track) { t.Likes = max(0, t.Likes+by) })
+	forgetMe()
+	return nil
+}
+
+func (t Track) Like() error {
+	return t.like(fasthttp.MethodPut, 1)
+}
+
+func (t Track) Unlike() error {
+	return t.like(fasthttp.MethodDelete, -1)
+}
+
+func (t Track) repost(method string) error {
+	err := authenticated(method, newRequest(nil, "me", "track_reposts", t.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	forgetMe()
+	return nil
+}
+
+func (t Track) Repost() error {
+	return t.repost(fasthttp.MethodPut)
+}
+
+func (t Track) Unrepost() error {
+	return t.repost(fasthttp.MethodDelete)
+}
+
+func (u User) follow(method string, by int64) error {
+	err := authenticated(method, newRequest(nil, "me", "followings", u.ID), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	adjustUser(u.Permalink, func(u *User) { u.Followers = max(0, u.Followers+by) })
+	forgetMe()
+	return nil
+}
+
+func (u User) Follow() error {
+	return u.follow(fasthttp.MethodPost, 1)
+}
+
+func (u User) Unfollow() error {
+	return u.follow(fasthttp.MethodDelete, -1)
+}
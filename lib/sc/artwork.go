@@ -0,0 +1,75 @@
+package sc
+
+import "strings"
+
+// Artwork and avatar sizes: the cdn serves every image in a few sizes, picked by the last part of the file name
+// (https://i1.sndcdn.com/artworks-000123-abcdef-t500x500.jpg)
+
+// smallest first, original is whatever was uploaded (it can be huge)
+var ArtworkSizes = []string{"t50x50", "t67x67", "large", "t120x120", "t200x200", "t300x300", "crop", "t500x500", "original"}
+
+// width (and height) in pixels
+var artworkPx = map[string]int{
+	"t50x50":   50,
+	"t67x67":   67,
+	"large":    100,
+	"t120x120": 120,
+	"t200x200": 200,
+	"t300x300": 300,
+	"crop":     400,
+	"t500x500": 500,
+}
+
+// the size part of the file name, "" if u doesn't look like an image from the cdn
+func artworkSize(u string) (size string, dash int, dot int) {
+	dot = strings.LastIndexByte(u, '.')
+	dash = strings.LastIndexByte(u, '-')
+	if dot == -1 || dash == -1 || dash > dot {
+		return "", 0, 0
+	}
+
+	size = u[dash+1 : dot]
+	if _, ok := artworkPx[size]; !ok && size != "original" {
+		return "", 0, 0
+	}
+
+	return size, dash, dot
+}
+
+// u in another size, unchanged if either isn't known
+func ArtworkURL(u string, size string) string {
+	if _, ok := artworkPx[size]; !ok && size != "original" {
+		return u
+	}
+
+	cur, dash, dot := artworkSize(u)
+	if cur == "" {
+		return u
+	}
+
+	return u[:dash+1] + size + u[dot:]
+}
+
+// smallest size which is at least px wide, original when none of them is
+func ArtworkSizeFor(px int) string {
+	for _, size := range ArtworkSizes {
+		if artworkPx[size] >= px {
+			return size
+		}
+	}
+
+	return "original"
+}
+
+// the api gives "large" (100x100) urls, Fix swaps them for a bigger size once
+func fixArtwork(u string, large bool) string {
+	if size, _, _ := artworkSize(u); size != "large" {
+		return u
+	}
+
+	if large {
+		return ArtworkURL(u, "t500x500")
+	}
+
+	return ArtworkURL(u, "t200x200")
+}
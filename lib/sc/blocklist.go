@@ -0,0 +1,106 @@
+package sc
+
+import (
+	"errors"
+	"path"
+	"slices"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Per-instance block and allow lists (cfg.BlockedUsers, cfg.BlockedTracks and their Allowed* exceptions)
+// checked when things are handed out rather than when they're cached, so changing the lists works without clearing caches
+// (except for playlist tracks resolved while they were blocked, those stay stubs until the playlist expires)
+
+var ErrBlocked = errors.New("blocked on this instance")
+
+func listed(list []string, id string, permalink string) bool {
+	for _, entry := range list {
+		if entry == id {
+			return true
+		}
+
+		if permalink != "" {
+			if ok, _ := path.Match(entry, permalink); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (u User) Blocked() bool {
+	return listed(cfg.Get().BlockedUsers, u.ID, u.Permalink) && !listed(cfg.Get().AllowedUsers, u.ID, u.Permalink)
+}
+
+// blocked by itself, or because the uploader is
+func (t Track) Blocked() bool {
+	permalink := ""
+	if t.Permalink != "" {
+		permalink = t.Author.Permalink + "/" + t.Permalink
+	}
+
+	if listed(cfg.Get().AllowedTracks, t.ID, permalink) {
+		return false
+	}
+
+	return listed(cfg.Get().BlockedTracks, t.ID, permalink) || (t.Author.ID != "" && t.Author.Blocked())
+}
+
+func (p Playlist) Blocked() bool {
+	return p.Author.Blocked()
+}
+
+func blocklistEmpty() bool {
+	return len(cfg.Get().BlockedUsers) == 0 && len(cfg.Get().BlockedTracks) == 0
+}
+
+// copy of the slice without blocked entries, s itself can be shared (caches) so it's left alone
+func withoutBlocked[T interface{ Blocked() bool }](s []T) ([]T, int) {
+	if blocklistEmpty() {
+		return s, 0
+	}
+
+	res := make([]T, 0, len(s))
+	for _, v := range s {
+		if !v.Blocked() {
+			res = append(res, v)
+		}
+	}
+
+	return res, len(s) - len(res)
+}
+
+// same for a page, blocked results count as hidden
+func pageWithoutBlocked[T interface{ Blocked() bool }](p *Paginated[T]) *Paginated[T] {
+	if blocklistEmpty() {
+		return p
+	}
+
+	res := *p
+	var hidden int
+	res.Collection, hidden = withoutBlocked(p.Collection)
+	res.Hidden += hidden
+	return &res
+}
+
+// blocked tracks of a playlist become stubs, like ones which were deleted
+func (p *Playlist) hideBlocked() {
+	if blocklistEmpty() {
+		return
+	}
+
+	cloned := false
+	for i, t := range p.Tracks {
+		if t.Title == "" || !t.Blocked() {
+			continue
+		}
+
+		if !cloned {
+			p.Tracks = slices.Clone(p.Tracks)
+			cloned = true
+		}
+		p.Tracks[i] = &Track{ID: t.ID, Unavailable: true}
+	}
+}
@@ -0,0 +1,281 @@
+package sc
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/tracing"
+)
+
+// In-memory caches (users, tracks, playlists, search pages, and waveforms and palettes outside of sc), one store per kind with its own ttl
+// every store is swept by the same goroutine. flushing a store only bumps its generation,
+// older entries count as missing right away and are removed by the next sweep
+
+type entry[V any] struct {
+	cached[V]
+	gen uint64
+}
+
+type Store[V any] struct {
+	name string
+	ttl  func(c *cfg.Config) time.Duration // read from the current config on every Set, so reloads apply
+
+	lock     sync.RWMutex
+	entries  map[string]entry[V]
+	gen      atomic.Uint64
+	counters cacheCounters
+
+	// optional second key (like the id of a track) -> key, see indexBy
+	index   func(v V) string
+	indexed map[string]string
+
+	// optional max amount of entries, see Limit
+	limit func(c *cfg.Config) int
+}
+
+// what the stats, the admin dashboard and the sweep need, regardless of the value type
+type anyStore interface {
+	kind() string
+	stat() CacheStat
+	keys() []CacheKey
+	expiry(key string) (time.Time, bool)
+	remove(key string) bool
+	flush()
+	sweep()
+}
+
+var stores []anyStore
+
+// entries deleted per write lock when sweeping
+const sweepBatch = 256
+
+// name is the kind in CacheStats/CacheKeys, ttl is read from the current config on every Set
+func NewStore[V any](name string, ttl func(c *cfg.Config) time.Duration) *Store[V] {
+	s := &Store[V]{name: name, ttl: ttl, entries: map[string]entry[V]{}}
+	stores = append(stores, s)
+	return s
+}
+
+// keeps the entries findable by index(v) too (ByIndex)
+func (s *Store[V]) indexBy(index func(v V) string) *Store[V] {
+	s.index = index
+	s.indexed = map[string]string{}
+	return s
+}
+
+// at most limit entries (read on every Set), when full the expired ones or the one closest to expiring are dropped
+// 0 or less means no limit
+func (s *Store[V]) Limit(limit func(c *cfg.Config) int) *Store[V] {
+	s.limit = limit
+	return s
+}
+
+// lock must be held
+func (s *Store[V]) valid(e entry[V], now time.Time) bool {
+	return e.gen == s.gen.Load() && e.Expires.After(now)
+}
+
+func (s *Store[V]) Get(key string) (V, bool) {
+	span := tracing.Start("cache.get", "cache", s.name, "cache.key", key)
+	defer span.End()
+
+	s.lock.RLock()
+	e, found := s.entries[key]
+	ok := found && s.valid(e, time.Now())
+	s.lock.RUnlock()
+
+	span.Set("cache.hit", strconv.FormatBool(ok))
+	if ok {
+		s.counters.hits.Add(1)
+	} else {
+		s.counters.misses.Add(1)
+		if found {
+			s.counters.stale.Add(1)
+		}
+	}
+
+	return e.Value, ok
+}
+
+func (s *Store[V]) Set(key string, v V) {
+	s.lock.Lock()
+	if s.limit != nil {
+		if max := s.limit(cfg.Get()); max > 0 && len(s.entries) >= max {
+			if _, ok := s.entries[key]; !ok {
+				s.evict(max)
+			}
+		}
+	}
+	s.entries[key] = entry[V]{cached: cached[V]{Value: v, Expires: time.Now().Add(s.ttl(cfg.Get()))}, gen: s.gen.Load()}
+	if s.index != nil {
+		s.indexed[s.index(v)] = key
+	}
+	s.lock.Unlock()
+}
+
+// lock must be held
+func (s *Store[V]) delete(key string, e entry[V]) {
+	delete(s.entries, key)
+	if s.index != nil {
+		if k := s.index(e.Value); s.indexed[k] == key {
+			delete(s.indexed, k)
+		}
+	}
+}
+
+// drops invalid entries, then the ones closest to expiring until there's room for one more. lock must be held
+func (s *Store[V]) evict(max int) {
+	now := time.Now()
+	for key, e := range s.entries {
+		if !s.valid(e, now) {
+			s.delete(key, e)
+			s.counters.evictions.Add(1)
+		}
+	}
+
+	for len(s.entries) >= max {
+		var oldest string
+		var oldestEntry entry[V]
+		for key, e := range s.entries {
+			if oldest == "" || e.Expires.Before(oldestEntry.Expires) {
+				oldest, oldestEntry = key, e
+			}
+		}
+
+		s.delete(oldest, oldestEntry)
+		s.counters.evictions.Add(1)
+	}
+}
+
+// changes a cached value (if it's cached), keeping its expiry
+func (s *Store[V]) Update(key string, f func(v *V)) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !s.valid(e, time.Now()) {
+		return false
+	}
+
+	f(&e.Value)
+	s.entries[key] = e
+	return true
+}
+
+// cached value by its second key (indexBy), doesn't count as a hit or miss
+func (s *Store[V]) ByIndex(k string) (V, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if key, ok := s.indexed[k]; ok {
+		if e, ok := s.entries[key]; ok && s.valid(e, time.Now()) {
+			return e.Value, true
+		}
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (s *Store[V]) kind() string {
+	return s.name
+}
+
+func (s *Store[V]) stat() CacheStat {
+	s.lock.RLock()
+	size := 0
+	now := time.Now()
+	for _, e := range s.entries {
+		if s.valid(e, now) {
+			size++
+		}
+	}
+	s.lock.RUnlock()
+
+	return s.counters.stat(size)
+}
+
+func (s *Store[V]) keys() []CacheKey {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	res := []CacheKey{}
+	now := time.Now()
+	for key, e := range s.entries {
+		if s.valid(e, now) {
+			res = append(res, CacheKey{Key: key, Expires: e.Expires})
+		}
+	}
+
+	return res
+}
+
+func (s *Store[V]) expiry(key string) (time.Time, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	e, ok := s.entries[key]
+	if !ok || !s.valid(e, time.Now()) {
+		return time.Time{}, false
+	}
+
+	return e.Expires, true
+}
+
+func (s *Store[V]) remove(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.entries[key]
+	if ok {
+		s.delete(key, e)
+		s.counters.evictions.Add(1)
+	}
+
+	return ok && s.valid(e, time.Now())
+}
+
+func (s *Store[V]) flush() {
+	s.gen.Add(1)
+}
+
+// the stale keys are collected with a read lock and deleted in batches, so a big cache doesn't block lookups for the whole sweep
+func (s *Store[V]) sweep() {
+	s.lock.RLock()
+	stale := []string{}
+	now := time.Now()
+	for key, e := range s.entries {
+		if !s.valid(e, now) {
+			stale = append(stale, key)
+		}
+	}
+	s.lock.RUnlock()
+
+	for i := 0; i < len(stale); i += sweepBatch {
+		s.lock.Lock()
+		now := time.Now()
+		for _, key := range stale[i:min(i+sweepBatch, len(stale))] {
+			// might have been set again in the meantime
+			if e, ok := s.entries[key]; ok && !s.valid(e, now) {
+				s.delete(key, e)
+				s.counters.evictions.Add(1)
+			}
+		}
+		s.lock.Unlock()
+	}
+}
+
+func init() {
+	go func() {
+		ticker := time.NewTicker(cfg.Get().CacheCleanDelay)
+		cfg.OnReload(func() { ticker.Reset(cfg.Get().CacheCleanDelay) })
+		for range ticker.C {
+			for _, s := range stores {
+				s.sweep()
+			}
+		}
+	}()
+}
@@ -0,0 +1,104 @@
+package sc
+
+import (
+	"time"
+
+	"net/url"
+	"sort"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Timed comments (the ones attached to a position in the track), grouped by position for dots on the seek bar
+
+// 5 pages, popular tracks have way more but the dots look the same
+const maxComments = 1000
+
+// comments shown when hovering a marker
+const markerComments = 3
+
+var commentsCache = NewStore[[]Comment]("comments", func(c *cfg.Config) time.Duration { return c.CommentsTTL })
+
+// timestamp is null for comments which aren't attached to a position
+type apiComment struct {
+	Comment
+	Timestamp *int64 `json:"timestamp"`
+}
+
+type CommentMarker struct {
+	Position int64     `json:"position"` // ms, start of the bucket
+	Count    int       `json:"count"`
+	Comments []Comment `json:"comments"` // the first few
+}
+
+// timed comments of the track, ordered by position. cached for cfg.CommentsTTL
+func (t Track) GetComments() ([]Comment, error) {
+	if res, ok := commentsCache.Get(t.ID); ok {
+		return withoutBlockedComments(res), nil
+	}
+
+	p := Paginated[apiComment]{Next: newRequest(url.Values{"threaded": {"0"}, "sort": {"timestamp"}, "limit": {"200"}}, "tracks", t.ID, "comments").String()}
+	res := []Comment{}
+	for p.Next != "" && len(res) < maxComments {
+		err := p.Proceed()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range p.Collection {
+			if c.Timestamp == nil {
+				continue
+			}
+
+			c.Comment.Timestamp = *c.Timestamp
+			c.Body = sanitizeDescription(c.Body)
+			c.Author.Fix(false)
+			res = append(res, c.Comment)
+		}
+	}
+
+	// sorted by soundcloud too, but markers rely on it
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp < res[j].Timestamp })
+	commentsCache.Set(t.ID, res)
+
+	return withoutBlockedComments(res), nil
+}
+
+func withoutBlockedComments(comments []Comment) []Comment {
+	if blocklistEmpty() {
+		return comments
+	}
+
+	res := make([]Comment, 0, len(comments))
+	for _, c := range comments {
+		if !c.Author.Blocked() {
+			res = append(res, c)
+		}
+	}
+
+	return res
+}
+
+// comments (ordered by position) split into buckets over the duration, only the ones with comments are returned
+func CommentMarkers(comments []Comment, duration int64, buckets int) []CommentMarker {
+	if duration <= 0 || buckets <= 0 {
+		return []CommentMarker{}
+	}
+
+	res := []CommentMarker{}
+	for _, c := range comments {
+		i := min(max(c.Timestamp, 0)*int64(buckets)/duration, int64(buckets)-1)
+		pos := i * duration / int64(buckets)
+		if len(res) == 0 || res[len(res)-1].Position != pos {
+			res = append(res, CommentMarker{Position: pos})
+		}
+
+		m := &res[len(res)-1]
+		m.Count++
+		if len(m.Comments) < markerComments {
+			m.Comments = append(m.Comments, c)
+		}
+	}
+
+	return res
+}
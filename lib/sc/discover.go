@@ -0,0 +1,93 @@
+package sc
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Functions/structures related to the discover page (/mixed-selections)
+
+// a module on the discover page, like "Charts: Top 50" or "Fresh pressed"
+type Selection struct {
+	ID          string                   `json:"urn"`
+	Title       string                   `json:"title"`
+	Description string                   `json:"description"`
+	Items       Paginated[SelectionItem] `json:"items"`
+}
+
+// playlist or system playlist (charts, personalized mixes)
+type SelectionItem struct {
+	Playlist
+	ShortTitle string `json:"short_title"`
+	URL        string `json:"permalink_url"`
+}
+
+var discoverCache cached[[]Selection]
+var discoverCacheLock = &sync.RWMutex{}
+
+// path of the item on this instance
+func (i SelectionItem) Href() string {
+	u, err := url.Parse(i.URL)
+	if err != nil || u.Path == "" {
+		return "/" + i.Author.Permalink + "/sets/" + i.Permalink
+	}
+
+	return u.Path
+}
+
+func (i SelectionItem) Name() string {
+	if i.ShortTitle != "" {
+		return i.ShortTitle
+	}
+
+	return i.Title
+}
+
+// discover modules, personalized ones are included when there's an oauth token
+func GetDiscover() ([]Selection, error) {
+	discoverCacheLock.RLock()
+	if discoverCache.Expires.After(time.Now()) {
+		s := discoverCache.Value
+		discoverCacheLock.RUnlock()
+		return s, nil
+	}
+	discoverCacheLock.RUnlock()
+
+	p := Paginated[Selection]{Next: newRequest(url.Values{"limit": {"10"}}, "mixed-selections").String()}
+	var err error
+	if cfg.Get().OAuthToken != "" {
+		err = p.ProceedAuthenticated()
+	} else {
+		err = p.Proceed()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Selection, 0, len(p.Collection))
+	for _, s := range p.Collection {
+		items := s.Items.Collection[:0]
+		for _, i := range s.Items.Collection {
+			if i.Kind != "playlist" && i.Kind != "system-playlist" {
+				continue
+			}
+
+			i.Fix(false)
+			items = append(items, i)
+		}
+
+		if len(items) != 0 {
+			s.Items.Collection = items
+			res = append(res, s)
+		}
+	}
+
+	discoverCacheLock.Lock()
+	discoverCache = cached[[]Selection]{Value: res, Expires: time.Now().Add(cfg.Get().DiscoverTTL)}
+	discoverCacheLock.Unlock()
+
+	return res, nil
+}
@@ -0,0 +1,144 @@
+package sc
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Schema drift detection (cfg.SchemaDrift): api responses are compared with the fields soundcloud sent when this was written
+// unknown fields are reported as added, fields we rely on which aren't there anymore as missing (a rename is both)
+// every change is logged once and counted, check /metrics (soundcloak_schema_drift_total) and /admin
+
+// everything in full objects of each kind
+var knownFields = map[string][]string{
+	"track": {
+		"artwork_url", "caption", "commentable", "comment_count", "created_at", "description", "display_date", "downloadable",
+		"download_count", "duration", "embeddable_by", "full_duration", "genre", "has_downloads_left", "id", "kind", "label_name",
+		"last_modified", "license", "likes_count", "media", "monetization_model", "permalink", "permalink_url", "playback_count",
+		"policy", "public", "publisher_metadata", "purchase_title", "purchase_url", "release_date", "reposts_count", "secret_token",
+		"sharing", "state", "station_permalink", "station_urn", "streamable", "tag_list", "title", "track_authorization",
+		"track_format", "uri", "urn", "user", "user_id", "visuals", "waveform_url",
+	},
+	"user": {
+		"avatar_url", "badges", "city", "comments_count", "country_code", "created_at", "creator_subscription", "creator_subscriptions",
+		"date_of_birth", "description", "first_name", "followers_count", "followings_count", "full_name", "groups_count", "id",
+		"kind", "last_modified", "last_name", "likes_count", "permalink", "permalink_url", "playlist_count", "playlist_likes_count",
+		"reposts_count", "station_permalink", "station_urn", "track_count", "uri", "urn", "username", "verified", "visuals",
+	},
+	"playlist": {
+		"artwork_url", "created_at", "description", "display_date", "duration", "embeddable_by", "genre", "id", "is_album", "kind",
+		"label_name", "last_modified", "license", "likes_count", "managed_by_feeds", "permalink", "permalink_url", "public",
+		"published_at", "purchase_title", "purchase_url", "release_date", "reposts_count", "secret_token", "set_type", "sharing",
+		"tag_list", "title", "track_count", "tracks", "uri", "urn", "user", "user_id",
+	},
+}
+
+// the ones features break without
+var requiredFields = map[string][]string{
+	"track":    {"title", "permalink", "urn", "duration", "media", "user"},
+	"user":     {"username", "permalink", "urn", "avatar_url"},
+	"playlist": {"title", "permalink", "urn", "tracks", "user"},
+}
+
+// objects with less fields are stubs (like the tracks of a playlist), only checked for added fields
+const fullObject = 10
+
+type Drift struct {
+	Kind   string `json:"kind"`
+	Field  string `json:"field"`
+	Change string `json:"change"` // added or missing
+	Count  int64  `json:"count"`
+}
+
+var known = map[string]map[string]bool{}
+var drift = map[[3]string]int64{}
+var driftLock = &sync.Mutex{}
+
+func init() {
+	for kind, fields := range knownFields {
+		known[kind] = map[string]bool{}
+		for _, f := range fields {
+			known[kind][f] = true
+		}
+	}
+}
+
+func report(kind string, field string, change string) {
+	key := [3]string{kind, field, change}
+	driftLock.Lock()
+	drift[key]++
+	first := drift[key] == 1
+	driftLock.Unlock()
+
+	if first {
+		log.Printf("schema drift: %s field %q is %s\n", kind, field, change)
+	}
+}
+
+func checkObject(obj map[string]any) {
+	kind, _ := obj["kind"].(string)
+	fields, ok := known[kind]
+	for f, v := range obj {
+		if ok && !fields[f] {
+			report(kind, f, "added")
+		}
+
+		checkValue(v)
+	}
+
+	if !ok || len(obj) < fullObject {
+		return
+	}
+
+	for _, f := range requiredFields[kind] {
+		if _, ok := obj[f]; !ok {
+			report(kind, f, "missing")
+		}
+	}
+}
+
+// walks objects, pages (collection) and arrays for things with a known kind
+func checkValue(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		checkObject(v)
+	case []any:
+		for _, item := range v {
+			checkValue(item)
+		}
+	}
+}
+
+// decodes the response a second time, generically. only when cfg.SchemaDrift is on
+func checkSchema(data []byte) {
+	if !cfg.Get().SchemaDrift {
+		return
+	}
+
+	var v any
+	if cfg.JSON.Unmarshal(data, &v) == nil {
+		checkValue(v)
+	}
+}
+
+// every change seen since startup, most frequent first
+func SchemaDrift() []Drift {
+	driftLock.Lock()
+	res := make([]Drift, 0, len(drift))
+	for k, n := range drift {
+		res = append(res, Drift{Kind: k[0], Field: k[1], Change: k[2], Count: n})
+	}
+	driftLock.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Kind+res[i].Field < res[j].Kind+res[j].Field
+	})
+
+	return res
+}
@@ -0,0 +1,41 @@
+package sc
+
+import (
+	"strconv"
+	"time"
+)
+
+// 3:05, or 1:02:03 for longer ones
+func FormatDuration(d time.Duration) string {
+	s := int64(d.Round(time.Second) / time.Second)
+	if s < 0 {
+		s = 0
+	}
+
+	pad := func(n int64) string {
+		if n < 10 {
+			return "0" + strconv.FormatInt(n, 10)
+		}
+
+		return strconv.FormatInt(n, 10)
+	}
+
+	if s >= 3600 {
+		return strconv.FormatInt(s/3600, 10) + ":" + pad(s/60%60) + ":" + pad(s%60)
+	}
+
+	return strconv.FormatInt(s/60, 10) + ":" + pad(s%60)
+}
+
+func msToDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// sum of track durations (tracks which weren't resolved yet have none)
+func TotalDuration(tracks []*Track) (d time.Duration) {
+	for _, t := range tracks {
+		d += t.Duration
+	}
+
+	return
+}
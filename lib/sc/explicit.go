@@ -0,0 +1,22 @@
+package sc
+
+import "strings"
+
+// Explicit content: soundcloud only knows about it when the publisher says so (publisher_metadata.explicit),
+// which most uploads don't set, so titles and tags are checked for the usual markers as well
+
+// matched as whole words of the title or tags
+var explicitMarkers = []string{"explicit", "nsfw", "18+", "xxx", "uncensored", "porn"}
+
+func looksExplicit(t *Track) bool {
+	for _, word := range strings.Fields(t.Title + " " + t.TagList) {
+		word = strings.Trim(strings.ToLower(word), "()[]{}#.,!?\"'")
+		for _, m := range explicitMarkers {
+			if word == m {
+				return true
+			}
+		}
+	}
+
+	return false
+}
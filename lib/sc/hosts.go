@@ -0,0 +1,36 @@
+package sc
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Checks for urls pointing at soundcloud's cdn (cfg.SoundcloudImageHosts, cfg.SoundcloudMediaHosts),
+// the proxies use them so they don't fetch whatever they're given
+
+func onHost(u *url.URL, hosts []string) bool {
+	if u.Scheme != "https" {
+		return false
+	}
+
+	host := u.Hostname()
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// artwork, avatars and waveforms
+func IsImageURL(u *url.URL) bool {
+	return onHost(u, cfg.Get().SoundcloudImageHosts)
+}
+
+// hls playlists, segments and progressive streams
+func IsMediaURL(u *url.URL) bool {
+	return onHost(u, cfg.Get().SoundcloudMediaHosts)
+}
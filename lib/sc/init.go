@@ -7,9 +7,14 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/tracing"
 	"github.com/valyala/fasthttp"
 )
 
@@ -19,23 +24,64 @@ var clientIdCache struct {
 	NextCheck time.Time
 }
 
-const api = "api-v2.soundcloud.com"
+// held for the whole of GetClientID, so concurrent callers wait for one refresh instead of all doing it
+var clientIdLock sync.Mutex
 
-var httpc = fasthttp.HostClient{
-	Addr:          api + ":443",
-	IsTLS:         true,
-	DialDualStack: true,
-	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.DNSCacheTTL}).Dial,
-	//MaxIdleConnDuration: 1<<63 - 1, //seems to cause some issues
+// cfg.SoundcloudAPI and cfg.SoundcloudWeb without the trailing slash, changed with SetBaseURLs
+var api = strings.TrimSuffix(cfg.Get().SoundcloudAPI, "/")
+var web = strings.TrimSuffix(cfg.Get().SoundcloudWeb, "/")
+
+var httpc = newClient(api)
+
+func newClient(base string) *fasthttp.HostClient {
+	u, _ := url.Parse(base) // checked by cfg
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			addr += ":443"
+		} else {
+			addr += ":80"
+		}
+	}
+
+	return &fasthttp.HostClient{
+		Addr:          addr,
+		IsTLS:         u.Scheme == "https",
+		DialDualStack: true,
+		Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+		//MaxIdleConnDuration: 1<<63 - 1, //seems to cause some issues
+	}
+}
+
+// points every request at another server (like lib/sctest), call it before making any
+// cached entities and the client id from the old one are dropped
+func SetBaseURLs(apiBase string, webBase string) error {
+	for _, base := range []string{apiBase, webBase} {
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("not an http(s) url: %s", base)
+		}
+	}
+
+	api = strings.TrimSuffix(apiBase, "/")
+	web = strings.TrimSuffix(webBase, "/")
+	httpc = newClient(api)
+	clientIdLock.Lock()
+	clientIdCache.NextCheck = time.Time{}
+	clientIdCache.Version = nil
+	clientIdLock.Unlock()
+	FlushCaches()
+	return nil
 }
 
 var verRegex = regexp.MustCompile(`(?m)^<script>window\.__sc_version="([0-9]{10})"</script>$`)
-var scriptsRegex = regexp.MustCompile(`(?m)^<script crossorigin src="(https://a-v2\.sndcdn\.com/assets/.+\.js)"></script>$`)
+var scriptsRegex = regexp.MustCompile(`(?m)^<script crossorigin src="(https?://[^"]+/assets/[^"]+\.js)"></script>$`)
 var clientIdRegex = regexp.MustCompile(`\("client_id=([A-Za-z0-9]{32})"\)`)
 var ErrVersionNotFound = errors.New("version not found")
 var ErrScriptNotFound = errors.New("script not found")
 var ErrIDNotFound = errors.New("clientid not found")
 var ErrKindNotCorrect = errors.New("entity of incorrect kind")
+var ErrNoOAuth = errors.New("no oauth token configured")
 
 type cached[T any] struct {
 	Value   T
@@ -43,22 +89,31 @@ type cached[T any] struct {
 }
 
 // inspired by github.com/imputnet/cobalt (mostly stolen lol)
-func GetClientID() (string, error) {
+func GetClientID() (cid string, err error) {
+	clientIdLock.Lock()
+	defer clientIdLock.Unlock()
+
 	if clientIdCache.NextCheck.After(time.Now()) {
 		return clientIdCache.ClientID, nil
 	}
 
+	span := tracing.StartClient("sc.GetClientID")
+	defer func() {
+		span.Error(err)
+		span.End()
+	}()
+
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
-	req.SetRequestURI("https://soundcloud.com/h") // 404 page
-	req.Header.Set("User-Agent", cfg.UserAgent)   // the connection is stuck with fasthttp useragent lol, maybe randomly select from a list of browser useragents in the future? low priority for now
+	req.SetRequestURI(web + "/h")                     // 404 page
+	req.Header.Set("User-Agent", cfg.Get().UserAgent) // the connection is stuck with fasthttp useragent lol, maybe randomly select from a list of browser useragents in the future? low priority for now
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
 
-	err := fasthttp.Do(req, resp)
+	err = fasthttp.Do(req, resp)
 	if err != nil {
 		return "", err
 	}
@@ -74,7 +129,7 @@ func GetClientID() (string, error) {
 	}
 
 	if bytes.Equal(res[1], clientIdCache.Version) {
-		clientIdCache.NextCheck = time.Now().Add(cfg.ClientIDTTL)
+		clientIdCache.NextCheck = time.Now().Add(cfg.Get().ClientIDTTL)
 		return clientIdCache.ClientID, nil
 	}
 
@@ -109,14 +164,160 @@ func GetClientID() (string, error) {
 
 		clientIdCache.ClientID = string(res[1])
 		clientIdCache.Version = ver
-		clientIdCache.NextCheck = time.Now().Add(cfg.ClientIDTTL)
+		clientIdCache.NextCheck = time.Now().Add(cfg.Get().ClientIDTTL)
 		return clientIdCache.ClientID, nil
 	}
 
 	return "", ErrIDNotFound
 }
 
+// current client id version and when it will be rechecked, empty version if we don't have one yet
+func ClientIDInfo() (version string, nextCheck time.Time) {
+	clientIdLock.Lock()
+	defer clientIdLock.Unlock()
+	return string(clientIdCache.Version), clientIdCache.NextCheck
+}
+
+// cheap request to check if the api is reachable at all
+func Ping() error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod("HEAD")
+	req.SetRequestURI(api + "/")
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := DoWithRetry(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() >= 500 {
+		return fmt.Errorf("ping: got status code %d", resp.StatusCode())
+	}
+
+	return nil
+}
+
+type CacheStat struct {
+	Size   int   `json:"size"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+
+	// misses which found the entry, but it had expired or was flushed
+	Stale int64 `json:"stale"`
+
+	// entries removed by sweeps, purges or because the cache was full
+	Evictions int64 `json:"evictions"`
+}
+
+type cacheCounters struct {
+	hits      atomic.Int64
+	misses    atomic.Int64
+	stale     atomic.Int64
+	evictions atomic.Int64
+}
+
+func (c *cacheCounters) stat(size int) CacheStat {
+	return CacheStat{Size: size, Hits: c.hits.Load(), Misses: c.misses.Load(), Stale: c.stale.Load(), Evictions: c.evictions.Load()}
+}
+
+// size and counters of each cache, for /admin and /metrics
+func CacheStats() map[string]CacheStat {
+	res := map[string]CacheStat{}
+	for _, s := range stores {
+		res[s.kind()] = s.stat()
+	}
+
+	return res
+}
+
+// clears all caches
+func FlushCaches() {
+	for _, s := range stores {
+		s.flush()
+	}
+}
+
+type CacheKey struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// lists keys of each cache with their expiry
+func CacheKeys() map[string][]CacheKey {
+	res := map[string][]CacheKey{}
+	for _, s := range stores {
+		res[s.kind()] = s.keys()
+	}
+
+	return res
+}
+
+// when the cached entity expires, kind is one from CacheKeys (users, tracks, playlists...)
+func CacheExpiry(kind string, key string) (expires time.Time, ok bool) {
+	for _, s := range stores {
+		if s.kind() == kind {
+			return s.expiry(key)
+		}
+	}
+
+	return
+}
+
+// removes the permalink from every entity cache, returns how many entries were removed
+func Purge(permalink string) (removed int) {
+	for _, s := range stores {
+		if s.remove(permalink) {
+			removed++
+		}
+	}
+
+	return
+}
+
+// forgets the current client id and fetches a new one
+func RefreshClientID() (string, error) {
+	clientIdLock.Lock()
+	clientIdCache.NextCheck = time.Time{}
+	clientIdCache.Version = nil
+	clientIdLock.Unlock()
+	return GetClientID()
+}
+
+var upstreamRequests, upstreamErrors atomic.Int64
+var lastRateLimit atomic.Int64
+
+// when the api last responded with 429, zero time if never
+func LastRateLimit() time.Time {
+	n := lastRateLimit.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
+
+// amount of requests made to the api, and how many of them failed (network errors, 429 and 5xx)
+func UpstreamStats() (requests int64, errors int64) {
+	return upstreamRequests.Load(), upstreamErrors.Load()
+}
+
 func DoWithRetry(req *fasthttp.Request, resp *fasthttp.Response) (err error) {
+	upstreamRequests.Add(1)
+	defer func() {
+		if err != nil || resp.StatusCode() == 429 || resp.StatusCode() >= 500 {
+			upstreamErrors.Add(1)
+		}
+
+		if err == nil && resp.StatusCode() == 429 {
+			lastRateLimit.Store(time.Now().UnixNano())
+		}
+	}()
+
 	for i := 0; i < 5; i++ {
 		err = httpc.Do(req, resp)
 		if err == nil {
@@ -131,7 +332,18 @@ func DoWithRetry(req *fasthttp.Request, resp *fasthttp.Response) (err error) {
 	return
 }
 
+// path can also be a full link, sharing params are dropped (check NormalizeURL)
 func Resolve(path string, out any) error {
+	return resolveURL(NormalizeURL(path).URL, out)
+}
+
+func resolveURL(u string, out any) (err error) {
+	span := tracing.StartClient("sc.Resolve", "url.full", u)
+	defer func() {
+		span.Error(err)
+		span.End()
+	}()
+
 	cid, err := GetClientID()
 	if err != nil {
 		return err
@@ -140,8 +352,8 @@ func Resolve(path string, out any) error {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
-	req.SetRequestURI("https://" + api + "/resolve?url=https%3A%2F%2Fsoundcloud.com%2F" + url.QueryEscape(path) + "&client_id=" + cid)
-	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.SetRequestURI(newRequest(url.Values{"url": {u}, "client_id": {cid}}, "resolve").String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 	resp := fasthttp.AcquireResponse()
@@ -161,6 +373,7 @@ func Resolve(path string, out any) error {
 		data = resp.Body()
 	}
 
+	checkSchema(data)
 	return cfg.JSON.Unmarshal(data, out)
 }
 
@@ -168,9 +381,32 @@ type Paginated[T any] struct {
 	Collection []T    `json:"collection"`
 	Total      int64  `json:"total_results"`
 	Next       string `json:"next_href"`
+
+	Page Page `json:"page"` // only set by functions taking a Page
+
+	Hidden int `json:"hidden,omitempty"` // results left out by the spam filter (check spam.go)
 }
 
 func (p *Paginated[T]) Proceed() error {
+	return p.proceed(false)
+}
+
+// only for endpoints which need an account, everything else should stay anonymous
+func (p *Paginated[T]) ProceedAuthenticated() error {
+	if cfg.Get().OAuthToken == "" {
+		return ErrNoOAuth
+	}
+
+	return p.proceed(true)
+}
+
+func (p *Paginated[T]) proceed(auth bool) (err error) {
+	span := tracing.StartClient("sc.Proceed", "url.full", p.Next)
+	defer func() {
+		span.Error(err)
+		span.End()
+	}()
+
 	cid, err := GetClientID()
 	if err != nil {
 		return err
@@ -180,9 +416,17 @@ func (p *Paginated[T]) Proceed() error {
 	defer fasthttp.ReleaseRequest(req)
 
 	oldNext := p.Next
-	req.SetRequestURI(p.Next + "&client_id=" + cid)
-	req.Header.Set("User-Agent", cfg.UserAgent)
+	u, err := withParams(p.Next, url.Values{"client_id": {cid}})
+	if err != nil {
+		return err
+	}
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	if auth {
+		req.Header.Set("Authorization", "OAuth "+cfg.Get().OAuthToken)
+	}
 
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseResponse(resp)
@@ -201,6 +445,7 @@ func (p *Paginated[T]) Proceed() error {
 		data = resp.Body()
 	}
 
+	checkSchema(data)
 	err = cfg.JSON.Unmarshal(data, p)
 	if err != nil {
 		return err
@@ -213,76 +458,73 @@ func (p *Paginated[T]) Proceed() error {
 	return nil
 }
 
+// Splits a tag list (like `electronic "deep house" techno`) into tags
+// tags are separated by whitespace, a tag starting with " goes on until the next unescaped " (or the end)
+// \" and \\ are escapes, a " in the middle of a tag (12") is kept as is. never returns empty tags
 func TagListParser(taglist string) (res []string) {
-	inString := false
-	cur := []rune{}
-	for i, c := range taglist {
-		if c == '"' {
-			if i == len(taglist)-1 {
-				res = append(res, string(cur))
-				return
-			}
-
-			inString = !inString
-			continue
+	var cur strings.Builder
+	quoted := false
+	started := false // cur has a tag in it, even an empty quoted one
+	end := func() {
+		if started && cur.Len() != 0 {
+			res = append(res, cur.String())
 		}
+		cur.Reset()
+		started = false
+		quoted = false
+	}
 
-		if !inString && c == ' ' {
-			res = append(res, string(cur))
-			cur = []rune{}
-			continue
+	rs := []rune(taglist)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '\\' && i+1 < len(rs) && (rs[i+1] == '"' || rs[i+1] == '\\'):
+			i++
+			cur.WriteRune(rs[i])
+			started = true
+		case c == '"' && quoted:
+			end() // "a""b" is two tags
+		case c == '"' && !started:
+			quoted = true
+			started = true
+		case unicode.IsSpace(c) && !quoted:
+			end()
+		default:
+			cur.WriteRune(c)
+			started = true
 		}
-
-		cur = append(cur, c)
 	}
+	end()
 
 	return
 }
 
-// could probably make a generic function, whatever
-func init() {
-	go func() {
-		ticker := time.NewTicker(cfg.UserCacheCleanDelay)
-		for range ticker.C {
-			usersCacheLock.Lock()
-
-			for key, val := range usersCache {
-				if val.Expires.Before(time.Now()) {
-					delete(usersCache, key)
-				}
-			}
-
-			usersCacheLock.Unlock()
+// The inverse of TagListParser, tags with whitespace, quotes or backslashes are quoted. empty tags are skipped
+func BuildTagList(tags []string) string {
+	var b strings.Builder
+	for _, tag := range tags {
+		if tag == "" {
+			continue
 		}
-	}()
-
-	go func() {
-		ticker := time.NewTicker(cfg.TrackCacheCleanDelay)
-		for range ticker.C {
-			tracksCacheLock.Lock()
-
-			for key, val := range tracksCache {
-				if val.Expires.Before(time.Now()) {
-					delete(tracksCache, key)
-				}
-			}
 
-			tracksCacheLock.Unlock()
+		if b.Len() != 0 {
+			b.WriteByte(' ')
 		}
-	}()
 
-	go func() {
-		ticker := time.NewTicker(cfg.PlaylistCacheCleanDelay)
-		for range ticker.C {
-			playlistsCacheLock.Lock()
+		if !strings.ContainsFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == '"' || r == '\\' }) {
+			b.WriteString(tag)
+			continue
+		}
 
-			for key, val := range playlistsCache {
-				if val.Expires.Before(time.Now()) {
-					delete(playlistsCache, key)
-				}
+		b.WriteByte('"')
+		for _, c := range tag {
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
 			}
-
-			playlistsCacheLock.Unlock()
+			b.WriteRune(c)
 		}
-	}()
+		b.WriteByte('"')
+	}
+
+	return b.String()
 }
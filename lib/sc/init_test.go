@@ -0,0 +1,69 @@
+package sc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTagListParser(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{``, nil},
+		{`electronic "deep house" techno`, []string{"electronic", "deep house", "techno"}},
+		{`a  b`, []string{"a", "b"}},
+		{" a\t\nb ", []string{"a", "b"}},
+		{`"a""b"`, []string{"a", "b"}},
+		{`"a" "b"`, []string{"a", "b"}},
+		{`""`, nil},
+		{`"" a ""`, []string{"a"}},
+		{`12"`, []string{`12"`}},
+		{`12" vinyl`, []string{`12"`, "vinyl"}},
+		{`"12\" vinyl"`, []string{`12" vinyl`}},
+		{`\"quoted\"`, []string{`"quoted"`}},
+		{`a\\b`, []string{`a\b`}},
+		{`a\b`, []string{`a\b`}},
+		{`"unterminated tag`, []string{"unterminated tag"}},
+		{`ünïcödé "ヴェイパーウェイヴ ok"`, []string{"ünïcödé", "ヴェイパーウェイヴ ok"}},
+	} {
+		got := TagListParser(tc.in)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("TagListParser(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestBuildTagList(t *testing.T) {
+	for _, tc := range []struct {
+		in   []string
+		want string
+	}{
+		{nil, ``},
+		{[]string{"electronic", "deep house"}, `electronic "deep house"`},
+		{[]string{"", "a", ""}, `a`},
+		{[]string{`12"`}, `"12\""`},
+		{[]string{`a\b`}, `"a\\b"`},
+		{[]string{"tab\there"}, "\"tab\there\""},
+	} {
+		got := BuildTagList(tc.in)
+		if got != tc.want {
+			t.Errorf("BuildTagList(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTagListRoundTrip(t *testing.T) {
+	for _, tags := range [][]string{
+		{"electronic", "deep house", "techno"},
+		{`12"`, `12" vinyl`, `"quoted"`, `""`},
+		{`a\b`, `\`, `\"`, `trailing\`},
+		{"a  b", " leading", "trailing "},
+		{"ünïcödé", "ヴェイパーウェイヴ ok"},
+	} {
+		got := TagListParser(BuildTagList(tags))
+		if !slices.Equal(got, tags) {
+			t.Errorf("round trip of %q gave %q", tags, got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sc
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Track licenses: all-rights-reserved, no-rights-reserved (public domain) or a creative commons one (cc-by, cc-by-nc-sa...)
+
+// search filters soundcloud has for licenses, from least to most permissive. every creative commons license allows sharing
+const (
+	LicenseToShare              = "to_share"
+	LicenseToUseCommercially    = "to_use_commercially"
+	LicenseToModifyCommercially = "to_modify_commercially"
+)
+
+func ValidLicenseFilter(f string) bool {
+	return f == LicenseToShare || f == LicenseToUseCommercially || f == LicenseToModifyCommercially
+}
+
+// public domain or creative commons, so it can be reused with the right attribution
+func (t Track) Reusable() bool {
+	return t.License == "no-rights-reserved" || strings.HasPrefix(t.License, "cc-")
+}
+
+// like "CC BY-NC-SA", "CC0" or "All rights reserved"
+func LicenseName(license string) string {
+	switch {
+	case license == "no-rights-reserved":
+		return "CC0"
+	case strings.HasPrefix(license, "cc-"):
+		return "CC " + strings.ToUpper(license[3:])
+	case license == "all-rights-reserved":
+		return "All rights reserved"
+	}
+
+	return license
+}
+
+// the creative commons deed, "" if there's none
+func LicenseURL(license string) string {
+	switch {
+	case license == "no-rights-reserved":
+		return "https://creativecommons.org/publicdomain/zero/1.0/"
+	case strings.HasPrefix(license, "cc-"):
+		return "https://creativecommons.org/licenses/" + license[3:] + "/4.0/"
+	}
+
+	return ""
+}
+
+// license is one of the License* filters, "" for any license
+func SearchTracksByLicense(q string, license string, pg Page) (*Paginated[*Track], error) {
+	if license == "" {
+		return SearchTracks(q, pg)
+	}
+
+	return searchTracks(q, url.Values{"filter.license": {license}}, pg)
+}
@@ -0,0 +1,183 @@
+package sc
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// limit/offset pagination for endpoints which support it (search), instead of passing next_href leftovers around
+// the rest (user tracks and playlists, charts, the feed) only take soundcloud's own opaque offsets, those use Cursor
+
+const DefaultLimit = 20
+
+// soundcloud doesn't return more than this in one page
+const MaxLimit = 200
+
+type Page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// clamps limit to 1..MaxLimit (0 means DefaultLimit) and offset to >= 0
+func NewPage(limit int, offset int) Page {
+	if limit <= 0 {
+		limit = DefaultLimit
+	} else if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	return Page{Limit: limit, Offset: offset}
+}
+
+// same as NewPage, but from query params. invalid values fall back to the defaults
+func ParsePage(limit string, offset string) Page {
+	l, err := strconv.Atoi(limit)
+	if err != nil {
+		l = 0
+	}
+
+	o, err := strconv.Atoi(offset)
+	if err != nil {
+		o = 0
+	}
+
+	return NewPage(l, o)
+}
+
+// 1-based
+func (pg Page) Number() int {
+	return pg.Offset/pg.Limit + 1
+}
+
+func (pg Page) Next() Page {
+	return Page{Limit: pg.Limit, Offset: pg.Offset + pg.Limit}
+}
+
+func (pg Page) Prev() Page {
+	return NewPage(pg.Limit, pg.Offset-pg.Limit)
+}
+
+// start and end of this page in a list of n things, offsets past the end give an empty page instead of overflowing
+func (pg Page) Bounds(n int) (start int, end int) {
+	start = min(pg.Offset, n)
+	return start, start + min(pg.Limit, n-start)
+}
+
+// is there anything after this page in a list of n things
+func (pg Page) HasMore(n int) bool {
+	return pg.Offset < n-pg.Limit
+}
+
+// limit=20&offset=40, for links to this page
+func (pg Page) Query() string {
+	return "limit=" + strconv.Itoa(pg.Limit) + "&offset=" + strconv.Itoa(pg.Offset)
+}
+
+// sets the api params, offset is left out on the first page so it can be cached (check search.go)
+func (pg Page) params(v url.Values) {
+	v.Set("limit", strconv.Itoa(pg.Limit))
+	if pg.Offset != 0 {
+		v.Set("offset", strconv.Itoa(pg.Offset))
+	}
+}
+
+// search params for the api: q, filters (like filter.genre_or_tag) and the page
+func searchParams(q string, filters url.Values, pg Page) url.Values {
+	v := url.Values{}
+	for key, vals := range filters {
+		v[key] = vals
+	}
+	v.Set("q", q)
+	pg.params(v)
+
+	return v
+}
+
+// amount of pages, 0 if soundcloud didn't tell us the total
+func (p *Paginated[T]) Pages() int {
+	if p.Total <= 0 || p.Page.Limit == 0 {
+		return 0
+	}
+
+	return int((p.Total + int64(p.Page.Limit) - 1) / int64(p.Page.Limit))
+}
+
+// is there anything after the current page
+func (p *Paginated[T]) HasNext() bool {
+	if p.Next == "" || len(p.Collection) == 0 {
+		return false
+	}
+
+	if p.Total > 0 {
+		return int64(p.Page.Offset+len(p.Collection)) < p.Total
+	}
+
+	return true
+}
+
+// cursors are short (a date and an id or a number), anything longer didn't come from soundcloud
+const maxCursor = 200
+
+// limit plus an opaque offset, which only ever comes from the next_href of the previous page
+type Cursor struct {
+	Limit  int    `json:"limit"`
+	Offset string `json:"offset,omitempty"` // empty on the first page
+}
+
+// clamps limit like NewPage, an offset which can't be a cursor means the first page
+func NewCursor(limit int, offset string) Cursor {
+	c := Cursor{Limit: NewPage(limit, 0).Limit, Offset: offset}
+	if len(offset) > maxCursor {
+		c.Offset = ""
+	}
+
+	return c
+}
+
+// same as NewCursor, but from query params
+func ParseCursor(limit string, offset string) Cursor {
+	l, err := strconv.Atoi(limit)
+	if err != nil {
+		l = 0
+	}
+
+	return NewCursor(l, offset)
+}
+
+// limit=20&offset=..., for links to this page
+func (c Cursor) Query() string {
+	q := "limit=" + strconv.Itoa(c.Limit)
+	if c.Offset != "" {
+		q += "&offset=" + url.QueryEscape(c.Offset)
+	}
+
+	return q
+}
+
+func (c Cursor) params(v url.Values) {
+	v.Set("limit", strconv.Itoa(c.Limit))
+	if c.Offset != "" {
+		v.Set("offset", c.Offset)
+	}
+}
+
+// cursor of the page after this one, from next_href. false on the last page
+func (p *Paginated[T]) NextCursor() (Cursor, bool) {
+	if p.Next == "" {
+		return Cursor{}, false
+	}
+
+	u, err := url.Parse(p.Next)
+	if err != nil {
+		return Cursor{}, false
+	}
+
+	q := u.Query()
+	l, _ := strconv.Atoi(q.Get("limit"))
+	c := NewCursor(l, q.Get("offset"))
+	return c, c.Offset != ""
+}
@@ -0,0 +1,30 @@
+package sc
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestPageBounds(t *testing.T) {
+	for _, tc := range []struct {
+		offset     string
+		n          int
+		start, end int
+		more       bool
+	}{
+		{"", 120, 0, 50, true},
+		{"50", 120, 50, 100, true},
+		{"100", 120, 100, 120, false},
+		{"120", 120, 120, 120, false},
+		{"500", 120, 120, 120, false},
+		{strconv.Itoa(math.MaxInt), 120, 120, 120, false},
+		{"", 0, 0, 0, false},
+	} {
+		pg := ParsePage("50", tc.offset)
+		start, end := pg.Bounds(tc.n)
+		if start != tc.start || end != tc.end || pg.HasMore(tc.n) != tc.more {
+			t.Errorf("offset %s of %d: got %d:%d (more %v), want %d:%d (more %v)", tc.offset, tc.n, start, end, pg.HasMore(tc.n), tc.start, tc.end, tc.more)
+		}
+	}
+}
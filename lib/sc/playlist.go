@@ -1,46 +1,74 @@
 package sc
 
 import (
-	"strconv"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/i18n"
 )
 
-var playlistsCache = map[string]cached[Playlist]{}
-var playlistsCacheLock = &sync.RWMutex{}
+var playlistsCache = NewStore[Playlist]("playlists", func(c *cfg.Config) time.Duration { return c.PlaylistTTL })
 
 // Functions/structures related to playlists
 
+const (
+	CategoryPlaylist = "playlist"
+	CategoryAlbum    = "album"   // also eps, singles and compilations (Type says which)
+	CategoryStation  = "station" // made by soundcloud: stations, mixes and charts (system playlists)
+)
+
 type Playlist struct {
-	Artwork      string `json:"artwork_url"`
-	CreatedAt    string `json:"created_at"`
-	Description  string `json:"description"`
-	Kind         string `json:"kind"` // should always be "playlist"!
-	LastModified string `json:"last_modified"`
-	Likes        int64  `json:"likes_count"`
-	Permalink    string `json:"permalink"`
-	//ReleaseDate  string  `json:"release_date"`
-	TagList    string   `json:"tag_list"`
-	Title      string   `json:"title"`
-	Type       string   `json:"set_type"`
-	Album      bool     `json:"is_album"`
-	Author     User     `json:"user"`
-	Tracks     []*Track `json:"tracks"`
-	TrackCount int64    `json:"track_count"`
+	Artwork      string   `json:"artwork_url"`
+	CreatedAt    string   `json:"created_at"`
+	Description  string   `json:"description"`
+	Kind         string   `json:"kind"` // should always be "playlist" (or "system-playlist")!
+	LastModified string   `json:"last_modified"`
+	Likes        int64    `json:"likes_count"`
+	Reposts      int64    `json:"reposts_count"`
+	Permalink    string   `json:"permalink"`
+	ReleaseDate  string   `json:"release_date"`
+	Sharing      string   `json:"sharing"` // public or private
+	TagList      string   `json:"tag_list"`
+	Title        string   `json:"title"`
+	Type         string   `json:"set_type"` // album, ep, single, compilation or empty
+	Album        bool     `json:"is_album"`
+	Author       User     `json:"user"`
+	Tracks       []*Track `json:"tracks"`
+	TrackCount   int64    `json:"track_count"`
+
+	Category string `json:"category"` // set in Fix, check the Category* constants
+	Secret   bool   `json:"secret"`   // set in Fix, private but reachable through a secret link
+
+	DurationMs   int64         `json:"duration"`      // total, in milliseconds
+	Duration     time.Duration `json:"-"`             // set in Fix
+	DurationText string        `json:"duration_text"` // set in Fix
 
 	MissingTracks string `json:"-"`
 }
 
 func GetPlaylist(permalink string) (Playlist, error) {
-	playlistsCacheLock.RLock()
-	if cell, ok := playlistsCache[permalink]; ok && cell.Expires.After(time.Now()) {
-		playlistsCacheLock.RUnlock()
-		return cell.Value, nil
+	p, err := getPlaylist(permalink)
+	if err != nil {
+		return p, err
+	}
+
+	if p.Blocked() {
+		return Playlist{}, ErrBlocked
+	}
+
+	p.hideBlocked()
+	return p, nil
+}
+
+func getPlaylist(permalink string) (Playlist, error) {
+	if p, ok := playlistsCache.Get(permalink); ok {
+		return p, nil
 	}
-	playlistsCacheLock.RUnlock()
 
 	var p Playlist
 	err := Resolve(permalink, &p)
@@ -48,7 +76,8 @@ func GetPlaylist(permalink string) (Playlist, error) {
 		return p, err
 	}
 
-	if p.Kind != "playlist" {
+	// system playlists are the charts/mixes linked from the discover page (/discover/sets/...)
+	if p.Kind != "playlist" && p.Kind != "system-playlist" {
 		return p, ErrKindNotCorrect
 	}
 
@@ -57,21 +86,131 @@ func GetPlaylist(permalink string) (Playlist, error) {
 		return p, err
 	}
 
-	playlistsCacheLock.Lock()
-	playlistsCache[permalink] = cached[Playlist]{Value: p, Expires: time.Now().Add(cfg.PlaylistTTL)}
-	playlistsCacheLock.Unlock()
+	playlistsCache.Set(permalink, p)
+
+	if p.MissingTracks != "" {
+		go prefetch(permalink, p)
+	}
 
 	return p, nil
 }
 
-func SearchPlaylists(args string) (*Paginated[*Playlist], error) {
-	cid, err := GetClientID()
+// permalinks of playlists being prefetched, so a playlist isn't prefetched twice at once
+var prefetching = map[string]bool{}
+var prefetchingLock = &sync.Mutex{}
+
+// resolves the rest of the tracks in the background (at most cfg.ResolveConcurrency batches at once) after the first page
+// every batch is stored in the cache entry as soon as it's done, so later pages don't have to wait for them
+func prefetch(permalink string, p Playlist) {
+	prefetchingLock.Lock()
+	if prefetching[permalink] {
+		prefetchingLock.Unlock()
+		return
+	}
+	prefetching[permalink] = true
+	prefetchingLock.Unlock()
+
+	defer func() {
+		prefetchingLock.Lock()
+		delete(prefetching, permalink)
+		prefetchingLock.Unlock()
+	}()
+
+	missing := []MissingTrack{}
+	for i, t := range p.Tracks {
+		if t.Title == "" && !t.Unavailable {
+			missing = append(missing, MissingTrack{ID: t.ID, Index: i})
+		}
+	}
+
+	sem := make(chan struct{}, cfg.Get().ResolveConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < len(missing); i += 50 {
+		batch := missing[i:min(i+50, len(missing))]
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			res, err := GetTracks(JoinMissingTracks(batch))
+			if err != nil {
+				// left for ResolveMore
+				return
+			}
+
+			storeResolved(permalink, batch, res)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// puts resolved tracks (and tombstones for the ones soundcloud didn't return) into the cached playlist
+// the tracks are copied, handlers might be reading the old ones
+func storeResolved(permalink string, batch []MissingTrack, res []*Track) {
+	resolved := make(map[string]*Track, len(res))
+	for _, t := range res {
+		resolved[t.ID] = t
+	}
+
+	// looked up before taking the lock of the playlists cache
+	tombstones := map[string]*Track{}
+	for _, m := range batch {
+		if _, ok := resolved[m.ID]; !ok {
+			tombstones[m.ID] = Tombstone(m.ID)
+		}
+	}
+
+	playlistsCache.Update(permalink, func(p *Playlist) {
+		tracks := slices.Clone(p.Tracks)
+		for _, m := range batch {
+			// the entry might have been refreshed in the meantime
+			if m.Index >= len(tracks) || tracks[m.Index].ID != m.ID {
+				continue
+			}
+
+			if t, ok := resolved[m.ID]; ok {
+				tracks[m.Index] = t
+			} else {
+				tracks[m.Index] = tombstones[m.ID]
+			}
+		}
+
+		rest := []MissingTrack{}
+		for i, t := range tracks {
+			if t.Title == "" && !t.Unavailable {
+				rest = append(rest, MissingTrack{ID: t.ID, Index: i})
+			}
+		}
+
+		p.Tracks = tracks
+		p.MissingTracks = JoinMissingTracks(rest)
+	})
+}
+
+func SearchPlaylists(q string, pg Page) (*Paginated[*Playlist], error) {
+	return searchPlaylists(q, nil, pg)
+}
+
+func searchPlaylists(q string, filters url.Values, pg Page) (*Paginated[*Playlist], error) {
+	params := searchParams(q, filters, pg)
+	p, err := cachedSearch("playlists", params, func() (*Paginated[*Playlist], error) {
+		return searchUncachedPlaylists(params, pg)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	p := Paginated[*Playlist]{Next: "https://" + api + "/search/playlists" + args + "&client_id=" + cid}
-	err = p.Proceed()
+	return pageWithoutBlocked(p), nil
+}
+
+func searchUncachedPlaylists(params url.Values, pg Page) (*Paginated[*Playlist], error) {
+	p := Paginated[*Playlist]{Next: newRequest(params, "search", "playlists").String(), Page: pg}
+	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
@@ -94,28 +233,71 @@ func (p *Playlist) Fix(cached bool) error {
 			return err
 		}
 
-		p.Artwork = strings.Replace(p.Artwork, "-large.", "-t500x500.", 1)
+		p.Artwork = fixArtwork(p.Artwork, true)
 	} else {
-		p.Artwork = strings.Replace(p.Artwork, "-large.", "-t200x200.", 1)
+		p.Artwork = fixArtwork(p.Artwork, false)
 	}
 
 	p.Author.Fix(false)
 
+	switch {
+	case p.Kind == "system-playlist":
+		p.Category = CategoryStation
+	case p.Album || p.Type != "":
+		p.Category = CategoryAlbum
+	default:
+		p.Category = CategoryPlaylist
+	}
+	p.Secret = p.Sharing == "private"
+
+	// system playlists don't have one, Tracks gets cut down to a single page later
+	if p.TrackCount == 0 {
+		p.TrackCount = int64(len(p.Tracks))
+	}
+
+	p.Title = sanitizeName(p.Title)
+	p.TagList = BuildTagList(TagListParser(sanitize(p.TagList, maxDescriptionLen, false))) // so api users get it in the form TagListParser understands
+	p.Description = sanitizeDescription(p.Description)
+
+	p.Duration = msToDuration(p.DurationMs)
+	if p.Duration == 0 {
+		p.Duration = TotalDuration(p.Tracks)
+	}
+	if p.Duration != 0 {
+		p.DurationText = FormatDuration(p.Duration)
+	}
+
 	return nil
 }
 
-func (p Playlist) FormatDescription() string {
+// year of ReleaseDate, 0 if it isn't set (playlists usually don't have one)
+func (p Playlist) ReleaseYear() int {
+	t, err := time.Parse(time.RFC3339, p.ReleaseDate)
+	if err != nil {
+		return 0
+	}
+
+	return t.Year()
+}
+
+func (p Playlist) FormatDescription(locale string) string {
 	desc := p.Description
 	if p.Description != "" {
 		desc += "\n\n"
 	}
 
-	desc += strconv.FormatInt(int64(len(p.Tracks)), 10) + " tracks"
-	desc += "\n" + strconv.FormatInt(p.Likes, 10) + " ❤️"
-	desc += "\nCreated: " + p.CreatedAt
-	desc += "\nLast modified: " + p.LastModified
+	desc += i18n.T(locale, "%s tracks", format.Number(p.TrackCount, locale))
+	if p.Duration != 0 {
+		desc += " | " + FormatDuration(p.Duration)
+	}
+	desc += "\n" + format.Number(p.Likes, locale) + " ❤️"
+	if p.ReleaseDate != "" {
+		desc += "\n" + i18n.T(locale, "Released: %s", format.Date(p.ReleaseDate, locale))
+	}
+	desc += "\n" + i18n.T(locale, "Created: %s", format.Date(p.CreatedAt, locale))
+	desc += "\n" + i18n.T(locale, "Last modified: %s", format.Date(p.LastModified, locale))
 	if len(p.TagList) != 0 {
-		desc += "\nTags: " + strings.Join(TagListParser(p.TagList), ", ")
+		desc += "\n" + i18n.T(locale, "Tags: %s", strings.Join(tagNames(p.Tags()), ", "))
 	}
 
 	return desc
@@ -146,21 +328,20 @@ func GetMissingTracks(missing []MissingTrack) (res []*Track, next []MissingTrack
 	return
 }
 
-func GetNextMissingTracks(raw string) (res []*Track, next []string, err error) {
-	missing := strings.Split(raw, ",")
-	if len(missing) > 50 {
-		next = missing[50:]
-		missing = missing[:50]
+// stub for a track which couldn't be resolved, with the title from the cache if it was there recently
+func Tombstone(id string) *Track {
+	t := &Track{ID: id, Unavailable: true}
+	if known, ok := tracksCache.ByIndex(id); ok && !known.Blocked() {
+		t.KnownTitle = known.Author.Username + " - " + known.Title
 	}
 
-	res, err = GetTracks(strings.Join(missing, ","))
-	return
+	return t
 }
 
 func (p *Playlist) GetMissingTracks() error {
 	missing := []MissingTrack{}
 	for i, track := range p.Tracks {
-		if track.Title == "" {
+		if track.Title == "" && !track.Unavailable {
 			//fmt.Println(track.ID)
 			missing = append(missing, MissingTrack{ID: track.ID, Index: i})
 		}
@@ -175,15 +356,78 @@ func (p *Playlist) GetMissingTracks() error {
 		return err
 	}
 
-	for _, oldTrack := range missing {
+	// the ones which were asked for, the rest is left for later (MissingTracks)
+	for _, oldTrack := range missing[:len(missing)-len(next)] {
+		found := false
 		for _, newTrack := range res {
 			if newTrack.ID == oldTrack.ID {
 				p.Tracks[oldTrack.Index] = newTrack
+				found = true
 			}
 		}
+
+		if !found {
+			p.Tracks[oldTrack.Index] = Tombstone(oldTrack.ID)
+		}
 	}
 
 	p.MissingTracks = JoinMissingTracks(next)
 
 	return nil
 }
+
+// resolves up to n of the tracks which are still stubs, in order, and updates MissingTracks
+// the tracks are copied first, so the cached playlist isn't changed
+func (p *Playlist) ResolveMore(n int) error {
+	missing := []MissingTrack{}
+	for i, t := range p.Tracks {
+		if len(missing) == n {
+			break
+		}
+
+		if t.Title == "" && !t.Unavailable {
+			missing = append(missing, MissingTrack{ID: t.ID, Index: i})
+		}
+	}
+
+	p.Tracks = slices.Clone(p.Tracks)
+	for len(missing) != 0 {
+		res, next, err := GetMissingTracks(missing)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range missing[:len(missing)-len(next)] {
+			i := slices.IndexFunc(res, func(t *Track) bool { return t.ID == m.ID })
+			if i == -1 {
+				p.Tracks[m.Index] = Tombstone(m.ID)
+			} else {
+				p.Tracks[m.Index] = res[i]
+			}
+		}
+
+		missing = next
+	}
+
+	rest := []MissingTrack{}
+	for i, t := range p.Tracks {
+		if t.Title == "" && !t.Unavailable {
+			rest = append(rest, MissingTrack{ID: t.ID, Index: i})
+		}
+	}
+	p.MissingTracks = JoinMissingTracks(rest)
+
+	return nil
+}
+
+// tombstones among the tracks, only resolved entries can be known to be unavailable
+func (p Playlist) Unavailable() int {
+	n := 0
+	for _, t := range p.Tracks {
+		if t.Unavailable {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,144 @@
+package sc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Everything a profile page shows, fetched at once. the user and its sections only depend on each other through the user id,
+// which is remembered for longer than the user itself: with a known id, the user and the sections are fetched concurrently
+
+type ProfileSection uint8
+
+const (
+	ProfileTracks ProfileSection = 1 << iota
+	ProfilePlaylists
+	ProfileAlbums
+	ProfileLikedPlaylists
+)
+
+// api calls in flight for a single profile
+const profileParallelism = 3
+
+// ids only change if the account is deleted, keeping them longer than cfg.UserTTL is fine
+var userIDs = NewStore[string]("user ids", func(*cfg.Config) time.Duration { return 24 * time.Hour })
+
+type Profile struct {
+	User           User
+	Tracks         *Paginated[Track]
+	Playlists      *Paginated[Playlist]
+	Albums         *Paginated[Playlist]
+	LikedPlaylists *Paginated[Playlist]
+
+	errors map[ProfileSection]error
+}
+
+// why a section is missing, nil if it loaded (or wasn't requested)
+func (p *Profile) Err(s ProfileSection) error {
+	return p.errors[s]
+}
+
+// the user is required: if it fails, so does everything. sections fail on their own (check Err)
+// the cursor is passed to every section, so only request one section when paginating
+// sections still waiting for their turn are skipped once the user fails, running ones can't be stopped since api calls don't take a context
+func FetchProfile(permalink string, sections ProfileSection, c Cursor) (*Profile, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &Profile{errors: map[ProfileSection]error{}}
+	sem := make(chan struct{}, profileParallelism)
+	wg := &sync.WaitGroup{}
+	lock := &sync.Mutex{}
+
+	id, known := userIDs.Get(permalink)
+
+	// closed once the user is there, sections wait on it if the id isn't known yet
+	ready := make(chan struct{})
+	var userErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ready)
+
+		sem <- struct{}{}
+		p.User, userErr = GetUser(permalink)
+		<-sem
+
+		if userErr != nil {
+			cancel()
+			return
+		}
+
+		userIDs.Set(permalink, p.User.ID)
+	}()
+
+	run := func(s ProfileSection, f func(u *User) error) {
+		if sections&s == 0 {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if !known {
+				<-ready
+				if userErr != nil {
+					return
+				}
+			}
+
+			var err error
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case sem <- struct{}{}:
+				u := &User{ID: id}
+				if !known {
+					u = &User{ID: p.User.ID}
+				}
+
+				err = f(u)
+				<-sem
+			}
+
+			if err != nil {
+				lock.Lock()
+				p.errors[s] = err
+				lock.Unlock()
+			}
+		}()
+	}
+
+	run(ProfileTracks, func(u *User) (err error) {
+		p.Tracks, err = u.GetTracks(c)
+		return
+	})
+	run(ProfilePlaylists, func(u *User) (err error) {
+		p.Playlists, err = u.GetPlaylists(c)
+		return
+	})
+	run(ProfileAlbums, func(u *User) (err error) {
+		p.Albums, err = u.GetAlbums(c)
+		return
+	})
+	run(ProfileLikedPlaylists, func(u *User) (err error) {
+		p.LikedPlaylists, err = u.GetLikedPlaylists(c)
+		return
+	})
+
+	wg.Wait()
+	if userErr != nil {
+		return nil, userErr
+	}
+
+	// the permalink belongs to someone else now, the sections are of the wrong user. the id is updated, so this only happens once
+	if known && id != p.User.ID {
+		return FetchProfile(permalink, sections, c)
+	}
+
+	return p, nil
+}
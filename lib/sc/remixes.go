@@ -0,0 +1,112 @@
+package sc
+
+import (
+	"time"
+
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Functions/structures related to remixes, soundcloud has no relation for them
+// candidates come from the related tracks and a search for the title, and are kept if their title looks like a remix of it
+
+var remixesCache = NewStore[[]*Track]("remixes", func(c *cfg.Config) time.Duration { return c.RemixesTTL })
+
+// shown on track pages
+const maxRemixes = 10
+
+var bracketed = regexp.MustCompile(`\s*[\(\[][^\)\]]*[\)\]]`)
+var featuring = regexp.MustCompile(`\s+(feat\.?|ft\.?|featuring)\s.*$`)
+
+var remixWords = []string{"remix", "edit", "bootleg", "flip", "rework", "vip", "mashup", "refix"}
+
+// the song name, without the artist ("Artist - Song"), featured artists and anything in brackets
+func baseTitle(title string) string {
+	title = strings.ToLower(title)
+	title = bracketed.ReplaceAllString(title, "")
+	if i := strings.LastIndex(title, " - "); i != -1 {
+		title = title[i+3:]
+	}
+
+	title = featuring.ReplaceAllString(title, "")
+	return strings.TrimSpace(title)
+}
+
+func remixWord(title string) string {
+	for _, w := range remixWords {
+		if strings.Contains(title, w) {
+			return w
+		}
+	}
+
+	return ""
+}
+
+// same song name, and a remix word the original doesn't have (so remixes of a remix still work)
+func isRemixOf(orig *Track, base string, t *Track) bool {
+	if t.ID == orig.ID || t.Title == "" {
+		return false
+	}
+
+	title := strings.ToLower(t.Title)
+	w := remixWord(title)
+	return w != "" && strings.Contains(title, base) && !strings.Contains(strings.ToLower(orig.Title), w)
+}
+
+func getRelated(id string) ([]*Track, error) {
+	p := Paginated[*Track]{Next: newRequest(url.Values{"limit": {"50"}}, "tracks", id, "related").String()}
+	err := p.Proceed()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range p.Collection {
+		t.Fix(false)
+	}
+
+	return p.Collection, nil
+}
+
+// most played first, at most maxRemixes. cached for cfg.RemixesTTL
+func (t Track) GetRemixes() ([]*Track, error) {
+	if res, ok := remixesCache.Get(t.ID); ok {
+		res, _ = withoutBlocked(res)
+		return res, nil
+	}
+
+	base := baseTitle(t.Title)
+	res := []*Track{}
+	if len(base) >= 3 { // anything shorter matches way too much
+		related, err := getRelated(t.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		search, err := SearchTracks(base+" remix", NewPage(50, 0))
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string]bool{}
+		for _, c := range append(related, search.Collection...) {
+			if !seen[c.ID] && isRemixOf(&t, base, c) {
+				seen[c.ID] = true
+				res = append(res, c)
+			}
+		}
+
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Played > res[j].Played })
+		if len(res) > maxRemixes {
+			res = res[:maxRemixes]
+		}
+	}
+
+	remixesCache.Set(t.ID, res)
+
+	res, _ = withoutBlocked(res)
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package sc
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Api urls are built from a path and url.Values instead of gluing strings together,
+// so everything gets escaped, there is exactly one "?" and callers can't sneak in params we set ourselves
+
+var ErrBadParams = errors.New("invalid query parameters")
+
+type request struct {
+	path  string
+	query url.Values
+}
+
+// segments are path escaped and joined: newRequest(nil, "users", id, "tracks") -> /users/<id>/tracks
+func newRequest(query url.Values, segments ...string) request {
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+
+	return request{path: "/" + strings.Join(segments, "/"), query: query}
+}
+
+// full url. used as Paginated.Next it shouldn't have the client id yet, proceed adds it
+func (r request) String() string {
+	if len(r.query) == 0 {
+		return api + r.path
+	}
+
+	return api + r.path + "?" + r.query.Encode()
+}
+
+// adds (or replaces) params in any url, also the ones soundcloud gives us (next_href, transcodings)
+func withParams(raw string, params url.Values) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	for key, vals := range params {
+		q[key] = vals
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// comma separated numeric ids, for /tracks?ids=
+func validIDs(ids string) bool {
+	if ids == "" {
+		return false
+	}
+
+	for _, id := range strings.Split(ids, ",") {
+		if id == "" || strings.Trim(id, "0123456789") != "" {
+			return false
+		}
+	}
+
+	return true
+}
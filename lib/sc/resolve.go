@@ -0,0 +1,174 @@
+package sc
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+type ResolveResult struct {
+	URL    string         `json:"url"` // as passed in
+	Kind   string         `json:"kind,omitempty"`
+	ID     string         `json:"id,omitempty"`
+	Entity map[string]any `json:"entity,omitempty"`
+	Err    error          `json:"-"`
+}
+
+type NormalizedURL struct {
+	URL         string        // what the resolve endpoint gets, without tracking params
+	Permalink   string        // like user/track, with the secret token if there is one (user/track/s-xxxxx)
+	SecretToken string        // s-xxxxx, for private tracks and playlists shared by link
+	Offset      time.Duration // from #t=1:23 or ?t=83, where the player should start
+}
+
+// turns links (with sharing params like ?si=, ?utm_source= and #t= offsets) and plain permalinks into something the resolve endpoint accepts
+func NormalizeURL(raw string) NormalizedURL {
+	var n NormalizedURL
+	raw = strings.TrimSpace(raw)
+	for _, h := range []string{"soundcloud.com/", "m.soundcloud.com/", "www.soundcloud.com/"} {
+		if strings.HasPrefix(raw, h) {
+			raw = "https://" + raw
+			break
+		}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		n.Permalink = strings.Trim(raw, "/")
+		n.URL = web + "/" + n.Permalink
+		return n
+	}
+
+	// fragments are like queries: #t=1:23
+	frag, _ := url.ParseQuery(u.Fragment)
+	query := u.Query()
+	n.Offset = ParseOffset(frag.Get("t"))
+	if n.Offset == 0 {
+		n.Offset = ParseOffset(query.Get("t"))
+	}
+
+	n.Permalink = strings.Trim(u.Path, "/")
+	if token := query.Get("secret_token"); token != "" && !strings.HasSuffix(n.Permalink, "/"+token) {
+		n.Permalink += "/" + token
+	}
+	if i := strings.LastIndexByte(n.Permalink, '/'); i != -1 && strings.HasPrefix(n.Permalink[i+1:], "s-") {
+		n.SecretToken = n.Permalink[i+1:]
+	}
+
+	if u.Host == "" {
+		n.URL = web + (&url.URL{Path: "/" + n.Permalink}).EscapedPath()
+		return n
+	}
+
+	host := u.Host
+	if host == "m.soundcloud.com" || host == "www.soundcloud.com" {
+		host = "soundcloud.com"
+	}
+	n.URL = (&url.URL{Scheme: "https", Host: host, Path: "/" + n.Permalink}).String()
+
+	return n
+}
+
+// 83, 1:23, 1:02:03 or 1m23s. 0 if it's none of those
+func ParseOffset(t string) time.Duration {
+	if t == "" {
+		return 0
+	}
+
+	if d, err := time.ParseDuration(t); err == nil && d > 0 {
+		return d
+	}
+
+	var res time.Duration
+	for _, part := range strings.Split(t, ":") {
+		v, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return 0
+		}
+
+		res = res*60 + time.Duration(v)*time.Second
+	}
+
+	return res
+}
+
+// resolves many urls concurrently (at most cfg.ResolveConcurrency at once), results are in the same order as urls
+func ResolveMany(urls []string) []ResolveResult {
+	res := make([]ResolveResult, len(urls))
+	sem := make(chan struct{}, cfg.Get().ResolveConcurrency)
+
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *ResolveResult, u string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			r.URL = u
+			r.Err = resolveURL(NormalizeURL(u).URL, &r.Entity)
+			if r.Err != nil {
+				r.Entity = nil
+				return
+			}
+
+			r.Kind, _ = r.Entity["kind"].(string)
+			if id, ok := r.Entity["id"].(float64); ok {
+				r.ID = strconv.FormatFloat(id, 'f', -1, 64)
+			}
+		}(&res[i], u)
+	}
+
+	wg.Wait()
+	return res
+}
+
+// the permalink (like user/sets/playlist, with the secret token if the link had one) of whatever a link points to
+// unlike NormalizeURL this asks soundcloud, so api links and renamed users/tracks end up in the right place
+func ResolvePath(raw string) (string, error) {
+	n := NormalizeURL(raw)
+	var e struct {
+		Kind      string `json:"kind"`
+		Permalink string `json:"permalink"`
+		URL       string `json:"permalink_url"`
+		User      struct {
+			Permalink string `json:"permalink"`
+		} `json:"user"`
+	}
+
+	err := resolveURL(n.URL, &e)
+	if err != nil {
+		return "", err
+	}
+
+	var p string
+	switch e.Kind {
+	case "user":
+		p = e.Permalink
+	case "track":
+		p = e.User.Permalink + "/" + e.Permalink
+	case "playlist":
+		p = e.User.Permalink + "/sets/" + e.Permalink
+	case "system-playlist": // stations and mixes, they aren't under a user (stations/track/<user>/<track>, discover/sets/<mix>)
+		u, err := url.Parse(e.URL)
+		if err != nil || strings.Trim(u.Path, "/") == "" {
+			return "", ErrKindNotCorrect
+		}
+
+		return strings.Trim(u.Path, "/"), nil
+	default:
+		return "", ErrKindNotCorrect
+	}
+
+	if n.SecretToken != "" {
+		p += "/" + n.SecretToken
+	}
+
+	return p, nil
+}
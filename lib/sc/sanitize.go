@@ -0,0 +1,90 @@
+package sc
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Cleaning up text from the api before it ends up in pages and rss feeds: control characters,
+// bidi overrides (one of them flips the rest of the page), double encoded utf-8 and absurdly long values
+
+// in runes
+const (
+	maxNameLen        = 200 // titles, usernames, genres
+	maxDescriptionLen = 10000
+)
+
+func bidiControl(r rune) bool {
+	return (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') || r == '\u200e' || r == '\u200f'
+}
+
+// "CafÃ©" -> "Café": utf-8 which was decoded as latin-1 and encoded again somewhere upstream
+// only touched if every rune fits in latin-1 and the bytes make valid utf-8 again, so it stays idempotent
+func fixMojibake(s string) string {
+	if !strings.ContainsAny(s, "ÃÂâ") {
+		return s
+	}
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return s
+		}
+
+		b = append(b, byte(r))
+	}
+
+	if !utf8.Valid(b) {
+		return s
+	}
+
+	return string(b)
+}
+
+// newlines and tabs are kept when multiline, everything else goes
+func sanitize(s string, max int, multiline bool) string {
+	if s == "" {
+		return s
+	}
+
+	s = norm.NFC.String(fixMojibake(strings.ToValidUTF8(s, "\ufffd")))
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			if multiline {
+				return r
+			}
+
+			return ' '
+		case r == '\r':
+			return -1
+		case unicode.IsControl(r) || bidiControl(r):
+			return -1
+		}
+
+		return r
+	}, s)
+
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-1]) + "…"
+	}
+
+	return s
+}
+
+// titles sometimes come with entities escaped once too often ("Tom &amp; Jerry"), templ escapes them again
+func sanitizeName(s string) string {
+	if strings.Contains(s, "&") && strings.Contains(s, ";") {
+		s = html.UnescapeString(s)
+	}
+
+	return strings.TrimSpace(sanitize(s, maxNameLen, false))
+}
+
+func sanitizeDescription(s string) string {
+	return sanitize(s, maxDescriptionLen, true)
+}
@@ -0,0 +1,160 @@
+package sc_test
+
+import (
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/sctest"
+)
+
+// lib/sc against the recorded responses in lib/sctest
+
+var srv *sctest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	srv, err = sctest.Start()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
+}
+
+func TestResolve(t *testing.T) {
+	res := sc.ResolveMany([]string{
+		"https://soundcloud.com/floppa",
+		"https://soundcloud.com/floppa/floppa-theme",
+		"https://soundcloud.com/floppa/sets/mix",
+		"https://soundcloud.com/floppa/does-not-exist",
+	})
+
+	for i, want := range []struct{ kind, id string }{
+		{"user", "2000001"},
+		{"track", "1000001"},
+		{"playlist", "3000001"},
+	} {
+		if res[i].Err != nil || res[i].Kind != want.kind || res[i].ID != want.id {
+			t.Errorf("%s: got %s %s (%v), want %s %s", res[i].URL, res[i].Kind, res[i].ID, res[i].Err, want.kind, want.id)
+		}
+	}
+
+	if res[3].Err == nil {
+		t.Errorf("%s: resolved to %s %s", res[3].URL, res[3].Kind, res[3].ID)
+	}
+
+	p, err := sc.ResolvePath("https://m.soundcloud.com/floppa/floppa-theme?si=abc")
+	if err != nil || p != "floppa/floppa-theme" {
+		t.Errorf("ResolvePath: got %q (%v)", p, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tracks, err := sc.SearchTracks("floppa", sc.NewPage(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks.Collection) == 0 {
+		t.Error("no tracks found")
+	}
+	for _, tr := range tracks.Collection {
+		if tr.Title == "" || tr.Author.Permalink == "" {
+			t.Errorf("incomplete track %+v", tr)
+		}
+	}
+
+	users, err := sc.SearchUsers("floppa", sc.NewPage(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users.Collection) == 0 || users.Collection[0].Permalink != "floppa" {
+		t.Errorf("got users %+v", users.Collection)
+	}
+
+	playlists, err := sc.SearchPlaylists("floppa", sc.NewPage(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playlists.Collection) == 0 {
+		t.Error("no playlists found")
+	}
+}
+
+func TestTracks(t *testing.T) {
+	tr, err := sc.GetTrack("floppa/floppa-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.ID != "1000001" || tr.Title != "Floppa Theme" || tr.Author.Permalink != "floppa" {
+		t.Errorf("got track %s %q by %s", tr.ID, tr.Title, tr.Author.Permalink)
+	}
+
+	tr, err = sc.GetTrackByID("1000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Permalink != "caracal-nights-remix" {
+		t.Errorf("GetTrackByID: got %s", tr.Permalink)
+	}
+
+	tracks, err := sc.GetTracks("1000001,1000003,1999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 {
+		t.Errorf("GetTracks: got %d tracks, want 2", len(tracks))
+	}
+
+	_, err = sc.GetTrack("floppa/does-not-exist")
+	if err == nil {
+		t.Error("missing track didn't fail")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	t.Cleanup(func() { cfg.Reload() }) // runs after the env is restored
+	t.Setenv("SOUNDCLOAK_BLOCKED_TRACKS", "1000003")
+	err := cfg.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := sc.GetUser("floppa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := u.GetTracks(sc.NewCursor(0, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range p.Collection {
+		if tr.ID == "1000003" {
+			t.Error("blocked track is on the profile")
+		}
+	}
+	if len(p.Collection) != 2 || p.Hidden != 1 {
+		t.Errorf("got %d tracks (%d hidden), want 2 (1 hidden)", len(p.Collection), p.Hidden)
+	}
+}
+
+func TestHLS(t *testing.T) {
+	tr, err := sc.GetTrack("floppa/floppa-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := tr.GetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stream, srv.URL) || !strings.HasSuffix(stream, ".m3u8") {
+		t.Errorf("expected a hls playlist from the test server, got %s", stream)
+	}
+}
@@ -0,0 +1,52 @@
+package sc
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Caching for the first page of search results, popular queries would hit the api over and over again otherwise
+
+// the pages are *Paginated[T] of different types, keyed by searchKey
+var searchCache = NewStore[any]("search", func(c *cfg.Config) time.Duration { return c.SearchTTL }).
+	Limit(func(c *cfg.Config) int { return c.SearchCacheSize })
+
+// returns the cache key for the search params, or "" if they shouldn't be cached (later pages)
+// the query is lowercased and whitespace is collapsed, so "Foo  Bar" and "foo bar" share an entry
+func searchKey(kind string, params url.Values) string {
+	if params.Has("offset") || params.Has("cursor") {
+		return ""
+	}
+
+	v := url.Values{}
+	for key, vals := range params {
+		v[key] = vals
+	}
+	v.Set("q", strings.Join(strings.Fields(strings.ToLower(v.Get("q"))), " "))
+
+	return kind + "?" + v.Encode() // Encode sorts by key, so the filter order doesn't matter
+}
+
+// serves the search from cache if possible, otherwise calls get and caches the result
+// the cached page is shared between requests, so don't modify it
+func cachedSearch[T any](kind string, params url.Values, get func() (*Paginated[T], error)) (*Paginated[T], error) {
+	key := searchKey(kind, params)
+	if key == "" || cfg.Get().SearchCacheSize == 0 {
+		return get()
+	}
+
+	if p, ok := searchCache.Get(key); ok {
+		return p.(*Paginated[T]), nil
+	}
+
+	p, err := get()
+	if err != nil {
+		return nil, err
+	}
+
+	searchCache.Set(key, p)
+	return p, nil
+}
@@ -0,0 +1,63 @@
+package sc
+
+import (
+	"strings"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Optional spam filter for track search results (cfg.SpamFilter), soundcloud search is full of bot uploads
+// it works on one page at a time, so a filtered page can have less than limit results
+
+func normalizeTitle(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
+
+// nobody follows the uploader and nobody played the track
+func lowReach(t *Track) bool {
+	return t.Played == 0 && t.Author.Followers == 0 && !t.Author.Verified
+}
+
+func spamKeyword(title string) bool {
+	title = strings.ToLower(title)
+	for _, kw := range cfg.Get().SpamKeywords {
+		if kw != "" && strings.Contains(title, strings.ToLower(kw)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copy of the page without spam, p itself can be a shared cached one so it's left alone
+func filterSpam(p *Paginated[*Track]) *Paginated[*Track] {
+	if !cfg.Get().SpamFilter {
+		return p
+	}
+
+	res := *p
+	res.Collection = make([]*Track, 0, len(p.Collection))
+
+	seen := map[string]bool{}
+	perUploader := map[string]int{}
+	for _, t := range p.Collection {
+		key := t.Author.ID + "/" + normalizeTitle(t.Title)
+		if seen[key] || spamKeyword(t.Title) {
+			res.Hidden++
+			continue
+		}
+		seen[key] = true
+
+		if lowReach(t) {
+			perUploader[t.Author.ID]++
+			if perUploader[t.Author.ID] > cfg.Get().SpamMaxPerUploader {
+				res.Hidden++
+				continue
+			}
+		}
+
+		res.Collection = append(res.Collection, t)
+	}
+
+	return &res
+}
@@ -0,0 +1,31 @@
+package sc
+
+import "strings"
+
+// Stations: queues soundcloud generates from a track or an artist (soundcloud.com/stations/track/<user>/<track>, /stations/artist/<user>)
+// they resolve to system playlists like the discover mixes, with the first batch of the queue as tracks
+
+// seed is "track/<user>/<track>" or "artist/<user>"
+func GetStation(seed string) (Playlist, error) {
+	parts := strings.Split(strings.Trim(seed, "/"), "/")
+	if !(len(parts) == 3 && parts[0] == "track") && !(len(parts) == 2 && parts[0] == "artist") {
+		return Playlist{}, ErrNoURL
+	}
+
+	p, err := GetPlaylist("stations/" + strings.Join(parts, "/"))
+	if err != nil {
+		return p, err
+	}
+
+	if p.Category != CategoryStation {
+		return Playlist{}, ErrKindNotCorrect
+	}
+
+	return p, nil
+}
+
+// where the station starts from: the track or user page
+func StationSeedPath(seed string) string {
+	_, path, _ := strings.Cut(strings.Trim(seed, "/"), "/")
+	return "/" + path
+}
@@ -0,0 +1,43 @@
+package sc
+
+import "net/url"
+
+// Functions/structures related to the "Following" feed (needs an oauth token)
+
+type StreamItem struct {
+	Type      string    `json:"type"` // track, track-repost, playlist, playlist-repost
+	CreatedAt string    `json:"created_at"`
+	User      User      `json:"user"` // who posted/reposted it
+	Track     *Track    `json:"track"`
+	Playlist  *Playlist `json:"playlist"`
+}
+
+func (i StreamItem) Repost() bool {
+	return i.Type == "track-repost" || i.Type == "playlist-repost"
+}
+
+// new uploads and reposts from users followed by the account of the configured oauth token
+func GetStream(c Cursor) (*Paginated[StreamItem], error) {
+	v := url.Values{}
+	c.params(v)
+
+	p := Paginated[StreamItem]{Next: newRequest(v, "stream").String()}
+	err := p.ProceedAuthenticated()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range p.Collection {
+		item := &p.Collection[i]
+		item.User.Fix(false)
+		if item.Track != nil {
+			item.Track.Fix(false)
+		}
+
+		if item.Playlist != nil {
+			item.Playlist.Fix(false)
+		}
+	}
+
+	return &p, nil
+}
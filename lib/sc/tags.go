@@ -0,0 +1,153 @@
+package sc
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Functions/structures related to tags and charts
+
+type ChartEntry struct {
+	Score float64 `json:"score"`
+	Track Track   `json:"track"`
+}
+
+type Tag struct {
+	Name string `json:"name"` // as the uploader wrote it
+	Key  string `json:"key"`  // lowercased, what tag pages search for
+}
+
+// the tag page (/tags/<tag>)
+func (t Tag) URL() string {
+	return "/tags/" + url.PathEscape(t.Key)
+}
+
+// a single tag (or genre), with the leading #s and extra whitespace gone
+func NewTag(raw string) Tag {
+	name := strings.Join(strings.Fields(strings.TrimLeft(raw, "#")), " ")
+	return Tag{Name: name, Key: strings.ToLower(name)}
+}
+
+// TagListParser, without empty entries and duplicates (case-insensitively, the first spelling wins)
+func ParseTags(taglist string) []Tag {
+	res := []Tag{}
+	seen := map[string]bool{}
+	for _, raw := range TagListParser(taglist) {
+		tag := NewTag(raw)
+		if tag.Key == "" || seen[tag.Key] {
+			continue
+		}
+
+		seen[tag.Key] = true
+		res = append(res, tag)
+	}
+
+	return res
+}
+
+func (t Track) Tags() []Tag {
+	return ParseTags(t.TagList)
+}
+
+func (p Playlist) Tags() []Tag {
+	return ParseTags(p.TagList)
+}
+
+// names only, for descriptions
+func tagNames(tags []Tag) []string {
+	res := make([]string, len(tags))
+	for i, t := range tags {
+		res[i] = t.Name
+	}
+
+	return res
+}
+
+var popularTagsCache cached[[]string]
+var popularTagsCacheLock = &sync.RWMutex{}
+
+// tracks with this genre or tag
+func SearchTag(tag string, pg Page) (*Paginated[*Track], error) {
+	return searchTracks("*", url.Values{"filter.genre_or_tag": {tag}}, pg)
+}
+
+func GetChart(kind string, genre string, c Cursor) (*Paginated[ChartEntry], error) {
+	v := url.Values{"kind": {kind}, "genre": {genre}}
+	c.params(v)
+
+	p := Paginated[ChartEntry]{Next: newRequest(v, "charts").String()}
+	err := p.Proceed()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range p.Collection {
+		p.Collection[i].Track.Fix(false)
+	}
+
+	return &p, nil
+}
+
+// most used genres/tags of the top tracks
+func PopularTags(n int) ([]string, error) {
+	popularTagsCacheLock.RLock()
+	if popularTagsCache.Expires.After(time.Now()) {
+		tags := popularTagsCache.Value
+		popularTagsCacheLock.RUnlock()
+		if len(tags) > n {
+			tags = tags[:n]
+		}
+		return tags, nil
+	}
+	popularTagsCacheLock.RUnlock()
+
+	chart, err := GetChart("top", "soundcloud:genres:all-music", NewCursor(100, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, e := range chart.Collection {
+		seen := map[string]bool{}
+		add := func(tag string) {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag != "" && !seen[tag] {
+				seen[tag] = true
+				counts[tag]++
+			}
+		}
+
+		add(e.Track.Genre)
+		for _, tag := range e.Track.Tags() {
+			add(tag.Key)
+		}
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if counts[tags[i]] == counts[tags[j]] {
+			return tags[i] < tags[j]
+		}
+
+		return counts[tags[i]] > counts[tags[j]]
+	})
+
+	popularTagsCacheLock.Lock()
+	popularTagsCache = cached[[]string]{Value: tags, Expires: time.Now().Add(cfg.Get().PopularTagsTTL)}
+	popularTagsCacheLock.Unlock()
+
+	if len(tags) > n {
+		tags = tags[:n]
+	}
+
+	return tags, nil
+}
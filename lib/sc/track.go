@@ -6,10 +6,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/i18n"
 	"github.com/valyala/fasthttp"
 )
 
@@ -18,8 +19,7 @@ import (
 var ErrIncompatibleStream = errors.New("incompatible stream")
 var ErrNoURL = errors.New("no url")
 
-var tracksCache = map[string]cached[Track]{}
-var tracksCacheLock = &sync.RWMutex{}
+var tracksCache = NewStore[Track]("tracks", func(c *cfg.Config) time.Duration { return c.TrackTTL }).indexBy(func(t Track) string { return t.ID })
 
 type Track struct {
 	Artwork     string `json:"artwork_url"`
@@ -38,10 +38,25 @@ type Track struct {
 	Title         string `json:"title"`
 	ID            string `json:"urn"`
 	Media         Media  `json:"media"`
+	Waveform      string `json:"waveform_url"` // json (or png) on wave.sndcdn.com, check lib/waveform
 	Authorization string `json:"track_authorization"`
 	Author        User   `json:"user"`
 
+	PublisherMetadata struct {
+		Explicit bool `json:"explicit"`
+	} `json:"publisher_metadata"`
+	Explicit bool `json:"explicit"` // set in Fix, the publisher flag or a guess from the title and tags
+
 	IDint int64 `json:"id"`
+
+	DurationMs   int64         `json:"duration"`      // in milliseconds, as returned by soundcloud
+	Duration     time.Duration `json:"-"`             // set in Fix
+	DurationText string        `json:"duration_text"` // set in Fix, like 3:05
+
+	// tombstone of a playlist entry soundcloud didn't return when resolving it (deleted or private)
+	// the title stays empty, KnownTitle is what it was called when we last saw it, if we know that
+	Unavailable bool   `json:"unavailable,omitempty"`
+	KnownTitle  string `json:"known_title,omitempty"`
 }
 
 type Protocol string
@@ -61,6 +76,9 @@ type Transcoding struct {
 	Preset  string `json:"preset"`
 	Format  Format `json:"format"`
 	Quality string `json:"quality"`
+
+	Duration int64 `json:"duration"` // in milliseconds
+	Snipped  bool  `json:"snipped"`  // only a preview (30 seconds usually)
 }
 
 type Media struct {
@@ -81,13 +99,108 @@ func (m Media) SelectCompatible() *Transcoding {
 	return nil
 }
 
+// like hls_mp3, hls_opus, hls_aac or progressive_mp3, used in cfg.AudioPreference
+func (t Transcoding) Name() string {
+	codec := "unknown"
+	switch {
+	case strings.HasPrefix(t.Format.MimeType, "audio/mpeg"):
+		codec = "mp3"
+	case strings.Contains(t.Format.MimeType, "opus"):
+		codec = "opus"
+	case strings.Contains(t.Format.MimeType, "mp4a"), strings.HasPrefix(t.Format.MimeType, "audio/mp4"):
+		codec = "aac"
+	}
+
+	return string(t.Format.Protocol) + "_" + codec
+}
+
+// first transcoding matching the preferences, entries are either full names (hls_opus) or just protocols (progressive)
+func (m Media) SelectByPreference(prefs []string) *Transcoding {
+	for _, p := range prefs {
+		for _, t := range m.Transcodings {
+			if t.Name() == p || string(t.Format.Protocol) == p {
+				return &t
+			}
+		}
+	}
+
+	return nil
+}
+
+// returns the transcoding with this preset and protocol, nil if there is none
+func (m Media) Find(preset string, protocol Protocol) *Transcoding {
+	for _, t := range m.Transcodings {
+		if t.Preset == preset && t.Format.Protocol == protocol {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+type StreamOption struct {
+	Protocol Protocol `json:"protocol"`
+	MimeType string   `json:"mime_type"`
+	Preset   string   `json:"preset"`
+	Quality  string   `json:"quality"`
+	Duration int64    `json:"duration"` // in milliseconds
+	Snipped  bool     `json:"snipped"`
+	Bitrate  int      `json:"bitrate"` // in kbps, guessed from the preset, 0 if unknown
+}
+
+// soundcloud doesn't tell us the bitrate, but the presets are always encoded the same way
+func guessBitrate(preset string) int {
+	switch {
+	case strings.HasPrefix(preset, "mp3_"):
+		return 128
+	case strings.HasPrefix(preset, "opus_"):
+		return 64
+	case strings.HasPrefix(preset, "aac_"):
+		// aac_160k, aac_256k etc
+		if i := strings.IndexByte(preset[4:], 'k'); i != -1 {
+			if n, err := strconv.Atoi(preset[4 : 4+i]); err == nil {
+				return n
+			}
+		}
+
+		// aac_1_0 is the hq one
+		return 256
+	}
+
+	return 0
+}
+
+// every available stream of the track, so clients can pick one themselves (use Media.Find and GetStreamFor to get it)
+func (t Track) Streams() []StreamOption {
+	res := make([]StreamOption, 0, len(t.Media.Transcodings))
+	for _, tr := range t.Media.Transcodings {
+		res = append(res, StreamOption{
+			Protocol: tr.Format.Protocol,
+			MimeType: tr.Format.MimeType,
+			Preset:   tr.Preset,
+			Quality:  tr.Quality,
+			Duration: tr.Duration,
+			Snipped:  tr.Snipped,
+			Bitrate:  guessBitrate(tr.Preset),
+		})
+	}
+
+	return res
+}
+
 func GetTrack(permalink string) (Track, error) {
-	tracksCacheLock.RLock()
-	if cell, ok := tracksCache[permalink]; ok && cell.Expires.After(time.Now()) {
-		tracksCacheLock.RUnlock()
-		return cell.Value, nil
+	t, err := getTrack(permalink)
+	if err == nil && t.Blocked() {
+		return Track{}, ErrBlocked
+	}
+
+	return t, err
+}
+
+func getTrack(permalink string) (Track, error) {
+	if t, ok := tracksCache.Get(permalink); ok {
+		return t, nil
 	}
-	tracksCacheLock.RUnlock()
 
 	var t Track
 	err := Resolve(permalink, &t)
@@ -101,9 +214,7 @@ func GetTrack(permalink string) (Track, error) {
 
 	t.Fix(true)
 
-	tracksCacheLock.Lock()
-	tracksCache[permalink] = cached[Track]{Value: t, Expires: time.Now().Add(cfg.TrackTTL)}
-	tracksCacheLock.Unlock()
+	tracksCache.Set(permalink, t)
 
 	return t, nil
 }
@@ -111,12 +222,17 @@ func GetTrack(permalink string) (Track, error) {
 // Currently supports:
 // http/https links:
 // - api.soundcloud.com/tracks/<id> (api-v2 subdomain also supported)
-// - soundcloud.com/<user>/<track>
+// - soundcloud.com/<user>/<track> (also m. and www., with sharing params or a secret token)
 //
 // plain permalink/id:
 // - <user>/<track>
 // - <id>
+// - soundcloud:tracks:<id> (urn, what Track.ID is)
 func GetArbitraryTrack(data string) (Track, error) {
+	if strings.HasPrefix(data, "soundcloud:tracks:") {
+		return GetTrackByID(data)
+	}
+
 	if len(data) > 8 && (data[:8] == "https://" || data[:7] == "http://") {
 		u, err := url.Parse(data)
 		if err == nil {
@@ -124,32 +240,23 @@ func GetArbitraryTrack(data string) (Track, error) {
 				return GetTrackByID(u.Path[8:])
 			}
 
-			if u.Host == "soundcloud.com" {
-				if len(u.Path) < 4 {
+			if u.Host == "soundcloud.com" || u.Host == "m.soundcloud.com" || u.Host == "www.soundcloud.com" {
+				n := NormalizeURL(data)
+				if len(n.Permalink) < 3 {
 					return Track{}, ErrNoURL
 				}
 
-				u.Path = u.Path[1:]
-				if u.Path[len(u.Path)-1] == '/' {
-					u.Path = u.Path[:len(u.Path)-1]
-				}
-
-				var n uint = 0
-				for _, c := range u.Path {
-					if c == '/' {
-						n++
-					}
-
-					if n == 2 {
-						return Track{}, ErrKindNotCorrect
-					}
+				// user/track, or user/track/s-xxxxx for private ones
+				segments := strings.Count(n.Permalink, "/")
+				if n.SecretToken != "" {
+					segments--
 				}
 
-				if n != 1 {
+				if segments != 1 {
 					return Track{}, ErrKindNotCorrect
 				}
 
-				return GetTrack(u.Path)
+				return GetTrack(n.Permalink)
 			}
 		} else {
 			return Track{}, err
@@ -195,14 +302,25 @@ func GetArbitraryTrack(data string) (Track, error) {
 	return Track{}, ErrKindNotCorrect
 }
 
-func SearchTracks(args string) (*Paginated[*Track], error) {
-	cid, err := GetClientID()
+func SearchTracks(q string, pg Page) (*Paginated[*Track], error) {
+	return searchTracks(q, nil, pg)
+}
+
+func searchTracks(q string, filters url.Values, pg Page) (*Paginated[*Track], error) {
+	params := searchParams(q, filters, pg)
+	p, err := cachedSearch("tracks", params, func() (*Paginated[*Track], error) {
+		return searchUncachedTracks(params, pg)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	p := Paginated[*Track]{Next: "https://" + api + "/search/tracks" + args + "&client_id=" + cid}
-	err = p.Proceed()
+	return filterSpam(pageWithoutBlocked(p)), nil
+}
+
+func searchUncachedTracks(params url.Values, pg Page) (*Paginated[*Track], error) {
+	p := Paginated[*Track]{Next: newRequest(params, "search", "tracks").String(), Page: pg}
+	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
@@ -215,6 +333,10 @@ func SearchTracks(args string) (*Paginated[*Track], error) {
 }
 
 func GetTracks(ids string) ([]*Track, error) {
+	if !validIDs(ids) {
+		return nil, ErrBadParams
+	}
+
 	cid, err := GetClientID()
 	if err != nil {
 		return nil, err
@@ -223,8 +345,8 @@ func GetTracks(ids string) ([]*Track, error) {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
-	req.SetRequestURI("https://" + api + "/tracks?ids=" + ids + "&client_id=" + cid)
-	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.SetRequestURI(newRequest(url.Values{"ids": {ids}, "client_id": {cid}}, "tracks").String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 	resp := fasthttp.AcquireResponse()
@@ -241,29 +363,47 @@ func GetTracks(ids string) ([]*Track, error) {
 	}
 
 	var res []*Track
+	checkSchema(data)
 	err = cfg.JSON.Unmarshal(data, &res)
 	for _, t := range res {
 		t.Fix(false)
 	}
+
+	// left out like deleted ones, callers treat missing tracks as unavailable
+	res, _ = withoutBlocked(res)
 	return res, err
 }
 
+// stream picked with cfg.AudioPreference, hls or progressive (check PreferredStream)
 func (t Track) GetStream() (string, error) {
-	cid, err := GetClientID()
-	if err != nil {
-		return "", err
-	}
+	return t.GetStreamFor(t.PreferredStream())
+}
+
+func (t Track) PreferredStream() *Transcoding {
+	return t.Media.SelectByPreference(cfg.Get().AudioPreference)
+}
 
-	tr := t.Media.SelectCompatible()
+// returns the url of the stream for a transcoding of this track (hls playlist or progressive file)
+func (t Track) GetStreamFor(tr *Transcoding) (string, error) {
 	if tr == nil {
 		return "", ErrIncompatibleStream
 	}
 
+	cid, err := GetClientID()
+	if err != nil {
+		return "", err
+	}
+
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
-	req.SetRequestURI(tr.URL + "?client_id=" + cid + "&track_authorization=" + t.Authorization)
-	req.Header.Set("User-Agent", cfg.UserAgent)
+	u, err := withParams(tr.URL, url.Values{"client_id": {cid}, "track_authorization": {t.Authorization}})
+	if err != nil {
+		return "", err
+	}
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 	resp := fasthttp.AcquireResponse()
@@ -297,11 +437,7 @@ func (t Track) GetStream() (string, error) {
 }
 
 func (t *Track) Fix(large bool) {
-	if large {
-		t.Artwork = strings.Replace(t.Artwork, "-large.", "-t500x500.", 1)
-	} else {
-		t.Artwork = strings.Replace(t.Artwork, "-large.", "-t200x200.", 1)
-	}
+	t.Artwork = fixArtwork(t.Artwork, large)
 	if t.ID == "" {
 		t.ID = strconv.FormatInt(t.IDint, 10)
 	} else {
@@ -309,49 +445,70 @@ func (t *Track) Fix(large bool) {
 		t.ID = ls[len(ls)-1]
 	}
 
+	t.Duration = msToDuration(t.DurationMs)
+	if t.Duration != 0 {
+		t.DurationText = FormatDuration(t.Duration)
+	}
+
+	t.Title = sanitizeName(t.Title)
+	t.Genre = sanitizeName(t.Genre)
+	t.TagList = BuildTagList(TagListParser(sanitize(t.TagList, maxDescriptionLen, false))) // so api users get it in the form TagListParser understands
+	t.Description = sanitizeDescription(t.Description)
+	t.Explicit = t.PublisherMetadata.Explicit || looksExplicit(t)
+
 	t.Author.Fix(false)
 }
 
-func (t Track) FormatDescription() string {
+// text for og:description, in the locale of the page (numbers, dates and labels)
+func (t Track) FormatDescription(locale string) string {
 	desc := t.Description
 	if t.Description != "" {
 		desc += "\n\n"
 	}
 
-	desc += strconv.FormatInt(t.Likes, 10) + " ❤️ | " + strconv.FormatInt(t.Played, 10) + " ▶️"
+	desc += format.Number(t.Likes, locale) + " ❤️ | " + format.Number(t.Played, locale) + " ▶️"
+	if t.Duration != 0 {
+		desc += "\n" + i18n.T(locale, "Duration: %s", FormatDuration(t.Duration))
+	}
 	if t.Genre != "" {
-		desc += "\nGenre: " + t.Genre
+		desc += "\n" + i18n.T(locale, "Genre: %s", t.Genre)
 	}
-	desc += "\nCreated: " + t.CreatedAt
-	desc += "\nLast modified: " + t.LastModified
+	desc += "\n" + i18n.T(locale, "Created: %s", format.Date(t.CreatedAt, locale))
+	desc += "\n" + i18n.T(locale, "Last modified: %s", format.Date(t.LastModified, locale))
 	if len(t.TagList) != 0 {
-		desc += "\nTags: " + strings.Join(TagListParser(t.TagList), ", ")
+		desc += "\n" + i18n.T(locale, "Tags: %s", strings.Join(tagNames(t.Tags()), ", "))
 	}
 
 	return desc
 }
 
 func GetTrackByID(id string) (Track, error) {
+	t, err := getTrackByID(id)
+	if err == nil && t.Blocked() {
+		return Track{}, ErrBlocked
+	}
+
+	return t, err
+}
+
+func getTrackByID(id string) (Track, error) {
 	cid, err := GetClientID()
 	if err != nil {
 		return Track{}, err
 	}
 
-	tracksCacheLock.RLock()
-	for _, cell := range tracksCache {
-		if cell.Value.ID == id && cell.Expires.After(time.Now()) {
-			tracksCacheLock.RUnlock()
-			return cell.Value, nil
-		}
+	if t, ok := tracksCache.ByIndex(id); ok {
+		tracksCache.counters.hits.Add(1)
+		return t, nil
 	}
-	tracksCacheLock.RUnlock()
+	tracksCache.counters.misses.Add(1)
 
 	var t Track
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req)
 
-	req.SetRequestURI("https://" + api + "/tracks/" + id + "?client_id=" + cid)
-	req.Header.Set("User-Agent", cfg.UserAgent)
+	req.SetRequestURI(newRequest(url.Values{"client_id": {cid}}, "tracks", id).String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
 	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
 
 	resp := fasthttp.AcquireResponse()
@@ -367,6 +524,7 @@ func GetTrackByID(id string) (Track, error) {
 		data = resp.Body()
 	}
 
+	checkSchema(data)
 	err = cfg.JSON.Unmarshal(data, &t)
 	if err != nil {
 		return t, err
@@ -378,9 +536,7 @@ func GetTrackByID(id string) (Track, error) {
 
 	t.Fix(true)
 
-	tracksCacheLock.Lock()
-	tracksCache[t.Author.Permalink+"/"+t.Permalink] = cached[Track]{Value: t, Expires: time.Now().Add(cfg.TrackTTL)}
-	tracksCacheLock.Unlock()
+	tracksCache.Set(t.Author.Permalink+"/"+t.Permalink, t)
 
 	return t, nil
 }
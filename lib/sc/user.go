@@ -1,18 +1,22 @@
 package sc
 
 import (
+	"time"
+
+	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/i18n"
+	"github.com/valyala/fasthttp"
 )
 
 // Functions/structures related to users
 
-var usersCache = map[string]cached[User]{}
-var usersCacheLock = &sync.RWMutex{}
+var usersCache = NewStore[User]("users", func(c *cfg.Config) time.Duration { return c.UserTTL })
 
 type User struct {
 	Avatar       string `json:"avatar_url"`
@@ -30,16 +34,61 @@ type User struct {
 	ID        string `json:"urn"`
 	Username  string `json:"username"`
 	Verified  bool   `json:"verified"`
+
+	Badges        Badges                `json:"badges"`
+	Subscription  CreatorSubscription   `json:"creator_subscription"`
+	Subscriptions []CreatorSubscription `json:"creator_subscriptions"`
+	Plan          string                `json:"plan"` // set in Fix, one of the Plan* constants or empty for free accounts
+}
+
+type Badges struct {
+	Pro            bool `json:"pro"`
+	ProUnlimited   bool `json:"pro_unlimited"`
+	CreatorMidTier bool `json:"creator_mid_tier"`
+	Verified       bool `json:"verified"`
+}
+
+type CreatorSubscription struct {
+	Product struct {
+		ID string `json:"id"` // free, creator-pro, creator-pro-unlimited, next-pro...
+	} `json:"product"`
+}
+
+// paid plans, the old pro ones still show up on accounts which kept them
+const (
+	PlanPro          = "pro"
+	PlanProUnlimited = "pro-unlimited"
+	PlanArtist       = "artist"
+	PlanArtistPro    = "artist-pro"
+)
+
+var planNames = map[string]string{
+	PlanPro:          "Pro",
+	PlanProUnlimited: "Pro Unlimited",
+	PlanArtist:       "Artist",
+	PlanArtistPro:    "Artist Pro",
+}
+
+var planProducts = map[string]string{
+	"creator-pro":           PlanPro,
+	"creator-pro-unlimited": PlanProUnlimited,
+	"next-mid":              PlanArtist,
+	"next-pro":              PlanArtistPro,
 }
 
 func GetUser(permalink string) (User, error) {
-	usersCacheLock.RLock()
-	if cell, ok := usersCache[permalink]; ok && cell.Expires.After(time.Now()) {
-		usersCacheLock.RUnlock()
-		return cell.Value, nil
+	u, err := getUser(permalink)
+	if err == nil && u.Blocked() {
+		return User{}, ErrBlocked
 	}
 
-	usersCacheLock.RUnlock()
+	return u, err
+}
+
+func getUser(permalink string) (User, error) {
+	if u, ok := usersCache.Get(permalink); ok {
+		return u, nil
+	}
 
 	var u User
 	err := Resolve(permalink, &u)
@@ -54,21 +103,30 @@ func GetUser(permalink string) (User, error) {
 
 	u.Fix(true)
 
-	usersCacheLock.Lock()
-	usersCache[permalink] = cached[User]{Value: u, Expires: time.Now().Add(cfg.UserTTL)}
-	usersCacheLock.Unlock()
+	usersCache.Set(permalink, u)
 
 	return u, err
 }
 
-func SearchUsers(args string) (*Paginated[*User], error) {
-	cid, err := GetClientID()
+func SearchUsers(q string, pg Page) (*Paginated[*User], error) {
+	return searchUsers(q, nil, pg)
+}
+
+func searchUsers(q string, filters url.Values, pg Page) (*Paginated[*User], error) {
+	params := searchParams(q, filters, pg)
+	p, err := cachedSearch("users", params, func() (*Paginated[*User], error) {
+		return searchUncachedUsers(params, pg)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	p := Paginated[*User]{Next: "https://" + api + "/search/users" + args + "&client_id=" + cid}
-	err = p.Proceed()
+	return pageWithoutBlocked(p), nil
+}
+
+func searchUncachedUsers(params url.Values, pg Page) (*Paginated[*User], error) {
+	p := Paginated[*User]{Next: newRequest(params, "search", "users").String(), Page: pg}
+	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
@@ -80,85 +138,209 @@ func SearchUsers(args string) (*Paginated[*User], error) {
 	return &p, nil
 }
 
-func (u User) GetTracks(args string) (*Paginated[Track], error) {
-	p := Paginated[Track]{
-		Next: "https://" + api + "/users/" + u.ID + "/tracks" + args,
-	}
+func (u User) GetTracks(c Cursor) (*Paginated[Track], error) {
+	v := url.Values{}
+	c.params(v)
 
+	p := Paginated[Track]{Next: newRequest(v, "users", u.ID, "tracks").String()}
 	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, u := range p.Collection {
-		u.Fix(false)
+	for i := range p.Collection {
+		p.Collection[i].Fix(false)
 	}
 
-	return &p, nil
+	return pageWithoutBlocked(&p), nil
+}
+
+// validators from a previous response, for conditional requests
+type Conditional struct {
+	ETag         string
+	LastModified string
+}
+
+// cheap check for new uploads: only the first page with a small limit, conditional if possible
+// when the api says nothing changed (304), notModified is true and tracks is empty
+func GetLatestTracks(userID string, limit int, cond Conditional) (tracks []Track, next Conditional, notModified bool, err error) {
+	cid, err := GetClientID()
+	if err != nil {
+		return nil, cond, false, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(newRequest(url.Values{"limit": {strconv.Itoa(limit)}, "client_id": {cid}}, "users", userID, "tracks").String())
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	if cond.ETag != "" {
+		req.Header.Set("If-None-Match", cond.ETag)
+	}
+	if cond.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cond.LastModified)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = DoWithRetry(req, resp)
+	if err != nil {
+		return nil, cond, false, err
+	}
+
+	if resp.StatusCode() == 304 {
+		return nil, cond, true, nil
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, cond, false, fmt.Errorf("getlatesttracks: got status code %d", resp.StatusCode())
+	}
+
+	data, err := resp.BodyUncompressed()
+	if err != nil {
+		data = resp.Body()
+	}
+
+	checkSchema(data)
+	var p Paginated[Track]
+	err = cfg.JSON.Unmarshal(data, &p)
+	if err != nil {
+		return nil, cond, false, err
+	}
+
+	for i := range p.Collection {
+		p.Collection[i].Fix(false)
+	}
+
+	next = Conditional{ETag: string(resp.Header.Peek("ETag")), LastModified: string(resp.Header.Peek("Last-Modified"))}
+	return p.Collection, next, false, nil
 }
 
-func (u User) FormatDescription() string {
+func (u User) FormatDescription(locale string) string {
 	desc := u.Description
 	if u.Description != "" {
 		desc += "\n\n"
 	}
 
-	desc += strconv.FormatInt(u.Followers, 10) + " followers | " + strconv.FormatInt(u.Following, 10) + " following"
-	desc += "\n" + strconv.FormatInt(u.Tracks, 10) + " tracks | " + strconv.FormatInt(u.Playlists, 10) + " playlists"
-	desc += "\nCreated: " + u.CreatedAt
-	desc += "\nLast modified: " + u.LastModified
+	desc += i18n.T(locale, "%s followers", format.Number(u.Followers, locale)) + " | " + i18n.T(locale, "%s following", format.Number(u.Following, locale))
+	desc += "\n" + i18n.T(locale, "%s tracks", format.Number(u.Tracks, locale)) + " | " + i18n.T(locale, "%s playlists", format.Number(u.Playlists, locale))
+	desc += "\n" + i18n.T(locale, "Created: %s", format.Date(u.CreatedAt, locale))
+	desc += "\n" + i18n.T(locale, "Last modified: %s", format.Date(u.LastModified, locale))
 
 	return desc
 }
 
+// shown like soundcloud does, "" for free accounts
+func (u User) PlanName() string {
+	return planNames[u.Plan]
+}
+
 func (u User) FormatUsername() string {
 	res := u.Username
 	if u.Verified {
 		res += " ☑️"
 	}
 
+	if u.Plan != "" {
+		res += " (" + u.PlanName() + ")"
+	}
+
 	return res
 }
 
 func (u *User) Fix(large bool) {
-	if large {
-		u.Avatar = strings.Replace(u.Avatar, "-large.", "-t500x500.", 1)
-	} else {
-		u.Avatar = strings.Replace(u.Avatar, "-large.", "-t200x200.", 1)
-	}
+	u.Avatar = fixArtwork(u.Avatar, large)
 	ls := strings.Split(u.ID, ":")
 	u.ID = ls[len(ls)-1]
+
+	u.Username = sanitizeName(u.Username)
+	u.FullName = sanitizeName(u.FullName)
+	u.Description = sanitizeDescription(u.Description)
+
+	u.Verified = u.Verified || u.Badges.Verified
+	u.Plan = plan(u)
 }
 
-func (u *User) GetPlaylists(args string) (*Paginated[Playlist], error) {
-	p := Paginated[Playlist]{
-		Next: "https://" + api + "/users/" + u.ID + "/playlists_without_albums" + args,
+// the subscription if there is one, the badges otherwise (stubs of users in listings only have those)
+func plan(u *User) string {
+	for _, s := range append([]CreatorSubscription{u.Subscription}, u.Subscriptions...) {
+		if p, ok := planProducts[s.Product.ID]; ok {
+			return p
+		}
+	}
+
+	switch {
+	case u.Badges.ProUnlimited:
+		return PlanProUnlimited
+	case u.Badges.Pro:
+		return PlanPro
+	case u.Badges.CreatorMidTier:
+		return PlanArtist
 	}
 
+	return ""
+}
+
+func (u *User) GetPlaylists(c Cursor) (*Paginated[Playlist], error) {
+	v := url.Values{}
+	c.params(v)
+
+	p := Paginated[Playlist]{Next: newRequest(v, "users", u.ID, "playlists_without_albums").String()}
 	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pl := range p.Collection {
-		pl.Fix(false)
+	for i := range p.Collection {
+		p.Collection[i].Fix(false)
 	}
 
 	return &p, nil
 }
 
-func (u *User) GetAlbums(args string) (*Paginated[Playlist], error) {
-	p := Paginated[Playlist]{
-		Next: "https://" + api + "/users/" + u.ID + "/albums" + args,
+type playlistLike struct {
+	Type     string   `json:"type"` // playlist-like, playlist (owned) or system-playlist-like
+	Playlist Playlist `json:"playlist"`
+}
+
+// playlists (and albums) the user liked, their own ones are filtered out so a page can have less than limit entries
+func (u *User) GetLikedPlaylists(c Cursor) (*Paginated[Playlist], error) {
+	v := url.Values{}
+	c.params(v)
+
+	p := Paginated[playlistLike]{Next: newRequest(v, "users", u.ID, "playlists", "liked_and_owned").String()}
+	err := p.Proceed()
+	if err != nil {
+		return nil, err
+	}
+
+	res := Paginated[Playlist]{Total: p.Total, Next: p.Next, Collection: make([]Playlist, 0, len(p.Collection))}
+	for _, l := range p.Collection {
+		if l.Type != "playlist-like" || l.Playlist.Title == "" {
+			continue
+		}
+
+		l.Playlist.Fix(false)
+		res.Collection = append(res.Collection, l.Playlist)
 	}
 
+	return &res, nil
+}
+
+func (u *User) GetAlbums(c Cursor) (*Paginated[Playlist], error) {
+	v := url.Values{}
+	c.params(v)
+
+	p := Paginated[Playlist]{Next: newRequest(v, "users", u.ID, "albums").String()}
 	err := p.Proceed()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pl := range p.Collection {
-		pl.Fix(false)
+	for i := range p.Collection {
+		p.Collection[i].Fix(false)
 	}
 
 	return &p, nil
@@ -0,0 +1 @@
+(self.webpackChunk=self.webpackChunk||[]).push([[0],{}]);
@@ -0,0 +1 @@
+(self.webpackChunk=self.webpackChunk||[]).push([[1],{1:function(e,t,n){n.p+="?"+("client_id=sctestsctestsctestsctestsctest12")}}]);
@@ -0,0 +1,249 @@
+package sctest
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Local stand-in for soundcloud, for testing lib/sc (and everything on top of it) without the network
+// it serves recorded api-v2 responses from fixtures/: the client id page and scripts, resolve, search,
+// tracks by id, user tracks, related tracks, stream urls and a small hls playlist
+//
+//	srv, err := sctest.Start()
+//	defer srv.Close()
+//	t, err := sc.GetTrack("floppa/floppa-theme")
+//
+// "{{base}}" in fixtures is replaced with the url of the server, so transcodings and segments point back at it
+
+// the client id in fixtures/web, api requests without it get a 401 like on soundcloud
+const ClientID = "sctestsctestsctestsctestsctest12"
+
+//go:embed fixtures
+var fixtures embed.FS
+
+type Server struct {
+	URL string
+
+	// every request which got here, including 404s
+	Requests atomic.Int64
+
+	ln  net.Listener
+	srv *fasthttp.Server
+
+	byPermalink map[string]string // resolve: "floppa/floppa-theme" -> fixture path
+	byID        map[string]string // "1000001" -> fixture path, only tracks
+	byUser      map[string][]string
+}
+
+type entity struct {
+	Kind      string `json:"kind"`
+	Permalink string `json:"permalink"`
+	ID        int64  `json:"id"`
+	User      struct {
+		Permalink string `json:"permalink"`
+		ID        int64  `json:"id"`
+	} `json:"user"`
+}
+
+func (s *Server) index() error {
+	s.byPermalink = map[string]string{}
+	s.byID = map[string]string{}
+	s.byUser = map[string][]string{}
+
+	return fs.WalkDir(fixtures, "fixtures", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) != ".json" || strings.HasPrefix(p, "fixtures/search/") {
+			return err
+		}
+
+		data, err := fixtures.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		var e entity
+		err = cfg.JSON.Unmarshal(data, &e)
+		if err != nil {
+			return err
+		}
+
+		switch e.Kind {
+		case "user":
+			s.byPermalink[e.Permalink] = p
+		case "track":
+			s.byPermalink[e.User.Permalink+"/"+e.Permalink] = p
+			id := strconv.FormatInt(e.ID, 10)
+			s.byID[id] = p
+			s.byUser[strconv.FormatInt(e.User.ID, 10)] = append(s.byUser[strconv.FormatInt(e.User.ID, 10)], p)
+		case "playlist", "system-playlist":
+			s.byPermalink[e.User.Permalink+"/sets/"+e.Permalink] = p
+		}
+
+		return nil
+	})
+}
+
+// fixture with {{base}} filled in
+func (s *Server) read(p string) ([]byte, bool) {
+	data, err := fixtures.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+
+	return bytes.ReplaceAll(data, []byte("{{base}}"), []byte(s.URL)), true
+}
+
+func (s *Server) send(ctx *fasthttp.RequestCtx, p string, ct string) {
+	data, ok := s.read(p)
+	if !ok {
+		ctx.Error("not found", fasthttp.StatusNotFound)
+		return
+	}
+
+	ctx.SetContentType(ct)
+	ctx.SetBody(data)
+}
+
+// json array of fixtures, for tracks?ids= and similar
+func (s *Server) sendList(ctx *fasthttp.RequestCtx, paths []string, paginated bool) {
+	var b bytes.Buffer
+	if paginated {
+		b.WriteString(`{"collection":`)
+	}
+	b.WriteByte('[')
+	for i, p := range paths {
+		data, _ := s.read(p)
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.Write(bytes.TrimSpace(data))
+	}
+	b.WriteByte(']')
+	if paginated {
+		b.WriteString(`,"next_href":null}`)
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetBody(b.Bytes())
+}
+
+func (s *Server) handle(ctx *fasthttp.RequestCtx) {
+	s.Requests.Add(1)
+
+	p := string(ctx.Path())
+	args := ctx.QueryArgs()
+
+	// the web side, for the client id
+	switch {
+	case p == "/h":
+		s.send(ctx, "fixtures/web/h.html", "text/html; charset=utf-8")
+		return
+	case strings.HasPrefix(p, "/assets/"):
+		s.send(ctx, "fixtures/web/"+path.Base(p), "application/javascript")
+		return
+	case strings.HasPrefix(p, "/hls/"):
+		ct := "audio/mpeg"
+		if path.Ext(p) == ".m3u8" {
+			ct = "application/vnd.apple.mpegurl"
+		}
+		s.send(ctx, "fixtures/hls/"+path.Base(p), ct)
+		return
+	}
+
+	// the api
+	if string(args.Peek("client_id")) != ClientID {
+		ctx.Error(`{"error":"invalid client id"}`, fasthttp.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case p == "/resolve":
+		u, err := url.Parse(string(args.Peek("url")))
+		if err != nil {
+			break
+		}
+
+		if f, ok := s.byPermalink[strings.Trim(u.Path, "/")]; ok {
+			s.send(ctx, f, "application/json")
+			return
+		}
+	case len(parts) == 2 && parts[0] == "search":
+		s.send(ctx, "fixtures/search/"+parts[1]+".json", "application/json")
+		return
+	case p == "/tracks":
+		var found []string
+		for _, id := range strings.Split(string(args.Peek("ids")), ",") {
+			if f, ok := s.byID[id]; ok {
+				found = append(found, f)
+			}
+		}
+		s.sendList(ctx, found, false)
+		return
+	case len(parts) == 2 && parts[0] == "tracks":
+		if f, ok := s.byID[parts[1]]; ok {
+			s.send(ctx, f, "application/json")
+			return
+		}
+	case len(parts) == 3 && parts[0] == "tracks" && parts[2] == "related":
+		s.send(ctx, "fixtures/search/tracks.json", "application/json")
+		return
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "tracks":
+		s.sendList(ctx, s.byUser[parts[1]], true)
+		return
+	case parts[0] == "media" && len(parts) > 2:
+		// /media/soundcloud:tracks:<id>/<hash>/stream/<protocol>
+		u := s.URL + "/hls/playlist.m3u8"
+		if parts[len(parts)-1] == "progressive" {
+			u = s.URL + "/hls/0.mp3"
+		}
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString(`{"url":"` + u + `"}`)
+		return
+	}
+
+	ctx.Error(`{"error":"not found"}`, fasthttp.StatusNotFound)
+}
+
+// listens on a random local port and points lib/sc at it (sc.SetBaseURLs), until Close
+func Start() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{URL: "http://" + ln.Addr().String(), ln: ln}
+	err = s.index()
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s.srv = &fasthttp.Server{Handler: s.handle, Name: "sctest"}
+	go s.srv.Serve(ln)
+
+	err = sc.SetBaseURLs(s.URL, s.URL)
+	if err != nil {
+		s.srv.Shutdown()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// stops the server and points lib/sc back at the configured urls
+func (s *Server) Close() error {
+	err := s.srv.Shutdown()
+	sc.SetBaseURLs(cfg.Get().SoundcloudAPI, cfg.Get().SoundcloudWeb)
+	return err
+}
@@ -0,0 +1,52 @@
+package sections
+
+import (
+	"log"
+	"sync"
+
+	"github.com/maid-zone/soundcloak/lib/sc"
+)
+
+// Pages made of independent parts (the stream and remixes of a track, the tabs of a user...)
+// every section is fetched at the same time and can fail on its own, the page renders what worked
+// and the templates show a notice where something didn't (check templates.SectionFailed)
+
+type Group struct {
+	wg sync.WaitGroup
+}
+
+type Section[T any] struct {
+	Value T
+	Err   error
+}
+
+func (s *Section[T]) Failed() bool {
+	return s.Err != nil
+}
+
+// starts fetching a section, Value and Err are set after g.Wait. name is only for the log
+func Fetch[T any](g *Group, name string, f func() (T, error)) *Section[T] {
+	s := &Section[T]{}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		v, err := f()
+		*s = *From(name, v, err)
+	}()
+
+	return s
+}
+
+// a section fetched some other way (like sc.FetchProfile), logged the same
+func From[T any](name string, v T, err error) *Section[T] {
+	if err != nil && err != sc.ErrBadParams {
+		log.Printf("error getting %s: %s\n", name, err)
+	}
+
+	return &Section[T]{Value: v, Err: err}
+}
+
+// blocks until every section is done
+func (g *Group) Wait() {
+	g.wg.Wait()
+}
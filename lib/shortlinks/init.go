@@ -0,0 +1,56 @@
+package shortlinks
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/export"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/ratelimit"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+func Load(r fiber.Router) {
+	r.Post("/s", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableShortLinks {
+			return fiber.ErrNotFound
+		}
+
+		return c.Next()
+	}, csrf.Check, ratelimit.Writes.Handler, func(c *fiber.Ctx) error {
+		offset, _ := strconv.Atoi(c.FormValue("t"))
+		l, err := Create(c.FormValue("path"), offset, c.FormValue("in"))
+		if err != nil {
+			if err == ErrInvalid {
+				return fiber.ErrBadRequest
+			}
+
+			log.Printf("error creating short link: %s\n", err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("short link", templates.ShortLink(export.BaseURL(c)+"/s/"+l.Code, l.URL()), nil).Render(preferences.Context(c), c)
+	})
+
+	r.Get("/s/:code", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableShortLinks {
+			return c.Next()
+		}
+
+		l, err := Get(c.Params("code"))
+		if err != nil {
+			if err == ErrNotFound {
+				return c.Next() // a track of a user called s
+			}
+
+			log.Printf("error getting short link %s: %s\n", c.Params("code"), err)
+			return err
+		}
+
+		return c.Redirect(l.URL())
+	})
+}
@@ -0,0 +1,153 @@
+package shortlinks
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Short links (/s/<code>) for pages of this instance, stored as json files in cfg.DataDir/shortlinks
+// the code is a hash of where the link goes, so sharing the same thing twice gives the same link
+//
+// not sqlite: there is no sqlite driver in our dependencies (the usual one needs cgo), and a link is only ever
+// looked up by its code, so one file per link works like favorites and local playlists. creating them is rate limited
+
+var ErrNotFound = errors.New("short link not found")
+var ErrInvalid = errors.New("not a track or playlist")
+
+const codeLength = 8
+
+type Link struct {
+	Code    string    `json:"code"`
+	Path    string    `json:"path"`             // user/track, user/sets/playlist or playlists/<id>
+	Offset  int       `json:"offset,omitempty"` // seconds, where the player starts (tracks)
+	From    string    `json:"from,omitempty"`   // the playlist a track was played from (check ValidPlaylistPath)
+	Created time.Time `json:"created"`
+}
+
+// where the link goes
+func (l Link) URL() string {
+	q := url.Values{}
+	if l.Offset > 0 {
+		q.Set("t", strconv.Itoa(l.Offset))
+	}
+	if l.From != "" {
+		q.Set("in", l.From)
+	}
+
+	if len(q) == 0 {
+		return "/" + l.Path
+	}
+
+	return "/" + l.Path + "?" + q.Encode()
+}
+
+func dir() string {
+	return filepath.Join(cfg.Get().DataDir, "shortlinks")
+}
+
+func code(l Link) string {
+	sum := sha256.Sum256([]byte(l.Path + "|" + strconv.Itoa(l.Offset) + "|" + l.From))
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:codeLength]
+}
+
+func validCode(c string) bool {
+	if len(c) != codeLength {
+		return false
+	}
+
+	for _, r := range c {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validPart(p string) bool {
+	return p != "" && p != "." && p != ".." && !strings.ContainsAny(p, "?#\\")
+}
+
+// a playlist on soundcloud (user/sets/playlist) or a local one (playlists/<id>)
+func ValidPlaylistPath(p string) bool {
+	parts := strings.Split(p, "/")
+	if len(parts) == 2 && parts[0] == "playlists" {
+		return validPart(parts[1])
+	}
+
+	return len(parts) == 3 && parts[1] == "sets" && validPart(parts[0]) && validPart(parts[2])
+}
+
+// what short links can point at: a track (user/track) or a playlist
+func validPath(p string) bool {
+	parts := strings.Split(p, "/")
+	if len(parts) == 2 && parts[0] != "playlists" && parts[1] != "sets" {
+		return validPart(parts[0]) && validPart(parts[1])
+	}
+
+	return ValidPlaylistPath(p)
+}
+
+func save(l Link) error {
+	data, err := cfg.JSON.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(filepath.Join(dir(), l.Code+".json"), data, 0o755)
+}
+
+// the existing link if the same thing was shortened before
+func Create(path string, offset int, from string) (Link, error) {
+	path = strings.Trim(path, "/")
+	from = strings.Trim(from, "/")
+	if !validPath(path) || (from != "" && !ValidPlaylistPath(from)) {
+		return Link{}, ErrInvalid
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	l := Link{Path: path, Offset: offset, From: from}
+	l.Code = code(l)
+	if existing, err := Get(l.Code); err == nil {
+		if existing.Path == l.Path && existing.Offset == l.Offset && existing.From == l.From {
+			return existing, nil
+		}
+
+		return Link{}, errors.New("short link collision for " + l.Code)
+	}
+
+	l.Created = time.Now().UTC()
+	return l, save(l)
+}
+
+func Get(code string) (Link, error) {
+	if !validCode(code) {
+		return Link{}, ErrNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir(), code+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Link{}, ErrNotFound
+		}
+
+		return Link{}, err
+	}
+
+	var l Link
+	err = cfg.JSON.Unmarshal(data, &l)
+	return l, err
+}
@@ -0,0 +1,58 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Signed, expiring urls for the stream and image proxies (cfg.ProxySecret), so nobody else can use the instance as a cdn proxy
+// only the upstream url (and the expiry) is signed, extra params like t or start can be added later
+
+func Enabled() bool {
+	return cfg.Get().ProxySecret != ""
+}
+
+func sign(target string, exp string) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Get().ProxySecret))
+	mac.Write([]byte(target + "|" + exp))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// the expiry only moves once per cfg.ProxyURLTTL, so the same image keeps the same url for a while and browsers can cache it
+// links are valid for at least cfg.ProxyURLTTL after they were made
+func expiry() string {
+	return strconv.FormatInt(time.Now().Truncate(cfg.Get().ProxyURLTTL).Add(2*cfg.Get().ProxyURLTTL).Unix(), 10)
+}
+
+// query for proxying target: url=...&exp=...&sig=... (just url=... with signing turned off)
+func Query(target string) string {
+	q := "url=" + url.QueryEscape(target)
+	if !Enabled() {
+		return q
+	}
+
+	exp := expiry()
+	return q + "&exp=" + exp + "&sig=" + sign(target, exp)
+}
+
+// checks the signature of a request made with a Query url, always true with signing turned off
+func Valid(c *fiber.Ctx) bool {
+	if !Enabled() {
+		return true
+	}
+
+	exp := c.Query("exp")
+	e, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > e {
+		return false
+	}
+
+	return hmac.Equal([]byte(c.Query("sig")), []byte(sign(c.Query("url"), exp)))
+}
@@ -0,0 +1,34 @@
+package themes
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+)
+
+// Built-in color palettes (defined in assets/global.css as [data-theme=...]) and custom css from the config
+
+// "system" follows the light/dark preference of the browser
+var names = []string{"dark", "light", "black", "system"}
+
+func Names() []string {
+	return names
+}
+
+func Valid(name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Load(r fiber.Router) {
+	// always served (empty by default), so static pages can link it too
+	r.Get("/_/custom.css", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/css; charset=utf-8")
+		c.Set("Cache-Control", "public, max-age=300")
+		return c.SendString(cfg.Get().CustomCSS)
+	})
+}
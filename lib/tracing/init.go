@@ -0,0 +1,385 @@
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"net/netip"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/valyala/fasthttp"
+)
+
+// Optional request tracing (cfg.TracingEndpoint), exported to an opentelemetry collector as otlp/http json
+// lib/sc doesn't pass contexts around, so the span a handler is in is tracked per goroutine:
+// everything started on the goroutine of a request ends up in its trace, work in other goroutines gets its own
+
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+)
+
+type Span struct {
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	kind    int
+	name    string
+	start   time.Time
+	end     time.Time
+	attrs   map[string]string
+	err     string
+
+	gid    uint64
+	prev   *Span // what was current on the goroutine before this one
+	active bool  // false for spans of unsampled traces, they're only kept to pass that on
+}
+
+var current = map[uint64]*Span{}
+var currentLock = &sync.Mutex{}
+
+var queue = make(chan *Span, 4096)
+var startOnce = &sync.Once{}
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+}
+
+func Enabled() bool {
+	return cfg.Get().TracingEndpoint != ""
+}
+
+// from the first line of runtime.Stack: "goroutine 123 [running]:"
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i != -1 {
+		b = b[:i]
+	}
+
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+func newSpan(name string, kind int, attrs []string) *Span {
+	s := &Span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]string, len(attrs)/2), gid: goroutineID()}
+	rand.Read(s.id[:])
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+
+	return s
+}
+
+func (s *Span) enter() {
+	currentLock.Lock()
+	s.prev = current[s.gid]
+	current[s.gid] = s
+	currentLock.Unlock()
+}
+
+// Start begins a span under the one currently running on this goroutine, attrs are key/value pairs
+// nil when tracing is off, every method works on nil
+func Start(name string, attrs ...string) *Span {
+	return start(name, kindInternal, attrs)
+}
+
+// same, for outgoing requests
+func StartClient(name string, attrs ...string) *Span {
+	return start(name, kindClient, attrs)
+}
+
+func start(name string, kind int, attrs []string) *Span {
+	if !Enabled() {
+		return nil
+	}
+
+	s := newSpan(name, kind, attrs)
+	currentLock.Lock()
+	parent := current[s.gid]
+	currentLock.Unlock()
+
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parent = parent.id
+		s.active = parent.active
+	} else {
+		rand.Read(s.traceID[:])
+		s.active = sampled()
+	}
+
+	s.enter()
+	return s
+}
+
+func sampled() bool {
+	return mrand.Intn(100) < cfg.Get().TracingSamplePercent
+}
+
+func (s *Span) Set(key string, value string) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// marks the span as failed, nil errors are ignored
+func (s *Span) Error(err error) {
+	if s != nil && err != nil {
+		s.err = err.Error()
+	}
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	currentLock.Lock()
+	if current[s.gid] == s {
+		if s.prev != nil {
+			current[s.gid] = s.prev
+		} else {
+			delete(current, s.gid)
+		}
+	}
+	currentLock.Unlock()
+
+	if !s.active {
+		return
+	}
+
+	startOnce.Do(func() { go exporter() })
+	select {
+	case queue <- s:
+	default: // collector too slow, drop it
+	}
+}
+
+// w3c trace context: 00-<trace id>-<parent id>-<flags>
+func parseTraceparent(h string) (traceID [16]byte, parent [8]byte, flagged bool, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parent[:], []byte(parts[2])); err != nil {
+		return
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return
+	}
+
+	return traceID, parent, flags[0]&1 == 1, true
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type status struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []attribute `json:"attributes,omitempty"`
+	Status       *status     `json:"status,omitempty"`
+}
+
+func attributes(m map[string]string) []attribute {
+	res := make([]attribute, 0, len(m))
+	for k, v := range m {
+		a := attribute{Key: k}
+		a.Value.StringValue = v
+		res = append(res, a)
+	}
+
+	return res
+}
+
+func (s *Span) otlp() otlpSpan {
+	o := otlpSpan{
+		TraceID:    hex.EncodeToString(s.traceID[:]),
+		SpanID:     hex.EncodeToString(s.id[:]),
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+		End:        strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes: attributes(s.attrs),
+	}
+
+	if s.parent != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	if s.err != "" {
+		o.Status = &status{Code: 2, Message: s.err}
+	}
+
+	return o
+}
+
+func export(spans []*Span) error {
+	converted := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		converted[i] = s.otlp()
+	}
+
+	body, err := cfg.JSON.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource":   map[string]any{"attributes": attributes(map[string]string{"service.name": cfg.Get().TracingServiceName, "service.version": cfg.Version})},
+			"scopeSpans": []any{map[string]any{"scope": map[string]string{"name": "soundcloak"}, "spans": converted}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(strings.TrimSuffix(cfg.Get().TracingEndpoint, "/") + "/v1/traces")
+	req.Header.SetMethod("POST")
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err = httpc.DoTimeout(req, resp, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("tracing: got status code %d", resp.StatusCode())
+	}
+
+	return nil
+}
+
+// sends spans in batches, when there's enough of them or every flushInterval
+func exporter() {
+	batch := make([]*Span, 0, batchSize)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := export(batch); err != nil {
+			log.Printf("error exporting %d spans: %s\n", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-queue:
+			batch = append(batch, s)
+			if len(batch) == batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// only proxies from cfg.TrustedProxies get to continue a trace, anyone else could get every request of theirs exported
+func trusted(c *fiber.Ctx) bool {
+	ip, ok := netip.AddrFromSlice(c.Context().RemoteIP())
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+
+	for _, p := range cfg.Get().TrustedProxies {
+		if prefix, err := netip.ParsePrefix(p); err == nil {
+			if prefix.Contains(ip) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(p); err == nil && addr == ip {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a server span for every request, continuing the trace from a traceparent header if a trusted proxy sent one
+func Load(r fiber.Router) {
+	r.Use(func(c *fiber.Ctx) error {
+		if !Enabled() {
+			return c.Next()
+		}
+
+		s := newSpan(c.Method(), kindServer, []string{"http.request.method", c.Method(), "url.path", c.Path()})
+		if traceID, parent, flagged, ok := parseTraceparent(c.Get("traceparent")); ok && trusted(c) {
+			s.traceID = traceID
+			s.parent = parent
+			s.active = flagged
+		} else {
+			rand.Read(s.traceID[:])
+			s.active = sampled()
+		}
+
+		s.enter()
+		s.prev = nil // whatever was left over on this goroutine (a panic skips End) isn't part of this request
+		err := c.Next()
+		s.Error(err)
+
+		// the route is only known after routing
+		route := c.Route().Path
+		s.name = c.Method() + " " + route
+		s.Set("http.route", route)
+		code := c.Response().StatusCode()
+		if err != nil {
+			if e, ok := err.(*fiber.Error); ok {
+				code = e.Code
+			} else {
+				code = fiber.StatusInternalServerError
+			}
+		}
+		s.Set("http.response.status_code", strconv.Itoa(code))
+		s.End()
+
+		currentLock.Lock()
+		delete(current, s.gid) // spans a panic didn't end
+		currentLock.Unlock()
+
+		return err
+	})
+}
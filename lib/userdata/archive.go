@@ -0,0 +1,132 @@
+package userdata
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/accounts"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/themes"
+)
+
+// Everything the instance keeps for someone in one json file, to move it to another instance
+// playlists are only known for logged in accounts, anonymous local playlists aren't tied to anyone
+
+var ErrBadArchive = errors.New("not a data export")
+
+const version = 1
+
+// playlists created by one import, each one is a new file
+const maxPlaylists = 100
+
+type Preferences struct {
+	Locale string `json:"locale"`
+	Theme  string `json:"theme"`
+	Safe   string `json:"safe,omitempty"`
+}
+
+type Archive struct {
+	Version     int                 `json:"version"`
+	Exported    time.Time           `json:"exported"`
+	Preferences Preferences         `json:"preferences"`
+	Favorites   favorites.Favorites `json:"favorites"`
+	Playlists   []local.Playlist    `json:"playlists"`
+}
+
+func Export(c *fiber.Ctx) Archive {
+	p := preferences.Get(c)
+	a := Archive{
+		Version:     version,
+		Exported:    time.Now().UTC(),
+		Preferences: Preferences{Locale: p.Locale, Theme: p.Theme, Safe: p.Safe},
+		Favorites:   favorites.For(c),
+		Playlists:   []local.Playlist{},
+	}
+
+	if cfg.Get().Features.EnableLocalPlaylists {
+		for _, ap := range accounts.Playlists(c) {
+			pl, err := local.Get(ap.ID)
+			if err != nil {
+				if err != local.ErrNotFound {
+					log.Printf("error getting local playlist %s (data export): %s\n", ap.ID, err)
+				}
+				continue
+			}
+
+			a.Playlists = append(a.Playlists, pl)
+		}
+	}
+
+	return a
+}
+
+func numeric(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// preferences from the archive replace the current ones, favorites are merged and playlists are created again (with new ids)
+func Import(c *fiber.Ctx, a Archive) ([]local.Playlist, error) {
+	if a.Version != version {
+		return nil, ErrBadArchive
+	}
+
+	p := preferences.Get(c)
+	if a.Preferences.Locale != "" {
+		p.Locale = format.Normalize(a.Preferences.Locale)
+	}
+	if themes.Valid(a.Preferences.Theme) {
+		p.Theme = a.Preferences.Theme
+	}
+	if preferences.ValidSafe(a.Preferences.Safe) {
+		p.Safe = a.Preferences.Safe
+	}
+	p.Save(c)
+
+	if cfg.Get().Features.EnableFavorites && len(a.Favorites.Tracks)+len(a.Favorites.Users) != 0 {
+		err := favorites.Merge(favorites.Key(c), a.Favorites)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !cfg.Get().Features.EnableLocalPlaylists {
+		return nil, nil
+	}
+
+	if len(a.Playlists) > maxPlaylists {
+		a.Playlists = a.Playlists[:maxPlaylists]
+	}
+
+	created := []local.Playlist{}
+	for _, ip := range a.Playlists {
+		// the ids were resolved on the other instance already, they are checked when the playlist is opened
+		tracks := []string{}
+		for _, id := range ip.Tracks {
+			if numeric(id) {
+				tracks = append(tracks, id)
+			}
+		}
+
+		if len(tracks) == 0 {
+			continue
+		}
+
+		pl, err := local.Create(ip.Title, tracks)
+		if err != nil {
+			return created, err
+		}
+
+		accounts.AddPlaylist(c, pl.ID, pl.Title)
+		created = append(created, pl)
+	}
+
+	return created, nil
+}
@@ -0,0 +1,65 @@
+package userdata
+
+import (
+	"io"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+func Load(r fiber.Router) {
+	r.Use("/data", csrf.Check)
+
+	r.Get("/data/export", func(c *fiber.Ctx) error {
+		c.Attachment("soundcloak-data.json")
+		return c.JSON(Export(c))
+	})
+
+	// file= (exported json)
+	r.Post("/data/import", func(c *fiber.Ctx) error {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		var a Archive
+		if cfg.JSON.Unmarshal(data, &a) != nil {
+			return fiber.NewError(fiber.StatusBadRequest, ErrBadArchive.Error())
+		}
+
+		created, err := Import(c, a)
+		if err != nil {
+			switch err {
+			case ErrBadArchive, favorites.ErrTooMany:
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+
+			log.Printf("error importing data: %s\n", err)
+			return err
+		}
+
+		pl := make([]templates.AccountPlaylist, len(created))
+		for i, p := range created {
+			pl[i] = templates.AccountPlaylist{ID: p.ID, Title: p.Title}
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("import data", templates.DataImported(pl), nil).Render(preferences.Context(c), c)
+	})
+}
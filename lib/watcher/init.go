@@ -0,0 +1,252 @@
+package watcher
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Polls watched users for new uploads and notifies webhooks about them, and keeps snapshots of watched playlists
+
+type state struct {
+	UserID string
+	LastID int64 // track ids only go up, so anything bigger is new
+	Cond   sc.Conditional
+}
+
+// per user permalink
+var states = map[string]*state{}
+var statesLock = &sync.Mutex{}
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+	ReadTimeout:   15 * time.Second,
+	WriteTimeout:  15 * time.Second,
+}
+
+// returns new tracks (oldest first), nothing on the first check of a user
+// polling is kept cheap: the user is only resolved once, and only a small first page is requested (conditionally)
+func check(permalink string) ([]sc.Track, error) {
+	statesLock.Lock()
+	st, seen := states[permalink]
+	statesLock.Unlock()
+	if !seen {
+		// only stored once the first fetch worked, otherwise the next poll would take every track as new
+		st = &state{}
+	}
+
+	if st.UserID == "" {
+		u, err := sc.GetUser(permalink)
+		if err != nil {
+			return nil, err
+		}
+
+		st.UserID = u.ID
+	}
+
+	tracks, cond, notModified, err := sc.GetLatestTracks(st.UserID, cfg.Get().WatchPageSize, st.Cond)
+	if err != nil {
+		st.UserID = "" // maybe the user got deleted/renamed, resolve again next time
+		return nil, err
+	}
+
+	if notModified {
+		return nil, nil
+	}
+
+	// everything on the small page is new, there might be even more
+	if seen && len(tracks) == cfg.Get().WatchPageSize && tracks[len(tracks)-1].IDint > st.LastID {
+		tracks, cond, _, err = sc.GetLatestTracks(st.UserID, 50, sc.Conditional{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := []sc.Track{}
+	last := st.LastID
+	for _, t := range tracks {
+		if t.IDint > last {
+			res = append(res, t)
+		}
+
+		if t.IDint > st.LastID {
+			st.LastID = t.IDint
+		}
+	}
+	st.Cond = cond
+
+	if !seen {
+		statesLock.Lock()
+		states[permalink] = st
+		statesLock.Unlock()
+		return nil, nil
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].IDint < res[j].IDint })
+	return res, nil
+}
+
+func poll() {
+	for _, permalink := range cfg.Get().WatchedUsers {
+		permalink = strings.ToLower(strings.Trim(permalink, "/"))
+		tracks, err := check(permalink)
+		if err != nil {
+			log.Printf("[watcher] error checking %s: %s\n", permalink, err)
+			continue
+		}
+
+		for _, t := range tracks {
+			for _, hook := range cfg.Get().Webhooks {
+				err := notify(hook, t)
+				if err != nil {
+					log.Printf("[watcher] error notifying %s about %s: %s\n", hookName(hook), t.Permalink, err)
+				}
+			}
+		}
+	}
+}
+
+func Start() {
+	go func() {
+		for {
+			if len(cfg.Get().WatchedUsers) != 0 {
+				poll()
+			}
+
+			if len(cfg.Get().WatchedPlaylists) != 0 {
+				pollPlaylists()
+			}
+
+			time.Sleep(cfg.Get().WatchInterval)
+		}
+	}()
+}
+
+// link to the track on this instance if we know where it is, otherwise to soundcloud
+func link(t sc.Track) string {
+	base := cfg.Get().InstanceURL
+	if base == "" {
+		base = "https://soundcloud.com"
+	}
+
+	return base + "/" + t.Author.Permalink + "/" + t.Permalink
+}
+
+// don't log secrets in webhook urls
+func hookName(hook string) string {
+	kind, u := hookKind(hook)
+	if i := strings.Index(u, "://"); i != -1 {
+		if end := strings.IndexByte(u[i+3:], '/'); end != -1 {
+			u = u[:i+3+end]
+		}
+	}
+
+	return kind + " " + u
+}
+
+func hookKind(hook string) (kind string, u string) {
+	for _, k := range []string{"ntfy", "discord"} {
+		if strings.HasPrefix(hook, k+":") {
+			return k, hook[len(k)+1:]
+		}
+	}
+
+	return "json", hook
+}
+
+type payloadTrack struct {
+	ID        string `json:"id"`
+	Title     string `json:"title"`
+	Permalink string `json:"permalink"`
+	Artwork   string `json:"artwork_url"`
+	CreatedAt string `json:"created_at"`
+	Genre     string `json:"genre"`
+	Link      string `json:"link"`
+}
+
+type payload struct {
+	Event string       `json:"event"`
+	User  string       `json:"user"`
+	Track payloadTrack `json:"track"`
+}
+
+type discordEmbed struct {
+	Title     string            `json:"title"`
+	URL       string            `json:"url"`
+	Author    map[string]string `json:"author"`
+	Thumbnail map[string]string `json:"thumbnail,omitempty"`
+}
+
+type discordPayload struct {
+	Content string         `json:"content"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+func notify(hook string, t sc.Track) error {
+	kind, u := hookKind(hook)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.Header.SetMethod("POST")
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", "soundcloak")
+
+	l := link(t)
+	switch kind {
+	case "ntfy":
+		req.Header.Set("Title", "New track by "+t.Author.Username)
+		req.Header.Set("Click", l)
+		if t.Artwork != "" {
+			req.Header.Set("Icon", t.Artwork)
+		}
+		req.SetBodyString(t.Title)
+	case "discord":
+		e := discordEmbed{Title: t.Title, URL: l, Author: map[string]string{"name": t.Author.Username}}
+		if t.Artwork != "" {
+			e.Thumbnail = map[string]string{"url": t.Artwork}
+		}
+
+		data, err := cfg.JSON.Marshal(discordPayload{Content: "New track by " + t.Author.Username, Embeds: []discordEmbed{e}})
+		if err != nil {
+			return err
+		}
+
+		req.Header.SetContentType("application/json")
+		req.SetBody(data)
+	default:
+		data, err := cfg.JSON.Marshal(payload{
+			Event: "new_track",
+			User:  t.Author.Permalink,
+			Track: payloadTrack{ID: t.ID, Title: t.Title, Permalink: t.Permalink, Artwork: t.Artwork, CreatedAt: t.CreatedAt, Genre: t.Genre, Link: l},
+		})
+		if err != nil {
+			return err
+		}
+
+		req.Header.SetContentType("application/json")
+		req.SetBody(data)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := httpc.Do(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() >= 300 {
+		return fmt.Errorf("webhook: got status code %d", resp.StatusCode())
+	}
+
+	return nil
+}
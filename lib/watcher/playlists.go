@@ -0,0 +1,271 @@
+package watcher
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/atomicfile"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/templates"
+)
+
+// Snapshots of the track lists of watched playlists, soundcloud playlists silently lose tracks when uploads get deleted
+// stored as json files in cfg.DataDir/snapshots, one per watched permalink. a snapshot is only added when the list changed
+
+type Snapshot struct {
+	Time   time.Time `json:"time"`
+	Tracks []string  `json:"tracks"` // track ids, in order
+}
+
+type History struct {
+	Permalink string     `json:"permalink"` // user/sets/playlist
+	Title     string     `json:"title"`
+	Snapshots []Snapshot `json:"snapshots"` // oldest first
+
+	// "artist - title" of every track we ever saw resolved, so removed tracks still have a name
+	Titles map[string]string `json:"titles"`
+}
+
+// tracks added and removed between two snapshots
+type Changes struct {
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	Added   []string  `json:"added"`
+	Removed []string  `json:"removed"`
+}
+
+var ErrNotWatched = errors.New("playlist is not watched")
+
+var historyLock = &sync.Mutex{}
+
+func snapshotsDir() string {
+	return filepath.Join(cfg.Get().DataDir, "snapshots")
+}
+
+func normalize(permalink string) string {
+	return strings.ToLower(strings.Trim(permalink, "/"))
+}
+
+func Watched(permalink string) bool {
+	permalink = normalize(permalink)
+	return slices.ContainsFunc(cfg.Get().WatchedPlaylists, func(p string) bool { return normalize(p) == permalink })
+}
+
+// user/sets/playlist -> user+sets+playlist.json
+func historyFile(permalink string) string {
+	return filepath.Join(snapshotsDir(), strings.ReplaceAll(normalize(permalink), "/", "+")+".json")
+}
+
+// only watched playlists have a history, which also keeps other paths out of the file name
+func loadHistory(permalink string) (History, error) {
+	h := History{Permalink: normalize(permalink), Titles: map[string]string{}}
+	if !Watched(permalink) {
+		return h, ErrNotWatched
+	}
+
+	data, err := os.ReadFile(historyFile(permalink))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return h, nil
+		}
+
+		return h, err
+	}
+
+	err = cfg.JSON.Unmarshal(data, &h)
+	if h.Titles == nil {
+		h.Titles = map[string]string{}
+	}
+
+	return h, err
+}
+
+func saveHistory(h History) error {
+	data, err := cfg.JSON.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.Write(historyFile(h.Permalink), data, 0o755)
+}
+
+// ErrNotWatched for playlists which aren't in cfg.WatchedPlaylists, no snapshots if it wasn't checked yet
+func GetHistory(permalink string) (History, error) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	return loadHistory(permalink)
+}
+
+// the playlist ids in p, including the ones which weren't resolved
+func trackIDs(p sc.Playlist) []string {
+	ids := make([]string, len(p.Tracks))
+	for i, t := range p.Tracks {
+		ids[i] = t.ID
+	}
+
+	return ids
+}
+
+func snapshot(permalink string) error {
+	p, err := sc.GetPlaylist(permalink)
+	if err != nil {
+		return err
+	}
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	h, err := loadHistory(permalink)
+	if err != nil {
+		return err
+	}
+
+	h.Title = p.Title
+	for _, t := range p.Tracks {
+		if t.Title != "" {
+			h.Titles[t.ID] = t.Author.Username + " - " + t.Title
+		}
+	}
+
+	ids := trackIDs(p)
+	if len(h.Snapshots) == 0 || !slices.Equal(h.Snapshots[len(h.Snapshots)-1].Tracks, ids) {
+		h.Snapshots = append(h.Snapshots, Snapshot{Time: time.Now().UTC(), Tracks: ids})
+		if len(h.Snapshots) > cfg.Get().SnapshotsMax {
+			h.Snapshots = h.Snapshots[len(h.Snapshots)-cfg.Get().SnapshotsMax:]
+		}
+	}
+
+	return saveHistory(h)
+}
+
+func pollPlaylists() {
+	for _, permalink := range cfg.Get().WatchedPlaylists {
+		permalink = normalize(permalink)
+		err := snapshot(permalink)
+		if err != nil {
+			log.Printf("[watcher] error snapshotting %s: %s\n", permalink, err)
+		}
+	}
+}
+
+// changes from the newest snapshot taken before since up to the latest one
+// the oldest snapshot is used if there is none that old
+func (h History) Since(since time.Time) Changes {
+	if len(h.Snapshots) == 0 {
+		return Changes{}
+	}
+
+	last := h.Snapshots[len(h.Snapshots)-1]
+	from := h.Snapshots[0]
+	for _, s := range h.Snapshots {
+		if s.Time.After(since) {
+			break
+		}
+
+		from = s
+	}
+
+	c := Changes{Since: from.Time, Until: last.Time, Added: []string{}, Removed: []string{}}
+	for _, id := range last.Tracks {
+		if !slices.Contains(from.Tracks, id) {
+			c.Added = append(c.Added, id)
+		}
+	}
+
+	for _, id := range from.Tracks {
+		if !slices.Contains(last.Tracks, id) {
+			c.Removed = append(c.Removed, id)
+		}
+	}
+
+	return c
+}
+
+// names for the tombstones of a watched playlist from its snapshots, the track cache usually forgot them already
+// tombstones are shared with the playlist cache, so annotated ones are replaced with copies (tracks has to be a copy too)
+func FillTombstones(permalink string, tracks []*sc.Track) {
+	if !Watched(permalink) {
+		return
+	}
+
+	h, err := GetHistory(permalink)
+	if err != nil {
+		log.Printf("error getting %s snapshots: %s\n", permalink, err)
+		return
+	}
+
+	for i, t := range tracks {
+		if n, ok := h.Titles[t.ID]; ok && t.Unavailable && t.KnownTitle == "" {
+			c := *t
+			c.KnownTitle = n
+			tracks[i] = &c
+		}
+	}
+}
+
+// the last known name of a track, the id if it was never resolved
+func (h History) Name(id string) string {
+	if t, ok := h.Titles[id]; ok {
+		return t
+	}
+
+	return id
+}
+
+func Load(r fiber.Router) {
+	// days= (7 by default), format=json for the data
+	r.Get("/:user/sets/:playlist/changes", func(c *fiber.Ctx) error {
+		permalink := c.Params("user") + "/sets/" + c.Params("playlist")
+		h, err := GetHistory(permalink)
+		if err != nil {
+			if err == ErrNotWatched {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error getting %s snapshots: %s\n", permalink, err)
+			return err
+		}
+
+		days, err := strconv.Atoi(c.Query("days"))
+		if err != nil || days < 1 {
+			days = 7
+		}
+
+		ch := h.Since(time.Now().Add(-time.Duration(days) * 24 * time.Hour))
+		if c.Query("format") == "json" {
+			return c.JSON(ch)
+		}
+
+		titles := func(ids []string) []string {
+			res := make([]string, len(ids))
+			for i, id := range ids {
+				res[i] = h.Name(id)
+			}
+			return res
+		}
+
+		title := h.Title
+		if title == "" {
+			title = h.Permalink
+		}
+
+		pc := templates.PlaylistChanges{Permalink: h.Permalink, Title: title, Snapshots: len(h.Snapshots), Added: titles(ch.Added), Removed: titles(ch.Removed)}
+		if !ch.Since.IsZero() {
+			pc.Since = ch.Since.Format(time.RFC3339)
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base(title+" changes", templates.PlaylistChangesPage(pc), nil).Render(preferences.Context(c), c)
+	})
+}
@@ -0,0 +1,158 @@
+package waveform
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Small waveform thumbnails for track listings: soundcloud's waveform data (wave.sndcdn.com) rendered as svg bars
+// a waveform never changes for the same url, so rendered ones are kept in memory
+
+// svg size and amount of bars
+const (
+	width  = 96
+	height = 24
+	bars   = 48
+)
+
+// the oldest ones are forgotten when there are more, one is around 2 KiB
+const maxEntries = 5000
+
+var cache = sc.NewStore[[]byte]("waveforms", func(*cfg.Config) time.Duration { return 24 * time.Hour }).
+	Limit(func(*cfg.Config) int { return maxEntries })
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+}
+
+type data struct {
+	Height  int   `json:"height"`
+	Samples []int `json:"samples"`
+}
+
+// path of the thumbnail for the waveform url of a track, "" if it doesn't have one
+func URL(waveform string) string {
+	if waveform == "" {
+		return ""
+	}
+
+	return "/_/waveform?url=" + url.QueryEscape(waveform)
+}
+
+// older tracks have a png, the same data is also there as json
+func dataURL(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || !strings.HasPrefix(u.Host, "wave.") || !sc.IsImageURL(u) {
+		return "", false
+	}
+
+	if strings.HasSuffix(u.Path, ".png") {
+		u.Path = strings.TrimSuffix(u.Path, ".png") + ".json"
+	}
+
+	return u.String(), true
+}
+
+func fetch(u string) (data, error) {
+	var d data
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := httpc.Do(req, resp)
+	if err != nil {
+		return d, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return d, fmt.Errorf("waveform: got status code %d", resp.StatusCode())
+	}
+
+	body, err := resp.BodyUncompressed()
+	if err != nil {
+		body = resp.Body()
+	}
+
+	err = cfg.JSON.Unmarshal(body, &d)
+	return d, err
+}
+
+// the loudest sample of each chunk as a bar, centered vertically like on soundcloud
+func render(d data) []byte {
+	var b strings.Builder
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 ` + strconv.Itoa(width) + " " + strconv.Itoa(height) + `" preserveAspectRatio="none"><g fill="#888">`)
+
+	if d.Height > 0 && len(d.Samples) != 0 {
+		step := float64(len(d.Samples)) / bars
+		for i := 0; i < bars; i++ {
+			start := int(float64(i) * step)
+			end := max(int(float64(i+1)*step), start+1)
+
+			peak := 0
+			for _, s := range d.Samples[start:end] {
+				peak = max(peak, s)
+			}
+
+			h := max(float64(min(peak, d.Height))/float64(d.Height)*height, 1)
+			fmt.Fprintf(&b, `<rect x="%d" y="%.1f" width="1.5" height="%.1f"/>`, i*width/bars, (height-h)/2, h)
+		}
+	}
+
+	b.WriteString("</g></svg>")
+	return []byte(b.String())
+}
+
+// rendered svg for a waveform url from the api
+func Get(raw string) ([]byte, error) {
+	u, ok := dataURL(raw)
+	if !ok {
+		return nil, fiber.ErrBadRequest
+	}
+
+	svg, ok := cache.Get(u)
+	if ok {
+		return svg, nil
+	}
+
+	d, err := fetch(u)
+	if err != nil {
+		return nil, err
+	}
+
+	svg = render(d)
+	cache.Set(u, svg)
+
+	return svg, nil
+}
+
+func Load(r fiber.Router) {
+	r.Get("/_/waveform", func(c *fiber.Ctx) error {
+		svg, err := Get(c.Query("url"))
+		if err != nil {
+			if err != fiber.ErrBadRequest {
+				log.Printf("error getting waveform %s: %s\n", c.Query("url"), err)
+			}
+			return err
+		}
+
+		c.Set("Content-Type", "image/svg+xml")
+		return c.Send(svg)
+	})
+}
@@ -0,0 +1,218 @@
+package wayback
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/valyala/fasthttp"
+)
+
+// Names of deleted tracks from the Wayback Machine, for tombstones that nobody remembers (check sc.Tombstone)
+// only the id is known, so archived api responses for it are looked up. that's slow, so it happens in the background
+// and the name shows up on the next page load
+
+// lookups which found nothing are tried again after this, maybe someone archived it since
+const missTTL = 24 * time.Hour
+
+// forgotten when there are more, they are small but shouldn't grow forever
+const maxEntries = 10000
+
+type entry struct {
+	Name    string // "artist - title", empty if nothing was found
+	Expires time.Time
+}
+
+var entries = map[string]entry{}
+var pending = map[string]bool{}
+var lock = &sync.Mutex{}
+
+var queue = make(chan string, 100)
+var startOnce = &sync.Once{}
+
+var httpc = &fasthttp.Client{
+	DialDualStack: true,
+	Dial:          (&fasthttp.TCPDialer{DNSCacheDuration: cfg.Get().DNSCacheTTL}).Dial,
+	ReadTimeout:   15 * time.Second,
+	WriteTimeout:  15 * time.Second,
+}
+
+// archived urls which could have the metadata of a track
+func candidates(id string) []string {
+	return []string{
+		"api.soundcloud.com/tracks/" + id,
+		"api-v2.soundcloud.com/tracks/" + id,
+		"api.soundcloud.com/tracks/" + id + ".json",
+	}
+}
+
+type availability struct {
+	Snapshots struct {
+		Closest struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+			Timestamp string `json:"timestamp"`
+			Status    string `json:"status"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+func get(u string) ([]byte, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(u)
+	req.Header.Set("User-Agent", cfg.Get().UserAgent)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	err := httpc.DoRedirects(req, resp, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("wayback: got status code %d for %s", resp.StatusCode(), u)
+	}
+
+	data, err := resp.BodyUncompressed()
+	if err != nil {
+		data = resp.Body()
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+// the raw archived response (id_ leaves out the wayback toolbar and link rewriting)
+func snapshot(target string) ([]byte, error) {
+	data, err := get("https://archive.org/wayback/available?url=" + target)
+	if err != nil {
+		return nil, err
+	}
+
+	var a availability
+	err = cfg.JSON.Unmarshal(data, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	c := a.Snapshots.Closest
+	if !c.Available || c.Status != "200" || c.Timestamp == "" {
+		return nil, nil
+	}
+
+	return get("https://web.archive.org/web/" + c.Timestamp + "id_/https://" + target)
+}
+
+var ogTitle = regexp.MustCompile(`<meta property="og:title" content="([^"]+)"`)
+
+// api responses are json tracks, anything else is hopefully a track page
+func parse(data []byte) string {
+	var t sc.Track
+	if cfg.JSON.Unmarshal(data, &t) == nil && t.Title != "" {
+		if t.Author.Username != "" {
+			return t.Author.Username + " - " + t.Title
+		}
+
+		return t.Title
+	}
+
+	if m := ogTitle.FindSubmatch(data); m != nil {
+		return html.UnescapeString(string(m[1]))
+	}
+
+	return ""
+}
+
+func lookup(id string) string {
+	for _, target := range candidates(id) {
+		data, err := snapshot(target)
+		if err != nil {
+			log.Printf("[wayback] error looking up %s: %s\n", target, err)
+			continue
+		}
+
+		if name := parse(data); name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func worker() {
+	for id := range queue {
+		name := lookup(id)
+
+		e := entry{Name: name}
+		if name == "" {
+			e.Expires = time.Now().Add(missTTL)
+		}
+
+		lock.Lock()
+		if len(entries) >= maxEntries {
+			clear(entries)
+		}
+		entries[id] = e
+		delete(pending, id)
+		lock.Unlock()
+	}
+}
+
+// sets KnownTitle of the tombstones we already looked up, the others are queued
+// tombstones are shared with the playlist cache, so annotated ones are replaced with copies (tracks has to be a copy too)
+// does nothing unless cfg.WaybackFallback is enabled
+func Fill(tracks []*sc.Track) {
+	if !cfg.Get().WaybackFallback {
+		return
+	}
+
+	startOnce.Do(func() { go worker() })
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i, t := range tracks {
+		if !t.Unavailable || t.KnownTitle != "" || !numeric(t.ID) {
+			continue
+		}
+
+		if e, ok := entries[t.ID]; ok && (e.Expires.IsZero() || e.Expires.After(time.Now())) {
+			c := *t
+			c.KnownTitle = e.Name
+			tracks[i] = &c
+			continue
+		}
+
+		if pending[t.ID] {
+			continue
+		}
+
+		select {
+		case queue <- t.ID:
+			pending[t.ID] = true
+		default: // busy, next time
+		}
+	}
+}
+
+// ids end up in urls
+func numeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,78 +1,274 @@
 package main
 
 import (
-	"context"
 	"log"
 	"net/url"
+	"os"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/earlydata"
+	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/valyala/fasthttp"
 
+	"github.com/maid-zone/soundcloak/lib/accounts"
+	"github.com/maid-zone/soundcloak/lib/actions"
+	"github.com/maid-zone/soundcloak/lib/admin"
+	"github.com/maid-zone/soundcloak/lib/api"
+	"github.com/maid-zone/soundcloak/lib/botguard"
 	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/compression"
+	"github.com/maid-zone/soundcloak/lib/cors"
+	"github.com/maid-zone/soundcloak/lib/csp"
+	"github.com/maid-zone/soundcloak/lib/csrf"
+	"github.com/maid-zone/soundcloak/lib/download"
+	"github.com/maid-zone/soundcloak/lib/export"
+	"github.com/maid-zone/soundcloak/lib/favorites"
+	"github.com/maid-zone/soundcloak/lib/format"
+	"github.com/maid-zone/soundcloak/lib/health"
+	"github.com/maid-zone/soundcloak/lib/httpcache"
+	"github.com/maid-zone/soundcloak/lib/instances"
+	"github.com/maid-zone/soundcloak/lib/jobs"
+	"github.com/maid-zone/soundcloak/lib/local"
+	"github.com/maid-zone/soundcloak/lib/nowplaying"
+	"github.com/maid-zone/soundcloak/lib/palette"
+	"github.com/maid-zone/soundcloak/lib/preferences"
+	"github.com/maid-zone/soundcloak/lib/proxyimages"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+	"github.com/maid-zone/soundcloak/lib/pwa"
+	"github.com/maid-zone/soundcloak/lib/qr"
+	"github.com/maid-zone/soundcloak/lib/rooms"
 	"github.com/maid-zone/soundcloak/lib/sc"
+	"github.com/maid-zone/soundcloak/lib/sections"
+	"github.com/maid-zone/soundcloak/lib/shortlinks"
+	"github.com/maid-zone/soundcloak/lib/themes"
+	"github.com/maid-zone/soundcloak/lib/tracing"
+	"github.com/maid-zone/soundcloak/lib/userdata"
+	"github.com/maid-zone/soundcloak/lib/watcher"
+	"github.com/maid-zone/soundcloak/lib/waveform"
+	"github.com/maid-zone/soundcloak/lib/wayback"
 	"github.com/maid-zone/soundcloak/templates"
 )
 
+// streamed/large responses (etag needs the whole body in memory) and static files (they have last-modified)
+func noETag(c *fiber.Ctx) bool {
+	p := c.Path()
+	return strings.HasPrefix(p, "/_/proxy/streams") || strings.HasPrefix(p, "/_/download") || strings.HasPrefix(p, "/_/jobs") || strings.HasSuffix(p, "/events") || path.Ext(p) != ""
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	cfg.WatchReload()
+	if !fiber.IsChild() { // with prefork, every process would send the same notifications
+		watcher.Start()
+	}
+
 	app := fiber.New(fiber.Config{
-		Prefork:     cfg.Prefork,
+		Prefork:     cfg.Get().Prefork,
 		JSONEncoder: cfg.JSON.Marshal,
 		JSONDecoder: cfg.JSON.Unmarshal,
 
-		EnableTrustedProxyCheck: cfg.TrustedProxyCheck,
-		TrustedProxies:          cfg.TrustedProxies,
+		EnableTrustedProxyCheck: cfg.Get().TrustedProxyCheck,
+		TrustedProxies:          cfg.Get().TrustedProxies,
+
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			// cfg.BlockedUsers/cfg.BlockedTracks, most of these are dmca requests
+			if err == sc.ErrBlocked {
+				err = fiber.NewError(fiber.StatusUnavailableForLegalReasons, err.Error())
+			}
+
+			return fiber.DefaultErrorHandler(c, err)
+		},
 	})
-	app.Use(compress.New())
+	tracing.Load(app) // first, so the spans cover everything else
+	app.Use(compression.New())
 	app.Use(recover.New())
-	if cfg.EarlyData {
+	// rendered pages and api responses get an etag (hash of the body), so revalidating is just a 304
+	app.Use(etag.New(etag.Config{Weak: true, Next: noETag}))
+	httpcache.Load(app)
+	csp.Load(app)
+	cors.Load(app)
+	if cfg.Get().EarlyData {
 		app.Use(earlydata.New())
 	}
 
-	app.Static("/", "assets", fiber.Static{Compress: true, MaxAge: 3600})
-	app.Static("/js/hls.js/", "node_modules/hls.js/dist", fiber.Static{Compress: true, MaxAge: 14400})
+	botguard.Load(app)
+
+	// compressed versions of the assets are cached next to them (.fiber.gz, .fasthttp.br, .fasthttp.zst)
+	app.Static("/", "assets", fiber.Static{Compress: cfg.Get().CompressionLevel != "off", MaxAge: 3600})
+	app.Static("/js/hls.js/", "node_modules/hls.js/dist", fiber.Static{Compress: cfg.Get().CompressionLevel != "off", MaxAge: 14400})
+
+	themes.Load(app)
+	pwa.Load(app)
+	health.Load(app)
+	admin.Load(app)
+	instances.Load(app)
+
+	proxystreams.Load(app)
+	proxyimages.Load(app)
+	waveform.Load(app)
+	download.Load(app)
+	export.Load(app)
+	jobs.Load(app)
+	local.Load(app)
+	shortlinks.Load(app)
+	qr.Load(app)
+	favorites.Load(app)
+	accounts.Load(app)
+	userdata.Load(app)
+	rooms.Load(app)
+	nowplaying.Load(app)
+	watcher.Load(app)
+	actions.Load(app)
+	api.Load(app)
+
+	app.Get("/search", botguard.ProofOfWork, func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableSearch {
+			return fiber.ErrNotFound
+		}
 
-	app.Get("/search", func(c *fiber.Ctx) error {
 		q := c.Query("q")
 		t := c.Query("type")
+		pg := sc.ParsePage(c.Query("limit"), c.Query("offset"))
+		base := "?type=" + url.QueryEscape(t) + "&q=" + url.QueryEscape(q) + "&"
 		switch t {
 		case "tracks":
-			p, err := sc.SearchTracks(c.Query("pagination", "?q="+url.QueryEscape(q)))
+			license := c.Query("license")
+			if license != "" {
+				if !sc.ValidLicenseFilter(license) {
+					return fiber.ErrBadRequest
+				}
+
+				base += "license=" + license + "&"
+			}
+
+			p, err := sc.SearchTracksByLicense(q, license, pg)
 			if err != nil {
 				log.Printf("error getting tracks for %s: %s\n", q, err)
 				return err
 			}
 
 			c.Set("Content-Type", "text/html")
-			return templates.Base("tracks: "+q, templates.SearchTracks(p), nil).Render(context.Background(), c)
+			return templates.Base("tracks: "+q, templates.SearchTracks(p, base), nil).Render(preferences.Context(c), c)
 
 		case "users":
-			p, err := sc.SearchUsers(c.Query("pagination", "?q="+url.QueryEscape(q)))
+			p, err := sc.SearchUsers(q, pg)
 			if err != nil {
 				log.Printf("error getting users for %s: %s\n", q, err)
 				return err
 			}
 
 			c.Set("Content-Type", "text/html")
-			return templates.Base("users: "+q, templates.SearchUsers(p), nil).Render(context.Background(), c)
+			return templates.Base("users: "+q, templates.SearchUsers(p, base), nil).Render(preferences.Context(c), c)
 
 		case "playlists":
-			p, err := sc.SearchPlaylists(c.Query("pagination", "?q="+url.QueryEscape(q)))
+			p, err := sc.SearchPlaylists(q, pg)
 			if err != nil {
 				log.Printf("error getting users for %s: %s\n", q, err)
 				return err
 			}
 
 			c.Set("Content-Type", "text/html")
-			return templates.Base("playlists: "+q, templates.SearchPlaylists(p), nil).Render(context.Background(), c)
+			return templates.Base("playlists: "+q, templates.SearchPlaylists(p, base), nil).Render(preferences.Context(c), c)
 		}
 
 		return c.SendStatus(404)
 	})
 
+	app.Get("/tags", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableSearch {
+			return fiber.ErrNotFound
+		}
+
+		tags, err := sc.PopularTags(50)
+		if err != nil {
+			log.Printf("error getting popular tags: %s\n", err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("tags", templates.PopularTags(tags), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/tags/:tag", botguard.ProofOfWork, func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableSearch {
+			return fiber.ErrNotFound
+		}
+
+		tag, err := url.PathUnescape(c.Params("tag"))
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		p, err := sc.SearchTag(tag, sc.ParsePage(c.Query("limit"), c.Query("offset")))
+		if err != nil {
+			log.Printf("error getting tracks for tag %s: %s\n", tag, err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("#"+tag, templates.Tag(tag, p), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/discover", func(c *fiber.Ctx) error {
+		s, err := sc.GetDiscover()
+		if err != nil {
+			log.Printf("error getting discover: %s\n", err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("discover", templates.Discover(s), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/feed", func(c *fiber.Ctx) error {
+		if cfg.Get().OAuthToken == "" {
+			return fiber.ErrNotFound
+		}
+
+		p, err := sc.GetStream(sc.ParseCursor(c.Query("limit"), c.Query("offset")))
+		if err != nil {
+			log.Printf("error getting feed: %s\n", err)
+			return err
+		}
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base("feed", templates.Feed(p), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/preferences", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/html")
+		return templates.Base("preferences", templates.Preferences(preferences.Get(c), false), nil).Render(preferences.Context(c), c)
+	})
+
+	app.Post("/preferences", csrf.Check, func(c *fiber.Ctx) error { // a cross-site form could turn off safe mode
+		p := preferences.Get(c)
+		p.Locale = ""
+		if l := c.FormValue("locale"); l != "" {
+			p.Locale = format.Normalize(l)
+		}
+		p.Theme = ""
+		if t := c.FormValue("theme"); themes.Valid(t) {
+			p.Theme = t
+		}
+		p.Safe = ""
+		if s := c.FormValue("safe"); preferences.ValidSafe(s) {
+			p.Safe = s
+		}
+		p.Save(c)
+
+		// render with the new preferences
+		ctx := preferences.With(c, p)
+		c.Set("Content-Type", "text/html")
+		return templates.Base("preferences", templates.Preferences(p, true), nil).Render(ctx, c)
+	})
+
 	app.Get("/on/:id", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
@@ -84,7 +280,7 @@ func main() {
 
 		req.Header.SetMethod("HEAD")
 		req.SetRequestURI("https://on.soundcloud.com/" + id)
-		req.Header.Set("User-Agent", cfg.UserAgent)
+		req.Header.Set("User-Agent", cfg.Get().UserAgent)
 
 		resp := fasthttp.AcquireResponse()
 		defer fasthttp.ReleaseResponse(resp)
@@ -101,15 +297,100 @@ func main() {
 
 		//fmt.Println(c.Hostname(), c.Protocol(), c.IPs())
 
-		u, err := url.Parse(string(loc))
+		// the player picks the offset up from the fragment
+		n := sc.NormalizeURL(string(loc))
+		if n.Offset != 0 {
+			return c.Redirect("/" + n.Permalink + "#t=" + strconv.Itoa(int(n.Offset.Seconds())))
+		}
+
+		return c.Redirect("/" + n.Permalink)
+	})
+
+	// any soundcloud link -> the page for it here, for redirect extensions and bookmarklets (/r?url=...)
+	app.Get("/r", func(c *fiber.Ctx) error {
+		raw := strings.TrimSpace(c.Query("url"))
+		if raw == "" {
+			return c.Next() // a user called r
+		}
+
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+
+		u, err := url.Parse(raw)
 		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		// the embed player, the link is in its query. only unwrapped once, an embed of an embed isn't a thing
+		if u.Hostname() == "w.soundcloud.com" {
+			raw = u.Query().Get("url")
+			if raw == "" {
+				return fiber.ErrNotFound
+			}
+			if !strings.Contains(raw, "://") {
+				raw = "https://" + raw
+			}
+
+			u, err = url.Parse(raw)
+			if err != nil || u.Hostname() == "w.soundcloud.com" {
+				return fiber.ErrBadRequest
+			}
+		}
+
+		p := strings.Trim(u.Path, "/")
+		switch u.Hostname() {
+		case "on.soundcloud.com":
+			if p == "" || strings.Contains(p, "/") {
+				return fiber.ErrNotFound
+			}
+
+			return c.Redirect("/on/" + url.PathEscape(p))
+		case "soundcloud.com", "www.soundcloud.com", "m.soundcloud.com", "api.soundcloud.com", "api-v2.soundcloud.com":
+		default:
+			return fiber.ErrBadRequest
+		}
+
+		// pages that aren't a user, track or playlist
+		switch {
+		case p == "" || p == "discover":
+			return c.Redirect("/discover")
+		case p == "search" || strings.HasPrefix(p, "search/"):
+			t := map[string]string{"search/people": "users", "search/sets": "playlists", "search/albums": "playlists"}[p]
+			if t == "" {
+				t = "tracks"
+			}
+
+			return c.Redirect("/search?type=" + t + "&q=" + url.QueryEscape(u.Query().Get("q")))
+		case strings.HasPrefix(p, "tags/"):
+			return c.Redirect("/tags/" + url.PathEscape(strings.TrimPrefix(p, "tags/")))
+		case strings.HasPrefix(p, "stations/"): // same paths here
+			return c.Redirect("/" + p)
+		}
+
+		path, err := sc.ResolvePath(raw)
+		if err != nil {
+			if err == sc.ErrKindNotCorrect {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error resolving %s: %s\n", raw, err)
 			return err
 		}
 
-		return c.Redirect(u.Path)
+		// the player picks the offset up from the fragment
+		if o := sc.NormalizeURL(raw).Offset; o != 0 {
+			return c.Redirect("/" + path + "#t=" + strconv.Itoa(int(o.Seconds())))
+		}
+
+		return c.Redirect("/" + path)
 	})
 
 	app.Get("/w/player", func(c *fiber.Ctx) error {
+		if !cfg.Get().Features.EnableEmbeds {
+			return fiber.ErrNotFound
+		}
+
 		u := c.Query("url")
 		if u == "" {
 			return fiber.ErrNotFound
@@ -127,42 +408,106 @@ func main() {
 			log.Printf("error getting %s stream from %s: %s\n", track.Permalink, track.Author.Permalink, err)
 		}
 
+		if cfg.Get().Features.EnableStreamProxy {
+			stream = proxystreams.ForTrack(proxystreams.URL(stream), track.ID)
+		}
+
 		c.Set("Content-Type", "text/html")
-		return templates.TrackEmbed(track, stream).Render(context.Background(), c)
+		return templates.TrackEmbed(track, stream).Render(preferences.Context(c), c)
 	})
 
-	app.Get("/:user/sets", func(c *fiber.Ctx) error {
-		user, err := sc.GetUser(c.Params("user"))
+	// compact metadata for the player (media session)
+	app.Get("/_/nowplaying/:id", func(c *fiber.Ctx) error {
+		if _, err := strconv.ParseUint(c.Params("id"), 10, 64); err != nil {
+			return fiber.ErrNotFound
+		}
+
+		t, err := sc.GetTrackByID(c.Params("id"))
 		if err != nil {
-			log.Printf("error getting %s (playlists): %s\n", c.Params("user"), err)
+			log.Printf("error getting %s (now playing): %s\n", c.Params("id"), err)
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"id":       t.ID,
+			"title":    t.Title,
+			"artist":   t.Author.Username,
+			"artwork":  proxyimages.URL(t.Artwork),
+			"duration": t.DurationMs,
+			"url":      "/" + t.Author.Permalink + "/" + t.Permalink,
+		})
+	})
+
+	// timed comments grouped by position, for dots on the seek bar. ?buckets= is how many dots there can be at most
+	app.Get("/_/comments/:id", func(c *fiber.Ctx) error {
+		if _, err := strconv.ParseUint(c.Params("id"), 10, 64); err != nil {
+			return fiber.ErrNotFound
+		}
+
+		buckets := c.QueryInt("buckets", 100)
+		if buckets < 1 || buckets > 1000 {
+			return fiber.ErrBadRequest
+		}
+
+		t, err := sc.GetTrackByID(c.Params("id"))
+		if err != nil {
+			log.Printf("error getting %s (comments): %s\n", c.Params("id"), err)
+			return err
+		}
+
+		comments, err := t.GetComments()
+		if err != nil {
+			log.Printf("error getting %s comments: %s\n", c.Params("id"), err)
 			return err
 		}
 
-		pl, err := user.GetPlaylists(c.Query("pagination", "?limit=20"))
+		return c.JSON(fiber.Map{
+			"duration": t.DurationMs,
+			"total":    len(comments),
+			"markers":  sc.CommentMarkers(comments, t.DurationMs, buckets),
+		})
+	})
+
+	app.Get("/:user/sets", func(c *fiber.Ctx) error {
+		p, err := sc.FetchProfile(c.Params("user"), sc.ProfilePlaylists, sc.ParseCursor(c.Query("limit"), c.Query("offset")))
 		if err != nil {
-			log.Printf("error getting %s playlists: %s\n", c.Params("user"), err)
+			log.Printf("error getting %s (playlists): %s\n", c.Params("user"), err)
 			return err
 		}
 
+		pl := sections.From(c.Params("user")+" playlists", p.Playlists, p.Err(sc.ProfilePlaylists))
+		user := p.User
+
 		c.Set("Content-Type", "text/html")
-		return templates.Base(user.Username, templates.UserPlaylists(user, pl), templates.UserHeader(user)).Render(context.Background(), c)
+		return templates.Base(user.Username, templates.UserPlaylists(user, pl), templates.UserHeader(user)).Render(preferences.Context(c), c)
 	})
 
 	app.Get("/:user/albums", func(c *fiber.Ctx) error {
-		user, err := sc.GetUser(c.Params("user"))
+		p, err := sc.FetchProfile(c.Params("user"), sc.ProfileAlbums, sc.ParseCursor(c.Query("limit"), c.Query("offset")))
 		if err != nil {
 			log.Printf("error getting %s (albums): %s\n", c.Params("user"), err)
 			return err
 		}
 
-		pl, err := user.GetAlbums(c.Query("pagination", "?limit=20"))
+		pl := sections.From(c.Params("user")+" albums", p.Albums, p.Err(sc.ProfileAlbums))
+		user := p.User
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base(user.Username, templates.UserAlbums(user, pl), templates.UserHeader(user)).Render(preferences.Context(c), c)
+	})
+
+	app.Get("/:user/likes/playlists", func(c *fiber.Ctx) error {
+		p, err := sc.FetchProfile(c.Params("user"), sc.ProfileLikedPlaylists, sc.ParseCursor(c.Query("limit"), c.Query("offset")))
 		if err != nil {
-			log.Printf("error getting %s albums: %s\n", c.Params("user"), err)
+			log.Printf("error getting %s (liked playlists): %s\n", c.Params("user"), err)
 			return err
 		}
 
+		pl := sections.From(c.Params("user")+" liked playlists", p.LikedPlaylists, p.Err(sc.ProfileLikedPlaylists))
+		user := p.User
+
 		c.Set("Content-Type", "text/html")
-		return templates.Base(user.Username, templates.UserAlbums(user, pl), templates.UserHeader(user)).Render(context.Background(), c)
+		return templates.Base(user.Username, templates.UserLikedPlaylists(user, pl), templates.UserHeader(user)).Render(preferences.Context(c), c)
 	})
 
 	app.Get("/:user/:track", func(c *fiber.Ctx) error {
@@ -172,58 +517,118 @@ func main() {
 			return err
 		}
 
-		stream, err := track.GetStream()
-		if err != nil {
-			log.Printf("error getting %s stream from %s: %s\n", c.Params("track"), c.Params("user"), err)
+		// ?t=1m30s, shared timestamps (#t= never gets here, track.js handles those)
+		start := sc.ParseOffset(c.Query("t"))
+
+		// neither is worth failing the whole page for
+		var g sections.Group
+		stream := sections.Fetch(&g, track.ID+" stream", func() (string, error) {
+			stream, err := track.GetStream()
+			if err != nil {
+				return "", err
+			}
+
+			if cfg.Get().Features.EnableStreamProxy {
+				stream = proxystreams.WithStart(proxystreams.ForTrack(proxystreams.URL(stream), track.ID), start)
+			}
+
+			return stream, nil
+		})
+		remixes := sections.Fetch(&g, track.ID+" remixes", track.GetRemixes)
+		g.Wait()
+
+		// tints the page once it's known, extracting it would hold up the first render
+		colors, _ := palette.Cached(track)
+
+		// the playlist it's played from (?in=user/sets/playlist), from playlist pages and short links
+		from := strings.Trim(c.Query("in"), "/")
+		if !shortlinks.ValidPlaylistPath(from) {
+			from = ""
+		}
+
+		// for casting, the receiver fetches it without our cookies
+		direct := ""
+		if cfg.Get().Features.EnableStreamProxy {
+			direct = proxystreams.DirectURL(export.BaseURL(c), track.ID)
 		}
 
 		c.Set("Content-Type", "text/html")
-		return templates.Base(track.Title+" by "+track.Author.Username, templates.Track(track, stream), templates.TrackHeader(track)).Render(context.Background(), c)
+		return templates.Base(track.Title+" by "+track.Author.Username, templates.Track(track, stream, favorites.For(c).HasTrack(track.ID), remixes, int(start.Seconds()), direct, from), templates.TrackHeader(track, colors)).Render(preferences.Context(c), c)
 	})
 
 	app.Get("/:user", func(c *fiber.Ctx) error {
-		//h := time.Now()
-		usr, err := sc.GetUser(c.Params("user"))
+		profile, err := sc.FetchProfile(c.Params("user"), sc.ProfileTracks, sc.ParseCursor(c.Query("limit"), c.Query("offset")))
 		if err != nil {
 			log.Printf("error getting %s: %s\n", c.Params("user"), err)
 			return err
 		}
-		//fmt.Println("getuser", time.Since(h))
 
-		//h = time.Now()
-		p, err := usr.GetTracks(c.Query("pagination", "?limit=20"))
+		p := sections.From(c.Params("user")+" tracks", profile.Tracks, profile.Err(sc.ProfileTracks))
+		usr := profile.User
+
+		c.Set("Content-Type", "text/html")
+		return templates.Base(usr.Username, templates.User(usr, p, favorites.For(c).HasUser(usr.ID)), templates.UserHeader(usr)).Render(preferences.Context(c), c)
+	})
+
+	// soundcloud.com/stations/... links, only the path needs to be swapped
+	station := func(c *fiber.Ctx, seed string) error {
+		playlist, err := sc.GetStation(seed)
+		if err != nil {
+			if err == sc.ErrNoURL {
+				return fiber.ErrNotFound
+			}
+
+			log.Printf("error getting station %s: %s\n", seed, err)
+			return err
+		}
+
+		pg := sc.ParsePage(c.Query("limit", "50"), c.Query("offset"))
+		total := len(playlist.Tracks)
+		start, end := pg.Bounds(total)
+		playlist.Tracks = playlist.Tracks[start:end]
+		err = playlist.ResolveMore(pg.Limit)
 		if err != nil {
-			log.Printf("error getting %s tracks: %s\n", c.Params("user"), err)
+			log.Printf("error getting station %s tracks: %s\n", seed, err)
 			return err
 		}
-		//fmt.Println("gettracks", time.Since(h))
 
 		c.Set("Content-Type", "text/html")
-		return templates.Base(usr.Username, templates.User(usr, p), templates.UserHeader(usr)).Render(context.Background(), c)
+		return templates.Base(playlist.Title, templates.Station(playlist, seed, pg, total), templates.PlaylistHeader(playlist)).Render(preferences.Context(c), c)
+	}
+
+	app.Get("/stations/track/:user/:track", func(c *fiber.Ctx) error {
+		return station(c, "track/"+c.Params("user")+"/"+c.Params("track"))
+	})
+
+	app.Get("/stations/artist/:user", func(c *fiber.Ctx) error {
+		return station(c, "artist/"+c.Params("user"))
 	})
 
 	app.Get("/:user/sets/:playlist", func(c *fiber.Ctx) error {
-		playlist, err := sc.GetPlaylist(c.Params("user") + "/sets/" + c.Params("playlist"))
+		permalink := c.Params("user") + "/sets/" + c.Params("playlist")
+		playlist, err := sc.GetPlaylist(permalink)
 		if err != nil {
 			log.Printf("error getting %s playlist from %s: %s\n", c.Params("playlist"), c.Params("user"), err)
 			return err
 		}
 
-		p := c.Query("pagination")
-		if p != "" {
-			tracks, next, err := sc.GetNextMissingTracks(p)
-			if err != nil {
-				log.Printf("error getting %s playlist tracks from %s: %s\n", c.Params("playlist"), c.Params("user"), err)
-				return err
-			}
-
-			playlist.Tracks = tracks
-			playlist.MissingTracks = strings.Join(next, ",")
+		// only the tracks of this page are resolved, so huge playlists don't need hundreds of lookups at once
+		pg := sc.ParsePage(c.Query("limit", "50"), c.Query("offset"))
+		total := len(playlist.Tracks)
+		start, end := pg.Bounds(total)
+		playlist.Tracks = playlist.Tracks[start:end]
+		err = playlist.ResolveMore(pg.Limit)
+		if err != nil {
+			log.Printf("error getting %s playlist tracks from %s: %s\n", c.Params("playlist"), c.Params("user"), err)
+			return err
 		}
 
+		watcher.FillTombstones(permalink, playlist.Tracks)
+		wayback.Fill(playlist.Tracks)
+
 		c.Set("Content-Type", "text/html")
-		return templates.Base(playlist.Title+" by "+playlist.Author.Username, templates.Playlist(playlist), templates.PlaylistHeader(playlist)).Render(context.Background(), c)
+		return templates.Base(playlist.Title+" by "+playlist.Author.Username, templates.Playlist(playlist, pg, total), templates.PlaylistHeader(playlist)).Render(preferences.Context(c), c)
 	})
 
-	log.Fatal(app.Listen(cfg.Addr))
+	serve(app)
 }
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/maid-zone/soundcloak/lib/admin"
+	"github.com/maid-zone/soundcloak/lib/cfg"
+	"github.com/maid-zone/soundcloak/lib/dlna"
+	"github.com/maid-zone/soundcloak/lib/grpc"
+	"github.com/maid-zone/soundcloak/lib/mpd"
+	"github.com/maid-zone/soundcloak/lib/proxystreams"
+)
+
+// Listening (on tcp/unix sockets, or sockets passed by systemd) and graceful shutdown
+
+// "unix:/path/to.sock" for a unix socket, anything else is a tcp address (like :4664, 127.0.0.1:4664 or [::1]:4664)
+func listen(addr string) (net.Listener, error) {
+	p, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// left over from the last run (if it wasn't shut down properly), only remove it if it really is a socket
+	if st, err := os.Stat(p); err == nil && st.Mode()&os.ModeSocket != 0 {
+		os.Remove(p)
+	}
+
+	ln, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := strconv.ParseUint(cfg.Get().UnixSocketMode, 8, 32) // checked in cfg
+	err = os.Chmod(p, os.FileMode(mode))
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// listeners for addr, listen and admin_addr
+func configuredListeners() ([]net.Listener, error) {
+	var lns []net.Listener
+	fail := func(addr string, err error) ([]net.Listener, error) {
+		for _, ln := range lns {
+			ln.Close()
+		}
+
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+
+	for _, addr := range append([]string{cfg.Get().Addr}, cfg.Get().Listen...) {
+		ln, err := listen(addr)
+		if err != nil {
+			return fail(addr, err)
+		}
+
+		lns = append(lns, ln)
+	}
+
+	if cfg.Get().AdminAddr != "" {
+		ln, err := listen(cfg.Get().AdminAddr)
+		if err != nil {
+			return fail(cfg.Get().AdminAddr, err)
+		}
+
+		lns = append(lns, admin.Listener(ln))
+	}
+
+	return lns, nil
+}
+
+// sockets passed by systemd socket activation (LISTEN_FDS), nil if there are none
+func systemdListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+
+	// don't pass them on to prefork children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFD = 3
+	lns := make([]net.Listener, 0, n)
+	for fd := firstFD; fd < firstFD+n; fd++ {
+		f := os.NewFile(uintptr(fd), "systemd socket "+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups it
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d: %w", fd, err)
+		}
+
+		lns = append(lns, ln)
+	}
+
+	return lns, nil
+}
+
+// blocks until the server fails or we get SIGTERM/SIGINT, then waits (up to cfg.ShutdownTimeout) for open connections like proxied streams to finish
+func serve(app *fiber.App) {
+	lns, err := systemdListeners()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if len(lns) != 0 {
+		log.Printf("using %d socket(s) from systemd, addr, listen and admin_addr are ignored\n", len(lns))
+	} else if !cfg.Get().Prefork || len(cfg.Get().Listen) != 0 || cfg.Get().AdminAddr != "" || strings.HasPrefix(cfg.Get().Addr, "unix:") {
+		if cfg.Get().Prefork {
+			log.Println("prefork only works with a single tcp addr, disabling it")
+		}
+
+		lns, err = configuredListeners()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// not in prefork children, the parent has it
+	if mpd.Enabled() && !fiber.IsChild() {
+		ln, err := listen(cfg.Get().MPDAddr)
+		if err != nil {
+			log.Fatalf("listen on %s: %s\n", cfg.Get().MPDAddr, err)
+		}
+
+		go mpd.Serve(ln)
+		defer ln.Close()
+	}
+
+	if dlna.Enabled() && !fiber.IsChild() {
+		ln, err := listen(cfg.Get().DLNAAddr)
+		if err != nil {
+			log.Fatalf("listen on %s: %s\n", cfg.Get().DLNAAddr, err)
+		}
+
+		go dlna.Serve(ln)
+		defer ln.Close()
+	}
+
+	if grpc.Enabled() && !fiber.IsChild() {
+		ln, err := listen(cfg.Get().GRPCAddr)
+		if err != nil {
+			log.Fatalf("listen on %s: %s\n", cfg.Get().GRPCAddr, err)
+		}
+
+		go grpc.Serve(ln)
+		defer ln.Close()
+	}
+
+	errs := make(chan error, 1+len(lns))
+	if len(lns) == 0 {
+		go func() { errs <- app.Listen(cfg.Get().Addr) }() // fiber does the listening itself with prefork
+	} else {
+		for _, ln := range lns {
+			go func(ln net.Listener) { errs <- app.Listener(ln) }(ln)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+
+	select {
+	case err := <-errs:
+		log.Fatalln(err)
+	case s := <-sig:
+		log.Printf("got %s, shutting down (%d proxied streams in flight, waiting up to %s)\n", s, proxystreams.InFlight(), cfg.Get().ShutdownTimeout)
+		err := app.ShutdownWithTimeout(cfg.Get().ShutdownTimeout)
+		if err != nil {
+			log.Printf("shutdown: %s\n", err)
+		}
+	}
+}